@@ -15,6 +15,9 @@ func main() {
 	dbEngine := flag.String("dbengine", "edgedb", "Database engine")
 	edgeDBInstance := flag.String("edgedb_instance", "", "EdgeDB instance name")
 	databaseDSN := flag.String("database_dsn", "", "Database DSN")
+	importFile := flag.String("import", "", "Import accounts from a JSON or CSV file and exit")
+	importOrganization := flag.String("import_organization", "default", "Organization slug the imported accounts belong to")
+	reencryptSecrets := flag.Bool("reencrypt_secrets", false, "Re-encrypt stored secrets with the current master key version and exit")
 
 	flag.Parse()
 
@@ -52,5 +55,15 @@ func main() {
 		config.Database.SetDSN(os.Getenv("DATABASE_DSN"))
 	}
 
+	if *importFile != "" {
+		core.RunImport(&config, *importFile, *importOrganization)
+		return
+	}
+
+	if *reencryptSecrets {
+		core.RunReencryptSecrets(&config)
+		return
+	}
+
 	core.RunService(&config)
 }
@@ -0,0 +1,95 @@
+package authn
+
+import (
+	"slices"
+	"time"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/ghostship-dev/authservice/core/config"
+	"github.com/ghostship-dev/authservice/core/datatypes"
+)
+
+// Authentication context classes, from the weakest to the strongest.
+const (
+	ACRPassword          = "urn:authservice:acr:pwd"
+	ACRMultiFactor       = "urn:authservice:acr:mfa"
+	ACRPhishingResistant = "urn:authservice:acr:phr"
+)
+
+// Authentication methods as registered by RFC 8176.
+const (
+	AMRPassword        = "pwd"
+	AMROneTimePassword = "otp"
+	AMRSMS             = "sms"
+	AMRHardwareKey     = "hwk"
+	AMRMultiFactor     = "mfa"
+	// AMREmailLink and AMRFederated are not registered by RFC 8176, they stand for a login link
+	// delivered by email and a sign in with an upstream identity provider.
+	AMREmailLink = "email"
+	AMRFederated = "fed"
+)
+
+var levels = map[string]int{
+	ACRPassword:          1,
+	ACRMultiFactor:       2,
+	ACRPhishingResistant: 3,
+}
+
+// New returns the authentication of a login which just succeeded with the given methods.
+// Using more than one method is multi-factor, and multi-factor with a security key is phishing-resistant.
+func New(methods ...string) datatypes.Authentication {
+	amr := make([]string, 0, len(methods)+1)
+	for _, method := range methods {
+		if !slices.Contains(amr, method) {
+			amr = append(amr, method)
+		}
+	}
+	if len(amr) > 1 && !slices.Contains(amr, AMRMultiFactor) {
+		amr = append(amr, AMRMultiFactor)
+	}
+
+	acr := ACRPassword
+	if slices.Contains(amr, AMRMultiFactor) {
+		acr = ACRMultiFactor
+		if slices.Contains(amr, AMRHardwareKey) {
+			acr = ACRPhishingResistant
+		}
+	}
+	return datatypes.Authentication{Time: time.Now(), ACR: acr, AMR: amr}
+}
+
+// FactorMethod returns the authentication method of a second factor.
+func FactorMethod(factor string) string {
+	switch factor {
+	case datatypes.FactorWebAuthn:
+		return AMRHardwareKey
+	case datatypes.FactorSMSOTP:
+		return AMRSMS
+	}
+	return AMROneTimePassword
+}
+
+// Satisfies reports whether the authentication is recent and strong enough for the requested acr values and max age in seconds.
+// The acr values are a preference list, any of them or a stronger class is accepted. Unknown classes are ignored.
+func Satisfies(authentication datatypes.Authentication, acrValues []string, maxAge edgedb.OptionalInt64) bool {
+	if seconds, isSet := maxAge.Get(); isSet {
+		if !authentication.IsSet() || time.Since(authentication.Time) > time.Duration(seconds)*time.Second {
+			return false
+		}
+	}
+
+	required := 0
+	for _, acr := range acrValues {
+		if level, known := levels[acr]; known && (required == 0 || level < required) {
+			required = level
+		}
+	}
+	return levels[authentication.ACR] >= required
+}
+
+// StepUp returns the acr and max age first-party endpoints guarding sensitive changes require,
+// read from STEP_UP_ACR and STEP_UP_MAX_AGE.
+func StepUp() ([]string, edgedb.OptionalInt64) {
+	maxAge := config.GetEnvDuration("STEP_UP_MAX_AGE", 15*time.Minute)
+	return []string{config.GetEnv("STEP_UP_ACR", ACRPassword)}, edgedb.NewOptionalInt64(int64(maxAge.Seconds()))
+}
@@ -0,0 +1,134 @@
+package authorization
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/ghostship-dev/authservice/core/authn"
+	"github.com/ghostship-dev/authservice/core/database"
+	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/responses"
+	"github.com/ghostship-dev/authservice/core/scopes"
+	"github.com/ghostship-dev/authservice/core/tenancy"
+	"github.com/ghostship-dev/authservice/core/utility"
+)
+
+type contextKey struct{}
+
+// FirstPartySession is a pseudo permission held only by tokens issued directly to the account
+// by the login endpoint, as opposed to tokens issued to OAuth2 client applications.
+const FirstPartySession = "first_party_session"
+
+// StepUp is a pseudo permission held only by tokens of sessions which authenticated recently and strongly
+// enough for sensitive changes, as configured by STEP_UP_ACR and STEP_UP_MAX_AGE.
+const StepUp = "step_up"
+
+// Principal is the caller of a request as resolved from its bearer token.
+// Its scope is capped by the current roles of the account.
+type Principal struct {
+	Token   datatypes.Token
+	Account datatypes.Account
+	Scope   []string
+}
+
+// Can reports whether the principal holds the given permission.
+func (p *Principal) Can(permission string) bool {
+	return scopes.Satisfies(p.Scope, permission)
+}
+
+func (p *Principal) IsFirstPartySession() bool {
+	_, isSet := p.Token.ApplicationID.Get()
+	return !isSet
+}
+
+// SatisfiesStepUp reports whether the session of the principal authenticated recently and strongly enough for sensitive changes.
+func (p *Principal) SatisfiesStepUp() bool {
+	acrValues, maxAge := authn.StepUp()
+	return authn.Satisfies(p.Token.Authentication(), acrValues, maxAge)
+}
+
+// StepUpRequiredResponse asks the client to authenticate the account again before retrying a sensitive change.
+func StepUpRequiredResponse() error {
+	return responses.InsufficientUserAuthenticationResponse(authn.StepUp())
+}
+
+// DecisionPoint is the router.Authorizer backed by the token store.
+type DecisionPoint struct{}
+
+func New() *DecisionPoint {
+	return &DecisionPoint{}
+}
+
+func (d *DecisionPoint) Authorize(req *http.Request, permissions []string) (*http.Request, error) {
+	principal, err := Authenticate(req)
+	if err != nil {
+		return req, err
+	}
+
+	for _, permission := range permissions {
+		if permission == FirstPartySession {
+			if !principal.IsFirstPartySession() {
+				return req, responses.InsufficientScopeResponse(permissions)
+			}
+			continue
+		}
+		if permission == StepUp {
+			if !principal.SatisfiesStepUp() {
+				return req, StepUpRequiredResponse()
+			}
+			continue
+		}
+		if !principal.Can(permission) {
+			return req, responses.InsufficientScopeResponse(permissions)
+		}
+	}
+
+	return req.WithContext(context.WithValue(req.Context(), contextKey{}, &principal)), nil
+}
+
+// Authenticate resolves the principal from the bearer token of the request.
+func Authenticate(req *http.Request) (Principal, error) {
+	bearerToken, err := utility.GetBearerTokenFromHeader(&req.Header)
+	if err != nil {
+		return Principal{}, responses.MissingBearerTokenResponse()
+	}
+
+	dbToken, err := database.Connection.Queries.GetToken(bearerToken)
+	if err != nil {
+		return Principal{}, responses.InvalidBearerTokenResponse("invalid bearer token")
+	}
+
+	if dbToken.Variant != "access_token" {
+		return Principal{}, responses.InvalidBearerTokenResponse("invalid bearer token")
+	}
+
+	if dbToken.Revoked {
+		return Principal{}, responses.InvalidBearerTokenResponse("token is revoked")
+	}
+
+	if dbToken.ExpiresAt.Before(time.Now()) {
+		return Principal{}, responses.InvalidBearerTokenResponse("token is expired")
+	}
+
+	// Tokens are only valid within the organization that issued them
+	if dbToken.Account.Organization.Id != tenancy.GetOrganization(req).Id {
+		return Principal{}, responses.InvalidBearerTokenResponse("invalid bearer token")
+	}
+
+	if dbToken.Account.IsBlocked() {
+		return Principal{}, responses.InvalidBearerTokenResponse("account is not active")
+	}
+
+	return Principal{
+		Token:   dbToken,
+		Account: dbToken.Account,
+		Scope:   scopes.Cap(dbToken.Scope, dbToken.Account.Permissions()),
+	}, nil
+}
+
+// GetPrincipal returns the principal attached to the request by the decision point.
+func GetPrincipal(req *http.Request) *Principal {
+	principal, _ := req.Context().Value(contextKey{}).(*Principal)
+	return principal
+}
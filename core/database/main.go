@@ -7,23 +7,51 @@ import (
 	"github.com/ghostship-dev/authservice/core/config"
 	"github.com/ghostship-dev/authservice/core/datatypes"
 	"github.com/ghostship-dev/authservice/core/queries"
+	"github.com/ghostship-dev/authservice/core/scopes"
+	"github.com/ghostship-dev/authservice/core/tenancy"
 )
 
 type DatabaseQueries interface {
-	GetPasswordByEmail(email string) (datatypes.Password, error)
-	CreateAccount(email, username, password string) (datatypes.Account, error)
+	GetPasswordByEmail(organizationId edgedb.UUID, email string) (datatypes.Password, error)
+	CreateAccount(organizationId edgedb.UUID, email, username, passwordHash, role string) (datatypes.Account, error)
 	GetAccountById(id string) (datatypes.Account, error)
-	IncrementFailedPasswordLoginAttempts(email string) error
-	ResetFailedPasswordLoginAttempts(email string) error
+	GetAccountByEmail(organizationId edgedb.UUID, email string) (datatypes.Account, error)
+	MarkVerificationMailSent(accountId edgedb.UUID, lastSentBefore time.Time) (bool, error)
+	ActivateAccount(accountId edgedb.UUID, email string) (bool, error)
+	SetAccountStatus(accountId edgedb.UUID, status string) error
+	GetProfile(accountId edgedb.UUID) (datatypes.Account, error)
+	IsUsernameTaken(organizationId edgedb.UUID, username string, exceptAccountId edgedb.OptionalUUID) (bool, error)
+	UpdateProfile(accountId edgedb.UUID, username, avatarURI edgedb.OptionalStr, updateAvatar bool) error
+	SetPendingEmail(accountId edgedb.UUID, email string) error
+	ConfirmEmailChange(accountId edgedb.UUID, email string) (bool, error)
+	CreatePasswordResetToken(accountId edgedb.UUID, tokenHash, requestedFrom string, expiresAt time.Time) error
+	CountPasswordResetTokens(accountId edgedb.UUID, requestedFrom string, since time.Time) (datatypes.RequestCount, error)
+	GetPasswordByAccountId(accountId edgedb.UUID) (datatypes.Password, error)
+	GetPasswordResetTokenAccountId(organizationId edgedb.UUID, tokenHash string) (edgedb.UUID, error)
+	UpdatePasswordHash(passwordId edgedb.UUID, passwordHash string) error
+	UpdatePassword(accountId edgedb.UUID, passwordHash string, previousPasswords []string) error
+	ResetPasswordWithToken(organizationId edgedb.UUID, tokenHash, passwordHash string, previousPasswords []string) (datatypes.Account, error)
+	IncrementFailedPasswordLoginAttempts(passwordId edgedb.UUID) (datatypes.Password, error)
+	UnlockPassword(organizationId, accountId edgedb.UUID, lockedAt edgedb.OptionalDateTime) (bool, error)
+	ResetFailedPasswordLoginAttempts(passwordId edgedb.UUID) error
 	AddNewToken(accountId edgedb.UUID, value, variant string, expiresAt time.Time, scope []string) error
-	AddNewTokenPair(accountId edgedb.UUID, accessTokenValue, refreshTokenValue string, accessTokenExpiresAt, refreshTokenExpiresAt time.Time, scope []string) error
+	AddNewTokenPair(accountId edgedb.UUID, applicationId edgedb.OptionalUUID, accessTokenValue, refreshTokenValue string, accessTokenExpiresAt, refreshTokenExpiresAt time.Time, scope []string, authentication datatypes.Authentication) error
 	GetToken(tokenValue string) (datatypes.Token, error)
 	ResetOTP(accountId edgedb.UUID) error
-	SetOTPSecret(accountId edgedb.UUID, otpSecret string) error
+	SetOTPSecret(accountId edgedb.UUID, otpSecret, algorithm string, digits, period int16) error
+	GetOTPSecrets() ([]datatypes.Account, error)
+	CountEncryptedSecrets() (int64, error)
+	ReplaceOTPSecret(accountId edgedb.UUID, previousSecret, otpSecret string) (bool, error)
+	ClaimOTPStep(accountId edgedb.UUID, step int64) (bool, error)
+	IncrementFailedOTPAttempts(accountId edgedb.UUID) (datatypes.Account, error)
+	ResetFailedOTPAttempts(accountId edgedb.UUID) error
 	SetOTPState(accountId edgedb.UUID, otpState string) error
+	ReplaceRecoveryCodes(accountId edgedb.UUID, codeHashes []string) error
+	UseRecoveryCode(accountId edgedb.UUID, codeHash string) (bool, error)
+	CountRecoveryCodes(accountId edgedb.UUID) (int64, error)
 	CreateNewOAuthClientApplication(oauthClient datatypes.OAuthClient) error
-	UpdateOAuth2ClientApplicationKeyValue(updateRequestData datatypes.UpdateOAuth2ClientKeyValueRequest) error
-	DeleteOAuth2ClientApplication(clientId string) error
+	UpdateOAuth2ClientApplicationKeyValue(organizationId, ownerId edgedb.UUID, updateRequestData datatypes.UpdateOAuth2ClientKeyValueRequest) (bool, error)
+	DeleteOAuth2ClientApplication(organizationId, ownerId edgedb.UUID, clientId string) (bool, error)
 	GetOAuth2ClientApplication(clientID string) (datatypes.OAuthClient, error)
 	CreateNewOAuth2AuthorizationCode(authorizationCode datatypes.OAuthAuthorizationCode) error
 	GetOAuth2ClientApplicationAndUserAccount(clientID string, accountID edgedb.UUID) (datatypes.OAuthClient, datatypes.Account, error)
@@ -33,6 +61,80 @@ type DatabaseQueries interface {
 	DeleteRefreshToken(id edgedb.UUID) error
 	DeleteTokens(ids []edgedb.UUID) error
 	DeleteTokensByValue(tokens []string) error
+	GetActiveOAuth2ApplicationsForAccount(accountId edgedb.UUID) ([]datatypes.OAuthClient, error)
+	DeleteAccountTokens(accountId edgedb.UUID) error
+	ConsentToOAuth2AuthorizationCode(code string, grantedScope []string, authentication datatypes.Authentication) error
+	GetOAuth2Consent(accountId, applicationId edgedb.UUID) (datatypes.Consent, error)
+	SaveOAuth2Consent(accountId, applicationId edgedb.UUID, grantedScope []string) error
+	GetAccountOAuth2Consents(accountId edgedb.UUID) ([]datatypes.Consent, error)
+	RevokeOAuth2Consent(accountId edgedb.UUID, clientID string) error
+	GetScopes() ([]datatypes.Scope, error)
+	CreateScope(scope datatypes.Scope) error
+	CreateScopes(scopes []datatypes.Scope) error
+	UpdateScope(scope datatypes.Scope) error
+	DeleteScope(name string) error
+	GetRoles() ([]datatypes.Role, error)
+	CreateRole(role datatypes.Role) error
+	CreateRoles(roles []datatypes.Role) error
+	UpdateRole(role datatypes.Role) error
+	DeleteRole(name string) error
+	AssignRole(organizationId, accountId edgedb.UUID, roleName string) (bool, error)
+	AssignRoleByEmail(organizationId edgedb.UUID, email, roleName string) error
+	UnassignRole(organizationId, accountId edgedb.UUID, roleName string) (bool, error)
+	AssignRoleToAccountsWithoutRoles(roleName string) error
+	SuspendAccount(organizationId, accountId edgedb.UUID, reason string) (bool, error)
+	ReactivateAccount(organizationId, accountId edgedb.UUID) (bool, error)
+	ScheduleAccountDeletion(accountId edgedb.UUID, scheduledAt time.Time) error
+	CancelAccountDeletion(accountId edgedb.UUID) error
+	GetAccountsDueForErasure(before time.Time) ([]datatypes.Account, error)
+	EraseAccount(accountId edgedb.UUID, pseudonym string) error
+	RecordAuditEvent(event datatypes.AuditEvent) error
+	GetLoginAccount(organizationId, accountId edgedb.UUID) (datatypes.Account, error)
+	GetWebAuthnCredentials(accountId edgedb.UUID) ([]datatypes.WebAuthnCredential, error)
+	CreateWebAuthnCredential(accountId edgedb.UUID, credential datatypes.WebAuthnCredential) (edgedb.UUID, error)
+	UpdateWebAuthnCredentialUsage(credentialId edgedb.UUID, signCount int64, backupState bool) error
+	DeleteWebAuthnCredential(accountId, credentialId edgedb.UUID) (bool, error)
+	CreateWebAuthnSession(organizationId edgedb.UUID, accountId edgedb.OptionalUUID, ceremony, challenge string, data []byte, expiresAt time.Time) error
+	ConsumeWebAuthnSession(organizationId edgedb.UUID, ceremony, challenge string) (datatypes.WebAuthnSession, error)
+	GetOTPFactors(accountId edgedb.UUID) ([]datatypes.OTPFactor, error)
+	SaveOTPFactor(accountId edgedb.UUID, channel string, phoneNumber edgedb.OptionalStr) error
+	VerifyOTPFactor(accountId edgedb.UUID, channel string) error
+	UpdateOTPFactorUsage(accountId edgedb.UUID, channel string) error
+	DeleteOTPFactor(accountId edgedb.UUID, channel string) (bool, error)
+	CountOTPChallenges(accountId edgedb.UUID, destination string, since time.Time) (datatypes.OTPChallengeCount, error)
+	CreateOTPChallenge(accountId edgedb.UUID, channel, purpose, destination, codeHash string, expiresAt time.Time) error
+	GetOTPChallenge(accountId edgedb.UUID, channel, purpose string) (datatypes.OTPChallenge, error)
+	ReserveOTPChallengeAttempt(challengeId edgedb.UUID, maxAttempts int16) (bool, error)
+	ConsumeOTPChallenge(challengeId edgedb.UUID) (bool, error)
+	CreateLoginTransaction(organizationId, accountId edgedb.UUID, tokenHash, firstFactor string, expiresAt time.Time) error
+	GetLoginTransaction(organizationId edgedb.UUID, tokenHash string) (datatypes.LoginTransaction, error)
+	ReserveLoginTransactionAttempt(transactionId edgedb.UUID, maxAttempts int16) (bool, error)
+	DeleteLoginTransaction(transactionId edgedb.UUID) (bool, error)
+	CreateMagicLink(accountId edgedb.UUID, tokenHash, nonceHash, requestedFrom string, expiresAt time.Time) error
+	CountMagicLinks(accountId edgedb.UUID, requestedFrom string, since time.Time) (datatypes.RequestCount, error)
+	UseMagicLink(organizationId edgedb.UUID, tokenHash, nonceHash string) (edgedb.UUID, error)
+	GetIdentityProviders(organizationId edgedb.UUID) ([]datatypes.IdentityProvider, error)
+	GetIdentityProvider(organizationId edgedb.UUID, name string) (datatypes.IdentityProvider, error)
+	GetIdentityProviderSecrets() ([]datatypes.IdentityProvider, error)
+	CreateIdentityProvider(provider datatypes.IdentityProvider) error
+	UpdateIdentityProvider(provider datatypes.IdentityProvider) (bool, error)
+	DeleteIdentityProvider(organizationId edgedb.UUID, name string) (bool, error)
+	ReplaceIdentityProviderSecret(providerId edgedb.UUID, previousSecret, clientSecret string) (bool, error)
+	CreateFederationState(providerId edgedb.UUID, stateHash, browserHash, nonce, codeVerifier string, linkAccountId edgedb.OptionalUUID, expiresAt time.Time) error
+	ConsumeFederationState(organizationId edgedb.UUID, stateHash, browserHash string) (datatypes.FederationState, error)
+	GetPendingSAMLState(organizationId edgedb.UUID, stateHash string) (datatypes.FederationState, error)
+	CompleteFederationState(stateId edgedb.UUID, codeHash, identity string) (bool, error)
+	RecordSAMLAssertion(providerId edgedb.UUID, assertionId string, expiresAt time.Time) error
+	GetFederatedIdentity(providerId edgedb.UUID, subject string) (datatypes.FederatedIdentity, error)
+	GetFederatedIdentities(accountId edgedb.UUID) ([]datatypes.FederatedIdentity, error)
+	CreateFederatedIdentity(accountId, providerId edgedb.UUID, subject string, email edgedb.OptionalStr) error
+	UpdateFederatedIdentityLogin(identityId edgedb.UUID, email edgedb.OptionalStr) error
+	DeleteFederatedIdentity(accountId edgedb.UUID, providerName string) (bool, error)
+	CreateFederatedAccount(organizationId edgedb.UUID, email, username, passwordHash, role, status string, providerId edgedb.UUID, subject string) (datatypes.Account, error)
+	GetOrganizations() ([]datatypes.Organization, error)
+	CreateOrganization(organization datatypes.Organization) error
+	UpdateOrganization(organization datatypes.Organization) error
+	DeleteOrganization(slug string) error
 }
 
 type Database struct {
@@ -48,3 +150,97 @@ func ConnectToSelectedDBDriver(c *config.Config) *Database {
 }
 
 var Connection *Database
+
+// LoadScopeRegistry refreshes the in-memory scope registry from the database.
+// An empty database is seeded with the default scopes first.
+func LoadScopeRegistry() error {
+	dbScopes, err := Connection.Queries.GetScopes()
+	if err != nil {
+		return err
+	}
+
+	if len(dbScopes) < 1 {
+		defaults := make([]datatypes.Scope, 0, len(scopes.DefaultDefinitions))
+		for _, definition := range scopes.DefaultDefinitions {
+			scope := datatypes.Scope{
+				Name:           definition.Name,
+				Description:    definition.Description,
+				FirstPartyOnly: definition.FirstPartyOnly,
+				RequiresAdmin:  definition.RequiresAdmin,
+			}
+			if definition.Parent != "" {
+				scope.ParentName = edgedb.NewOptionalStr(definition.Parent)
+			}
+			defaults = append(defaults, scope)
+		}
+		if err = Connection.Queries.CreateScopes(defaults); err != nil {
+			return err
+		}
+		if dbScopes, err = Connection.Queries.GetScopes(); err != nil {
+			return err
+		}
+	}
+
+	definitions := make([]scopes.Definition, 0, len(dbScopes))
+	for _, scope := range dbScopes {
+		definitions = append(definitions, scope.ToDefinition())
+	}
+	scopes.Load(definitions)
+	return nil
+}
+
+// SeedDefaultRoles creates the default roles if no role exists yet and grants the
+// administrator role to the bootstrap administrator account, if one is configured.
+// Accounts which predate the roles get DEFAULT_ROLE, as they would hold no permission at all otherwise.
+func SeedDefaultRoles(bootstrapAdminEmail string) error {
+	roles, err := Connection.Queries.GetRoles()
+	if err != nil {
+		return err
+	}
+
+	if len(roles) < 1 {
+		if err = Connection.Queries.CreateRoles(datatypes.DefaultRoles()); err != nil {
+			return err
+		}
+		if err = Connection.Queries.AssignRoleToAccountsWithoutRoles(config.GetEnv("DEFAULT_ROLE", datatypes.UserRole)); err != nil {
+			return err
+		}
+	}
+
+	if bootstrapAdminEmail != "" {
+		return Connection.Queries.AssignRoleByEmail(tenancy.Default().Id, bootstrapAdminEmail, datatypes.AdministratorRole)
+	}
+	return nil
+}
+
+// LoadOrganizations refreshes the in-memory organization registry from the database.
+// The default organization is created first if it does not exist yet.
+func LoadOrganizations() error {
+	organizations, err := Connection.Queries.GetOrganizations()
+	if err != nil {
+		return err
+	}
+
+	hasDefault := false
+	for _, organization := range organizations {
+		if organization.Slug == datatypes.DefaultOrganizationSlug {
+			hasDefault = true
+		}
+	}
+
+	if !hasDefault {
+		if err = Connection.Queries.CreateOrganization(datatypes.Organization{
+			Slug:    datatypes.DefaultOrganizationSlug,
+			Name:    "Default",
+			Domains: make([]string, 0),
+		}); err != nil {
+			return err
+		}
+		if organizations, err = Connection.Queries.GetOrganizations(); err != nil {
+			return err
+		}
+	}
+
+	tenancy.Load(organizations)
+	return nil
+}
@@ -0,0 +1,39 @@
+package datatypes
+
+import (
+	"time"
+
+	"github.com/edgedb/edgedb-go"
+)
+
+// Authentication records when and with which methods an account proved its identity for a session,
+// expressed as the auth_time, acr and amr claims of OpenID Connect.
+type Authentication struct {
+	Time time.Time
+	ACR  string
+	AMR  []string
+}
+
+func (a Authentication) IsSet() bool {
+	return !a.Time.IsZero()
+}
+
+func newAuthentication(authTime edgedb.OptionalDateTime, acr edgedb.OptionalStr, amr []string) Authentication {
+	value, isSet := authTime.Get()
+	if !isSet {
+		return Authentication{}
+	}
+	acrValue, _ := acr.Get()
+	return Authentication{Time: value, ACR: acrValue, AMR: amr}
+}
+
+// Authentication returns how the account authenticated for the session of the token.
+// Tokens issued before it was recorded return an unset authentication.
+func (t *Token) Authentication() Authentication {
+	return newAuthentication(t.AuthTime, t.ACR, t.AMR)
+}
+
+// Authentication returns how the account authenticated for the session which consented to the code.
+func (c *OAuthAuthorizationCode) Authentication() Authentication {
+	return newAuthentication(c.AuthTime, c.ACR, c.AMR)
+}
@@ -1,6 +1,7 @@
 package datatypes
 
 import (
+	"slices"
 	"time"
 
 	"github.com/edgedb/edgedb-go"
@@ -31,35 +32,105 @@ func NewRequestError(statusCode int, message string) error {
 	}
 }
 
+// AuthorizationError is a RequestError that carries a WWW-Authenticate challenge.
+type AuthorizationError struct {
+	RequestError
+	challenge string
+}
+
+func (e *AuthorizationError) Challenge() string {
+	return e.challenge
+}
+
+func NewAuthorizationError(statusCode int, message, challenge string) error {
+	return &AuthorizationError{
+		RequestError: RequestError{
+			statusCode: statusCode,
+			message:    message,
+		},
+		challenge: challenge,
+	}
+}
+
 type Token struct {
-	ID        edgedb.UUID `json:"id" edgedb:"id"`
-	Variant   string      `json:"variant" edgedb:"variant"`
-	Value     string      `json:"value" edgedb:"value"`
-	Scope     []string    `json:"scope" edgedb:"scope"`
-	Account   Account     `json:"account" edgedb:"account"`
-	Revoked   bool        `json:"revoked" edgedb:"revoked"`
-	ExpiresAt time.Time   `json:"expires_at" edgedb:"expires_at"`
+	ID            edgedb.UUID             `json:"id" edgedb:"id"`
+	Variant       string                  `json:"variant" edgedb:"variant"`
+	Value         string                  `json:"value" edgedb:"value"`
+	Scope         []string                `json:"scope" edgedb:"scope"`
+	Account       Account                 `json:"account" edgedb:"account"`
+	ApplicationID edgedb.OptionalUUID     `json:"application_id" edgedb:"application_id"`
+	Revoked       bool                    `json:"revoked" edgedb:"revoked"`
+	ExpiresAt     time.Time               `json:"expires_at" edgedb:"expires_at"`
+	AuthTime      edgedb.OptionalDateTime `json:"-" edgedb:"auth_time"`
+	ACR           edgedb.OptionalStr      `json:"-" edgedb:"acr"`
+	AMR           []string                `json:"-" edgedb:"amr"`
 }
 
 type Password struct {
-	Id                edgedb.UUID `edgedb:"id"`
-	Account           Account     `edgedb:"account"`
-	Email             string      `edgedb:"email"`
-	Password          string      `edgedb:"password"`
-	LastUsed          time.Time   `edgedb:"last_used"`
-	FailedAttempts    int16       `edgedb:"failed_attempts"`
-	LastFailedAttempt time.Time   `edgedb:"last_failed_attempt"`
+	Id                edgedb.UUID             `edgedb:"id"`
+	Account           Account                 `edgedb:"account"`
+	Email             string                  `edgedb:"email"`
+	Password          string                  `edgedb:"password"`
+	PreviousPasswords []string                `edgedb:"previous_passwords"`
+	LastUsed          time.Time               `edgedb:"last_used"`
+	FailedAttempts    int16                   `edgedb:"failed_attempts"`
+	LastFailedAttempt edgedb.OptionalDateTime `edgedb:"last_failed_attempt"`
 }
 
 type Account struct {
-	Id                edgedb.UUID        `edgedb:"id"`
-	Username          string             `edgedb:"username"`
-	Email             string             `edgedb:"email"`
-	AvatarURI         string             `edgedb:"avatar_uri"`
-	Status            string             `edgedb:"status"`
-	OtpSecret         edgedb.OptionalStr `edgedb:"otp_secret"`
-	OtpState          string             `edgedb:"otp_state"`
-	StatusDescription string             `edgedb:"status_description"`
-	StatusChanged     time.Time          `edgedb:"status_changed"`
-	CreatedAt         time.Time          `edgedb:"created_at"`
+	Id                  edgedb.UUID             `edgedb:"id"`
+	Organization        Organization            `edgedb:"organization"`
+	Username            string                  `edgedb:"username"`
+	Email               string                  `edgedb:"email"`
+	PendingEmail        edgedb.OptionalStr      `edgedb:"pending_email"`
+	AvatarURI           edgedb.OptionalStr      `edgedb:"avatar_uri"`
+	Status              string                  `edgedb:"status"`
+	OtpSecret           edgedb.OptionalStr      `edgedb:"otp_secret"`
+	OtpState            string                  `edgedb:"otp_state"`
+	OtpAlgorithm        edgedb.OptionalStr      `edgedb:"otp_algorithm"`
+	OtpDigits           edgedb.OptionalInt16    `edgedb:"otp_digits"`
+	OtpPeriod           edgedb.OptionalInt16    `edgedb:"otp_period"`
+	OtpFailedAttempts   int16                   `edgedb:"otp_failed_attempts"`
+	OtpLastFailed       edgedb.OptionalDateTime `edgedb:"otp_last_failed_attempt"`
+	StatusDescription   edgedb.OptionalStr      `edgedb:"status_description"`
+	StatusChanged       edgedb.OptionalDateTime `edgedb:"status_changed"`
+	VerificationSentAt  edgedb.OptionalDateTime `edgedb:"verification_sent_at"`
+	DeletionScheduledAt edgedb.OptionalDateTime `edgedb:"deletion_scheduled_at"`
+	CreatedAt           edgedb.OptionalDateTime `edgedb:"created_at"`
+	Roles               []Role                  `edgedb:"roles"`
+}
+
+// Account statuses. Accounts stay "created" until their email address is verified.
+const (
+	AccountStatusCreated   = "created"
+	AccountStatusActive    = "active"
+	AccountStatusSuspended = "suspended"
+	AccountStatusDeleted   = "deleted"
+)
+
+// IsBlocked reports whether the account was suspended or deleted and must not sign in or obtain tokens.
+func (a *Account) IsBlocked() bool {
+	return a.Status == AccountStatusSuspended || a.Status == AccountStatusDeleted
+}
+
+// RoleNames returns the names of the roles assigned to the account.
+func (a *Account) RoleNames() []string {
+	names := make([]string, 0, len(a.Roles))
+	for _, role := range a.Roles {
+		names = append(names, role.Name)
+	}
+	return names
+}
+
+// Permissions returns the union of the permissions granted by the roles of the account.
+func (a *Account) Permissions() []string {
+	var permissions []string
+	for _, role := range a.Roles {
+		for _, permission := range role.Permissions {
+			if !slices.Contains(permissions, permission) {
+				permissions = append(permissions, permission)
+			}
+		}
+	}
+	return permissions
 }
@@ -0,0 +1,239 @@
+package datatypes
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/edgedb/edgedb-go"
+)
+
+// Kinds of upstream identity providers. The google, microsoft and github kinds come with preset endpoints.
+const (
+	IdentityProviderOIDC      = "oidc"
+	IdentityProviderGoogle    = "google"
+	IdentityProviderMicrosoft = "microsoft"
+	IdentityProviderGitHub    = "github"
+	IdentityProviderSAML      = "saml"
+)
+
+// Bindings SAML authentication requests are sent to the identity provider with.
+const (
+	SAMLBindingRedirect = "redirect"
+	SAMLBindingPOST     = "post"
+)
+
+var identityProviderNameRegexp = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+type IdentityProvider struct {
+	Id                    edgedb.UUID        `edgedb:"id"`
+	Organization          Organization       `edgedb:"organization"`
+	Name                  string             `edgedb:"name"`
+	DisplayName           string             `edgedb:"display_name"`
+	Kind                  string             `edgedb:"kind"`
+	Issuer                edgedb.OptionalStr `edgedb:"issuer"`
+	AuthorizationEndpoint edgedb.OptionalStr `edgedb:"authorization_endpoint"`
+	TokenEndpoint         edgedb.OptionalStr `edgedb:"token_endpoint"`
+	UserinfoEndpoint      edgedb.OptionalStr `edgedb:"userinfo_endpoint"`
+	JWKSURI               edgedb.OptionalStr `edgedb:"jwks_uri"`
+	ClientID              string             `edgedb:"client_id"`
+	ClientSecret          string             `edgedb:"client_secret"`
+	Scope                 []string           `edgedb:"scope"`
+	SAMLCertificates      []string           `edgedb:"saml_certificates"`
+	SAMLBinding           string             `edgedb:"saml_binding"`
+	EmailAttribute        edgedb.OptionalStr `edgedb:"email_attribute"`
+	UsernameAttribute     edgedb.OptionalStr `edgedb:"username_attribute"`
+	AllowSignup           bool               `edgedb:"allow_signup"`
+	Enabled               bool               `edgedb:"enabled"`
+	CreatedAt             time.Time          `edgedb:"created_at"`
+}
+
+type FederatedIdentity struct {
+	Id          edgedb.UUID             `edgedb:"id"`
+	AccountId   edgedb.UUID             `edgedb:"account_id"`
+	Provider    IdentityProvider        `edgedb:"provider"`
+	Subject     string                  `edgedb:"subject"`
+	Email       edgedb.OptionalStr      `edgedb:"email"`
+	CreatedAt   time.Time               `edgedb:"created_at"`
+	LastLoginAt edgedb.OptionalDateTime `edgedb:"last_login_at"`
+}
+
+type FederationState struct {
+	Id            edgedb.UUID         `edgedb:"id"`
+	Provider      IdentityProvider    `edgedb:"provider"`
+	Nonce         string              `edgedb:"nonce"`
+	CodeVerifier  string              `edgedb:"code_verifier"`
+	LinkAccountId edgedb.OptionalUUID `edgedb:"link_account_id"`
+	CodeHash      edgedb.OptionalStr  `edgedb:"code_hash"`
+	Identity      edgedb.OptionalStr  `edgedb:"identity"`
+	ExpiresAt     time.Time           `edgedb:"expires_at"`
+}
+
+type IdentityProviderRequest struct {
+	Name                  string   `json:"name"`
+	DisplayName           string   `json:"display_name"`
+	Kind                  string   `json:"kind"`
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	UserinfoEndpoint      string   `json:"userinfo_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	ClientID              string   `json:"client_id"`
+	ClientSecret          string   `json:"client_secret"`
+	Scope                 []string `json:"scope"`
+	SAMLCertificates      []string `json:"saml_certificates"`
+	SAMLBinding           string   `json:"saml_binding"`
+	EmailAttribute        string   `json:"email_attribute"`
+	UsernameAttribute     string   `json:"username_attribute"`
+	AllowSignup           bool     `json:"allow_signup"`
+	Enabled               *bool    `json:"enabled"`
+}
+
+func (r *IdentityProviderRequest) Validate() map[string]string {
+	var errors map[string]string = make(map[string]string)
+	if !identityProviderNameRegexp.MatchString(r.Name) {
+		errors["name"] = "name is required and may only contain lowercase letters, digits and dashes"
+	}
+	if len(strings.TrimSpace(r.DisplayName)) < 1 {
+		errors["display_name"] = "display_name is required"
+	}
+	switch r.Kind {
+	case IdentityProviderOIDC:
+		if r.Issuer == "" {
+			errors["issuer"] = "issuer is required for oidc providers"
+		}
+	case IdentityProviderGoogle, IdentityProviderMicrosoft, IdentityProviderGitHub:
+	case IdentityProviderSAML:
+		return r.validateSAML(errors)
+	default:
+		errors["kind"] = "kind not allowed! available kinds: oidc, google, microsoft, github, saml"
+	}
+	for key, value := range map[string]string{
+		"issuer":                 r.Issuer,
+		"authorization_endpoint": r.AuthorizationEndpoint,
+		"token_endpoint":         r.TokenEndpoint,
+		"userinfo_endpoint":      r.UserinfoEndpoint,
+		"jwks_uri":               r.JWKSURI,
+	} {
+		if value != "" && !urlRegexp.MatchString(value) {
+			errors[key] = "'" + value + "' is not a valid url"
+		}
+	}
+	if r.ClientID == "" {
+		errors["client_id"] = "client_id is required"
+	}
+	if r.ClientSecret == "" {
+		errors["client_secret"] = "client_secret is required"
+	}
+	return errors
+}
+
+// validateSAML checks the settings of a SAML provider, whose issuer is the entity id of the provider and not necessarily a url.
+// The certificates are parsed by the caller.
+func (r *IdentityProviderRequest) validateSAML(errors map[string]string) map[string]string {
+	if r.Issuer == "" {
+		errors["issuer"] = "issuer is required for saml providers and has to be the entity id of the provider"
+	}
+	if !urlRegexp.MatchString(r.AuthorizationEndpoint) {
+		errors["authorization_endpoint"] = "authorization_endpoint is required for saml providers and has to be the url of the single sign-on service"
+	}
+	if len(r.SAMLCertificates) < 1 {
+		errors["saml_certificates"] = "saml_certificates is required for saml providers"
+	}
+	if r.SAMLBinding != "" && r.SAMLBinding != SAMLBindingRedirect && r.SAMLBinding != SAMLBindingPOST {
+		errors["saml_binding"] = "saml_binding not allowed! available bindings: redirect, post"
+	}
+	return errors
+}
+
+// ToIdentityProvider returns the provider of the request. The client secret is stored as given and has to be sealed by the caller.
+func (r *IdentityProviderRequest) ToIdentityProvider(organization Organization) IdentityProvider {
+	provider := IdentityProvider{
+		Organization: organization,
+		Name:         r.Name,
+		DisplayName:  strings.TrimSpace(r.DisplayName),
+		Kind:         r.Kind,
+		ClientID:     r.ClientID,
+		ClientSecret: r.ClientSecret,
+		Scope:        r.Scope,
+		AllowSignup:  r.AllowSignup,
+		Enabled:      r.Enabled == nil || *r.Enabled,
+	}
+	if provider.Scope == nil {
+		provider.Scope = make([]string, 0)
+	}
+
+	provider.SAMLCertificates = make([]string, 0)
+	provider.SAMLBinding = SAMLBindingRedirect
+	if r.Kind == IdentityProviderSAML {
+		provider.Issuer = edgedb.NewOptionalStr(r.Issuer)
+		provider.AuthorizationEndpoint = edgedb.NewOptionalStr(r.AuthorizationEndpoint)
+		provider.SAMLCertificates = r.SAMLCertificates
+		if r.SAMLBinding != "" {
+			provider.SAMLBinding = r.SAMLBinding
+		}
+		if r.EmailAttribute != "" {
+			provider.EmailAttribute = edgedb.NewOptionalStr(r.EmailAttribute)
+		}
+		if r.UsernameAttribute != "" {
+			provider.UsernameAttribute = edgedb.NewOptionalStr(r.UsernameAttribute)
+		}
+		return provider
+	}
+
+	optionalURL := func(value string) edgedb.OptionalStr {
+		if value == "" {
+			return edgedb.OptionalStr{}
+		}
+		return edgedb.NewOptionalStr(strings.TrimSuffix(value, "/"))
+	}
+	provider.Issuer = optionalURL(r.Issuer)
+	provider.AuthorizationEndpoint = optionalURL(r.AuthorizationEndpoint)
+	provider.TokenEndpoint = optionalURL(r.TokenEndpoint)
+	provider.UserinfoEndpoint = optionalURL(r.UserinfoEndpoint)
+	provider.JWKSURI = optionalURL(r.JWKSURI)
+	return provider
+}
+
+type DeleteIdentityProviderRequest struct {
+	Name string `json:"name"`
+}
+
+func (r *DeleteIdentityProviderRequest) Validate() map[string]string {
+	var errors map[string]string = make(map[string]string)
+	if r.Name == "" {
+		errors["name"] = "name is required"
+	}
+	return errors
+}
+
+// FederatedCallbackRequest passes the parameters the upstream provider redirected the browser back with.
+type FederatedCallbackRequest struct {
+	State            string `json:"state"`
+	Code             string `json:"code"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func (r *FederatedCallbackRequest) Validate() map[string]string {
+	var errors map[string]string = make(map[string]string)
+	if r.State == "" {
+		errors["state"] = "state is required"
+	}
+	if r.Code == "" && r.Error == "" {
+		errors["code"] = "code is required"
+	}
+	return errors
+}
+
+type FederatedIdentityRequest struct {
+	Provider string `json:"provider"`
+}
+
+func (r *FederatedIdentityRequest) Validate() map[string]string {
+	var errors map[string]string = make(map[string]string)
+	if r.Provider == "" {
+		errors["provider"] = "provider is required"
+	}
+	return errors
+}
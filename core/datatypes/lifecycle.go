@@ -0,0 +1,54 @@
+package datatypes
+
+import (
+	"github.com/edgedb/edgedb-go"
+)
+
+// AuditEvent records an account lifecycle change. Subject and actor are pseudonyms of account ids.
+type AuditEvent struct {
+	OrganizationID edgedb.OptionalUUID `edgedb:"organization_id"`
+	Action         string              `edgedb:"action"`
+	Subject        string              `edgedb:"subject"`
+	Actor          edgedb.OptionalStr  `edgedb:"actor"`
+	Description    edgedb.OptionalStr  `edgedb:"description"`
+}
+
+type SuspendAccountRequest struct {
+	AccountID string `json:"account_id"`
+	Reason    string `json:"reason"`
+}
+
+func (r *SuspendAccountRequest) Validate() map[string]string {
+	var errors map[string]string = make(map[string]string)
+	if r.AccountID == "" {
+		errors["account_id"] = "account_id is required"
+	}
+	if r.Reason == "" {
+		errors["reason"] = "reason is required"
+	}
+	return errors
+}
+
+type ReactivateAccountRequest struct {
+	AccountID string `json:"account_id"`
+}
+
+func (r *ReactivateAccountRequest) Validate() map[string]string {
+	var errors map[string]string = make(map[string]string)
+	if r.AccountID == "" {
+		errors["account_id"] = "account_id is required"
+	}
+	return errors
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+func (r *DeleteAccountRequest) Validate() map[string]string {
+	var errors map[string]string = make(map[string]string)
+	if r.Password == "" {
+		errors["password"] = "password is required"
+	}
+	return errors
+}
@@ -0,0 +1,55 @@
+package datatypes
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/edgedb/edgedb-go"
+)
+
+// Second factors a login can be completed with.
+const (
+	FactorTOTP         = "totp"
+	FactorRecoveryCode = "recovery_code"
+	FactorWebAuthn     = "webauthn"
+	FactorEmailOTP     = "email_otp"
+	FactorSMSOTP       = "sms_otp"
+)
+
+type LoginTransaction struct {
+	Id          edgedb.UUID `edgedb:"id"`
+	AccountId   edgedb.UUID `edgedb:"account_id"`
+	FirstFactor string      `edgedb:"first_factor"`
+	Attempts    int16       `edgedb:"attempts"`
+	ExpiresAt   time.Time   `edgedb:"expires_at"`
+}
+
+// MFAStepRequest submits a second factor for the login transaction of the mfa_token.
+// Submitting the email_otp or sms_otp factor without a code sends a new code.
+type MFAStepRequest struct {
+	MFAToken string          `json:"mfa_token"`
+	Factor   string          `json:"factor"`
+	Code     string          `json:"code"`
+	WebAuthn json.RawMessage `json:"webauthn"`
+}
+
+func (r *MFAStepRequest) Validate() map[string]string {
+	var errors map[string]string = make(map[string]string)
+	if r.MFAToken == "" {
+		errors["mfa_token"] = "mfa_token is required"
+	}
+	switch r.Factor {
+	case FactorTOTP, FactorRecoveryCode:
+		if r.Code == "" {
+			errors["code"] = "code is required for this factor"
+		}
+	case FactorWebAuthn:
+		if len(r.WebAuthn) == 0 {
+			errors["webauthn"] = "webauthn is required for this factor"
+		}
+	case FactorEmailOTP, FactorSMSOTP:
+	default:
+		errors["factor"] = "factor not allowed! available factors: totp, recovery_code, webauthn, email_otp, sms_otp"
+	}
+	return errors
+}
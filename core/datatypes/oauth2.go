@@ -24,12 +24,16 @@ const (
 	OAuthApplicationStatusSuspended string = "suspended"
 )
 
+var urlRegexp = regexp.MustCompile(`^(https?)://[^\s/$.?#].\S*$`)
+
 type OAuthClient struct {
 	ID                     edgedb.UUID        `json:"id" edgedb:"id"`
+	Organization           Organization       `json:"-" edgedb:"organization"`
 	ClientID               string             `json:"client_id" edgedb:"client_id"`
 	ClientSecret           string             `json:"client_secret" edgedb:"client_secret"`
 	ClientName             string             `json:"client_name" edgedb:"client_name"`
 	ClientType             string             `json:"client_type" edgedb:"client_type"`
+	FirstParty             bool               `json:"first_party" edgedb:"first_party"`
 	RedirectURIs           []string           `json:"redirect_uris" edgedb:"redirect_uris"`
 	GrantTypes             []string           `json:"grant_types" edgedb:"grant_types"`
 	Scope                  []string           `json:"scope" edgedb:"scope"`
@@ -42,20 +46,27 @@ type OAuthClient struct {
 	ClientRegistrationDate time.Time          `json:"client_registration_date" edgedb:"client_registration_date"`
 	ClientStatus           string             `json:"client_status" edgedb:"client_status"`
 	ClientRateLimits       []byte             `json:"client_rate_limits" edgedb:"client_rate_limits"`
+	PostLogoutRedirectURIs []string           `json:"post_logout_redirect_uris" edgedb:"post_logout_redirect_uris"`
+	FrontchannelLogoutURI  edgedb.OptionalStr `json:"frontchannel_logout_uri" edgedb:"frontchannel_logout_uri"`
+	BackchannelLogoutURI   edgedb.OptionalStr `json:"backchannel_logout_uri" edgedb:"backchannel_logout_uri"`
 }
 
 type NewOAuthClientRequest struct {
-	ClientName        string      `json:"client_name"`
-	ClientType        string      `json:"client_type"`
-	RedirectUris      []string    `json:"redirect_uris"`
-	GrantTypes        []string    `json:"grant_types"`
-	Scope             []string    `json:"scope"`
-	ClientOwner       edgedb.UUID `json:"client_owner"`
-	ClientDescription string      `json:"client_description"`
-	ClientHomepageUrl string      `json:"client_homepage_url"`
-	ClientLogoUrl     string      `json:"client_logo_url"`
-	ClientTosUrl      string      `json:"client_tos_url"`
-	ClientPrivacyUrl  string      `json:"client_privacy_url"`
+	ClientName             string      `json:"client_name"`
+	ClientType             string      `json:"client_type"`
+	FirstParty             bool        `json:"first_party"`
+	RedirectUris           []string    `json:"redirect_uris"`
+	GrantTypes             []string    `json:"grant_types"`
+	Scope                  []string    `json:"scope"`
+	ClientOwner            edgedb.UUID `json:"client_owner"`
+	ClientDescription      string      `json:"client_description"`
+	ClientHomepageUrl      string      `json:"client_homepage_url"`
+	ClientLogoUrl          string      `json:"client_logo_url"`
+	ClientTosUrl           string      `json:"client_tos_url"`
+	ClientPrivacyUrl       string      `json:"client_privacy_url"`
+	PostLogoutRedirectUris []string    `json:"post_logout_redirect_uris"`
+	FrontchannelLogoutUri  string      `json:"frontchannel_logout_uri"`
+	BackchannelLogoutUri   string      `json:"backchannel_logout_uri"`
 }
 
 func (r *NewOAuthClientRequest) Validate() map[string]string {
@@ -69,17 +80,29 @@ func (r *NewOAuthClientRequest) Validate() map[string]string {
 	if len(r.RedirectUris) <= 0 {
 		errors["redirect_uris"] = "redirect_uris is required"
 	} else {
-		regex, err := regexp.Compile(`^(https?)://[^\s/$.?#].\S*$`)
-		if err != nil {
-			errors["redirect_uris"] = "internal server error"
-		} else {
-			for i := range r.RedirectUris {
-				if !regex.MatchString(r.RedirectUris[i]) {
-					errors["redirect_uris_"+strconv.Itoa(i)] = "'" + r.RedirectUris[i] + "' does not match the redirect url requirements."
-				}
+		for i := range r.RedirectUris {
+			if !urlRegexp.MatchString(r.RedirectUris[i]) {
+				errors["redirect_uris_"+strconv.Itoa(i)] = "'" + r.RedirectUris[i] + "' does not match the redirect url requirements."
+			}
+		}
+	}
+	if len(r.PostLogoutRedirectUris) > 0 {
+		for i := range r.PostLogoutRedirectUris {
+			if !urlRegexp.MatchString(r.PostLogoutRedirectUris[i]) {
+				errors["post_logout_redirect_uris_"+strconv.Itoa(i)] = "'" + r.PostLogoutRedirectUris[i] + "' does not match the redirect url requirements."
 			}
 		}
 	}
+	if len(r.FrontchannelLogoutUri) > 0 {
+		if !urlRegexp.MatchString(r.FrontchannelLogoutUri) {
+			errors["frontchannel_logout_uri"] = "'" + r.FrontchannelLogoutUri + "' does not match the logout url requirements."
+		}
+	}
+	if len(r.BackchannelLogoutUri) > 0 {
+		if !urlRegexp.MatchString(r.BackchannelLogoutUri) {
+			errors["backchannel_logout_uri"] = "'" + r.BackchannelLogoutUri + "' does not match the logout url requirements."
+		}
+	}
 	if len(r.GrantTypes) < 1 {
 		errors["grant_types"] = "grant_types is required"
 	} else {
@@ -93,11 +116,9 @@ func (r *NewOAuthClientRequest) Validate() map[string]string {
 	if len(r.Scope) < 1 {
 		errors["scope"] = "scope is required"
 	} else {
-		if !scopes.AllScopesAllowed(r.Scope) {
-			for i, scope := range r.Scope {
-				if !scopes.IsScopeAllowed(scope) {
-					errors["scope_"+strconv.Itoa(i)] = "scope '" + scope + "' is not allowed"
-				}
+		for i, scope := range r.Scope {
+			if !scopes.IsScopeAllowedForClient(scope, r.FirstParty) {
+				errors["scope_"+strconv.Itoa(i)] = "scope '" + scope + "' is not allowed"
 			}
 		}
 	}
@@ -130,7 +151,10 @@ func (r *UpdateOAuth2ClientKeyValueRequest) Validate() map[string]string {
 		r.Key != "client_homepage_url" &&
 		r.Key != "client_logo_url" &&
 		r.Key != "client_tos_url" &&
-		r.Key != "client_privacy_url" {
+		r.Key != "client_privacy_url" &&
+		r.Key != "post_logout_redirect_uris" &&
+		r.Key != "frontchannel_logout_uri" &&
+		r.Key != "backchannel_logout_uri" {
 		errors["key"] = "key (" + r.Key + ") can not be modified"
 	}
 	if len(strings.TrimSpace(r.Value)) < 1 {
@@ -144,57 +168,49 @@ func (r *UpdateOAuth2ClientKeyValueRequest) Validate() map[string]string {
 		errors["redirect_uris"] = "redirect_uris is required"
 	}
 	if r.Key == "redirect_uris" {
-		regex, err := regexp.Compile(`^(https?)://[^\s/$.?#].\S*$`)
-		if err != nil {
-			errors["redirect_uris"] = "internal server error"
-		} else {
-			strArray := strings.Split(strings.TrimSpace(r.Value), ",")
-			for i := range strArray {
-				if !regex.MatchString(strArray[i]) {
-					errors["redirect_uris_"+strconv.Itoa(i)] = "'" + strArray[i] + "' does not match the redirect url requirements."
-				}
+		strArray := strings.Split(strings.TrimSpace(r.Value), ",")
+		for i := range strArray {
+			if !urlRegexp.MatchString(strArray[i]) {
+				errors["redirect_uris_"+strconv.Itoa(i)] = "'" + strArray[i] + "' does not match the redirect url requirements."
 			}
 		}
 	}
 	if r.Key == "client_logo_url" {
-		regex, err := regexp.Compile(`^(https?)://[^\s/$.?#].\S*$`)
-		if err != nil {
-			errors["client_logo_url"] = "internal server error"
-		} else {
-			strArray := strings.Split(strings.TrimSpace(r.Value), ",")
-			for i := range strArray {
-				if !regex.MatchString(strArray[i]) {
-					errors["client_logo_url_"+strconv.Itoa(i)] = "'" + strArray[i] + "' does not match the client logo url requirements."
-				}
+		strArray := strings.Split(strings.TrimSpace(r.Value), ",")
+		for i := range strArray {
+			if !urlRegexp.MatchString(strArray[i]) {
+				errors["client_logo_url_"+strconv.Itoa(i)] = "'" + strArray[i] + "' does not match the client logo url requirements."
 			}
 		}
 	}
 	if r.Key == "client_privacy_url" {
-		regex, err := regexp.Compile(`^(https?)://[^\s/$.?#].\S*$`)
-		if err != nil {
-			errors["client_privacy_url"] = "internal server error"
-		} else {
-			strArray := strings.Split(strings.TrimSpace(r.Value), ",")
-			for i := range strArray {
-				if !regex.MatchString(strArray[i]) {
-					errors["client_privacy_url_"+strconv.Itoa(i)] = "'" + strArray[i] + "' does not match the client privacy url requirements."
-				}
+		strArray := strings.Split(strings.TrimSpace(r.Value), ",")
+		for i := range strArray {
+			if !urlRegexp.MatchString(strArray[i]) {
+				errors["client_privacy_url_"+strconv.Itoa(i)] = "'" + strArray[i] + "' does not match the client privacy url requirements."
 			}
 		}
 	}
 	if r.Key == "client_tos_url" {
-		regex, err := regexp.Compile(`^(https?)://[^\s/$.?#].\S*$`)
-		if err != nil {
-			errors["redirect_uris"] = "internal server error"
-		} else {
-			strArray := strings.Split(strings.TrimSpace(r.Value), ",")
-			for i := range strArray {
-				if !regex.MatchString(strArray[i]) {
-					errors["client_tos_url_"+strconv.Itoa(i)] = "'" + strArray[i] + "' does not match the client tos url requirements."
-				}
+		strArray := strings.Split(strings.TrimSpace(r.Value), ",")
+		for i := range strArray {
+			if !urlRegexp.MatchString(strArray[i]) {
+				errors["client_tos_url_"+strconv.Itoa(i)] = "'" + strArray[i] + "' does not match the client tos url requirements."
+			}
+		}
+	}
+	if r.Key == "post_logout_redirect_uris" {
+		for i, uri := range r.ListValue() {
+			if !urlRegexp.MatchString(uri) {
+				errors["post_logout_redirect_uris_"+strconv.Itoa(i)] = "'" + uri + "' does not match the logout url requirements."
 			}
 		}
 	}
+	if r.Key == "frontchannel_logout_uri" || r.Key == "backchannel_logout_uri" {
+		if value := strings.TrimSpace(r.Value); strings.Contains(value, ",") || !urlRegexp.MatchString(value) {
+			errors[r.Key] = "'" + value + "' does not match the logout url requirements."
+		}
+	}
 	if r.Key == "grant_types" && len(r.Value) < 1 {
 		errors["grant_types"] = "grant_types is required"
 	}
@@ -233,6 +249,15 @@ func (r *UpdateOAuth2ClientKeyValueRequest) GetKeyType() (string, error) {
 	return "", stdErrors.New("failed to parse key: " + r.Key)
 }
 
+// ListValue returns the comma separated values of a key holding a list.
+func (r *UpdateOAuth2ClientKeyValueRequest) ListValue() []string {
+	values := strings.Split(strings.TrimSpace(r.Value), ",")
+	for i := range values {
+		values[i] = strings.TrimSpace(values[i])
+	}
+	return values
+}
+
 type DeleteOAuth2ClientRequest struct {
 	ClientID string `json:"client_id"`
 }
@@ -268,6 +293,8 @@ type AuthorizeOAuth2ClientRequest struct {
 	CodeChallenge       string `json:"code_challenge"`
 	CodeChallengeMethod string `json:"code_challenge_method"`
 	UserID              string `json:"user_id"`
+	ACRValues           string `json:"acr_values"`
+	MaxAge              string `json:"max_age"`
 }
 
 func (r *AuthorizeOAuth2ClientRequest) Validate() map[string]string {
@@ -290,19 +317,30 @@ func (r *AuthorizeOAuth2ClientRequest) Validate() map[string]string {
 	if len(r.UserID) < 1 {
 		errors["user_id"] = "user_id is required"
 	}
+	if r.MaxAge != "" {
+		if maxAge, err := strconv.ParseInt(r.MaxAge, 10, 64); err != nil || maxAge < 0 {
+			errors["max_age"] = "max_age must be a non-negative number of seconds"
+		}
+	}
 	return errors
 }
 
 type OAuthAuthorizationCode struct {
-	Id             edgedb.UUID `edgedb:"id"`
-	Code           string      `edgedb:"code"`
-	Consented      bool        `edgedb:"consented"`
-	ExpiresAt      time.Time   `edgedb:"expires_at"`
-	GrantedScope   []string    `edgedb:"granted_scope"`
-	RequestedScope []string    `edgedb:"requested_scope"`
-	Account        Account     `edgedb:"account"`
-	Application    OAuthClient `edgedb:"application"`
-	RedirectURI    string      `edgedb:"redirect_uri"`
+	Id             edgedb.UUID             `edgedb:"id"`
+	Code           string                  `edgedb:"code"`
+	Consented      bool                    `edgedb:"consented"`
+	ExpiresAt      time.Time               `edgedb:"expires_at"`
+	GrantedScope   []string                `edgedb:"granted_scope"`
+	RequestedScope []string                `edgedb:"requested_scope"`
+	Account        Account                 `edgedb:"account"`
+	Application    OAuthClient             `edgedb:"application"`
+	RedirectURI    string                  `edgedb:"redirect_uri"`
+	State          edgedb.OptionalStr      `edgedb:"state"`
+	ACRValues      []string                `edgedb:"acr_values"`
+	MaxAge         edgedb.OptionalInt64    `edgedb:"max_age"`
+	AuthTime       edgedb.OptionalDateTime `edgedb:"auth_time"`
+	ACR            edgedb.OptionalStr      `edgedb:"acr"`
+	AMR            []string                `edgedb:"amr"`
 }
 
 type OAuthTokenRequest struct {
@@ -347,3 +385,67 @@ func (r *OAuthRevokeTokenRequest) Validate() map[string]string {
 	}
 	return errors
 }
+
+type EndSessionRequest struct {
+	IDTokenHint           string `json:"id_token_hint"`
+	PostLogoutRedirectURI string `json:"post_logout_redirect_uri"`
+	ClientID              string `json:"client_id"`
+	State                 string `json:"state"`
+}
+
+func (r *EndSessionRequest) Validate() map[string]string {
+	var errors map[string]string = make(map[string]string)
+	if len(r.IDTokenHint) < 1 {
+		errors["id_token_hint"] = "id_token_hint is required"
+	}
+	return errors
+}
+
+type Consent struct {
+	Id           edgedb.UUID             `edgedb:"id"`
+	Account      Account                 `edgedb:"account"`
+	Application  OAuthClient             `edgedb:"application"`
+	GrantedScope []string                `edgedb:"granted_scope"`
+	CreatedAt    time.Time               `edgedb:"created_at"`
+	UpdatedAt    edgedb.OptionalDateTime `edgedb:"updated_at"`
+}
+
+// Covers reports whether the consent already grants every scope in the given slice,
+// either directly or through a parent scope.
+func (c *Consent) Covers(scope []string) bool {
+	for _, s := range scope {
+		if !scopes.Satisfies(c.GrantedScope, s) {
+			return false
+		}
+	}
+	return true
+}
+
+type OAuthConsentRequest struct {
+	Code   string   `json:"code"`
+	Scope  []string `json:"scope"`
+	Denied bool     `json:"denied"`
+}
+
+func (r *OAuthConsentRequest) Validate() map[string]string {
+	var errors map[string]string = make(map[string]string)
+	if r.Code == "" {
+		errors["code"] = "code is required"
+	}
+	if !r.Denied && len(r.Scope) < 1 {
+		errors["scope"] = "scope is required"
+	}
+	return errors
+}
+
+type RevokeOAuthConsentRequest struct {
+	ClientID string `json:"client_id"`
+}
+
+func (r *RevokeOAuthConsentRequest) Validate() map[string]string {
+	var errors map[string]string = make(map[string]string)
+	if r.ClientID == "" {
+		errors["client_id"] = "client_id is required"
+	}
+	return errors
+}
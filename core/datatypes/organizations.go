@@ -0,0 +1,83 @@
+package datatypes
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/edgedb/edgedb-go"
+)
+
+const DefaultOrganizationSlug string = "default"
+
+var (
+	slugRegexp   = regexp.MustCompile(`^[a-z0-9-]+$`)
+	domainRegexp = regexp.MustCompile(`^[a-z0-9.-]+(:[0-9]+)?$`)
+)
+
+type Organization struct {
+	Id         edgedb.UUID        `edgedb:"id"`
+	Slug       string             `edgedb:"slug"`
+	Name       string             `edgedb:"name"`
+	Domains    []string           `edgedb:"domains"`
+	Issuer     edgedb.OptionalStr `edgedb:"issuer"`
+	SigningKey edgedb.OptionalStr `edgedb:"signing_key"`
+	CreatedAt  time.Time          `edgedb:"created_at"`
+}
+
+type OrganizationRequest struct {
+	Slug    string   `json:"slug"`
+	Name    string   `json:"name"`
+	Domains []string `json:"domains"`
+	Issuer  string   `json:"issuer"`
+}
+
+func (r *OrganizationRequest) Validate() map[string]string {
+	var errors map[string]string = make(map[string]string)
+	if !slugRegexp.MatchString(r.Slug) {
+		errors["slug"] = "slug is required and may only contain lowercase letters, digits and dashes"
+	}
+	if len(strings.TrimSpace(r.Name)) < 1 {
+		errors["name"] = "name is required"
+	}
+	for i, domain := range r.Domains {
+		if !domainRegexp.MatchString(domain) {
+			errors["domains_"+strconv.Itoa(i)] = "'" + domain + "' is not a valid host name"
+		}
+	}
+	if r.Issuer != "" && !urlRegexp.MatchString(r.Issuer) {
+		errors["issuer"] = "'" + r.Issuer + "' is not a valid issuer url"
+	}
+	return errors
+}
+
+func (r *OrganizationRequest) ToOrganization() Organization {
+	organization := Organization{
+		Slug:    r.Slug,
+		Name:    r.Name,
+		Domains: r.Domains,
+	}
+	if organization.Domains == nil {
+		organization.Domains = make([]string, 0)
+	}
+	if r.Issuer != "" {
+		organization.Issuer = edgedb.NewOptionalStr(strings.TrimSuffix(r.Issuer, "/"))
+	}
+	return organization
+}
+
+type DeleteOrganizationRequest struct {
+	Slug string `json:"slug"`
+}
+
+func (r *DeleteOrganizationRequest) Validate() map[string]string {
+	var errors map[string]string = make(map[string]string)
+	if r.Slug == "" {
+		errors["slug"] = "slug is required"
+	}
+	if r.Slug == DefaultOrganizationSlug {
+		errors["slug"] = "the default organization can not be deleted"
+	}
+	return errors
+}
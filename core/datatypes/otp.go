@@ -1,8 +1,10 @@
 package datatypes
 
+// OTPRequest accepts a recovery code instead of the otp to disable a lost authenticator.
 type OTPRequest struct {
-	Action string `json:"action"`
-	OTP    string `json:"otp"`
+	Action       string `json:"action"`
+	OTP          string `json:"otp"`
+	RecoveryCode string `json:"recovery_code"`
 }
 
 func (r *OTPRequest) Validate() map[string]string {
@@ -10,8 +12,20 @@ func (r *OTPRequest) Validate() map[string]string {
 	if r.Action != "enable" && r.Action != "disable" && r.Action != "verify" {
 		errors["action"] = "action not allowed! available actions: enable, disable, verify"
 	}
-	if r.OTP == "" && r.Action != "enable" {
+	if r.OTP == "" && r.Action != "enable" && !(r.Action == "disable" && r.RecoveryCode != "") {
 		errors["otp"] = "otp is required for this action"
 	}
 	return errors
 }
+
+type RegenerateRecoveryCodesRequest struct {
+	OTP string `json:"otp"`
+}
+
+func (r *RegenerateRecoveryCodesRequest) Validate() map[string]string {
+	var errors map[string]string = make(map[string]string)
+	if r.OTP == "" {
+		errors["otp"] = "otp is required"
+	}
+	return errors
+}
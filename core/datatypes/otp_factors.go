@@ -0,0 +1,79 @@
+package datatypes
+
+import (
+	"time"
+
+	"github.com/edgedb/edgedb-go"
+)
+
+// Channels one-time codes are delivered through.
+const (
+	OTPChannelEmail = "email"
+	OTPChannelSMS   = "sms"
+)
+
+type OTPFactor struct {
+	Id          edgedb.UUID             `edgedb:"id" json:"id"`
+	Channel     string                  `edgedb:"channel" json:"channel"`
+	PhoneNumber edgedb.OptionalStr      `edgedb:"phone_number" json:"phone_number"`
+	Verified    bool                    `edgedb:"verified" json:"verified"`
+	CreatedAt   time.Time               `edgedb:"created_at" json:"created_at"`
+	LastUsedAt  edgedb.OptionalDateTime `edgedb:"last_used_at" json:"last_used_at"`
+}
+
+type OTPChallenge struct {
+	Id        edgedb.UUID `edgedb:"id"`
+	CodeHash  string      `edgedb:"code_hash"`
+	Attempts  int16       `edgedb:"attempts"`
+	ExpiresAt time.Time   `edgedb:"expires_at"`
+}
+
+// OTPChallengeCount is the number of codes recently sent to an account and to a destination.
+type OTPChallengeCount struct {
+	Account     int64 `edgedb:"account"`
+	Destination int64 `edgedb:"destination"`
+}
+
+type EnrollOTPFactorRequest struct {
+	Channel     string `json:"channel"`
+	PhoneNumber string `json:"phone_number"`
+}
+
+func (r *EnrollOTPFactorRequest) Validate() map[string]string {
+	var errors map[string]string = make(map[string]string)
+	if r.Channel != OTPChannelEmail && r.Channel != OTPChannelSMS {
+		errors["channel"] = "channel not allowed! available channels: email, sms"
+	}
+	if r.Channel == OTPChannelSMS && r.PhoneNumber == "" {
+		errors["phone_number"] = "phone_number is required for the sms channel"
+	}
+	return errors
+}
+
+type VerifyOTPFactorRequest struct {
+	Channel string `json:"channel"`
+	Code    string `json:"code"`
+}
+
+func (r *VerifyOTPFactorRequest) Validate() map[string]string {
+	var errors map[string]string = make(map[string]string)
+	if r.Channel != OTPChannelEmail && r.Channel != OTPChannelSMS {
+		errors["channel"] = "channel not allowed! available channels: email, sms"
+	}
+	if r.Code == "" {
+		errors["code"] = "code is required"
+	}
+	return errors
+}
+
+type DeleteOTPFactorRequest struct {
+	Channel string `json:"channel"`
+}
+
+func (r *DeleteOTPFactorRequest) Validate() map[string]string {
+	var errors map[string]string = make(map[string]string)
+	if r.Channel != OTPChannelEmail && r.Channel != OTPChannelSMS {
+		errors["channel"] = "channel not allowed! available channels: email, sms"
+	}
+	return errors
+}
@@ -1,12 +1,19 @@
 package datatypes
 
+import "encoding/json"
+
 type LoginRequestData struct {
-	Email       string `json:"email"`
-	Password    string `json:"password"`
-	ClientId    string `json:"client_id"`
-	RedirectUri string `json:"redirect_uri"`
-	Scope       string `json:"scope"`
-	OTP         string `json:"otp"`
+	Email        string          `json:"email"`
+	Password     string          `json:"password"`
+	ClientId     string          `json:"client_id"`
+	RedirectUri  string          `json:"redirect_uri"`
+	Scope        string          `json:"scope"`
+	OTP          string          `json:"otp"`
+	RecoveryCode string          `json:"recovery_code"`
+	WebAuthn     json.RawMessage `json:"webauthn"`
+	// Factor selects the email_otp or sms_otp factor. Without a code, a new code is sent through it.
+	Factor string `json:"factor"`
+	Code   string `json:"code"`
 }
 
 func (r *LoginRequestData) Validate() map[string]string {
@@ -26,6 +33,9 @@ func (r *LoginRequestData) Validate() map[string]string {
 	if r.Scope == "" {
 		errors["scope"] = "scope is required"
 	}
+	if r.Factor != "" && r.Factor != FactorEmailOTP && r.Factor != FactorSMSOTP {
+		errors["factor"] = "factor not allowed! available factors: email_otp, sms_otp"
+	}
 	return errors
 }
 
@@ -65,3 +75,146 @@ func (r *IntrospectRequestData) Validate() map[string]string {
 	}
 	return errors
 }
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+func (r *VerifyEmailRequest) Validate() map[string]string {
+	var errors map[string]string = make(map[string]string)
+	if r.Token == "" {
+		errors["token"] = "token is required"
+	}
+	return errors
+}
+
+type ResendVerificationEmailRequest struct {
+	Email string `json:"email"`
+}
+
+func (r *ResendVerificationEmailRequest) Validate() map[string]string {
+	var errors map[string]string = make(map[string]string)
+	if r.Email == "" {
+		errors["email"] = "email is required"
+	}
+	return errors
+}
+
+// RequestCount is the number of mails recently sent to an account and on requests from a client address.
+type RequestCount struct {
+	Account int64 `edgedb:"account"`
+	Address int64 `edgedb:"address"`
+}
+
+type PasswordResetRequest struct {
+	Email string `json:"email"`
+}
+
+func (r *PasswordResetRequest) Validate() map[string]string {
+	var errors map[string]string = make(map[string]string)
+	if r.Email == "" {
+		errors["email"] = "email is required"
+	}
+	return errors
+}
+
+type MagicLinkRequest struct {
+	Email string `json:"email"`
+}
+
+func (r *MagicLinkRequest) Validate() map[string]string {
+	var errors map[string]string = make(map[string]string)
+	if r.Email == "" {
+		errors["email"] = "email is required"
+	}
+	return errors
+}
+
+type MagicLinkLoginRequest struct {
+	Token string `json:"token"`
+}
+
+func (r *MagicLinkLoginRequest) Validate() map[string]string {
+	var errors map[string]string = make(map[string]string)
+	if r.Token == "" {
+		errors["token"] = "token is required"
+	}
+	return errors
+}
+
+type ConfirmPasswordResetRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+func (r *ConfirmPasswordResetRequest) Validate() map[string]string {
+	var errors map[string]string = make(map[string]string)
+	if r.Token == "" {
+		errors["token"] = "token is required"
+	}
+	if r.Password == "" {
+		errors["password"] = "password is required"
+	}
+	return errors
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+	OTP             string `json:"otp"`
+}
+
+func (r *ChangePasswordRequest) Validate() map[string]string {
+	var errors map[string]string = make(map[string]string)
+	if r.CurrentPassword == "" {
+		errors["current_password"] = "current_password is required"
+	}
+	if r.NewPassword == "" {
+		errors["new_password"] = "new_password is required"
+	}
+	return errors
+}
+
+type UnlockAccountRequest struct {
+	Token string `json:"token"`
+}
+
+func (r *UnlockAccountRequest) Validate() map[string]string {
+	var errors map[string]string = make(map[string]string)
+	if r.Token == "" {
+		errors["token"] = "token is required"
+	}
+	return errors
+}
+
+type AdminUnlockAccountRequest struct {
+	AccountID string `json:"account_id"`
+}
+
+func (r *AdminUnlockAccountRequest) Validate() map[string]string {
+	var errors map[string]string = make(map[string]string)
+	if r.AccountID == "" {
+		errors["account_id"] = "account_id is required"
+	}
+	return errors
+}
+
+// UpdateProfileRequest only changes the fields present in the body. An empty avatar_uri removes the avatar.
+// Changing the email requires the current password.
+type UpdateProfileRequest struct {
+	Username  *string `json:"username"`
+	Email     *string `json:"email"`
+	AvatarURI *string `json:"avatar_uri"`
+	Password  string  `json:"password"`
+}
+
+func (r *UpdateProfileRequest) Validate() map[string]string {
+	var errors map[string]string = make(map[string]string)
+	if r.Username == nil && r.Email == nil && r.AvatarURI == nil {
+		errors["body"] = "at least one of username, email or avatar_uri is required"
+	}
+	if r.Email != nil && r.Password == "" {
+		errors["password"] = "password is required to change the email"
+	}
+	return errors
+}
@@ -0,0 +1,100 @@
+package datatypes
+
+import (
+	"regexp"
+	"strconv"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/ghostship-dev/authservice/core/scopes"
+)
+
+const (
+	UserRole          string = "user"
+	AdministratorRole string = "administrator"
+)
+
+var roleNameRegexp = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+type Role struct {
+	Id          edgedb.UUID        `edgedb:"id"`
+	Name        string             `edgedb:"name"`
+	Description edgedb.OptionalStr `edgedb:"description"`
+	Permissions []string           `edgedb:"permission_names"`
+}
+
+// DefaultRoles seed an empty database. Regular users get every scope that
+// does not require an administrator, administrators get everything.
+func DefaultRoles() []Role {
+	var userPermissions, administratorPermissions []string
+	for _, scope := range scopes.All() {
+		if !scope.RequiresAdmin {
+			userPermissions = append(userPermissions, scope.Name)
+		}
+		administratorPermissions = append(administratorPermissions, scope.Name)
+	}
+	return []Role{
+		{Name: UserRole, Description: edgedb.NewOptionalStr("Regular user"), Permissions: userPermissions},
+		{Name: AdministratorRole, Description: edgedb.NewOptionalStr("Administrator of this service"), Permissions: administratorPermissions},
+	}
+}
+
+type RoleRequest struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+func (r *RoleRequest) Validate() map[string]string {
+	var errors map[string]string = make(map[string]string)
+	if !roleNameRegexp.MatchString(r.Name) {
+		errors["name"] = "name is required and may only contain lowercase letters, digits and underscores"
+	}
+	for i, permission := range r.Permissions {
+		if _, found := scopes.Get(permission); !found {
+			errors["permissions_"+strconv.Itoa(i)] = "permission '" + permission + "' does not exist"
+		}
+	}
+	return errors
+}
+
+func (r *RoleRequest) ToRole() Role {
+	role := Role{
+		Name:        r.Name,
+		Permissions: r.Permissions,
+	}
+	if role.Permissions == nil {
+		role.Permissions = make([]string, 0)
+	}
+	if r.Description != "" {
+		role.Description = edgedb.NewOptionalStr(r.Description)
+	}
+	return role
+}
+
+type DeleteRoleRequest struct {
+	Name string `json:"name"`
+}
+
+func (r *DeleteRoleRequest) Validate() map[string]string {
+	var errors map[string]string = make(map[string]string)
+	if r.Name == "" {
+		errors["name"] = "name is required"
+	}
+	return errors
+}
+
+type AccountRoleRequest struct {
+	AccountID string `json:"account_id"`
+	Role      string `json:"role"`
+}
+
+func (r *AccountRoleRequest) Validate() map[string]string {
+	var errors map[string]string = make(map[string]string)
+	if _, err := edgedb.ParseUUID(r.AccountID); err != nil {
+		errors["account_id"] = "account_id must be a valid uuid"
+	}
+	if r.Role == "" {
+		errors["role"] = "role is required"
+	}
+	return errors
+}
@@ -0,0 +1,83 @@
+package datatypes
+
+import (
+	"regexp"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/ghostship-dev/authservice/core/scopes"
+)
+
+var scopeNameRegexp = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+type Scope struct {
+	Id             edgedb.UUID        `edgedb:"id"`
+	Name           string             `edgedb:"name"`
+	Description    string             `edgedb:"description"`
+	ParentName     edgedb.OptionalStr `edgedb:"parent_name"`
+	FirstPartyOnly bool               `edgedb:"first_party_only"`
+	RequiresAdmin  bool               `edgedb:"requires_admin"`
+}
+
+func (s *Scope) ToDefinition() scopes.Definition {
+	parent, _ := s.ParentName.Get()
+	return scopes.Definition{
+		Name:           s.Name,
+		Description:    s.Description,
+		Parent:         parent,
+		FirstPartyOnly: s.FirstPartyOnly,
+		RequiresAdmin:  s.RequiresAdmin,
+	}
+}
+
+type ScopeRequest struct {
+	Name           string `json:"name"`
+	Description    string `json:"description"`
+	Parent         string `json:"parent"`
+	FirstPartyOnly bool   `json:"first_party_only"`
+	RequiresAdmin  bool   `json:"requires_admin"`
+}
+
+func (r *ScopeRequest) Validate() map[string]string {
+	var errors map[string]string = make(map[string]string)
+	if !scopeNameRegexp.MatchString(r.Name) {
+		errors["name"] = "name is required and may only contain lowercase letters, digits and underscores"
+	}
+	if r.Description == "" {
+		errors["description"] = "description is required"
+	}
+	if r.Parent != "" {
+		if r.Parent == r.Name {
+			errors["parent"] = "a scope can not be its own parent"
+		} else if _, found := scopes.Get(r.Parent); !found {
+			errors["parent"] = "parent scope '" + r.Parent + "' does not exist"
+		} else if scopes.IsAncestor(r.Name, r.Parent) {
+			errors["parent"] = "parent scope '" + r.Parent + "' is a descendant of '" + r.Name + "'"
+		}
+	}
+	return errors
+}
+
+func (r *ScopeRequest) ToScope() Scope {
+	scope := Scope{
+		Name:           r.Name,
+		Description:    r.Description,
+		FirstPartyOnly: r.FirstPartyOnly,
+		RequiresAdmin:  r.RequiresAdmin,
+	}
+	if r.Parent != "" {
+		scope.ParentName = edgedb.NewOptionalStr(r.Parent)
+	}
+	return scope
+}
+
+type DeleteScopeRequest struct {
+	Name string `json:"name"`
+}
+
+func (r *DeleteScopeRequest) Validate() map[string]string {
+	var errors map[string]string = make(map[string]string)
+	if r.Name == "" {
+		errors["name"] = "name is required"
+	}
+	return errors
+}
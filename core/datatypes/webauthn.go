@@ -0,0 +1,70 @@
+package datatypes
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/edgedb/edgedb-go"
+)
+
+type WebAuthnCredential struct {
+	Id              edgedb.UUID             `edgedb:"id" json:"id"`
+	CredentialID    []byte                  `edgedb:"credential_id" json:"credential_id"`
+	PublicKey       []byte                  `edgedb:"public_key" json:"-"`
+	AttestationType string                  `edgedb:"attestation_type" json:"attestation_type"`
+	AAGUID          []byte                  `edgedb:"aaguid" json:"aaguid"`
+	SignCount       int64                   `edgedb:"sign_count" json:"sign_count"`
+	Transports      []string                `edgedb:"transports" json:"transports"`
+	BackupEligible  bool                    `edgedb:"backup_eligible" json:"backup_eligible"`
+	BackupState     bool                    `edgedb:"backup_state" json:"backup_state"`
+	Name            edgedb.OptionalStr      `edgedb:"name" json:"name"`
+	CreatedAt       time.Time               `edgedb:"created_at" json:"created_at"`
+	LastUsedAt      edgedb.OptionalDateTime `edgedb:"last_used_at" json:"last_used_at"`
+}
+
+// WebAuthnSession holds the serialized session data of a ceremony. Account is only set if the ceremony was started for a known account.
+type WebAuthnSession struct {
+	Id      edgedb.UUID         `edgedb:"id"`
+	Account edgedb.OptionalUUID `edgedb:"account_id"`
+	Data    []byte              `edgedb:"data"`
+}
+
+type FinishWebAuthnRegistrationRequest struct {
+	Name       string          `json:"name"`
+	Credential json.RawMessage `json:"credential"`
+}
+
+func (r *FinishWebAuthnRegistrationRequest) Validate() map[string]string {
+	var errors map[string]string = make(map[string]string)
+	if len(r.Credential) == 0 {
+		errors["credential"] = "credential is required"
+	}
+	if len(r.Name) > 64 {
+		errors["name"] = "name must not be longer than 64 characters"
+	}
+	return errors
+}
+
+type DeleteWebAuthnCredentialRequest struct {
+	ID string `json:"id"`
+}
+
+func (r *DeleteWebAuthnCredentialRequest) Validate() map[string]string {
+	var errors map[string]string = make(map[string]string)
+	if r.ID == "" {
+		errors["id"] = "id is required"
+	}
+	return errors
+}
+
+type FinishWebAuthnLoginRequest struct {
+	Credential json.RawMessage `json:"credential"`
+}
+
+func (r *FinishWebAuthnLoginRequest) Validate() map[string]string {
+	var errors map[string]string = make(map[string]string)
+	if len(r.Credential) == 0 {
+		errors["credential"] = "credential is required"
+	}
+	return errors
+}
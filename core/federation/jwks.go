@@ -0,0 +1,143 @@
+package federation
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ghostship-dev/authservice/core/config"
+)
+
+var ErrUnknownKey = errors.New("id_token is signed with an unknown key")
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type keySet struct {
+	keys      map[string]interface{}
+	fetchedAt time.Time
+}
+
+var (
+	keySets     = make(map[string]keySet)
+	keySetsLock sync.Mutex
+)
+
+// metadataTTL is how long discovery documents and key sets of providers are cached.
+func metadataTTL() time.Duration {
+	return config.GetEnvDuration("FEDERATION_METADATA_TTL", time.Hour)
+}
+
+// publicKey returns the signing key of the key set with the key id. An unknown key id refetches the key set,
+// as providers rotate keys by publishing the new key before signing with it, but at most once a minute.
+func publicKey(jwksURI, kid string) (interface{}, error) {
+	keySetsLock.Lock()
+	defer keySetsLock.Unlock()
+
+	cached, isCached := keySets[jwksURI]
+	if isCached && time.Since(cached.fetchedAt) < metadataTTL() {
+		if key, found := cached.keys[kid]; found {
+			return key, nil
+		}
+		if time.Since(cached.fetchedAt) < time.Minute {
+			return nil, ErrUnknownKey
+		}
+	}
+
+	keys, err := fetchKeySet(jwksURI)
+	if err != nil {
+		return nil, err
+	}
+	keySets[jwksURI] = keySet{keys: keys, fetchedAt: time.Now()}
+
+	if key, found := keys[kid]; found {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+func fetchKeySet(jwksURI string) (map[string]interface{}, error) {
+	var document struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := getJSON(jwksURI, "", &document); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]interface{})
+	for _, jwk := range document.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			fmt.Println(fmt.Sprintf("skipping key %s of %s: %s", jwk.Kid, jwksURI, err))
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(bytes) == 0 {
+		return nil, errors.New("malformed key parameter")
+	}
+	return new(big.Int).SetBytes(bytes), nil
+}
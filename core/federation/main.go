@@ -0,0 +1,423 @@
+package federation
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghostship-dev/authservice/core/config"
+	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/tenancy"
+	"github.com/golang-jwt/jwt/v5"
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+// BrowserCookie holds the value binding an outbound authorization request to the browser which started it.
+const BrowserCookie = "federation_browser"
+
+var (
+	ErrMissingIDToken = errors.New("provider returned no id_token")
+	ErrInvalidIDToken = errors.New("id_token is invalid")
+)
+
+// Client performs the requests to upstream providers.
+var Client = &http.Client{Timeout: 10 * time.Second}
+
+// Endpoints of an upstream provider, resolved from its preset, its discovery document and its configuration.
+type Endpoints struct {
+	Issuer                string
+	AuthorizationEndpoint string
+	TokenEndpoint         string
+	UserinfoEndpoint      string
+	JWKSURI               string
+}
+
+// Identity is the account of the user at the upstream provider.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Username      string
+	// MultiFactor is set when the provider reports it authenticated the user with more than one factor
+	MultiFactor bool
+}
+
+var presets = map[string]Endpoints{
+	datatypes.IdentityProviderGoogle: {
+		Issuer: "https://accounts.google.com",
+	},
+	// Signs in work and personal accounts of every tenant unless the issuer of a single tenant is configured
+	datatypes.IdentityProviderMicrosoft: {
+		Issuer: "https://login.microsoftonline.com/common/v2.0",
+	},
+	datatypes.IdentityProviderGitHub: {
+		AuthorizationEndpoint: "https://github.com/login/oauth/authorize",
+		TokenEndpoint:         "https://github.com/login/oauth/access_token",
+		UserinfoEndpoint:      "https://api.github.com/user",
+	},
+}
+
+// IsOIDC reports whether the provider signs in with an id_token. GitHub only supports plain OAuth2.
+func IsOIDC(provider datatypes.IdentityProvider) bool {
+	return provider.Kind != datatypes.IdentityProviderGitHub
+}
+
+// Scope returns the scope requested from the provider, which defaults to the scope identifying the user.
+func Scope(provider datatypes.IdentityProvider) []string {
+	if len(provider.Scope) > 0 {
+		return provider.Scope
+	}
+	if !IsOIDC(provider) {
+		return []string{"read:user", "user:email"}
+	}
+	return []string{"openid", "email", "profile"}
+}
+
+// ResolveEndpoints returns the endpoints of the provider. Configured endpoints take precedence over
+// the discovery document of the issuer, which takes precedence over the preset of the provider kind.
+func ResolveEndpoints(provider datatypes.IdentityProvider) (Endpoints, error) {
+	endpoints := presets[provider.Kind]
+	if issuer, isSet := provider.Issuer.Get(); isSet {
+		endpoints.Issuer = issuer
+	}
+
+	authorizationEndpoint, hasAuthorizationEndpoint := provider.AuthorizationEndpoint.Get()
+	tokenEndpoint, hasTokenEndpoint := provider.TokenEndpoint.Get()
+	jwksURI, hasJWKSURI := provider.JWKSURI.Get()
+	if IsOIDC(provider) && !(hasAuthorizationEndpoint && hasTokenEndpoint && hasJWKSURI) {
+		metadata, err := discover(endpoints.Issuer)
+		if err != nil {
+			return Endpoints{}, err
+		}
+		endpoints = metadata
+	}
+
+	if hasAuthorizationEndpoint {
+		endpoints.AuthorizationEndpoint = authorizationEndpoint
+	}
+	if hasTokenEndpoint {
+		endpoints.TokenEndpoint = tokenEndpoint
+	}
+	if userinfoEndpoint, isSet := provider.UserinfoEndpoint.Get(); isSet {
+		endpoints.UserinfoEndpoint = userinfoEndpoint
+	}
+	if hasJWKSURI {
+		endpoints.JWKSURI = jwksURI
+	}
+
+	if endpoints.AuthorizationEndpoint == "" || endpoints.TokenEndpoint == "" {
+		return Endpoints{}, fmt.Errorf("provider %s has no authorization or token endpoint", provider.Name)
+	}
+	if IsOIDC(provider) && (endpoints.Issuer == "" || endpoints.JWKSURI == "") {
+		return Endpoints{}, fmt.Errorf("provider %s has no issuer or jwks_uri", provider.Name)
+	}
+	return endpoints, nil
+}
+
+type cachedMetadata struct {
+	endpoints Endpoints
+	fetchedAt time.Time
+}
+
+var (
+	discoveryCache     = make(map[string]cachedMetadata)
+	discoveryCacheLock sync.Mutex
+)
+
+// discover reads the endpoints from the OpenID Connect discovery document of the issuer.
+func discover(issuer string) (Endpoints, error) {
+	discoveryCacheLock.Lock()
+	defer discoveryCacheLock.Unlock()
+
+	if cached, isCached := discoveryCache[issuer]; isCached && time.Since(cached.fetchedAt) < metadataTTL() {
+		return cached.endpoints, nil
+	}
+
+	var document struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		UserinfoEndpoint      string `json:"userinfo_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	if err := getJSON(strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", "", &document); err != nil {
+		return Endpoints{}, err
+	}
+
+	// Multi-tenant issuers publish a template, which is checked against the tenant of each id_token
+	if document.Issuer != issuer && !strings.Contains(document.Issuer, "{tenantid}") {
+		return Endpoints{}, fmt.Errorf("discovery document of %s is for issuer %s", issuer, document.Issuer)
+	}
+
+	endpoints := Endpoints{
+		Issuer:                document.Issuer,
+		AuthorizationEndpoint: document.AuthorizationEndpoint,
+		TokenEndpoint:         document.TokenEndpoint,
+		UserinfoEndpoint:      document.UserinfoEndpoint,
+		JWKSURI:               document.JWKSURI,
+	}
+	discoveryCache[issuer] = cachedMetadata{endpoints: endpoints, fetchedAt: time.Now()}
+	return endpoints, nil
+}
+
+// RedirectURI is where providers send the browser back to. FEDERATION_CALLBACK_URL should point to a frontend
+// which submits the state and code to the callback endpoint from the same browser, so the browser cookie is sent along.
+// It has to be registered as redirect uri of the client at the provider.
+func RedirectURI(organization datatypes.Organization) string {
+	return config.GetEnv("FEDERATION_CALLBACK_URL", tenancy.Issuer(organization)+"/login/federated/callback")
+}
+
+// StateTTL is how long the user has to sign in at the provider.
+func StateTTL() time.Duration {
+	return config.GetEnvDuration("FEDERATION_STATE_TTL", 10*time.Minute)
+}
+
+// NewRandom returns a random value for the state, nonce, PKCE code verifier and browser cookie.
+func NewRandom() (string, error) {
+	return gonanoid.New(48)
+}
+
+// SetBrowserCookie stores the value binding authorization requests to the browser until the latest request expires.
+// FEDERATION_COOKIE_DOMAIN shares the cookie with the subdomains serving the frontend.
+func SetBrowserCookie(w http.ResponseWriter, value string, expiresAt time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     BrowserCookie,
+		Value:    value,
+		Path:     "/",
+		Domain:   config.GetEnv("FEDERATION_COOKIE_DOMAIN", ""),
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   config.GetEnvBool("FEDERATION_COOKIE_SECURE", true),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// CodeChallenge returns the S256 PKCE code challenge of the verifier.
+func CodeChallenge(codeVerifier string) string {
+	digest := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
+
+// AuthorizationURL returns the URL to send the browser to for signing in at the provider.
+func AuthorizationURL(provider datatypes.IdentityProvider, endpoints Endpoints, state, nonce, codeVerifier string) string {
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {provider.ClientID},
+		"redirect_uri":          {RedirectURI(provider.Organization)},
+		"scope":                 {strings.Join(Scope(provider), " ")},
+		"state":                 {state},
+		"code_challenge":        {CodeChallenge(codeVerifier)},
+		"code_challenge_method": {"S256"},
+	}
+	if IsOIDC(provider) {
+		params.Set("nonce", nonce)
+	}
+	separator := "?"
+	if strings.Contains(endpoints.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return endpoints.AuthorizationEndpoint + separator + params.Encode()
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Authenticate redeems the authorization code at the provider and returns the identity it was issued for.
+// The id_token of OpenID Connect providers is validated against the key set of the provider and the nonce.
+func Authenticate(provider datatypes.IdentityProvider, endpoints Endpoints, code, codeVerifier, nonce string) (Identity, error) {
+	clientSecret, err := OpenClientSecret(provider)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {RedirectURI(provider.Organization)},
+		"client_id":     {provider.ClientID},
+		"client_secret": {clientSecret},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoints.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Identity{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var tokens tokenResponse
+	if err = doJSON(req, &tokens); err != nil && tokens.Error == "" {
+		return Identity{}, err
+	}
+	if tokens.Error != "" {
+		return Identity{}, fmt.Errorf("token endpoint of %s responded with %s: %s", provider.Name, tokens.Error, tokens.ErrorDescription)
+	}
+
+	if !IsOIDC(provider) {
+		return gitHubIdentity(endpoints, tokens.AccessToken)
+	}
+
+	if tokens.IDToken == "" {
+		return Identity{}, ErrMissingIDToken
+	}
+	claims, err := validateIDToken(provider, endpoints, tokens.IDToken, nonce)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	identity := Identity{
+		Subject:       stringClaim(claims, "sub"),
+		Email:         stringClaim(claims, "email"),
+		EmailVerified: boolClaim(claims, "email_verified"),
+		Name:          stringClaim(claims, "name"),
+		Username:      stringClaim(claims, "preferred_username"),
+		MultiFactor:   hasMultiFactorClaim(claims),
+	}
+
+	// Some providers only return the email from the userinfo endpoint
+	if identity.Email == "" && endpoints.UserinfoEndpoint != "" && tokens.AccessToken != "" {
+		var userinfo map[string]interface{}
+		if err = getJSON(endpoints.UserinfoEndpoint, tokens.AccessToken, &userinfo); err == nil && stringClaim(userinfo, "sub") == identity.Subject {
+			identity.Email = stringClaim(userinfo, "email")
+			identity.EmailVerified = boolClaim(userinfo, "email_verified")
+		}
+	}
+	return identity, nil
+}
+
+func validateIDToken(provider datatypes.IdentityProvider, endpoints Endpoints, idToken, nonce string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(idToken, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return publicKey(endpoints.JWKSURI, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}),
+		jwt.WithAudience(provider.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidIDToken, err)
+	}
+	claims := token.Claims.(jwt.MapClaims)
+
+	issuer := strings.ReplaceAll(endpoints.Issuer, "{tenantid}", stringClaim(claims, "tid"))
+	if stringClaim(claims, "iss") != issuer {
+		return nil, fmt.Errorf("%w: issuer %s is not %s", ErrInvalidIDToken, stringClaim(claims, "iss"), issuer)
+	}
+	if subtle.ConstantTimeCompare([]byte(stringClaim(claims, "nonce")), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+	if stringClaim(claims, "sub") == "" {
+		return nil, fmt.Errorf("%w: sub is missing", ErrInvalidIDToken)
+	}
+	return claims, nil
+}
+
+// gitHubIdentity reads the user and its primary email from the GitHub API, as GitHub issues no id_token.
+func gitHubIdentity(endpoints Endpoints, accessToken string) (Identity, error) {
+	var user struct {
+		Id    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := getJSON(endpoints.UserinfoEndpoint, accessToken, &user); err != nil {
+		return Identity{}, err
+	}
+	if user.Id == 0 {
+		return Identity{}, errors.New("github returned no user id")
+	}
+	identity := Identity{Subject: strconv.FormatInt(user.Id, 10), Name: user.Name, Username: user.Login}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getJSON(strings.TrimSuffix(endpoints.UserinfoEndpoint, "/user")+"/user/emails", accessToken, &emails); err != nil {
+		return Identity{}, err
+	}
+	for _, email := range emails {
+		if email.Primary {
+			identity.Email = email.Email
+			identity.EmailVerified = email.Verified
+		}
+	}
+	return identity, nil
+}
+
+func getJSON(uri, accessToken string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	return doJSON(req, v)
+}
+
+// doJSON decodes the response into v. Error responses are decoded as well, as token endpoints describe errors in their body.
+func doJSON(req *http.Request, v interface{}) error {
+	resp, err := Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	decodeErr := json.Unmarshal(body, v)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s responded with %d", req.URL.Host, resp.StatusCode)
+	}
+	return decodeErr
+}
+
+func stringClaim(claims map[string]interface{}, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+// boolClaim also accepts "true", which some providers send for email_verified.
+func boolClaim(claims map[string]interface{}, name string) bool {
+	switch value := claims[name].(type) {
+	case bool:
+		return value
+	case string:
+		return value == "true"
+	}
+	return false
+}
+
+// hasMultiFactorClaim reports whether the amr claim lists multi-factor authentication.
+func hasMultiFactorClaim(claims map[string]interface{}) bool {
+	methods, _ := claims["amr"].([]interface{})
+	for _, method := range methods {
+		if method == "mfa" {
+			return true
+		}
+	}
+	return false
+}
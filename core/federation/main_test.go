@@ -0,0 +1,411 @@
+package federation
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testClientID     = "authservice"
+	testClientSecret = "client-secret"
+	testNonce        = "nonce-of-the-request"
+	testCodeVerifier = "code-verifier-of-the-request-which-is-long-enough"
+)
+
+// fakeProvider is an OpenID Connect provider issuing id_tokens signed with the keys it publishes.
+type fakeProvider struct {
+	server *httptest.Server
+	// issuer the discovery document announces, the issuer URL unless a tenant template is tested
+	discoveryIssuer string
+
+	lock         sync.Mutex
+	keys         map[string]*rsa.PrivateKey
+	published    []string
+	codes        map[string]authorization
+	jwksRequests int
+}
+
+// authorization is an issued authorization code with the PKCE challenge it was requested with.
+type authorization struct {
+	codeChallenge string
+	idToken       string
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	t.Helper()
+	p := &fakeProvider{keys: make(map[string]*rsa.PrivateKey), codes: make(map[string]authorization)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/common/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/jwks", p.jwks)
+	mux.HandleFunc("/token", p.token)
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+
+	p.discoveryIssuer = p.server.URL
+	return p
+}
+
+// addKey generates a signing key, which is only published in the key set with publish.
+func (p *fakeProvider) addKey(t *testing.T, kid string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.keys[kid] = key
+}
+
+// publish replaces the key set with the keys of the key ids, like a provider rotating its keys.
+func (p *fakeProvider) publish(kids ...string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.published = kids
+}
+
+func (p *fakeProvider) discovery(w http.ResponseWriter, _ *http.Request) {
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 p.discoveryIssuer,
+		"authorization_endpoint": p.server.URL + "/authorize",
+		"token_endpoint":         p.server.URL + "/token",
+		"jwks_uri":               p.server.URL + "/jwks",
+	})
+}
+
+func (p *fakeProvider) jwks(w http.ResponseWriter, _ *http.Request) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.jwksRequests++
+
+	var keys []jsonWebKey
+	for _, kid := range p.published {
+		publicKey := p.keys[kid].PublicKey
+		keys = append(keys, jsonWebKey{
+			Kty: "RSA",
+			Kid: kid,
+			Use: "sig",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+		})
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
+
+// token redeems an authorization code, verifying the client and the PKCE code verifier like a provider does.
+func (p *fakeProvider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	p.lock.Lock()
+	issued, found := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.lock.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.PostForm.Get("client_id") != testClientID || r.PostForm.Get("client_secret") != testClientSecret:
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+	case !found || r.PostForm.Get("grant_type") != "authorization_code" || CodeChallenge(r.PostForm.Get("code_verifier")) != issued.codeChallenge:
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+	default:
+		_ = json.NewEncoder(w).Encode(map[string]string{"access_token": "access-token", "token_type": "Bearer", "id_token": issued.idToken})
+	}
+}
+
+// authorize issues an authorization code for the id_token to the authorization request.
+func (p *fakeProvider) authorize(t *testing.T, authorizationURL, idToken string) string {
+	t.Helper()
+	parsed, err := url.Parse(authorizationURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Fatalf("authorization request without S256 code challenge: %s", authorizationURL)
+	}
+
+	code := "code-" + query.Get("state")
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.codes[code] = authorization{codeChallenge: query.Get("code_challenge"), idToken: idToken}
+	return code
+}
+
+func (p *fakeProvider) sign(t *testing.T, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	p.lock.Lock()
+	key := p.keys[kid]
+	p.lock.Unlock()
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func (p *fakeProvider) identityProvider() datatypes.IdentityProvider {
+	return datatypes.IdentityProvider{
+		Name:         "fake",
+		Kind:         datatypes.IdentityProviderOIDC,
+		Issuer:       edgedb.NewOptionalStr(p.server.URL),
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		Enabled:      true,
+	}
+}
+
+func (p *fakeProvider) claims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":            p.server.URL,
+		"aud":            testClientID,
+		"sub":            "user-1",
+		"nonce":          testNonce,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"email":          "user@example.com",
+		"email_verified": true,
+	}
+}
+
+// signIn runs the authorization code flow for the id_token and returns the identity Authenticate validated.
+func (p *fakeProvider) signIn(t *testing.T, provider datatypes.IdentityProvider, state, idToken string) (Identity, error) {
+	t.Helper()
+	endpoints, err := ResolveEndpoints(provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := p.authorize(t, AuthorizationURL(provider, endpoints, state, testNonce, testCodeVerifier), idToken)
+	return Authenticate(provider, endpoints, code, testCodeVerifier, testNonce)
+}
+
+func TestAuthorizationURL(t *testing.T) {
+	p := newFakeProvider(t)
+	provider := p.identityProvider()
+	endpoints, err := ResolveEndpoints(provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := url.Parse(AuthorizationURL(provider, endpoints, "state", testNonce, testCodeVerifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	if parsed.Path != "/authorize" || query.Get("client_id") != testClientID || query.Get("state") != "state" || query.Get("nonce") != testNonce {
+		t.Fatalf("unexpected authorization request %s", parsed)
+	}
+	if query.Get("scope") != "openid email profile" {
+		t.Fatalf("expected the default scope, got %q", query.Get("scope"))
+	}
+	// RFC 7636 appendix B
+	if CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk") != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Fatal("code challenge does not match the S256 transformation of the verifier")
+	}
+	if query.Get("code_challenge") != CodeChallenge(testCodeVerifier) || query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("expected the S256 code challenge of the verifier, got %q", query.Get("code_challenge"))
+	}
+	if strings.Contains(parsed.RawQuery, testCodeVerifier) {
+		t.Fatal("the code verifier must not be sent with the authorization request")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	p := newFakeProvider(t)
+	p.addKey(t, "key-1")
+	p.publish("key-1")
+
+	claims := p.claims()
+	claims["amr"] = []string{"pwd", "mfa"}
+	identity, err := p.signIn(t, p.identityProvider(), "state", p.sign(t, "key-1", claims))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Subject != "user-1" || identity.Email != "user@example.com" || !identity.EmailVerified || !identity.MultiFactor {
+		t.Fatalf("unexpected identity %+v", identity)
+	}
+}
+
+func TestAuthenticateRequiresCodeVerifier(t *testing.T) {
+	p := newFakeProvider(t)
+	p.addKey(t, "key-1")
+	p.publish("key-1")
+	provider := p.identityProvider()
+
+	endpoints, err := ResolveEndpoints(provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := p.authorize(t, AuthorizationURL(provider, endpoints, "state", testNonce, testCodeVerifier), p.sign(t, "key-1", p.claims()))
+	if _, err = Authenticate(provider, endpoints, code, "code-verifier-of-another-request", testNonce); err == nil {
+		t.Fatal("code redeemed with the verifier of another request")
+	}
+}
+
+func TestAuthenticateRejectsInvalidIDTokens(t *testing.T) {
+	p := newFakeProvider(t)
+	p.addKey(t, "key-1")
+	p.addKey(t, "unpublished")
+	p.publish("key-1")
+
+	tests := []struct {
+		name    string
+		idToken func() string
+	}{
+		{"other issuer", func() string {
+			claims := p.claims()
+			claims["iss"] = "https://attacker.example.com"
+			return p.sign(t, "key-1", claims)
+		}},
+		{"other audience", func() string {
+			claims := p.claims()
+			claims["aud"] = "other-client"
+			return p.sign(t, "key-1", claims)
+		}},
+		{"other nonce", func() string {
+			claims := p.claims()
+			claims["nonce"] = "nonce-of-another-request"
+			return p.sign(t, "key-1", claims)
+		}},
+		{"without nonce", func() string {
+			claims := p.claims()
+			delete(claims, "nonce")
+			return p.sign(t, "key-1", claims)
+		}},
+		{"expired", func() string {
+			claims := p.claims()
+			claims["iat"] = time.Now().Add(-time.Hour).Unix()
+			claims["exp"] = time.Now().Add(-10 * time.Minute).Unix()
+			return p.sign(t, "key-1", claims)
+		}},
+		{"without expiry", func() string {
+			claims := p.claims()
+			delete(claims, "exp")
+			return p.sign(t, "key-1", claims)
+		}},
+		{"issued in the future", func() string {
+			claims := p.claims()
+			claims["iat"] = time.Now().Add(time.Hour).Unix()
+			claims["exp"] = time.Now().Add(2 * time.Hour).Unix()
+			return p.sign(t, "key-1", claims)
+		}},
+		{"without subject", func() string {
+			claims := p.claims()
+			delete(claims, "sub")
+			return p.sign(t, "key-1", claims)
+		}},
+		{"unpublished key", func() string {
+			return p.sign(t, "unpublished", p.claims())
+		}},
+		{"tampered payload", func() string {
+			parts := strings.Split(p.sign(t, "key-1", p.claims()), ".")
+			claims := p.claims()
+			claims["sub"] = "admin"
+			payload, _ := json.Marshal(claims)
+			return parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+		}},
+		{"hmac with the client secret", func() string {
+			token := jwt.NewWithClaims(jwt.SigningMethodHS256, p.claims())
+			token.Header["kid"] = "key-1"
+			signed, _ := token.SignedString([]byte(testClientSecret))
+			return signed
+		}},
+		{"unsigned", func() string {
+			token := jwt.NewWithClaims(jwt.SigningMethodNone, p.claims())
+			token.Header["kid"] = "key-1"
+			signed, _ := token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+			return signed
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := p.signIn(t, p.identityProvider(), test.name, test.idToken())
+			if !errors.Is(err, ErrInvalidIDToken) {
+				t.Fatalf("expected ErrInvalidIDToken, got %v", err)
+			}
+		})
+	}
+}
+
+func TestAuthenticateFollowsKeyRotation(t *testing.T) {
+	p := newFakeProvider(t)
+	p.addKey(t, "key-1")
+	p.addKey(t, "key-2")
+	p.publish("key-1")
+	provider := p.identityProvider()
+
+	if _, err := p.signIn(t, provider, "state-1", p.sign(t, "key-1", p.claims())); err != nil {
+		t.Fatal(err)
+	}
+
+	// The provider rotates to a new key. The key set was just fetched, so it is not refetched right away.
+	p.publish("key-2")
+	if _, err := p.signIn(t, provider, "state-2", p.sign(t, "key-2", p.claims())); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("expected ErrUnknownKey while the key set was just fetched, got %v", err)
+	}
+	if p.jwksRequests != 1 {
+		t.Fatalf("expected the key set to be fetched once, got %d", p.jwksRequests)
+	}
+
+	keySetsLock.Lock()
+	cached := keySets[p.server.URL+"/jwks"]
+	cached.fetchedAt = cached.fetchedAt.Add(-2 * time.Minute)
+	keySets[p.server.URL+"/jwks"] = cached
+	keySetsLock.Unlock()
+
+	if _, err := p.signIn(t, provider, "state-3", p.sign(t, "key-2", p.claims())); err != nil {
+		t.Fatalf("expected the new key to be picked up, got %v", err)
+	}
+	if p.jwksRequests != 2 {
+		t.Fatalf("expected the key set to be refetched once, got %d", p.jwksRequests)
+	}
+
+	if _, err := p.signIn(t, provider, "state-4", p.sign(t, "key-1", p.claims())); !errors.Is(err, ErrInvalidIDToken) {
+		t.Fatalf("expected the retired key to be rejected, got %v", err)
+	}
+}
+
+func TestAuthenticateWithTenantIssuer(t *testing.T) {
+	p := newFakeProvider(t)
+	p.addKey(t, "key-1")
+	p.publish("key-1")
+	p.discoveryIssuer = p.server.URL + "/{tenantid}"
+	provider := p.identityProvider()
+	provider.Issuer = edgedb.NewOptionalStr(p.server.URL + "/common")
+
+	claims := p.claims()
+	claims["iss"] = p.server.URL + "/tenant-a"
+	claims["tid"] = "tenant-a"
+	if _, err := p.signIn(t, provider, "state-1", p.sign(t, "key-1", claims)); err != nil {
+		t.Fatal(err)
+	}
+
+	claims["tid"] = "tenant-b"
+	if _, err := p.signIn(t, provider, "state-2", p.sign(t, "key-1", claims)); !errors.Is(err, ErrInvalidIDToken) {
+		t.Fatalf("expected an issuer of another tenant to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package federation
+
+import (
+	"fmt"
+
+	"github.com/ghostship-dev/authservice/core/database"
+	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/secrets"
+)
+
+func clientSecretAssociatedData(provider datatypes.IdentityProvider) []byte {
+	return []byte(provider.Organization.Id.String() + ":" + provider.Name)
+}
+
+// SealClientSecret encrypts the client secret of the provider for storage.
+// While no master key is configured the client secret is stored as it is.
+func SealClientSecret(provider datatypes.IdentityProvider, clientSecret string) (string, error) {
+	if !secrets.IsConfigured() {
+		return clientSecret, nil
+	}
+	return secrets.Encrypt([]byte(clientSecret), clientSecretAssociatedData(provider))
+}
+
+// OpenClientSecret decrypts the stored client secret of the provider. Client secrets stored
+// while no master key was configured are returned as they are until ReencryptSecrets ran.
+func OpenClientSecret(provider datatypes.IdentityProvider) (string, error) {
+	if !secrets.IsEncrypted(provider.ClientSecret) {
+		return provider.ClientSecret, nil
+	}
+	clientSecret, err := secrets.Decrypt(provider.ClientSecret, clientSecretAssociatedData(provider))
+	if err != nil {
+		return "", err
+	}
+	return string(clientSecret), nil
+}
+
+// ReencryptSecrets seals the client secrets of every provider with the current master key.
+// It returns the number of secrets which were re-encrypted.
+func ReencryptSecrets() (int, error) {
+	providers, err := database.Connection.Queries.GetIdentityProviderSecrets()
+	if err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for _, provider := range providers {
+		if !secrets.NeedsReencryption(provider.ClientSecret) {
+			continue
+		}
+
+		clientSecret, err := OpenClientSecret(provider)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("decrypting the client secret of identity provider %s failed: %s", provider.Name, err))
+			continue
+		}
+		sealedSecret, err := SealClientSecret(provider, clientSecret)
+		if err != nil {
+			return updated, err
+		}
+
+		replaced, err := database.Connection.Queries.ReplaceIdentityProviderSecret(provider.Id, provider.ClientSecret, sealedSecret)
+		if err != nil {
+			return updated, err
+		}
+		if replaced {
+			updated++
+		}
+	}
+	return updated, nil
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/ghostship-dev/authservice/core/authn"
+	"github.com/ghostship-dev/authservice/core/authorization"
+	"github.com/ghostship-dev/authservice/core/database"
+	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/responses"
+)
+
+func OAuthConsent(w http.ResponseWriter, r *http.Request) error {
+	var reqData datatypes.OAuthConsentRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	principal := authorization.GetPrincipal(r)
+
+	authCode, err := database.Connection.Queries.GetOAuth2AuthorizationCode(reqData.Code)
+	if err != nil {
+		return responses.UnauthorizedErrorResponse("invalid authorization code")
+	}
+
+	if authCode.Account.Id != principal.Account.Id {
+		return responses.UnauthorizedErrorResponse("invalid authorization code")
+	}
+
+	if authCode.ExpiresAt.Before(time.Now()) {
+		return responses.UnauthorizedErrorResponse("authorization code expired")
+	}
+
+	if reqData.Denied {
+		if err = database.Connection.Queries.DeleteOAuth2AuthorizationCode(authCode.Code); err != nil {
+			return responses.InternalServerErrorResponse()
+		}
+		return responses.SendOAuthConsentDeniedResponse(w, authCode)
+	}
+
+	var invalidScope []string
+	for _, scope := range reqData.Scope {
+		if !slices.Contains(authCode.RequestedScope, scope) {
+			invalidScope = append(invalidScope, scope)
+		}
+	}
+	if len(invalidScope) > 0 {
+		return responses.OAuth2InvalidScope(invalidScope)
+	}
+
+	// The client asked for a stronger or more recent authentication than the session has
+	authentication := principal.Token.Authentication()
+	if !authn.Satisfies(authentication, authCode.ACRValues, authCode.MaxAge) {
+		return responses.InsufficientUserAuthenticationResponse(authCode.ACRValues, authCode.MaxAge)
+	}
+
+	if err = database.Connection.Queries.ConsentToOAuth2AuthorizationCode(authCode.Code, reqData.Scope, authentication); err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	if err = database.Connection.Queries.SaveOAuth2Consent(authCode.Account.Id, authCode.Application.ID, reqData.Scope); err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	return responses.SendOAuthConsentGrantedResponse(w, authCode)
+}
+
+func GetOAuthConsentDetails(w http.ResponseWriter, r *http.Request) error {
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		return responses.ValidationErrorResponse(map[string]string{"code": "code is required"})
+	}
+
+	principal := authorization.GetPrincipal(r)
+
+	authCode, err := database.Connection.Queries.GetOAuth2AuthorizationCode(code)
+	if err != nil || authCode.Account.Id != principal.Account.Id {
+		return responses.UnauthorizedErrorResponse("invalid authorization code")
+	}
+
+	if authCode.ExpiresAt.Before(time.Now()) {
+		return responses.UnauthorizedErrorResponse("authorization code expired")
+	}
+
+	reauthenticate := !authn.Satisfies(principal.Token.Authentication(), authCode.ACRValues, authCode.MaxAge)
+	return responses.SendOAuthConsentDetailsResponse(w, authCode, reauthenticate)
+}
+
+func ListAuthorizedApplications(w http.ResponseWriter, r *http.Request) error {
+	principal := authorization.GetPrincipal(r)
+
+	consents, err := database.Connection.Queries.GetAccountOAuth2Consents(principal.Account.Id)
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	return responses.SendAuthorizedApplicationsResponse(w, consents)
+}
+
+func RevokeAuthorizedApplication(w http.ResponseWriter, r *http.Request) error {
+	var reqData datatypes.RevokeOAuthConsentRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	principal := authorization.GetPrincipal(r)
+
+	if err := database.Connection.Queries.RevokeOAuth2Consent(principal.Account.Id, reqData.ClientID); err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	return responses.SendNewOKResponseMessage(w, "application access revoked successfully")
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/ghostship-dev/authservice/core/authn"
+	"github.com/ghostship-dev/authservice/core/responses"
+	"github.com/ghostship-dev/authservice/core/scopes"
+	"github.com/ghostship-dev/authservice/core/tenancy"
+)
+
+type openIDConfiguration struct {
+	Issuer                             string   `json:"issuer"`
+	AuthorizationEndpoint              string   `json:"authorization_endpoint"`
+	TokenEndpoint                      string   `json:"token_endpoint"`
+	IntrospectionEndpoint              string   `json:"introspection_endpoint"`
+	RevocationEndpoint                 string   `json:"revocation_endpoint"`
+	EndSessionEndpoint                 string   `json:"end_session_endpoint"`
+	ScopesSupported                    []string `json:"scopes_supported"`
+	ResponseTypesSupported             []string `json:"response_types_supported"`
+	SubjectTypesSupported              []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported   []string `json:"id_token_signing_alg_values_supported"`
+	ACRValuesSupported                 []string `json:"acr_values_supported"`
+	FrontchannelLogoutSupported        bool     `json:"frontchannel_logout_supported"`
+	FrontchannelLogoutSessionSupported bool     `json:"frontchannel_logout_session_supported"`
+	BackchannelLogoutSupported         bool     `json:"backchannel_logout_supported"`
+	BackchannelLogoutSessionSupported  bool     `json:"backchannel_logout_session_supported"`
+}
+
+func OpenIDConfiguration(w http.ResponseWriter, r *http.Request) error {
+	issuer := tenancy.Issuer(tenancy.GetOrganization(r))
+
+	var supportedScopes []string
+	for _, scope := range scopes.All() {
+		if scopes.IsScopeAllowed(scope.Name) {
+			supportedScopes = append(supportedScopes, scope.Name)
+		}
+	}
+
+	return responses.NewJSONResponse(w, http.StatusOK, openIDConfiguration{
+		Issuer:                             issuer,
+		AuthorizationEndpoint:              issuer + "/oauth/authorize",
+		TokenEndpoint:                      issuer + "/oauth/token",
+		IntrospectionEndpoint:              issuer + "/oauth/token/introspect",
+		RevocationEndpoint:                 issuer + "/oauth/token/revoke",
+		EndSessionEndpoint:                 issuer + "/oauth/logout",
+		ScopesSupported:                    supportedScopes,
+		ResponseTypesSupported:             []string{"code"},
+		SubjectTypesSupported:              []string{"public"},
+		IDTokenSigningAlgValuesSupported:   []string{"HS256"},
+		ACRValuesSupported:                 []string{authn.ACRPassword, authn.ACRMultiFactor, authn.ACRPhishingResistant},
+		FrontchannelLogoutSupported:        true,
+		FrontchannelLogoutSessionSupported: false,
+		BackchannelLogoutSupported:         true,
+		BackchannelLogoutSessionSupported:  false,
+	})
+}
@@ -0,0 +1,516 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/ghostship-dev/authservice/core/authn"
+	"github.com/ghostship-dev/authservice/core/authorization"
+	"github.com/ghostship-dev/authservice/core/config"
+	"github.com/ghostship-dev/authservice/core/database"
+	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/federation"
+	"github.com/ghostship-dev/authservice/core/passwords"
+	"github.com/ghostship-dev/authservice/core/profile"
+	"github.com/ghostship-dev/authservice/core/responses"
+	"github.com/ghostship-dev/authservice/core/saml"
+	"github.com/ghostship-dev/authservice/core/tenancy"
+	"github.com/ghostship-dev/authservice/core/utility"
+	"github.com/ghostship-dev/authservice/core/verification"
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+var usernameDisallowedCharacters = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+func ListIdentityProviders(w http.ResponseWriter, r *http.Request) error {
+	providers, err := database.Connection.Queries.GetIdentityProviders(tenancy.GetOrganization(r).Id)
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+	return responses.SendIdentityProvidersResponse(w, providers)
+}
+
+func CreateIdentityProvider(w http.ResponseWriter, r *http.Request) error {
+	provider, err := decodeIdentityProvider(r)
+	if err != nil {
+		return err
+	}
+
+	if err = database.Connection.Queries.CreateIdentityProvider(provider); err != nil {
+		var edbErr edgedb.Error
+		if errors.As(err, &edbErr) && edbErr.Category(edgedb.ConstraintViolationError) {
+			return responses.IdentityProviderNameInUseResponse()
+		}
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	return responses.SendNewOKResponseMessage(w, "identity provider created successfully")
+}
+
+func UpdateIdentityProvider(w http.ResponseWriter, r *http.Request) error {
+	provider, err := decodeIdentityProvider(r)
+	if err != nil {
+		return err
+	}
+
+	updated, err := database.Connection.Queries.UpdateIdentityProvider(provider)
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+	if !updated {
+		return responses.IdentityProviderNotFoundResponse()
+	}
+
+	return responses.SendNewOKResponseMessage(w, "identity provider updated successfully")
+}
+
+// decodeIdentityProvider reads the provider of the request for the organization of the request and seals its client secret.
+func decodeIdentityProvider(r *http.Request) (datatypes.IdentityProvider, error) {
+	var reqData datatypes.IdentityProviderRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return datatypes.IdentityProvider{}, responses.BadRequestResponse()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return datatypes.IdentityProvider{}, responses.ValidationErrorResponse(validationErrors)
+	}
+
+	if reqData.Kind == datatypes.IdentityProviderSAML {
+		if _, err := saml.ParseCertificates(reqData.SAMLCertificates); err != nil {
+			return datatypes.IdentityProvider{}, responses.ValidationErrorResponse(map[string]string{"saml_certificates": err.Error()})
+		}
+	}
+
+	provider := reqData.ToIdentityProvider(tenancy.GetOrganization(r))
+	clientSecret, err := federation.SealClientSecret(provider, reqData.ClientSecret)
+	if err != nil {
+		fmt.Println(err)
+		return datatypes.IdentityProvider{}, responses.InternalServerErrorResponse()
+	}
+	provider.ClientSecret = clientSecret
+	return provider, nil
+}
+
+func DeleteIdentityProvider(w http.ResponseWriter, r *http.Request) error {
+	var reqData datatypes.DeleteIdentityProviderRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	deleted, err := database.Connection.Queries.DeleteIdentityProvider(tenancy.GetOrganization(r).Id, reqData.Name)
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+	if !deleted {
+		return responses.IdentityProviderNotFoundResponse()
+	}
+
+	return responses.SendNewOKResponseMessage(w, "identity provider deleted successfully")
+}
+
+// ListLoginProviders lists the providers the login page offers to sign in with.
+func ListLoginProviders(w http.ResponseWriter, r *http.Request) error {
+	providers, err := database.Connection.Queries.GetIdentityProviders(tenancy.GetOrganization(r).Id)
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+	return responses.SendLoginProvidersResponse(w, providers)
+}
+
+// StartFederatedLogin redirects the browser to the provider of the provider query parameter.
+func StartFederatedLogin(w http.ResponseWriter, r *http.Request) error {
+	name := r.URL.Query().Get("provider")
+	if name == "" {
+		return responses.ValidationErrorResponse(map[string]string{"provider": "provider is required"})
+	}
+
+	provider, err := findEnabledIdentityProvider(tenancy.GetOrganization(r), name)
+	if err != nil {
+		return err
+	}
+
+	authorizationURL, err := beginFederation(w, r, provider, edgedb.OptionalUUID{})
+	if err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, authorizationURL, http.StatusSeeOther)
+	return nil
+}
+
+// FederatedLoginCallback completes the authorization request of the state with the code the provider sent the browser back with.
+// It signs in the account linked to the identity, creates one if the provider allows signups, or links the identity
+// to the account which started the request.
+func FederatedLoginCallback(w http.ResponseWriter, r *http.Request) error {
+	var reqData datatypes.FederatedCallbackRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	browser, err := r.Cookie(federation.BrowserCookie)
+	if err != nil || browser.Value == "" {
+		return responses.InvalidFederationStateResponse()
+	}
+
+	organization := tenancy.GetOrganization(r)
+	state, err := database.Connection.Queries.ConsumeFederationState(organization.Id, utility.HashToken(reqData.State), utility.HashToken(browser.Value))
+	if err != nil {
+		var edbErr edgedb.Error
+		if errors.As(err, &edbErr) && edbErr.Category(edgedb.NoDataError) {
+			return responses.InvalidFederationStateResponse()
+		}
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	provider := state.Provider
+	if reqData.Error != "" {
+		fmt.Println(fmt.Sprintf("identity provider %s returned %s: %s", provider.Name, reqData.Error, reqData.ErrorDescription))
+		return responses.FederatedLoginFailedResponse()
+	}
+	if !provider.Enabled {
+		return responses.IdentityProviderNotFoundResponse()
+	}
+
+	var identity federation.Identity
+	if provider.Kind == datatypes.IdentityProviderSAML {
+		identity, err = samlIdentity(state, reqData.Code)
+	} else {
+		var endpoints federation.Endpoints
+		if endpoints, err = federation.ResolveEndpoints(provider); err != nil {
+			fmt.Println(err)
+			return responses.IdentityProviderUnavailableResponse()
+		}
+		identity, err = federation.Authenticate(provider, endpoints, reqData.Code, state.CodeVerifier, state.Nonce)
+	}
+	if err != nil {
+		fmt.Println(fmt.Sprintf("signing in with identity provider %s failed: %s", provider.Name, err))
+		return responses.FederatedLoginFailedResponse()
+	}
+
+	var upstreamMethods []string
+	if identity.MultiFactor {
+		upstreamMethods = append(upstreamMethods, authn.AMRMultiFactor)
+	}
+
+	email := edgedb.OptionalStr{}
+	if identity.Email != "" {
+		email = edgedb.NewOptionalStr(identity.Email)
+	}
+
+	if accountId, isLinking := state.LinkAccountId.Get(); isLinking {
+		if err = database.Connection.Queries.CreateFederatedIdentity(accountId, provider.Id, identity.Subject, email); err != nil {
+			var edbErr edgedb.Error
+			if errors.As(err, &edbErr) && edbErr.Category(edgedb.ConstraintViolationError) {
+				return responses.FederatedIdentityAlreadyLinkedResponse()
+			}
+			fmt.Println(err)
+			return responses.InternalServerErrorResponse()
+		}
+		return responses.SendNewOKResponseMessage(w, "identity provider linked successfully")
+	}
+
+	federatedIdentity, err := database.Connection.Queries.GetFederatedIdentity(provider.Id, identity.Subject)
+	if err != nil {
+		var edbErr edgedb.Error
+		if !errors.As(err, &edbErr) || !edbErr.Category(edgedb.NoDataError) {
+			fmt.Println(err)
+			return responses.InternalServerErrorResponse()
+		}
+		if !provider.AllowSignup {
+			return responses.FederatedIdentityNotLinkedResponse()
+		}
+		account, err := createFederatedAccount(organization, provider, identity)
+		if err != nil {
+			return err
+		}
+		return completeFirstFactor(w, organization, account, authn.AMRFederated, upstreamMethods...)
+	}
+
+	if err = database.Connection.Queries.UpdateFederatedIdentityLogin(federatedIdentity.Id, email); err != nil {
+		fmt.Println(err)
+	}
+
+	account, err := database.Connection.Queries.GetLoginAccount(organization.Id, federatedIdentity.AccountId)
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	return completeFirstFactor(w, organization, account, authn.AMRFederated, upstreamMethods...)
+}
+
+func GetFederatedIdentities(w http.ResponseWriter, r *http.Request) error {
+	principal := authorization.GetPrincipal(r)
+
+	identities, err := database.Connection.Queries.GetFederatedIdentities(principal.Account.Id)
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	return responses.SendFederatedIdentitiesResponse(w, identities)
+}
+
+// LinkFederatedIdentity returns the URL to send the browser to for linking the provider to the account.
+// The link is completed by the callback endpoint.
+func LinkFederatedIdentity(w http.ResponseWriter, r *http.Request) error {
+	var reqData datatypes.FederatedIdentityRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	provider, err := findEnabledIdentityProvider(tenancy.GetOrganization(r), reqData.Provider)
+	if err != nil {
+		return err
+	}
+
+	principal := authorization.GetPrincipal(r)
+	authorizationURL, err := beginFederation(w, r, provider, edgedb.NewOptionalUUID(principal.Account.Id))
+	if err != nil {
+		return err
+	}
+
+	return responses.SendFederationAuthorizationURLResponse(w, authorizationURL)
+}
+
+// UnlinkFederatedIdentity removes the provider from the sign in methods of the account.
+// The account keeps its password, which accounts created by a provider can set with a password reset.
+func UnlinkFederatedIdentity(w http.ResponseWriter, r *http.Request) error {
+	var reqData datatypes.FederatedIdentityRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	principal := authorization.GetPrincipal(r)
+
+	deleted, err := database.Connection.Queries.DeleteFederatedIdentity(principal.Account.Id, reqData.Provider)
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+	if !deleted {
+		return responses.FederatedIdentityNotFoundResponse()
+	}
+
+	return responses.SendNewOKResponseMessage(w, "identity provider unlinked successfully")
+}
+
+func findEnabledIdentityProvider(organization datatypes.Organization, name string) (datatypes.IdentityProvider, error) {
+	provider, err := database.Connection.Queries.GetIdentityProvider(organization.Id, name)
+	if err != nil {
+		var edbErr edgedb.Error
+		if errors.As(err, &edbErr) && edbErr.Category(edgedb.NoDataError) {
+			return datatypes.IdentityProvider{}, responses.IdentityProviderNotFoundResponse()
+		}
+		fmt.Println(err)
+		return datatypes.IdentityProvider{}, responses.InternalServerErrorResponse()
+	}
+	if !provider.Enabled {
+		return datatypes.IdentityProvider{}, responses.IdentityProviderNotFoundResponse()
+	}
+	return provider, nil
+}
+
+// beginFederation stores an authorization request with PKCE for the provider, bound to the browser by a cookie,
+// and returns the URL to send the browser to. The cookie of an earlier request is reused, so requests started
+// in several tabs can all complete. Requests to SAML providers keep the ID of the authentication request as nonce
+// and the state is sent as relay state.
+func beginFederation(w http.ResponseWriter, r *http.Request, provider datatypes.IdentityProvider, linkAccountId edgedb.OptionalUUID) (string, error) {
+	isSAML := provider.Kind == datatypes.IdentityProviderSAML
+
+	var endpoints federation.Endpoints
+	var err error
+	if !isSAML {
+		if endpoints, err = federation.ResolveEndpoints(provider); err != nil {
+			fmt.Println(err)
+			return "", responses.IdentityProviderUnavailableResponse()
+		}
+	}
+
+	values := make([]string, 3)
+	for i := range values {
+		if values[i], err = federation.NewRandom(); err != nil {
+			return "", responses.InternalServerErrorResponse()
+		}
+	}
+	state, nonce, codeVerifier := values[0], values[1], values[2]
+	if isSAML {
+		if nonce, err = saml.NewRequestID(); err != nil {
+			return "", responses.InternalServerErrorResponse()
+		}
+		codeVerifier = ""
+	}
+
+	browser := ""
+	if cookie, err := r.Cookie(federation.BrowserCookie); err == nil {
+		browser = cookie.Value
+	}
+	if browser == "" {
+		if browser, err = federation.NewRandom(); err != nil {
+			return "", responses.InternalServerErrorResponse()
+		}
+	}
+
+	expiresAt := time.Now().Add(federation.StateTTL())
+	if err = database.Connection.Queries.CreateFederationState(provider.Id, utility.HashToken(state), utility.HashToken(browser), nonce, codeVerifier, linkAccountId, expiresAt); err != nil {
+		fmt.Println(err)
+		return "", responses.InternalServerErrorResponse()
+	}
+
+	federation.SetBrowserCookie(w, browser, expiresAt)
+	if isSAML {
+		return samlRequestURL(provider, state, nonce)
+	}
+	return federation.AuthorizationURL(provider, endpoints, state, nonce, codeVerifier), nil
+}
+
+// createFederatedAccount creates an account for an identity which is not linked yet. Identities are never linked
+// to an existing account by their email, the owner has to sign in and link the provider instead.
+// Emails the provider did not verify have to be verified like after a registration.
+func createFederatedAccount(organization datatypes.Organization, provider datatypes.IdentityProvider, identity federation.Identity) (datatypes.Account, error) {
+	if !profile.IsValidEmail(identity.Email) {
+		return datatypes.Account{}, responses.FederatedEmailMissingResponse()
+	}
+
+	_, err := database.Connection.Queries.GetAccountByEmail(organization.Id, identity.Email)
+	if err == nil {
+		return datatypes.Account{}, responses.FederatedAccountExistsResponse()
+	}
+	var edbErr edgedb.Error
+	if !errors.As(err, &edbErr) || !edbErr.Category(edgedb.NoDataError) {
+		fmt.Println(err)
+		return datatypes.Account{}, responses.InternalServerErrorResponse()
+	}
+
+	username, err := federatedUsername(organization, identity)
+	if err != nil {
+		fmt.Println(err)
+		return datatypes.Account{}, responses.InternalServerErrorResponse()
+	}
+
+	randomPassword, err := gonanoid.New(48)
+	if err != nil {
+		return datatypes.Account{}, responses.InternalServerErrorResponse()
+	}
+	passwordHash, err := passwords.Hash(randomPassword)
+	if err != nil {
+		return datatypes.Account{}, responses.InternalServerErrorResponse()
+	}
+
+	status := datatypes.AccountStatusCreated
+	if identity.EmailVerified || verification.Mode() == verification.ModeOff {
+		status = datatypes.AccountStatusActive
+	}
+
+	role := config.GetEnv("DEFAULT_ROLE", datatypes.UserRole)
+	created, err := database.Connection.Queries.CreateFederatedAccount(organization.Id, identity.Email, username, passwordHash, role, status, provider.Id, identity.Subject)
+	if err != nil {
+		if errors.As(err, &edbErr) && edbErr.Category(edgedb.ConstraintViolationError) {
+			return datatypes.Account{}, responses.FederatedAccountExistsResponse()
+		}
+		fmt.Println(err)
+		return datatypes.Account{}, responses.InternalServerErrorResponse()
+	}
+
+	account, err := database.Connection.Queries.GetLoginAccount(organization.Id, created.Id)
+	if err != nil {
+		fmt.Println(err)
+		return datatypes.Account{}, responses.InternalServerErrorResponse()
+	}
+
+	if status == datatypes.AccountStatusCreated {
+		if err = sendVerificationEmail(account, time.Now()); err != nil {
+			fmt.Println(err)
+		}
+	}
+	return account, nil
+}
+
+// federatedUsername derives an available username from the username or email at the provider,
+// adding a random suffix if it is taken or not acceptable.
+func federatedUsername(organization datatypes.Organization, identity federation.Identity) (string, error) {
+	base := identity.Username
+	if base == "" {
+		base, _, _ = strings.Cut(identity.Email, "@")
+	}
+	base = strings.Trim(usernameDisallowedCharacters.ReplaceAllString(base, "-"), ".-_")
+	if len(base) > 24 {
+		base = base[:24]
+	}
+	if base == "" {
+		base = "user"
+	}
+
+	username := base
+	for attempt := 0; attempt < 5; attempt++ {
+		if profile.ValidateUsername(username) == "" {
+			taken, err := database.Connection.Queries.IsUsernameTaken(organization.Id, username, edgedb.OptionalUUID{})
+			if err != nil {
+				return "", err
+			}
+			if !taken {
+				return username, nil
+			}
+		}
+		suffix, err := gonanoid.Generate("0123456789", 6)
+		if err != nil {
+			return "", err
+		}
+		username = base + "-" + suffix
+	}
+	return "", errors.New("no available username for the federated identity")
+}
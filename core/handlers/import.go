@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/ghostship-dev/authservice/core/importer"
+	"github.com/ghostship-dev/authservice/core/responses"
+	"github.com/ghostship-dev/authservice/core/tenancy"
+)
+
+// ImportAccounts creates accounts of the current organization from a JSON array or, with a text/csv
+// content type, from CSV. Passwords are given as hashes of the source system.
+func ImportAccounts(w http.ResponseWriter, r *http.Request) error {
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	var records []importer.Record
+	var err error
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "text/csv" {
+		records, err = importer.ParseCSV(r.Body)
+	} else {
+		records, err = importer.ParseJSON(r.Body)
+	}
+	if err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	result, err := importer.Import(tenancy.GetOrganization(r).Id, records)
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	return responses.NewJSONResponse(w, http.StatusOK, responses.GenericDataResponse{
+		Error: false,
+		Data:  result,
+	})
+}
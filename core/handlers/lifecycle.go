@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/ghostship-dev/authservice/core/authorization"
+	"github.com/ghostship-dev/authservice/core/database"
+	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/lifecycle"
+	"github.com/ghostship-dev/authservice/core/lockout"
+	"github.com/ghostship-dev/authservice/core/logout"
+	"github.com/ghostship-dev/authservice/core/passwords"
+	"github.com/ghostship-dev/authservice/core/responses"
+	"github.com/ghostship-dev/authservice/core/tenancy"
+)
+
+// blockedAccountResponse rejects suspended and deleted accounts. Deleted accounts look like they no longer exist.
+func blockedAccountResponse(account datatypes.Account) error {
+	if account.Status == datatypes.AccountStatusSuspended {
+		return responses.AccountSuspendedResponse()
+	}
+	return responses.AccountNotFoundResponse()
+}
+
+// signedInApplications returns the applications the account is signed in to. They are looked up before the tokens
+// of the account are revoked and only notified by endSessions once that succeeded.
+func signedInApplications(accountId edgedb.UUID) []datatypes.OAuthClient {
+	activeApplications, err := database.Connection.Queries.GetActiveOAuth2ApplicationsForAccount(accountId)
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	return activeApplications
+}
+
+// endSessions notifies the applications the account was signed in to after its tokens were revoked.
+func endSessions(accountId edgedb.UUID, applications []datatypes.OAuthClient) {
+	logout.NotifyBackChannel(accountId.String(), applications)
+}
+
+func SuspendAccount(w http.ResponseWriter, r *http.Request) error {
+	var reqData datatypes.SuspendAccountRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	accountId, err := edgedb.ParseUUID(reqData.AccountID)
+	if err != nil {
+		return responses.ValidationErrorResponse(map[string]string{"account_id": "account_id is not a valid uuid"})
+	}
+
+	organization := tenancy.GetOrganization(r)
+	applications := signedInApplications(accountId)
+
+	suspended, err := database.Connection.Queries.SuspendAccount(organization.Id, accountId, reqData.Reason)
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+	if !suspended {
+		return responses.AccountNotSuspendableResponse()
+	}
+	endSessions(accountId, applications)
+
+	account := datatypes.Account{Id: accountId, Organization: organization}
+	lifecycle.Audit(account, lifecycle.ActionSuspended, &authorization.GetPrincipal(r).Account, reqData.Reason)
+
+	return responses.SendNewOKResponseMessage(w, "account suspended successfully")
+}
+
+func ReactivateAccount(w http.ResponseWriter, r *http.Request) error {
+	var reqData datatypes.ReactivateAccountRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	accountId, err := edgedb.ParseUUID(reqData.AccountID)
+	if err != nil {
+		return responses.ValidationErrorResponse(map[string]string{"account_id": "account_id is not a valid uuid"})
+	}
+
+	organization := tenancy.GetOrganization(r)
+	reactivated, err := database.Connection.Queries.ReactivateAccount(organization.Id, accountId)
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+	if !reactivated {
+		return responses.AccountNotSuspendedResponse()
+	}
+
+	account := datatypes.Account{Id: accountId, Organization: organization}
+	lifecycle.Audit(account, lifecycle.ActionReactivated, &authorization.GetPrincipal(r).Account, "")
+
+	return responses.SendNewOKResponseMessage(w, "account reactivated successfully")
+}
+
+// DeleteOwnAccount schedules the deletion of the signed in account. Signing in again within the grace period cancels it.
+func DeleteOwnAccount(w http.ResponseWriter, r *http.Request) error {
+	var reqData datatypes.DeleteAccountRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	principal := authorization.GetPrincipal(r)
+
+	password, err := database.Connection.Queries.GetPasswordByAccountId(principal.Account.Id)
+	if err != nil {
+		return responses.InternalServerErrorResponse()
+	}
+
+	if lockedUntil, locked := lockout.LockedUntil(password); locked {
+		return responses.AccountLockedResponse(lockedUntil)
+	}
+
+	matches, _, err := passwords.Verify(reqData.Password, password.Password)
+	if err != nil || !matches {
+		registerFailedAttempt(password)
+		return responses.UnauthorizedErrorResponse("invalid password")
+	}
+
+	applications := signedInApplications(principal.Account.Id)
+
+	erasureAt := time.Now().Add(lifecycle.GracePeriod())
+	if err = database.Connection.Queries.ScheduleAccountDeletion(principal.Account.Id, erasureAt); err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+	endSessions(principal.Account.Id, applications)
+
+	lifecycle.Audit(password.Account, lifecycle.ActionDeletionRequested, &password.Account, "")
+	lifecycle.SendDeletionScheduledMail(password.Account, erasureAt)
+
+	return responses.SendAccountDeletionScheduledResponse(w, erasureAt)
+}
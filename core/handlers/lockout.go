@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/ghostship-dev/authservice/core/database"
+	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/lockout"
+	"github.com/ghostship-dev/authservice/core/responses"
+	"github.com/ghostship-dev/authservice/core/tenancy"
+)
+
+// registerFailedAttempt counts a failed password attempt and notifies the account owner whenever the account gets locked.
+// Callers reject attempts while the account is locked, so every counted attempt which results in a lock starts a new one,
+// be it the first lock or a longer one after the previous lock expired.
+func registerFailedAttempt(password datatypes.Password) {
+	updated, err := database.Connection.Queries.IncrementFailedPasswordLoginAttempts(password.Id)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	lockedUntil, locked := lockout.LockedUntil(updated)
+	if !locked {
+		return
+	}
+
+	lockedAt, _ := updated.LastFailedAttempt.Get()
+	if err = lockout.SendLockedMail(password.Account, lockedAt, lockedUntil); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// UnlockAccountWithToken unlocks the account the emailed unlock link was issued for.
+// The token is read from the query string when the link is opened directly, otherwise from the JSON body.
+func UnlockAccountWithToken(w http.ResponseWriter, r *http.Request) error {
+	var reqData datatypes.UnlockAccountRequest
+
+	if r.Method == http.MethodGet {
+		reqData.Token = r.URL.Query().Get("token")
+	} else {
+		if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+			return responses.BadRequestResponse()
+		}
+
+		defer func(Body io.ReadCloser) {
+			_ = Body.Close()
+		}(r.Body)
+	}
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	organization := tenancy.GetOrganization(r)
+	accountId, lockedAt, err := lockout.ParseUnlockToken(organization, reqData.Token)
+	if err != nil {
+		return responses.InvalidUnlockTokenResponse()
+	}
+
+	unlocked, err := database.Connection.Queries.UnlockPassword(organization.Id, accountId, edgedb.NewOptionalDateTime(lockedAt))
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+	if !unlocked {
+		return responses.InvalidUnlockTokenResponse()
+	}
+
+	return responses.SendNewOKResponseMessage(w, "account unlocked successfully")
+}
+
+func UnlockAccount(w http.ResponseWriter, r *http.Request) error {
+	var reqData datatypes.AdminUnlockAccountRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	accountId, err := edgedb.ParseUUID(reqData.AccountID)
+	if err != nil {
+		return responses.ValidationErrorResponse(map[string]string{"account_id": "account_id is not a valid uuid"})
+	}
+
+	unlocked, err := database.Connection.Queries.UnlockPassword(tenancy.GetOrganization(r).Id, accountId, edgedb.OptionalDateTime{})
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+	if !unlocked {
+		return responses.AccountNotLockedResponse()
+	}
+
+	return responses.SendNewOKResponseMessage(w, "account unlocked successfully")
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,12 +10,21 @@ import (
 	"time"
 
 	"github.com/edgedb/edgedb-go"
+	"github.com/ghostship-dev/authservice/core/authn"
 	"github.com/ghostship-dev/authservice/core/database"
 	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/lifecycle"
+	"github.com/ghostship-dev/authservice/core/lockout"
+	"github.com/ghostship-dev/authservice/core/mfa"
+	"github.com/ghostship-dev/authservice/core/otpcodes"
+	"github.com/ghostship-dev/authservice/core/passkeys"
+	"github.com/ghostship-dev/authservice/core/passwords"
+	"github.com/ghostship-dev/authservice/core/recovery"
 	"github.com/ghostship-dev/authservice/core/responses"
+	"github.com/ghostship-dev/authservice/core/scopes"
+	"github.com/ghostship-dev/authservice/core/tenancy"
 	"github.com/ghostship-dev/authservice/core/utility"
-	"github.com/xlzd/gotp"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/ghostship-dev/authservice/core/verification"
 )
 
 func LoginHandler(w http.ResponseWriter, r *http.Request) error {
@@ -32,7 +42,8 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) error {
 		return responses.ValidationErrorResponse(validationErrors)
 	}
 
-	password, err := database.Connection.Queries.GetPasswordByEmail(reqData.Email)
+	organization := tenancy.GetOrganization(r)
+	password, err := database.Connection.Queries.GetPasswordByEmail(organization.Id, reqData.Email)
 	if err != nil {
 		var edbErr edgedb.Error
 		if errors.As(err, &edbErr) && edbErr.Category(edgedb.NoDataError) {
@@ -41,57 +52,391 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) error {
 		return responses.InternalServerErrorResponse()
 	}
 
-	if password.FailedAttempts >= 3 {
-		return responses.ToManyFailedAttemptsResponse()
+	if lockedUntil, locked := lockout.LockedUntil(password); locked {
+		return responses.AccountLockedResponse(lockedUntil)
 	}
 
-	if err = bcrypt.CompareHashAndPassword([]byte(password.Password), []byte(reqData.Password)); err != nil {
-		err = database.Connection.Queries.IncrementFailedPasswordLoginAttempts(reqData.Email)
-		if err != nil {
-			fmt.Println(err)
-		}
+	matches, needsRehash, err := passwords.Verify(reqData.Password, password.Password)
+	if err != nil || !matches {
+		registerFailedAttempt(password)
 		return responses.AccountNotFoundResponse()
 	}
 
-	if password.Account.OtpState == "enabled" {
-		if len(reqData.OTP) < 6 {
-			return responses.TwoFactorAuthenticationRequiredResponse()
-		} else {
-			totpSecret, isSecretSet := password.Account.OtpSecret.Get()
-			if !isSecretSet {
-				return responses.InvalidTotpStateErrorResponse()
+	// Upgrade hashes of older algorithms or weaker parameters while the plain password is at hand
+	if needsRehash {
+		if passwordHash, err := passwords.Hash(reqData.Password); err == nil {
+			if err = database.Connection.Queries.UpdatePasswordHash(password.Id, passwordHash); err != nil {
+				fmt.Println(err)
 			}
+		}
+	}
 
-			totp := gotp.NewDefaultTOTP(totpSecret)
-			if !totp.Verify(reqData.OTP, time.Now().Unix()) {
-				return responses.UnauthorizedErrorResponse("invalid otp")
+	if password.FailedAttempts > 0 {
+		err = database.Connection.Queries.ResetFailedPasswordLoginAttempts(password.Id)
+		if err != nil {
+			return responses.InternalServerErrorResponse()
+		}
+	}
+
+	factors, err := loadSecondFactors(password.Account)
+	if err != nil {
+		return err
+	}
+
+	authentication := authn.New(authn.AMRPassword)
+	if factors.any() {
+		// Clients may still submit the factor together with the password instead of using the mfa_token
+		submission, isSubmitted := inlineFactorSubmission(reqData)
+		if !isSubmitted {
+			if password.Account.Status == datatypes.AccountStatusSuspended {
+				return blockedAccountResponse(password.Account)
 			}
+			return startLoginTransaction(organization, password.Account, factors, reqData.Factor, authn.AMRPassword)
+		}
+		if err = verifyFactor(organization, password.Account, factors, submission); err != nil {
+			return err
 		}
+		authentication = authn.New(authn.AMRPassword, authn.FactorMethod(submission.Factor))
 	}
 
-	if password.FailedAttempts > 0 {
-		err = database.Connection.Queries.ResetFailedPasswordLoginAttempts(reqData.Email)
+	if err = checkAccountAccess(&password.Account); err != nil {
+		return err
+	}
+
+	return issueLoginTokens(w, password.Account, authentication)
+}
+
+// LoginMFAHandler completes the login transaction of the mfa_token with a second factor. After too many
+// failed submissions the transaction ends and the login has to start over with the password.
+func LoginMFAHandler(w http.ResponseWriter, r *http.Request) error {
+	var reqData datatypes.MFAStepRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	organization := tenancy.GetOrganization(r)
+	transaction, err := database.Connection.Queries.GetLoginTransaction(organization.Id, utility.HashToken(reqData.MFAToken))
+	if err != nil {
+		var edbErr edgedb.Error
+		if errors.As(err, &edbErr) && edbErr.Category(edgedb.NoDataError) {
+			return responses.InvalidMFATokenResponse()
+		}
+		return responses.InternalServerErrorResponse()
+	}
+	if transaction.Attempts >= mfa.MaxAttempts() {
+		return responses.InvalidMFATokenResponse()
+	}
+
+	account, err := database.Connection.Queries.GetLoginAccount(organization.Id, transaction.AccountId)
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	factors, err := loadSecondFactors(account)
+	if err != nil {
+		return err
+	}
+	factors.excludeFirstFactor(transaction.FirstFactor)
+
+	if channel, isCodeFactor := codeFactorChannels[reqData.Factor]; isCodeFactor && reqData.Code == "" {
+		factor, isEnrolled := factors.codeFactors[channel]
+		if !isEnrolled {
+			return factorNotEnabledResponse()
+		}
+		destination := otpcodes.Destination(account, factor)
+		expiresAt, err := otpcodes.Send(account, channel, destination, otpcodes.PurposeLogin)
 		if err != nil {
+			return sendCodeErrorResponse(err)
+		}
+		return responses.SendOTPCodeSentResponse(w, otpcodes.MaskDestination(channel, destination), expiresAt)
+	}
+
+	// The submission is counted before it is verified so concurrent submissions can't get past MaxAttempts
+	reserved, err := database.Connection.Queries.ReserveLoginTransactionAttempt(transaction.Id, mfa.MaxAttempts())
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+	if !reserved {
+		if _, err := database.Connection.Queries.DeleteLoginTransaction(transaction.Id); err != nil {
+			fmt.Println(err)
+		}
+		return responses.InvalidMFATokenResponse()
+	}
+
+	if err = verifyFactor(organization, account, factors, reqData); err != nil {
+		return err
+	}
+
+	if ended, err := database.Connection.Queries.DeleteLoginTransaction(transaction.Id); err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	} else if !ended {
+		return responses.InvalidMFATokenResponse()
+	}
+
+	if err = checkAccountAccess(&account); err != nil {
+		return err
+	}
+
+	// The transaction is only created once the first factor was verified
+	return issueLoginTokens(w, account, authn.New(transaction.FirstFactor, authn.FactorMethod(reqData.Factor)))
+}
+
+// secondFactors are the second factors an account has enabled.
+type secondFactors struct {
+	totp        bool
+	credentials []datatypes.WebAuthnCredential
+	codeFactors map[string]datatypes.OTPFactor
+	user        *passkeys.User
+}
+
+func (f secondFactors) any() bool {
+	return f.totp || len(f.credentials) > 0 || len(f.codeFactors) > 0
+}
+
+// excludeFirstFactor drops the factors proving nothing beyond the first factor. A code sent
+// to the inbox a login link was just opened from is no second factor.
+func (f *secondFactors) excludeFirstFactor(firstFactor string) {
+	if firstFactor == authn.AMREmailLink {
+		delete(f.codeFactors, datatypes.OTPChannelEmail)
+	}
+}
+
+func loadSecondFactors(account datatypes.Account) (secondFactors, error) {
+	credentials, err := database.Connection.Queries.GetWebAuthnCredentials(account.Id)
+	if err != nil {
+		fmt.Println(err)
+		return secondFactors{}, responses.InternalServerErrorResponse()
+	}
+
+	otpFactors, err := database.Connection.Queries.GetOTPFactors(account.Id)
+	if err != nil {
+		fmt.Println(err)
+		return secondFactors{}, responses.InternalServerErrorResponse()
+	}
+
+	factors := secondFactors{
+		totp:        account.OtpState == "enabled",
+		credentials: credentials,
+		codeFactors: make(map[string]datatypes.OTPFactor),
+		user:        &passkeys.User{Account: account, Credentials: credentials},
+	}
+	for _, factor := range otpFactors {
+		if factor.Verified {
+			factors.codeFactors[factor.Channel] = factor
+		}
+	}
+	return factors, nil
+}
+
+// inlineFactorSubmission returns the factor submitted together with the password, if any.
+func inlineFactorSubmission(reqData datatypes.LoginRequestData) (datatypes.MFAStepRequest, bool) {
+	switch {
+	case reqData.OTP != "":
+		return datatypes.MFAStepRequest{Factor: datatypes.FactorTOTP, Code: reqData.OTP}, true
+	case reqData.RecoveryCode != "":
+		return datatypes.MFAStepRequest{Factor: datatypes.FactorRecoveryCode, Code: reqData.RecoveryCode}, true
+	case len(reqData.WebAuthn) > 0:
+		return datatypes.MFAStepRequest{Factor: datatypes.FactorWebAuthn, WebAuthn: reqData.WebAuthn}, true
+	case reqData.Factor != "" && reqData.Code != "":
+		return datatypes.MFAStepRequest{Factor: reqData.Factor, Code: reqData.Code}, true
+	}
+	return datatypes.MFAStepRequest{}, false
+}
+
+// startLoginTransaction returns the mfa_token to submit the second factor with and the factors of the account.
+// A security key ceremony is started, and a code is sent if the email_otp or sms_otp factor was selected.
+func startLoginTransaction(organization datatypes.Organization, account datatypes.Account, factors secondFactors, selectedFactor, firstFactor string) error {
+	var codeFactor *datatypes.OTPFactor
+	if selectedFactor != "" {
+		factor, isEnrolled := factors.codeFactors[codeFactorChannels[selectedFactor]]
+		if !isEnrolled {
+			return factorNotEnabledResponse()
+		}
+		codeFactor = &factor
+	}
+
+	mfaToken, err := mfa.NewToken()
+	if err != nil {
+		return responses.InternalServerErrorResponse()
+	}
+	expiresAt := time.Now().Add(mfa.TokenTTL())
+	if err = database.Connection.Queries.CreateLoginTransaction(organization.Id, account.Id, utility.HashToken(mfaToken), firstFactor, expiresAt); err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	challenge := responses.SecondFactorChallenge{MFAToken: mfaToken, MFATokenExpiresAt: &expiresAt}
+	if factors.totp {
+		challenge.Factors = append(challenge.Factors, datatypes.FactorTOTP)
+	}
+	if remaining, err := database.Connection.Queries.CountRecoveryCodes(account.Id); err == nil && remaining > 0 {
+		challenge.Factors = append(challenge.Factors, datatypes.FactorRecoveryCode)
+	}
+	if _, isEnrolled := factors.codeFactors[datatypes.OTPChannelEmail]; isEnrolled {
+		challenge.Factors = append(challenge.Factors, datatypes.FactorEmailOTP)
+	}
+	if _, isEnrolled := factors.codeFactors[datatypes.OTPChannelSMS]; isEnrolled {
+		challenge.Factors = append(challenge.Factors, datatypes.FactorSMSOTP)
+	}
+	if len(factors.credentials) > 0 {
+		challenge.Factors = append(challenge.Factors, datatypes.FactorWebAuthn)
+		if challenge.WebAuthn, err = startWebAuthnLogin(organization, factors.user); err != nil {
+			fmt.Println(err)
 			return responses.InternalServerErrorResponse()
 		}
 	}
 
+	if codeFactor != nil {
+		destination := otpcodes.Destination(account, *codeFactor)
+		codeExpiresAt, err := otpcodes.Send(account, codeFactor.Channel, destination, otpcodes.PurposeLogin)
+		if err != nil {
+			return sendCodeErrorResponse(err)
+		}
+		challenge.CodeSentTo = otpcodes.MaskDestination(codeFactor.Channel, destination)
+		challenge.CodeExpiresAt = &codeExpiresAt
+	}
+	return responses.SecondFactorRequiredResponse(challenge)
+}
+
+// verifyFactor checks a submitted second factor of the account.
+func verifyFactor(organization datatypes.Organization, account datatypes.Account, factors secondFactors, submission datatypes.MFAStepRequest) error {
+	switch submission.Factor {
+	case datatypes.FactorTOTP:
+		if !factors.totp {
+			return factorNotEnabledResponse()
+		}
+		return verifyOTP(account, submission.Code)
+	case datatypes.FactorRecoveryCode:
+		return useRecoveryCode(account, submission.Code)
+	case datatypes.FactorWebAuthn:
+		if len(factors.credentials) == 0 {
+			return factorNotEnabledResponse()
+		}
+		response, err := passkeys.ParseLoginResponse(bytes.NewReader(submission.WebAuthn))
+		if err != nil {
+			return responses.ValidationErrorResponse(map[string]string{"webauthn": "webauthn is malformed"})
+		}
+		return verifyWebAuthnAssertion(organization, factors.user, response)
+	case datatypes.FactorEmailOTP, datatypes.FactorSMSOTP:
+		factor, isEnrolled := factors.codeFactors[codeFactorChannels[submission.Factor]]
+		if !isEnrolled {
+			return factorNotEnabledResponse()
+		}
+		if err := verifyDeliveredCode(account, factor.Channel, otpcodes.PurposeLogin, submission.Code); err != nil {
+			return err
+		}
+		if err := database.Connection.Queries.UpdateOTPFactorUsage(account.Id, factor.Channel); err != nil {
+			fmt.Println(err)
+		}
+		return nil
+	}
+	return factorNotEnabledResponse()
+}
+
+func factorNotEnabledResponse() error {
+	return responses.ValidationErrorResponse(map[string]string{"factor": "factor is not enabled for this account"})
+}
+
+// useRecoveryCode accepts an unused recovery code in place of the second factor and tells the owner how many are left.
+// Invalid codes count towards the one-time password lock.
+func useRecoveryCode(account datatypes.Account, code string) error {
+	if lockedUntil, locked := lockout.OTPLockedUntil(account); locked {
+		return responses.AccountLockedResponse(lockedUntil)
+	}
+
+	used, err := database.Connection.Queries.UseRecoveryCode(account.Id, recovery.HashRecoveryCode(account.Id, code))
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+	if !used {
+		registerFailedOTPAttempt(account)
+		return responses.UnauthorizedErrorResponse("invalid recovery code")
+	}
+
+	remaining, err := database.Connection.Queries.CountRecoveryCodes(account.Id)
+	if err != nil {
+		fmt.Println(err)
+	}
+	recovery.SendRecoveryCodeUsedMail(account, remaining)
+	return nil
+}
+
+// completeFirstFactor finishes a login which did not start with the password. Accounts with a second factor
+// get an mfa_token, just like after the password step of LoginHandler. Otherwise the upstream methods an identity provider
+// reports it used count towards the authentication.
+func completeFirstFactor(w http.ResponseWriter, organization datatypes.Organization, account datatypes.Account, firstFactor string, upstreamMethods ...string) error {
+	factors, err := loadSecondFactors(account)
+	if err != nil {
+		return err
+	}
+	factors.excludeFirstFactor(firstFactor)
+
+	if factors.any() {
+		if account.Status == datatypes.AccountStatusSuspended {
+			return blockedAccountResponse(account)
+		}
+		return startLoginTransaction(organization, account, factors, "", firstFactor)
+	}
+
+	if err = checkAccountAccess(&account); err != nil {
+		return err
+	}
+
+	return issueLoginTokens(w, account, authn.New(append([]string{firstFactor}, upstreamMethods...)...))
+}
+
+// checkAccountAccess rejects accounts which may not sign in once they proved their identity.
+// Signing in within the grace period of a requested deletion cancels it.
+func checkAccountAccess(account *datatypes.Account) error {
+	if deletionScheduledAt, isScheduled := account.DeletionScheduledAt.Get(); account.Status == datatypes.AccountStatusDeleted && isScheduled && deletionScheduledAt.After(time.Now()) {
+		if err := database.Connection.Queries.CancelAccountDeletion(account.Id); err != nil {
+			return responses.InternalServerErrorResponse()
+		}
+		account.Status = datatypes.AccountStatusActive
+		lifecycle.Audit(*account, lifecycle.ActionDeletionCancelled, account, "")
+	}
+
+	if account.IsBlocked() {
+		return blockedAccountResponse(*account)
+	}
+
+	if !verification.AllowsLogin(*account) {
+		return responses.EmailNotVerifiedResponse()
+	}
+	return nil
+}
+
+// issueLoginTokens issues a first party token pair for the account, recording how it authenticated.
+func issueLoginTokens(w http.ResponseWriter, account datatypes.Account, authentication datatypes.Authentication) error {
 	accessTokenExpiresAt := time.Now().Add(time.Hour * 1)
 	refreshTokenExpiresAt := time.Now().Add(time.Hour * 24 * 7)
 
-	grantedScope := []string{"*"}
+	grantedScope := verification.CapScope(account, scopes.Cap([]string{scopes.Wildcard}, account.Permissions()))
 
-	accessToken, err := utility.NewAccessToken(password.Account, accessTokenExpiresAt, grantedScope)
+	accessToken, err := utility.NewAccessToken(account, accessTokenExpiresAt, grantedScope, authentication)
 	if err != nil {
 		return responses.InternalServerErrorResponse()
 	}
 
-	refreshToken, err := utility.NewRefreshToken(password.Account, refreshTokenExpiresAt, grantedScope)
+	refreshToken, err := utility.NewRefreshToken(account, refreshTokenExpiresAt, grantedScope, authentication)
 	if err != nil {
 		return responses.InternalServerErrorResponse()
 	}
 
-	if err = database.Connection.Queries.AddNewTokenPair(password.Account.Id, accessToken.Value, refreshToken.Value, accessTokenExpiresAt, refreshTokenExpiresAt, accessToken.Scope); err != nil {
+	if err = database.Connection.Queries.AddNewTokenPair(account.Id, edgedb.OptionalUUID{}, accessToken.Value, refreshToken.Value, accessTokenExpiresAt, refreshTokenExpiresAt, accessToken.Scope, authentication); err != nil {
 		return responses.InternalServerErrorResponse()
 	}
 
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/ghostship-dev/authservice/core/database"
+	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/logout"
+	"github.com/ghostship-dev/authservice/core/responses"
+	"github.com/ghostship-dev/authservice/core/tenancy"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func EndSessionHandler(w http.ResponseWriter, r *http.Request) error {
+	err := r.ParseForm()
+	if err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	reqData := datatypes.EndSessionRequest{
+		IDTokenHint:           r.Form.Get("id_token_hint"),
+		PostLogoutRedirectURI: r.Form.Get("post_logout_redirect_uri"),
+		ClientID:              r.Form.Get("client_id"),
+		State:                 r.Form.Get("state"),
+	}
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	// The hint may already be expired, so only its signature, issuer and audience are checked.
+	var oauth2Application datatypes.OAuthClient
+	hint, err := jwt.Parse(reqData.IDTokenHint, func(token *jwt.Token) (interface{}, error) {
+		audience, err := token.Claims.GetAudience()
+		if err != nil || len(audience) != 1 {
+			return nil, errors.New("id token hint must have exactly one audience")
+		}
+		oauth2Application, err = database.Connection.Queries.GetOAuth2ClientApplication(audience[0])
+		if err != nil {
+			return nil, err
+		}
+		return []byte(oauth2Application.ClientSecret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithoutClaimsValidation())
+	if err != nil || !hint.Valid {
+		return responses.InvalidIDTokenHintResponse()
+	}
+
+	organization := tenancy.ForClient(oauth2Application)
+	if organization.Id != tenancy.GetOrganization(r).Id {
+		return responses.InvalidIDTokenHintResponse()
+	}
+
+	if issuer, err := hint.Claims.GetIssuer(); err != nil || issuer != tenancy.Issuer(organization) {
+		return responses.InvalidIDTokenHintResponse()
+	}
+
+	if reqData.ClientID != "" && reqData.ClientID != oauth2Application.ClientID {
+		return responses.InvalidIDTokenHintResponse()
+	}
+
+	if reqData.PostLogoutRedirectURI != "" && !slices.Contains(oauth2Application.PostLogoutRedirectURIs, reqData.PostLogoutRedirectURI) {
+		return responses.OAuth2PostLogoutRedirectURIDoesNotMatch()
+	}
+
+	subject, err := hint.Claims.GetSubject()
+	if err != nil {
+		return responses.InvalidIDTokenHintResponse()
+	}
+
+	accountId, err := edgedb.ParseUUID(subject)
+	if err != nil {
+		return responses.InvalidIDTokenHintResponse()
+	}
+
+	activeApplications, err := database.Connection.Queries.GetActiveOAuth2ApplicationsForAccount(accountId)
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	if err = database.Connection.Queries.DeleteAccountTokens(accountId); err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	logout.NotifyBackChannel(subject, activeApplications)
+
+	return responses.SendEndSessionResponse(w, r, logout.FrontChannelURIs(activeApplications), reqData.PostLogoutRedirectURI, reqData.State)
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/ghostship-dev/authservice/core/authn"
+	"github.com/ghostship-dev/authservice/core/database"
+	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/magiclinks"
+	"github.com/ghostship-dev/authservice/core/responses"
+	"github.com/ghostship-dev/authservice/core/tenancy"
+	"github.com/ghostship-dev/authservice/core/utility"
+)
+
+// RequestMagicLink emails a single-use login link bound to a nonce cookie of the requesting browser.
+// The cookie is set whether or not an account with the email exists, so the response reveals nothing.
+// Links are throttled per account and client address within MAGIC_LINK_RATE_WINDOW.
+func RequestMagicLink(w http.ResponseWriter, r *http.Request) error {
+	var reqData datatypes.MagicLinkRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	nonce, err := magiclinks.NewNonce()
+	if err != nil {
+		return responses.InternalServerErrorResponse()
+	}
+	expiresAt := time.Now().Add(magiclinks.TTL())
+
+	account, err := database.Connection.Queries.GetAccountByEmail(tenancy.GetOrganization(r).Id, reqData.Email)
+	if err != nil {
+		var edbErr edgedb.Error
+		if errors.As(err, &edbErr) && edbErr.Category(edgedb.NoDataError) {
+			magiclinks.SetNonceCookie(w, nonce, expiresAt)
+			return responses.SendMagicLinkRequestedResponse(w)
+		}
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	// Requests over the limit are answered like any other, so the response still does not reveal the account
+	clientIP := utility.ClientIP(r)
+	limit, window := magiclinks.RateLimit()
+	count, err := database.Connection.Queries.CountMagicLinks(account.Id, clientIP, time.Now().Add(-window))
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+	if count.Account >= limit || count.Address >= limit {
+		fmt.Println(fmt.Sprintf("magic link for account %s requested from %s was rate limited", account.Id, clientIP))
+		magiclinks.SetNonceCookie(w, nonce, expiresAt)
+		return responses.SendMagicLinkRequestedResponse(w)
+	}
+
+	token, err := magiclinks.NewToken()
+	if err != nil {
+		return responses.InternalServerErrorResponse()
+	}
+
+	if err = database.Connection.Queries.CreateMagicLink(account.Id, utility.HashToken(token), utility.HashToken(nonce), clientIP, expiresAt); err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	magiclinks.SendLinkMail(account, token)
+
+	magiclinks.SetNonceCookie(w, nonce, expiresAt)
+	return responses.SendMagicLinkRequestedResponse(w)
+}
+
+// MagicLinkLogin signs in with the token of a login link. It only succeeds in the browser holding the nonce
+// cookie the link was requested with, and is a POST so mail scanners following the link cannot use it up.
+// Accounts with a second factor get an mfa_token, just like after the password step of LoginHandler.
+func MagicLinkLogin(w http.ResponseWriter, r *http.Request) error {
+	var reqData datatypes.MagicLinkLoginRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	nonce, err := r.Cookie(magiclinks.NonceCookie)
+	if err != nil || nonce.Value == "" {
+		return responses.InvalidMagicLinkResponse()
+	}
+
+	organization := tenancy.GetOrganization(r)
+	accountId, err := database.Connection.Queries.UseMagicLink(organization.Id, utility.HashToken(reqData.Token), utility.HashToken(nonce.Value))
+	if err != nil {
+		var edbErr edgedb.Error
+		if errors.As(err, &edbErr) && edbErr.Category(edgedb.NoDataError) {
+			return responses.InvalidMagicLinkResponse()
+		}
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+	magiclinks.ClearNonceCookie(w)
+
+	account, err := database.Connection.Queries.GetLoginAccount(organization.Id, accountId)
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	return completeFirstFactor(w, organization, account, authn.AMREmailLink)
+}
@@ -6,15 +6,18 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"os"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/edgedb/edgedb-go"
+	"github.com/ghostship-dev/authservice/core/authorization"
 	"github.com/ghostship-dev/authservice/core/database"
 	"github.com/ghostship-dev/authservice/core/scopes"
+	"github.com/ghostship-dev/authservice/core/tenancy"
 	"github.com/ghostship-dev/authservice/core/utility"
+	"github.com/ghostship-dev/authservice/core/verification"
 	"github.com/golang-jwt/jwt/v5"
 	gonanoid "github.com/matoous/go-nanoid/v2"
 
@@ -37,27 +40,12 @@ func NewOAuthApplication(w http.ResponseWriter, r *http.Request) error {
 		return responses.ValidationErrorResponse(validationErrors)
 	}
 
-	bearerToken, err := utility.GetBearerTokenFromHeader(&r.Header)
-	if err != nil {
-		return responses.UnauthorizedErrorResponse("missing bearer token")
-	}
-
-	dbToken, err := database.Connection.Queries.GetToken(bearerToken)
-	if err != nil {
-		return responses.UnauthorizedErrorResponse("invalid bearer token")
-	}
+	principal := authorization.GetPrincipal(r)
 
-	if dbToken.Revoked {
-		return responses.UnauthorizedErrorResponse("token is revoked")
+	if reqData.FirstParty && !principal.Can("admin") {
+		return responses.InsufficientScopeResponse([]string{"admin"})
 	}
 
-	// TODO: Replace with a permission decision point in the future
-	if !slices.Contains(dbToken.Scope, "oauth2_write") && !slices.Contains(dbToken.Scope, "*") {
-		return responses.UnauthorizedErrorResponse("missing required permission")
-	}
-
-	// TODO: create new oauth2 client application
-
 	clientId, err := gonanoid.New(30)
 	if err != nil {
 		return responses.InternalServerErrorResponse()
@@ -72,10 +60,12 @@ func NewOAuthApplication(w http.ResponseWriter, r *http.Request) error {
 		ClientSecret:           clientSecret,
 		ClientName:             reqData.ClientName,
 		ClientType:             reqData.ClientType,
+		FirstParty:             reqData.FirstParty,
 		RedirectURIs:           reqData.RedirectUris,
 		GrantTypes:             reqData.GrantTypes,
 		Scope:                  reqData.Scope,
-		ClientOwner:            dbToken.Account,
+		ClientOwner:            principal.Account,
+		Organization:           tenancy.GetOrganization(r),
 		ClientDescription:      edgedb.NewOptionalStr(reqData.ClientDescription),
 		ClientHomepageUrl:      edgedb.NewOptionalStr(reqData.ClientHomepageUrl),
 		ClientLogoUrl:          edgedb.NewOptionalStr(reqData.ClientLogoUrl),
@@ -84,6 +74,13 @@ func NewOAuthApplication(w http.ResponseWriter, r *http.Request) error {
 		ClientRegistrationDate: time.Now(),
 		ClientStatus:           "active",
 		ClientRateLimits:       []byte(""),
+		PostLogoutRedirectURIs: reqData.PostLogoutRedirectUris,
+		FrontchannelLogoutURI:  edgedb.NewOptionalStr(reqData.FrontchannelLogoutUri),
+		BackchannelLogoutURI:   edgedb.NewOptionalStr(reqData.BackchannelLogoutUri),
+	}
+
+	if oauthApplication.PostLogoutRedirectURIs == nil {
+		oauthApplication.PostLogoutRedirectURIs = make([]string, 0)
 	}
 
 	if err = database.Connection.Queries.CreateNewOAuthClientApplication(oauthApplication); err != nil {
@@ -124,26 +121,20 @@ func UpdateOAuthApplicationKeyValue(w http.ResponseWriter, r *http.Request) erro
 		return responses.ValidationErrorResponse(validationErrors)
 	}
 
-	bearerToken, err := utility.GetBearerTokenFromHeader(&r.Header)
+	oauth2Application, err := getManagedOAuthApplication(r, reqData.ClientID)
 	if err != nil {
-		return responses.UnauthorizedErrorResponse("missing bearer token")
+		return err
 	}
 
-	dbToken, err := database.Connection.Queries.GetToken(bearerToken)
-	if err != nil {
-		return responses.UnauthorizedErrorResponse("invalid bearer token")
-	}
-
-	if dbToken.Revoked {
-		return responses.UnauthorizedErrorResponse("token is revoked")
-	}
-
-	// TODO: Replace with a permission decision point in the future
-	if !slices.Contains(dbToken.Scope, "oauth2_write") && !slices.Contains(dbToken.Scope, "*") {
-		return responses.UnauthorizedErrorResponse("missing required permission")
+	if reqData.Key == "scope" {
+		scopeSlice := strings.Split(strings.TrimSpace(reqData.Value), ",")
+		if forbiddenScopes := scopes.GetForbiddenScopesForClient(scopeSlice, oauth2Application.FirstParty); len(forbiddenScopes) > 0 {
+			return responses.OAuth2InvalidScope(forbiddenScopes)
+		}
 	}
 
-	if err = database.Connection.Queries.UpdateOAuth2ClientApplicationKeyValue(reqData); err != nil {
+	updated, err := database.Connection.Queries.UpdateOAuth2ClientApplicationKeyValue(oauth2Application.Organization.Id, oauth2Application.ClientOwner.Id, reqData)
+	if err != nil {
 		var edbErr edgedb.Error
 		if errors.As(err, &edbErr) && edbErr.Category(edgedb.ConstraintViolationError) {
 			if strings.Contains(edbErr.Error(), "violates exclusivity constraint") {
@@ -151,11 +142,15 @@ func UpdateOAuthApplicationKeyValue(w http.ResponseWriter, r *http.Request) erro
 			} else {
 				return responses.BadRequestResponse()
 			}
-		} else if edbErr.Category(edgedb.ParameterTypeMismatchError) {
+		} else if errors.As(err, &edbErr) && edbErr.Category(edgedb.ParameterTypeMismatchError) {
 			return responses.OAuth2ApplicationTypeParameterNameMismatchErrorResponse()
 		}
+		fmt.Println(err)
 		return responses.InternalServerErrorResponse()
 	}
+	if !updated {
+		return responses.OAuth2ApplicationNotFoundResponse()
+	}
 
 	return responses.SendNewOKResponse(w)
 }
@@ -174,30 +169,44 @@ func DeleteOAuthClientApplication(w http.ResponseWriter, r *http.Request) error
 		return responses.ValidationErrorResponse(validationErrors)
 	}
 
-	bearerToken, err := utility.GetBearerTokenFromHeader(&r.Header)
+	oauth2Application, err := getManagedOAuthApplication(r, reqData.ClientID)
 	if err != nil {
-		return responses.UnauthorizedErrorResponse("missing bearer token")
+		return err
 	}
 
-	dbToken, err := database.Connection.Queries.GetToken(bearerToken)
+	deleted, err := database.Connection.Queries.DeleteOAuth2ClientApplication(oauth2Application.Organization.Id, oauth2Application.ClientOwner.Id, reqData.ClientID)
 	if err != nil {
-		return responses.UnauthorizedErrorResponse("invalid bearer token")
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
 	}
-
-	if dbToken.Revoked {
-		return responses.UnauthorizedErrorResponse("token is revoked")
+	if !deleted {
+		return responses.OAuth2ApplicationNotFoundResponse()
 	}
 
-	// TODO: Replace with a permission decision point in the future
-	if !slices.Contains(dbToken.Scope, "oauth2_delete") && !slices.Contains(dbToken.Scope, "*") {
-		return responses.UnauthorizedErrorResponse("missing required permission")
-	}
+	return responses.SendNewOKResponse(w)
+}
 
-	if err = database.Connection.Queries.DeleteOAuth2ClientApplication(reqData.ClientID); err != nil {
-		return responses.InternalServerErrorResponse()
+// getManagedOAuthApplication returns the application of the organization of the request if the principal owns it,
+// or administers the organization. Applications of other owners and organizations are reported as not found.
+func getManagedOAuthApplication(r *http.Request, clientID string) (datatypes.OAuthClient, error) {
+	oauth2Application, err := database.Connection.Queries.GetOAuth2ClientApplication(clientID)
+	if err != nil {
+		var edbErr edgedb.Error
+		if errors.As(err, &edbErr) && edbErr.Category(edgedb.NoDataError) {
+			return datatypes.OAuthClient{}, responses.OAuth2ApplicationNotFoundResponse()
+		}
+		fmt.Println(err)
+		return datatypes.OAuthClient{}, responses.InternalServerErrorResponse()
 	}
 
-	return responses.SendNewOKResponse(w)
+	principal := authorization.GetPrincipal(r)
+	if oauth2Application.Organization.Id != tenancy.GetOrganization(r).Id {
+		return datatypes.OAuthClient{}, responses.OAuth2ApplicationNotFoundResponse()
+	}
+	if oauth2Application.ClientOwner.Id != principal.Account.Id && !principal.Can("admin") {
+		return datatypes.OAuthClient{}, responses.OAuth2ApplicationNotFoundResponse()
+	}
+	return oauth2Application, nil
 }
 
 func IntrospectOAuthToken(w http.ResponseWriter, r *http.Request) error {
@@ -214,9 +223,10 @@ func IntrospectOAuthToken(w http.ResponseWriter, r *http.Request) error {
 		return responses.ValidationErrorResponse(validationErrors)
 	}
 
+	organization := tenancy.GetOrganization(r)
 	token, err := jwt.Parse(reqData.Token, func(token *jwt.Token) (interface{}, error) {
-		return []byte(os.Getenv("JWT_SECRET_KEY")), nil
-	})
+		return tenancy.SigningKey(organization), nil
+	}, jwt.WithIssuer(tenancy.Issuer(organization)))
 
 	if err != nil || !token.Valid {
 		return responses.UnauthorizedErrorResponse("invalid token")
@@ -236,7 +246,31 @@ func IntrospectOAuthToken(w http.ResponseWriter, r *http.Request) error {
 		}
 	}
 
-	return responses.SendNewOKResponseMessage(w, "token is valid")
+	claims := token.Claims.(jwt.MapClaims)
+	scope, _ := claims["scope"].([]interface{})
+	expiresAt, _ := claims["exp"].(float64)
+	return responses.SendTokenIntrospectionResponse(w, claimStrings(scope), time.Unix(int64(expiresAt), 0), authenticationFromClaims(claims))
+}
+
+// authenticationFromClaims reads the auth_time, acr and amr claims of a token.
+func authenticationFromClaims(claims jwt.MapClaims) datatypes.Authentication {
+	authTime, isSet := claims["auth_time"].(float64)
+	if !isSet {
+		return datatypes.Authentication{}
+	}
+	acr, _ := claims["acr"].(string)
+	amr, _ := claims["amr"].([]interface{})
+	return datatypes.Authentication{Time: time.Unix(int64(authTime), 0), ACR: acr, AMR: claimStrings(amr)}
+}
+
+func claimStrings(values []interface{}) []string {
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if str, ok := value.(string); ok {
+			result = append(result, str)
+		}
+	}
+	return result
 }
 
 func AuthorizeOAuthApplication(w http.ResponseWriter, r *http.Request) error {
@@ -252,6 +286,8 @@ func AuthorizeOAuthApplication(w http.ResponseWriter, r *http.Request) error {
 		ResponseType: r.Form.Get("response_type"),
 		Scope:        r.Form.Get("scope"),
 		State:        r.Form.Get("state"),
+		ACRValues:    r.Form.Get("acr_values"),
+		MaxAge:       r.Form.Get("max_age"),
 	}
 
 	defer func(Body io.ReadCloser) {
@@ -280,6 +316,23 @@ func AuthorizeOAuthApplication(w http.ResponseWriter, r *http.Request) error {
 		return responses.OAuth2UserNotFoundResponse()
 	}
 
+	// Applications and accounts of other organizations are invisible to this tenant
+	tenant := tenancy.GetOrganization(r)
+	if oauth2Application.Organization.Id != tenant.Id {
+		return responses.OAuth2ApplicationNotFoundResponse()
+	}
+	if account.Organization.Id != tenant.Id {
+		return responses.OAuth2UserNotFoundResponse()
+	}
+
+	if account.IsBlocked() {
+		return blockedAccountResponse(account)
+	}
+
+	if !verification.AllowsLogin(account) {
+		return responses.EmailNotVerifiedResponse()
+	}
+
 	if !slices.Contains(oauth2Application.RedirectURIs, reqData.RedirectURI) {
 		return responses.OAuth2RedirectURIDoesNotMatch()
 	}
@@ -290,8 +343,8 @@ func AuthorizeOAuthApplication(w http.ResponseWriter, r *http.Request) error {
 		return responses.OAuth2ScopeIsRequired()
 	}
 
-	if !scopes.AllScopesAllowed(scopeSlice) {
-		return responses.OAuth2InvalidScope(scopes.GetForbiddenScopes(scopeSlice))
+	if forbiddenScopes := scopes.GetForbiddenScopesForClient(scopeSlice, oauth2Application.FirstParty); len(forbiddenScopes) > 0 {
+		return responses.OAuth2InvalidScope(forbiddenScopes)
 	}
 
 	stateToken, err := gonanoid.New(50)
@@ -308,15 +361,55 @@ func AuthorizeOAuthApplication(w http.ResponseWriter, r *http.Request) error {
 		Account:        account,
 		Application:    oauth2Application,
 		RedirectURI:    reqData.RedirectURI,
+		State:          edgedb.NewOptionalStr(reqData.State),
+		ACRValues:      strings.Fields(reqData.ACRValues),
+	}
+	if authCode.ACRValues == nil {
+		authCode.ACRValues = make([]string, 0)
+	}
+	if reqData.MaxAge != "" {
+		maxAge, _ := strconv.ParseInt(reqData.MaxAge, 10, 64)
+		authCode.MaxAge = edgedb.NewOptionalInt64(maxAge)
+	}
+
+	// Skip the consent page if the account already granted every requested scope to this application,
+	// unless the client asked for a specific authentication, which the consent page has to check.
+	// As user_id is chosen by the caller, this needs the first-party session of that very account,
+	// everyone else is sent to the consent page, which requires it.
+	_, maxAgeRequested := authCode.MaxAge.Get()
+	if len(authCode.ACRValues) == 0 && !maxAgeRequested && isSessionOfAccount(r, account) {
+		consent, err := database.Connection.Queries.GetOAuth2Consent(account.Id, oauth2Application.ID)
+		if err != nil {
+			var edbErr edgedb.Error
+			if !errors.As(err, &edbErr) || !edbErr.Category(edgedb.NoDataError) {
+				return responses.InternalServerErrorResponse()
+			}
+		} else if consent.Covers(scopeSlice) {
+			authCode.Consented = true
+			authCode.GrantedScope = scopeSlice
+		}
 	}
 
 	if err = database.Connection.Queries.CreateNewOAuth2AuthorizationCode(authCode); err != nil {
 		return responses.InternalServerErrorResponse()
 	}
 
+	if authCode.Consented {
+		return responses.ReturnRedirectResponseToClient(w, r, authCode)
+	}
+
 	return responses.ReturnRedirectResponseToConsentPage(w, r, authCode)
 }
 
+// isSessionOfAccount reports whether the request carries a valid first-party session of the account.
+func isSessionOfAccount(r *http.Request, account datatypes.Account) bool {
+	principal, err := authorization.Authenticate(r)
+	if err != nil {
+		return false
+	}
+	return principal.IsFirstPartySession() && principal.Account.Id == account.Id
+}
+
 func OAuthTokenEndpoint(w http.ResponseWriter, r *http.Request) error {
 	var reqData datatypes.OAuthTokenRequest
 	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
@@ -341,23 +434,23 @@ func OAuthTokenEndpoint(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	if reqData.GrantType == "authorization_code" {
-		return handleAuthorizationCodeGrantType(w, reqData, clientID, clientSecret)
+		return handleAuthorizationCodeGrantType(w, reqData, tenancy.GetOrganization(r), clientID, clientSecret)
 	}
 
 	if reqData.GrantType == "refresh_token" {
-		return handleRefreshTokenGrantType(w, reqData)
+		return handleRefreshTokenGrantType(w, reqData, tenancy.GetOrganization(r))
 	}
 
 	return responses.BadRequestResponse()
 }
 
-func handleAuthorizationCodeGrantType(w http.ResponseWriter, reqData datatypes.OAuthTokenRequest, clientID, clientSecret string) error {
+func handleAuthorizationCodeGrantType(w http.ResponseWriter, reqData datatypes.OAuthTokenRequest, tenant datatypes.Organization, clientID, clientSecret string) error {
 	authCode, err := database.Connection.Queries.GetOAuth2AuthorizationCode(reqData.Code)
 	if err != nil {
 		return responses.UnauthorizedErrorResponse("invalid authorization code")
 	}
 
-	if authCode.Application.ClientID != clientID {
+	if authCode.Application.ClientID != clientID || authCode.Application.Organization.Id != tenant.Id {
 		return responses.UnauthorizedErrorResponse("invalid client id")
 	}
 
@@ -376,17 +469,37 @@ func handleAuthorizationCodeGrantType(w http.ResponseWriter, reqData datatypes.O
 	accessTokenExpiresAt := time.Now().Add(time.Hour * 1)
 	refreshTokenExpiresAt := time.Now().Add(time.Hour * 24 * 7)
 
-	accessToken, err := utility.NewAccessToken(authCode.Account, accessTokenExpiresAt, authCode.GrantedScope)
+	if authCode.Account.IsBlocked() {
+		return responses.UnauthorizedErrorResponse("account is not active")
+	}
+
+	if !verification.AllowsLogin(authCode.Account) {
+		return responses.EmailNotVerifiedResponse()
+	}
+
+	grantedScope := verification.CapScope(authCode.Account, scopes.Cap(authCode.GrantedScope, authCode.Account.Permissions()))
+
+	authentication := authCode.Authentication()
+
+	accessToken, err := utility.NewAccessToken(authCode.Account, accessTokenExpiresAt, grantedScope, authentication)
 	if err != nil {
 		return responses.InternalServerErrorResponse()
 	}
 
-	refreshToken, err := utility.NewRefreshToken(authCode.Account, refreshTokenExpiresAt, authCode.GrantedScope)
+	refreshToken, err := utility.NewRefreshToken(authCode.Account, refreshTokenExpiresAt, grantedScope, authentication)
 	if err != nil {
 		return responses.InternalServerErrorResponse()
 	}
 
-	if err = database.Connection.Queries.AddNewTokenPair(authCode.Account.Id, accessToken.Value, refreshToken.Value, accessTokenExpiresAt, refreshTokenExpiresAt, accessToken.Scope); err != nil {
+	var idToken string
+	if slices.Contains(grantedScope, "openid") {
+		idToken, err = utility.GenerateIDToken(accessTokenExpiresAt, authCode.Account, authCode.Application, authentication)
+		if err != nil {
+			return responses.InternalServerErrorResponse()
+		}
+	}
+
+	if err = database.Connection.Queries.AddNewTokenPair(authCode.Account.Id, edgedb.NewOptionalUUID(authCode.Application.ID), accessToken.Value, refreshToken.Value, accessTokenExpiresAt, refreshTokenExpiresAt, accessToken.Scope, authentication); err != nil {
 		return responses.InternalServerErrorResponse()
 	}
 
@@ -394,10 +507,10 @@ func handleAuthorizationCodeGrantType(w http.ResponseWriter, reqData datatypes.O
 		return responses.InternalServerErrorResponse()
 	}
 
-	return responses.SendTokenExchangeSuccessResponse(accessToken, refreshToken, w)
+	return responses.SendTokenExchangeSuccessResponse(accessToken, refreshToken, idToken, w)
 }
 
-func handleRefreshTokenGrantType(w http.ResponseWriter, reqData datatypes.OAuthTokenRequest) error {
+func handleRefreshTokenGrantType(w http.ResponseWriter, reqData datatypes.OAuthTokenRequest, tenant datatypes.Organization) error {
 	refreshToken, err := database.Connection.Queries.GetRefreshToken(reqData.RefreshToken)
 	if err != nil {
 		fmt.Println(err)
@@ -409,24 +522,38 @@ func handleRefreshTokenGrantType(w http.ResponseWriter, reqData datatypes.OAuthT
 		return responses.UnauthorizedErrorResponse("invalid refresh token")
 	}
 
+	if refreshToken.Account.Organization.Id != tenant.Id {
+		return responses.UnauthorizedErrorResponse("invalid refresh token")
+	}
+
 	if refreshToken.ExpiresAt.Before(time.Now()) {
 		return responses.UnauthorizedErrorResponse("refresh token expired")
 	}
 
+	if refreshToken.Account.IsBlocked() {
+		return responses.UnauthorizedErrorResponse("account is not active")
+	}
+
 	accessTokenExpiresAt := time.Now().Add(time.Hour * 1)
 	refreshTokenExpiresAt := time.Now().Add(time.Hour * 24 * 7)
 
-	accessToken, err := utility.NewAccessToken(refreshToken.Account, accessTokenExpiresAt, refreshToken.Scope)
+	// Roles may have changed since the refresh token was issued
+	grantedScope := verification.CapScope(refreshToken.Account, scopes.Cap(refreshToken.Scope, refreshToken.Account.Permissions()))
+
+	// The new tokens belong to the same session, which keeps its authentication
+	authentication := refreshToken.Authentication()
+
+	accessToken, err := utility.NewAccessToken(refreshToken.Account, accessTokenExpiresAt, grantedScope, authentication)
 	if err != nil {
 		return responses.InternalServerErrorResponse()
 	}
 
-	newRefreshToken, err := utility.NewRefreshToken(refreshToken.Account, refreshTokenExpiresAt, refreshToken.Scope)
+	newRefreshToken, err := utility.NewRefreshToken(refreshToken.Account, refreshTokenExpiresAt, grantedScope, authentication)
 	if err != nil {
 		return responses.InternalServerErrorResponse()
 	}
 
-	if err = database.Connection.Queries.AddNewTokenPair(refreshToken.Account.Id, accessToken.Value, newRefreshToken.Value, accessTokenExpiresAt, refreshTokenExpiresAt, accessToken.Scope); err != nil {
+	if err = database.Connection.Queries.AddNewTokenPair(refreshToken.Account.Id, refreshToken.ApplicationID, accessToken.Value, newRefreshToken.Value, accessTokenExpiresAt, refreshTokenExpiresAt, accessToken.Scope, authentication); err != nil {
 		return responses.InternalServerErrorResponse()
 	}
 
@@ -434,7 +561,7 @@ func handleRefreshTokenGrantType(w http.ResponseWriter, reqData datatypes.OAuthT
 		return responses.InternalServerErrorResponse()
 	}
 
-	return responses.SendTokenExchangeSuccessResponse(accessToken, newRefreshToken, w)
+	return responses.SendTokenExchangeSuccessResponse(accessToken, newRefreshToken, "", w)
 }
 
 func RevokeOAuthToken(w http.ResponseWriter, r *http.Request) error {
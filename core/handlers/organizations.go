@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/ghostship-dev/authservice/core/authorization"
+	"github.com/ghostship-dev/authservice/core/database"
+	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/responses"
+	"github.com/ghostship-dev/authservice/core/tenancy"
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+// requireServiceAdministrator restricts organization management to administrators of the default organization.
+// The same goes for the role and scope definitions, which are shared by all organizations.
+func requireServiceAdministrator(r *http.Request) error {
+	principal := authorization.GetPrincipal(r)
+	if principal.Account.Organization.Id != tenancy.Default().Id {
+		return responses.InsufficientScopeResponse([]string{"admin"})
+	}
+	return nil
+}
+
+func ListOrganizations(w http.ResponseWriter, r *http.Request) error {
+	if err := requireServiceAdministrator(r); err != nil {
+		return err
+	}
+	return responses.SendOrganizationsResponse(w, tenancy.All())
+}
+
+func CreateOrganization(w http.ResponseWriter, r *http.Request) error {
+	if err := requireServiceAdministrator(r); err != nil {
+		return err
+	}
+
+	var reqData datatypes.OrganizationRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	signingKey, err := gonanoid.New(64)
+	if err != nil {
+		return responses.InternalServerErrorResponse()
+	}
+
+	organization := reqData.ToOrganization()
+	organization.SigningKey = edgedb.NewOptionalStr(signingKey)
+
+	if err = database.Connection.Queries.CreateOrganization(organization); err != nil {
+		var edbErr edgedb.Error
+		if errors.As(err, &edbErr) && edbErr.Category(edgedb.ConstraintViolationError) {
+			return responses.OrganizationSlugInUseErrorResponse()
+		}
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	if err = database.LoadOrganizations(); err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	return responses.SendNewOKResponseMessage(w, "organization created successfully")
+}
+
+func UpdateOrganization(w http.ResponseWriter, r *http.Request) error {
+	if err := requireServiceAdministrator(r); err != nil {
+		return err
+	}
+
+	var reqData datatypes.OrganizationRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	if _, found := tenancy.GetBySlug(reqData.Slug); !found {
+		return responses.OrganizationNotFoundResponse()
+	}
+
+	if err := database.Connection.Queries.UpdateOrganization(reqData.ToOrganization()); err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	if err := database.LoadOrganizations(); err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	return responses.SendNewOKResponseMessage(w, "organization updated successfully")
+}
+
+func DeleteOrganization(w http.ResponseWriter, r *http.Request) error {
+	if err := requireServiceAdministrator(r); err != nil {
+		return err
+	}
+
+	var reqData datatypes.DeleteOrganizationRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	if _, found := tenancy.GetBySlug(reqData.Slug); !found {
+		return responses.OrganizationNotFoundResponse()
+	}
+
+	if err := database.Connection.Queries.DeleteOrganization(reqData.Slug); err != nil {
+		var edbErr edgedb.Error
+		if errors.As(err, &edbErr) && edbErr.Category(edgedb.ConstraintViolationError) {
+			return responses.OrganizationInUseErrorResponse()
+		}
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	if err := database.LoadOrganizations(); err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	return responses.SendNewOKResponseMessage(w, "organization deleted successfully")
+}
@@ -5,15 +5,15 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"slices"
 	"time"
 
+	"github.com/ghostship-dev/authservice/core/authorization"
 	"github.com/ghostship-dev/authservice/core/database"
-	"github.com/ghostship-dev/authservice/core/utility"
-	"github.com/xlzd/gotp"
-
 	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/lockout"
+	"github.com/ghostship-dev/authservice/core/recovery"
 	"github.com/ghostship-dev/authservice/core/responses"
+	"github.com/ghostship-dev/authservice/core/totp"
 )
 
 func AccountOTP(w http.ResponseWriter, r *http.Request) error {
@@ -31,33 +31,28 @@ func AccountOTP(w http.ResponseWriter, r *http.Request) error {
 		return responses.ValidationErrorResponse(validationErrors)
 	}
 
-	bearerToken, err := utility.GetBearerTokenFromHeader(&r.Header)
-	if err != nil {
-		return responses.UnauthorizedErrorResponse("missing bearer token")
-	}
+	principal := authorization.GetPrincipal(r)
 
-	dbToken, err := database.Connection.Queries.GetToken(bearerToken)
+	// Tokens do not carry the otp secret, it is only loaded where it is needed
+	account, err := database.Connection.Queries.GetLoginAccount(principal.Account.Organization.Id, principal.Account.Id)
 	if err != nil {
 		fmt.Println(err)
-		return responses.UnauthorizedErrorResponse("invalid bearer token")
-	}
-
-	if dbToken.Revoked {
-		return responses.UnauthorizedErrorResponse("token is revoked")
-	}
-
-	// TODO: Replace with a permission decision point in the future
-	if !slices.Contains(dbToken.Scope, "account_otp_write") && !slices.Contains(dbToken.Scope, "account_*") {
-		return responses.UnauthorizedErrorResponse("missing required permission")
+		return responses.InternalServerErrorResponse()
 	}
 
 	// Enable Section
-	if reqData.Action == "enable" && dbToken.Account.OtpState == "disabled" {
-		totpSecret := gotp.RandomSecret(16)
-		totp := gotp.NewDefaultTOTP(totpSecret)
-		qrUri := totp.ProvisioningUri(dbToken.Account.Username, "Ghostship")
+	if reqData.Action == "enable" && account.OtpState == "disabled" {
+		totpSecret := totp.NewSecret()
+		settings := totp.SettingsFromEnv()
+		qrUri := totp.ProvisioningURI(totpSecret, account.Username, "Ghostship", settings)
+
+		sealedSecret, err := totp.SealSecret(account.Id, totpSecret)
+		if err != nil {
+			fmt.Println(err)
+			return responses.InternalServerErrorResponse()
+		}
 
-		if err = database.Connection.Queries.SetOTPSecret(dbToken.Account.Id, totpSecret); err != nil {
+		if err = database.Connection.Queries.SetOTPSecret(account.Id, sealedSecret, settings.Algorithm, int16(settings.Digits), int16(settings.Period)); err != nil {
 			fmt.Println(err)
 			return responses.InternalServerErrorResponse()
 		}
@@ -65,19 +60,21 @@ func AccountOTP(w http.ResponseWriter, r *http.Request) error {
 		return responses.SendActivateTotpSuccessResponse(totpSecret, qrUri, w)
 	}
 
-	secret, secretFound := dbToken.Account.OtpSecret.Get()
-	if !secretFound {
+	if _, secretFound := account.OtpSecret.Get(); !secretFound {
 		return responses.UnauthorizedErrorResponse("invalid otp secret")
 	}
 
 	// Disable Section
-	if reqData.Action == "disable" && dbToken.Account.OtpState == "enabled" {
-		totp := gotp.NewDefaultTOTP(secret)
-		if !totp.Verify(reqData.OTP, time.Now().Unix()) {
-			return responses.UnauthorizedErrorResponse("invalid otp")
+	if reqData.Action == "disable" && account.OtpState == "enabled" {
+		if reqData.RecoveryCode != "" {
+			if err := useRecoveryCode(account, reqData.RecoveryCode); err != nil {
+				return err
+			}
+		} else if err := verifyOTP(account, reqData.OTP); err != nil {
+			return err
 		}
 
-		if err = database.Connection.Queries.ResetOTP(dbToken.Account.Id); err != nil {
+		if err := database.Connection.Queries.ResetOTP(account.Id); err != nil {
 			return responses.InternalServerErrorResponse()
 		}
 
@@ -85,18 +82,119 @@ func AccountOTP(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	// Verify Section
-	if reqData.Action == "verify" && dbToken.Account.OtpState == "verifying" {
-		totp := gotp.NewDefaultTOTP(secret)
-		if !totp.Verify(reqData.OTP, time.Now().Unix()) {
-			return responses.UnauthorizedErrorResponse("invalid otp")
+	if reqData.Action == "verify" && account.OtpState == "verifying" {
+		if err := verifyOTP(account, reqData.OTP); err != nil {
+			return err
+		}
+
+		recoveryCodes, codeHashes, err := recovery.NewRecoveryCodes(account.Id)
+		if err != nil {
+			return responses.InternalServerErrorResponse()
+		}
+
+		if err = database.Connection.Queries.ReplaceRecoveryCodes(account.Id, codeHashes); err != nil {
+			fmt.Println(err)
+			return responses.InternalServerErrorResponse()
 		}
 
-		if err = database.Connection.Queries.SetOTPState(dbToken.Account.Id, "enabled"); err != nil {
+		if err = database.Connection.Queries.SetOTPState(account.Id, "enabled"); err != nil {
 			return responses.InternalServerErrorResponse()
 		}
 
-		return responses.SendEnableTotpSuccessResponse(w)
+		return responses.SendEnableTotpSuccessResponse(w, recoveryCodes)
 	}
 
 	return responses.InvalidTotpStateErrorResponse()
 }
+
+// RegenerateRecoveryCodes replaces every recovery code of the account after confirming the current otp.
+func RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) error {
+	var reqData datatypes.RegenerateRecoveryCodesRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	principal := authorization.GetPrincipal(r)
+
+	if principal.Account.OtpState != "enabled" {
+		return responses.InvalidTotpStateErrorResponse()
+	}
+
+	account, err := database.Connection.Queries.GetLoginAccount(principal.Account.Organization.Id, principal.Account.Id)
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	if err = verifyOTP(account, reqData.OTP); err != nil {
+		return err
+	}
+
+	recoveryCodes, codeHashes, err := recovery.NewRecoveryCodes(account.Id)
+	if err != nil {
+		return responses.InternalServerErrorResponse()
+	}
+
+	if err = database.Connection.Queries.ReplaceRecoveryCodes(account.Id, codeHashes); err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	return responses.SendRecoveryCodesRegeneratedResponse(w, recoveryCodes)
+}
+
+// verifyOTP checks the one-time password against the TOTP secret of the account. Every code is only accepted once,
+// and consecutive invalid codes lock the second factor just like failed passwords lock the password.
+func verifyOTP(account datatypes.Account, code string) error {
+	if lockedUntil, locked := lockout.OTPLockedUntil(account); locked {
+		return responses.AccountLockedResponse(lockedUntil)
+	}
+
+	storedSecret, isSecretSet := account.OtpSecret.Get()
+	if !isSecretSet {
+		return responses.InvalidTotpStateErrorResponse()
+	}
+
+	secret, err := totp.OpenSecret(account.Id, storedSecret)
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	step, matches := totp.Match(secret, code, totp.AccountSettings(account), time.Now())
+	if !matches {
+		registerFailedOTPAttempt(account)
+		return responses.UnauthorizedErrorResponse("invalid otp")
+	}
+
+	claimed, err := database.Connection.Queries.ClaimOTPStep(account.Id, step)
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+	if !claimed {
+		return responses.UnauthorizedErrorResponse("otp was already used")
+	}
+
+	if account.OtpFailedAttempts > 0 {
+		if err = database.Connection.Queries.ResetFailedOTPAttempts(account.Id); err != nil {
+			fmt.Println(err)
+		}
+	}
+	return nil
+}
+
+func registerFailedOTPAttempt(account datatypes.Account) {
+	if _, err := database.Connection.Queries.IncrementFailedOTPAttempts(account.Id); err != nil {
+		fmt.Println(err)
+	}
+}
@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/ghostship-dev/authservice/core/authorization"
+	"github.com/ghostship-dev/authservice/core/database"
+	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/lockout"
+	"github.com/ghostship-dev/authservice/core/otpcodes"
+	"github.com/ghostship-dev/authservice/core/responses"
+)
+
+var phoneNumberRegexp = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+// codeFactorChannels maps the login factors delivering one-time codes to their channels.
+var codeFactorChannels = map[string]string{
+	datatypes.FactorEmailOTP: datatypes.OTPChannelEmail,
+	datatypes.FactorSMSOTP:   datatypes.OTPChannelSMS,
+}
+
+func GetOTPFactors(w http.ResponseWriter, r *http.Request) error {
+	principal := authorization.GetPrincipal(r)
+
+	factors, err := database.Connection.Queries.GetOTPFactors(principal.Account.Id)
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	return responses.SendOTPFactorsResponse(w, factors)
+}
+
+// EnrollOTPFactor starts the enrollment of the email or SMS factor by sending a code to confirm the destination.
+func EnrollOTPFactor(w http.ResponseWriter, r *http.Request) error {
+	var reqData datatypes.EnrollOTPFactorRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	principal := authorization.GetPrincipal(r)
+	account, err := database.Connection.Queries.GetLoginAccount(principal.Account.Organization.Id, principal.Account.Id)
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	factor := datatypes.OTPFactor{Channel: reqData.Channel}
+	if reqData.Channel == datatypes.OTPChannelSMS {
+		if !phoneNumberRegexp.MatchString(reqData.PhoneNumber) {
+			return responses.InvalidPhoneNumberResponse()
+		}
+		factor.PhoneNumber = edgedb.NewOptionalStr(reqData.PhoneNumber)
+	} else if account.Status != datatypes.AccountStatusActive {
+		return responses.EmailNotVerifiedResponse()
+	}
+
+	if existing, found, err := findOTPFactor(account.Id, reqData.Channel); err != nil {
+		return err
+	} else if found && existing.Verified {
+		return responses.OTPFactorAlreadyEnrolledResponse()
+	}
+
+	if err = database.Connection.Queries.SaveOTPFactor(account.Id, factor.Channel, factor.PhoneNumber); err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	destination := otpcodes.Destination(account, factor)
+	expiresAt, err := otpcodes.Send(account, factor.Channel, destination, otpcodes.PurposeEnrollment)
+	if err != nil {
+		return sendCodeErrorResponse(err)
+	}
+
+	return responses.SendOTPCodeSentResponse(w, otpcodes.MaskDestination(factor.Channel, destination), expiresAt)
+}
+
+// VerifyOTPFactor finishes the enrollment with the code sent to the destination of the factor.
+func VerifyOTPFactor(w http.ResponseWriter, r *http.Request) error {
+	var reqData datatypes.VerifyOTPFactorRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	principal := authorization.GetPrincipal(r)
+	account, err := database.Connection.Queries.GetLoginAccount(principal.Account.Organization.Id, principal.Account.Id)
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	factor, found, err := findOTPFactor(account.Id, reqData.Channel)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return responses.OTPFactorNotFoundResponse()
+	}
+	if factor.Verified {
+		return responses.OTPFactorAlreadyEnrolledResponse()
+	}
+
+	if err = verifyDeliveredCode(account, reqData.Channel, otpcodes.PurposeEnrollment, reqData.Code); err != nil {
+		return err
+	}
+
+	if err = database.Connection.Queries.VerifyOTPFactor(account.Id, reqData.Channel); err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	return responses.SendOTPFactorEnabledResponse(w)
+}
+
+func DeleteOTPFactor(w http.ResponseWriter, r *http.Request) error {
+	var reqData datatypes.DeleteOTPFactorRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	principal := authorization.GetPrincipal(r)
+
+	deleted, err := database.Connection.Queries.DeleteOTPFactor(principal.Account.Id, reqData.Channel)
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+	if !deleted {
+		return responses.OTPFactorNotFoundResponse()
+	}
+
+	return responses.SendNewOKResponse(w)
+}
+
+func findOTPFactor(accountId edgedb.UUID, channel string) (datatypes.OTPFactor, bool, error) {
+	factors, err := database.Connection.Queries.GetOTPFactors(accountId)
+	if err != nil {
+		fmt.Println(err)
+		return datatypes.OTPFactor{}, false, responses.InternalServerErrorResponse()
+	}
+	for _, factor := range factors {
+		if factor.Channel == channel {
+			return factor, true, nil
+		}
+	}
+	return datatypes.OTPFactor{}, false, nil
+}
+
+// verifyDeliveredCode checks a code sent by email or SMS. Wrong codes count towards the one-time password lock.
+func verifyDeliveredCode(account datatypes.Account, channel, purpose, code string) error {
+	if lockedUntil, locked := lockout.OTPLockedUntil(account); locked {
+		return responses.AccountLockedResponse(lockedUntil)
+	}
+
+	valid, err := otpcodes.Verify(account.Id, channel, purpose, code)
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+	if !valid {
+		registerFailedOTPAttempt(account)
+		return responses.UnauthorizedErrorResponse("invalid or expired code")
+	}
+
+	if account.OtpFailedAttempts > 0 {
+		if err = database.Connection.Queries.ResetFailedOTPAttempts(account.Id); err != nil {
+			fmt.Println(err)
+		}
+	}
+	return nil
+}
+
+func sendCodeErrorResponse(err error) error {
+	if errors.Is(err, otpcodes.ErrRateLimited) {
+		return responses.OTPCodeRateLimitedResponse()
+	}
+	fmt.Println(err)
+	return responses.InternalServerErrorResponse()
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/ghostship-dev/authservice/core/authorization"
+	"github.com/ghostship-dev/authservice/core/database"
+	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/lockout"
+	"github.com/ghostship-dev/authservice/core/passwords"
+	"github.com/ghostship-dev/authservice/core/recovery"
+	"github.com/ghostship-dev/authservice/core/responses"
+)
+
+// ChangePassword sets the new password after checking the current one and signs the account out everywhere.
+func ChangePassword(w http.ResponseWriter, r *http.Request) error {
+	var reqData datatypes.ChangePasswordRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	principal := authorization.GetPrincipal(r)
+
+	password, err := database.Connection.Queries.GetPasswordByAccountId(principal.Account.Id)
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	if lockedUntil, locked := lockout.LockedUntil(password); locked {
+		return responses.AccountLockedResponse(lockedUntil)
+	}
+
+	if matches, _, err := passwords.Verify(reqData.CurrentPassword, password.Password); err != nil || !matches {
+		registerFailedAttempt(password)
+		return responses.UnauthorizedErrorResponse("invalid current password")
+	}
+
+	if password.Account.OtpState == "enabled" {
+		if reqData.OTP == "" {
+			return responses.TwoFactorAuthenticationRequiredResponse()
+		}
+		if err = verifyOTP(password.Account, reqData.OTP); err != nil {
+			return err
+		}
+	}
+
+	passwordHash, previousPasswords, err := hashNewPassword("new_password", reqData.NewPassword, password)
+	if err != nil {
+		return err
+	}
+
+	if err = database.Connection.Queries.UpdatePassword(password.Account.Id, passwordHash, previousPasswords); err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	recovery.SendPasswordChangedMail(password.Account)
+
+	return responses.SendNewOKResponseMessage(w, "password changed successfully")
+}
+
+// hashNewPassword checks the new password against the password policy and the password history
+// and returns its hash together with the history to store.
+func hashNewPassword(field, newPassword string, current datatypes.Password) (string, []string, error) {
+	policy := passwords.PolicyFromEnv()
+
+	if violations := policy.Check(newPassword, current.Account.Email, current.Account.Username); len(violations) > 0 {
+		return "", nil, responses.ValidationErrorResponse(map[string]string{field: strings.Join(violations, ", ")})
+	}
+
+	if policy.IsReused(newPassword, append([]string{current.Password}, current.PreviousPasswords...)) {
+		return "", nil, responses.ValidationErrorResponse(map[string]string{field: "password was used recently, choose a different one"})
+	}
+
+	hashedPassword, err := passwords.Hash(newPassword)
+	if err != nil {
+		return "", nil, responses.InternalServerErrorResponse()
+	}
+
+	return hashedPassword, policy.History(current.Password, current.PreviousPasswords), nil
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/ghostship-dev/authservice/core/authorization"
+	"github.com/ghostship-dev/authservice/core/database"
+	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/lockout"
+	"github.com/ghostship-dev/authservice/core/passwords"
+	"github.com/ghostship-dev/authservice/core/profile"
+	"github.com/ghostship-dev/authservice/core/responses"
+	"github.com/ghostship-dev/authservice/core/verification"
+)
+
+func GetProfile(w http.ResponseWriter, r *http.Request) error {
+	principal := authorization.GetPrincipal(r)
+
+	account, err := database.Connection.Queries.GetProfile(principal.Account.Id)
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	response := responses.ProfileResponse{
+		ID:       account.Id.String(),
+		Username: account.Username,
+	}
+	response.AvatarURI, _ = account.AvatarURI.Get()
+
+	if principal.Can("email") || principal.Can("account_read") {
+		emailVerified := verification.IsVerified(account)
+		response.Email = account.Email
+		response.EmailVerified = &emailVerified
+	}
+
+	if principal.Can("account_read") {
+		otpEnabled := account.OtpState == "enabled"
+		response.PendingEmail, _ = account.PendingEmail.Get()
+		response.Status = account.Status
+		response.StatusDescription, _ = account.StatusDescription.Get()
+		response.OtpEnabled = &otpEnabled
+		response.Roles = account.RoleNames()
+		if otpEnabled {
+			remaining, err := database.Connection.Queries.CountRecoveryCodes(account.Id)
+			if err != nil {
+				fmt.Println(err)
+				return responses.InternalServerErrorResponse()
+			}
+			response.RecoveryCodesLeft = &remaining
+		}
+		if createdAt, isSet := account.CreatedAt.Get(); isSet {
+			response.CreatedAt = &createdAt
+		}
+	}
+
+	return responses.SendProfileResponse(w, response)
+}
+
+// UpdateProfile changes the username and avatar right away. A new email address only replaces
+// the current one after it was verified, and the current address is notified about the change.
+func UpdateProfile(w http.ResponseWriter, r *http.Request) error {
+	var reqData datatypes.UpdateProfileRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	validationErrors := make(map[string]string)
+	if reqData.Username != nil {
+		if message := profile.ValidateUsername(*reqData.Username); message != "" {
+			validationErrors["username"] = message
+		}
+	}
+	if reqData.AvatarURI != nil && *reqData.AvatarURI != "" {
+		if message := profile.ValidateAvatarURI(*reqData.AvatarURI); message != "" {
+			validationErrors["avatar_uri"] = message
+		}
+	}
+	if reqData.Email != nil && !profile.IsValidEmail(*reqData.Email) {
+		validationErrors["email"] = "email is invalid"
+	}
+	if len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	principal := authorization.GetPrincipal(r)
+
+	account, err := database.Connection.Queries.GetProfile(principal.Account.Id)
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	changeEmail := reqData.Email != nil && *reqData.Email != account.Email
+	if changeEmail {
+		// Third-party applications must not be able to take over the account by redirecting its email
+		if !principal.IsFirstPartySession() {
+			return responses.InsufficientScopeResponse([]string{authorization.FirstPartySession})
+		}
+
+		// Redirecting the email also redirects password resets, so it needs a recent, strong enough login
+		if !principal.SatisfiesStepUp() {
+			return authorization.StepUpRequiredResponse()
+		}
+
+		password, err := database.Connection.Queries.GetPasswordByAccountId(account.Id)
+		if err != nil {
+			fmt.Println(err)
+			return responses.InternalServerErrorResponse()
+		}
+
+		if lockedUntil, locked := lockout.LockedUntil(password); locked {
+			return responses.AccountLockedResponse(lockedUntil)
+		}
+
+		if matches, _, err := passwords.Verify(reqData.Password, password.Password); err != nil || !matches {
+			registerFailedAttempt(password)
+			return responses.UnauthorizedErrorResponse("invalid password")
+		}
+
+		if _, err = database.Connection.Queries.GetAccountByEmail(account.Organization.Id, *reqData.Email); err == nil {
+			return responses.EmailInUseErrorResponse()
+		} else {
+			var edbErr edgedb.Error
+			if !errors.As(err, &edbErr) || !edbErr.Category(edgedb.NoDataError) {
+				fmt.Println(err)
+				return responses.InternalServerErrorResponse()
+			}
+		}
+	}
+
+	var username edgedb.OptionalStr
+	if reqData.Username != nil && *reqData.Username != account.Username {
+		taken, err := database.Connection.Queries.IsUsernameTaken(account.Organization.Id, *reqData.Username, edgedb.NewOptionalUUID(account.Id))
+		if err != nil {
+			fmt.Println(err)
+			return responses.InternalServerErrorResponse()
+		}
+		if taken {
+			return responses.UsernameInUseErrorResponse()
+		}
+		username = edgedb.NewOptionalStr(*reqData.Username)
+	}
+
+	var avatarURI edgedb.OptionalStr
+	if reqData.AvatarURI != nil && *reqData.AvatarURI != "" {
+		avatarURI = edgedb.NewOptionalStr(*reqData.AvatarURI)
+	}
+
+	if err = database.Connection.Queries.UpdateProfile(account.Id, username, avatarURI, reqData.AvatarURI != nil); err != nil {
+		var edbErr edgedb.Error
+		if errors.As(err, &edbErr) && edbErr.Category(edgedb.ConstraintViolationError) {
+			return responses.UsernameInUseErrorResponse()
+		}
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	if !changeEmail {
+		return responses.SendNewOKResponseMessage(w, "profile updated successfully")
+	}
+
+	if err = database.Connection.Queries.SetPendingEmail(account.Id, *reqData.Email); err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	if newUsername, isSet := username.Get(); isSet {
+		account.Username = newUsername
+	}
+	if err = verification.SendEmailChangeMail(account, *reqData.Email); err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+	profile.SendEmailChangeNoticeMail(account, *reqData.Email)
+
+	return responses.SendEmailChangeRequestedResponse(w)
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/ghostship-dev/authservice/core/database"
+	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/recovery"
+	"github.com/ghostship-dev/authservice/core/responses"
+	"github.com/ghostship-dev/authservice/core/tenancy"
+	"github.com/ghostship-dev/authservice/core/utility"
+)
+
+func RequestPasswordReset(w http.ResponseWriter, r *http.Request) error {
+	var reqData datatypes.PasswordResetRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	account, err := database.Connection.Queries.GetAccountByEmail(tenancy.GetOrganization(r).Id, reqData.Email)
+	if err != nil {
+		var edbErr edgedb.Error
+		if errors.As(err, &edbErr) && edbErr.Category(edgedb.NoDataError) {
+			return responses.SendPasswordResetRequestedResponse(w)
+		}
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	// Requests over the limit are answered like any other, so the response still does not reveal the account
+	clientIP := utility.ClientIP(r)
+	limit, window := recovery.ResetRateLimit()
+	count, err := database.Connection.Queries.CountPasswordResetTokens(account.Id, clientIP, time.Now().Add(-window))
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+	if count.Account >= limit || count.Address >= limit {
+		fmt.Println(fmt.Sprintf("password reset for account %s requested from %s was rate limited", account.Id, clientIP))
+		return responses.SendPasswordResetRequestedResponse(w)
+	}
+
+	resetToken, err := recovery.NewResetToken()
+	if err != nil {
+		return responses.InternalServerErrorResponse()
+	}
+
+	if err = database.Connection.Queries.CreatePasswordResetToken(account.Id, utility.HashToken(resetToken), clientIP, time.Now().Add(recovery.ResetTokenTTL())); err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	recovery.SendResetMail(account, resetToken)
+
+	return responses.SendPasswordResetRequestedResponse(w)
+}
+
+// ConfirmPasswordReset sets the new password, consumes the reset token and signs the account out everywhere.
+func ConfirmPasswordReset(w http.ResponseWriter, r *http.Request) error {
+	var reqData datatypes.ConfirmPasswordResetRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	organization := tenancy.GetOrganization(r)
+	tokenHash := utility.HashToken(reqData.Token)
+
+	accountId, err := database.Connection.Queries.GetPasswordResetTokenAccountId(organization.Id, tokenHash)
+	if err != nil {
+		var edbErr edgedb.Error
+		if errors.As(err, &edbErr) && edbErr.Category(edgedb.NoDataError) {
+			return responses.InvalidPasswordResetTokenResponse()
+		}
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	password, err := database.Connection.Queries.GetPasswordByAccountId(accountId)
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	passwordHash, previousPasswords, err := hashNewPassword("password", reqData.Password, password)
+	if err != nil {
+		return err
+	}
+
+	account, err := database.Connection.Queries.ResetPasswordWithToken(organization.Id, tokenHash, passwordHash, previousPasswords)
+	if err != nil {
+		var edbErr edgedb.Error
+		if errors.As(err, &edbErr) && edbErr.Category(edgedb.NoDataError) {
+			return responses.InvalidPasswordResetTokenResponse()
+		}
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	recovery.SendPasswordChangedMail(account)
+
+	return responses.SendPasswordResetSuccessResponse(w)
+}
@@ -3,14 +3,21 @@ package handlers
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/edgedb/edgedb-go"
+	"github.com/ghostship-dev/authservice/core/config"
 	"github.com/ghostship-dev/authservice/core/database"
 	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/passwords"
+	"github.com/ghostship-dev/authservice/core/profile"
 	"github.com/ghostship-dev/authservice/core/responses"
+	"github.com/ghostship-dev/authservice/core/tenancy"
+	"github.com/ghostship-dev/authservice/core/verification"
 )
 
 func RegisterHandler(w http.ResponseWriter, r *http.Request) error {
@@ -28,7 +35,30 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) error {
 		return responses.ValidationErrorResponse(validationErrors)
 	}
 
-	account, err := database.Connection.Queries.CreateAccount(reqData.Email, reqData.Username, reqData.Password)
+	if message := profile.ValidateUsername(reqData.Username); message != "" {
+		return responses.ValidationErrorResponse(map[string]string{"username": message})
+	}
+
+	organization := tenancy.GetOrganization(r)
+	taken, err := database.Connection.Queries.IsUsernameTaken(organization.Id, reqData.Username, edgedb.OptionalUUID{})
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+	if taken {
+		return responses.UsernameInUseErrorResponse()
+	}
+
+	if violations := passwords.PolicyFromEnv().Check(reqData.Password, reqData.Email, reqData.Username); len(violations) > 0 {
+		return responses.ValidationErrorResponse(map[string]string{"password": strings.Join(violations, ", ")})
+	}
+
+	passwordHash, err := passwords.Hash(reqData.Password)
+	if err != nil {
+		return responses.InternalServerErrorResponse()
+	}
+
+	account, err := database.Connection.Queries.CreateAccount(organization.Id, reqData.Email, reqData.Username, passwordHash, config.GetEnv("DEFAULT_ROLE", datatypes.UserRole))
 	if err != nil {
 		var edbErr edgedb.Error
 		if errors.As(err, &edbErr) && edbErr.Category(edgedb.ConstraintViolationError) {
@@ -42,7 +72,20 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) error {
 		return responses.InternalServerErrorResponse()
 	}
 
-	_ = account
+	account.Organization = organization
+	account.Email = reqData.Email
+	account.Username = reqData.Username
+	account.Status = datatypes.AccountStatusCreated
+
+	if verification.Mode() == verification.ModeOff {
+		if err = database.Connection.Queries.SetAccountStatus(account.Id, datatypes.AccountStatusActive); err != nil {
+			fmt.Println(err)
+			return responses.InternalServerErrorResponse()
+		}
+	} else if err = sendVerificationEmail(account, time.Now()); err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
 
 	return responses.SendRegisterSuccessResponse(w)
 }
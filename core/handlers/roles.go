@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/ghostship-dev/authservice/core/database"
+	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/responses"
+	"github.com/ghostship-dev/authservice/core/tenancy"
+)
+
+func ListRoles(w http.ResponseWriter, r *http.Request) error {
+	roles, err := database.Connection.Queries.GetRoles()
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+	return responses.SendRolesResponse(w, roles)
+}
+
+func CreateRole(w http.ResponseWriter, r *http.Request) error {
+	if err := requireServiceAdministrator(r); err != nil {
+		return err
+	}
+
+	var reqData datatypes.RoleRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	if err := database.Connection.Queries.CreateRole(reqData.ToRole()); err != nil {
+		var edbErr edgedb.Error
+		if errors.As(err, &edbErr) && edbErr.Category(edgedb.ConstraintViolationError) {
+			return responses.RoleNameInUseErrorResponse()
+		}
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	return responses.SendNewOKResponseMessage(w, "role created successfully")
+}
+
+func UpdateRole(w http.ResponseWriter, r *http.Request) error {
+	if err := requireServiceAdministrator(r); err != nil {
+		return err
+	}
+
+	var reqData datatypes.RoleRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	if err := requireExistingRole(reqData.Name); err != nil {
+		return err
+	}
+
+	if err := database.Connection.Queries.UpdateRole(reqData.ToRole()); err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	return responses.SendNewOKResponseMessage(w, "role updated successfully")
+}
+
+func DeleteRole(w http.ResponseWriter, r *http.Request) error {
+	if err := requireServiceAdministrator(r); err != nil {
+		return err
+	}
+
+	var reqData datatypes.DeleteRoleRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	if err := requireExistingRole(reqData.Name); err != nil {
+		return err
+	}
+
+	if err := database.Connection.Queries.DeleteRole(reqData.Name); err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	return responses.SendNewOKResponseMessage(w, "role deleted successfully")
+}
+
+func AssignAccountRole(w http.ResponseWriter, r *http.Request) error {
+	var reqData datatypes.AccountRoleRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	if err := requireExistingRole(reqData.Role); err != nil {
+		return err
+	}
+
+	accountId, _ := edgedb.ParseUUID(reqData.AccountID)
+	assigned, err := database.Connection.Queries.AssignRole(tenancy.GetOrganization(r).Id, accountId, reqData.Role)
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+	if !assigned {
+		return responses.RoleAccountNotFoundResponse()
+	}
+
+	return responses.SendNewOKResponseMessage(w, "role assigned successfully")
+}
+
+func UnassignAccountRole(w http.ResponseWriter, r *http.Request) error {
+	var reqData datatypes.AccountRoleRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	accountId, _ := edgedb.ParseUUID(reqData.AccountID)
+	unassigned, err := database.Connection.Queries.UnassignRole(tenancy.GetOrganization(r).Id, accountId, reqData.Role)
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+	if !unassigned {
+		return responses.RoleAccountNotFoundResponse()
+	}
+
+	return responses.SendNewOKResponseMessage(w, "role unassigned successfully")
+}
+
+func requireExistingRole(name string) error {
+	roles, err := database.Connection.Queries.GetRoles()
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+	if !slices.ContainsFunc(roles, func(role datatypes.Role) bool { return role.Name == name }) {
+		return responses.RoleNotFoundResponse()
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/ghostship-dev/authservice/core/database"
+	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/federation"
+	"github.com/ghostship-dev/authservice/core/responses"
+	"github.com/ghostship-dev/authservice/core/saml"
+	"github.com/ghostship-dev/authservice/core/tenancy"
+	"github.com/ghostship-dev/authservice/core/utility"
+)
+
+// SAMLMetadata serves the service provider metadata of the organization for registering it with identity providers.
+func SAMLMetadata(w http.ResponseWriter, r *http.Request) error {
+	metadata, err := saml.Metadata(tenancy.GetOrganization(r))
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	_, err = w.Write(metadata)
+	return err
+}
+
+// SAMLSingleSignOn sends the authentication request of the relay state to a provider using the HTTP-POST binding,
+// with a page the browser submits to the provider.
+func SAMLSingleSignOn(w http.ResponseWriter, r *http.Request) error {
+	relayState := r.URL.Query().Get("RelayState")
+	if relayState == "" {
+		return responses.ValidationErrorResponse(map[string]string{"RelayState": "RelayState is required"})
+	}
+
+	state, err := getPendingSAMLState(tenancy.GetOrganization(r), relayState)
+	if err != nil {
+		return err
+	}
+
+	request, err := saml.AuthnRequest(state.Provider, state.Nonce, time.Now())
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+	return saml.WritePOSTForm(w, state.Provider, request, relayState)
+}
+
+// SAMLAssertionConsumer validates the response a provider posts with the browser and remembers the identity it asserts.
+// As the browser cookie is not sent along with the cross-site post, the browser is sent on to FEDERATION_CALLBACK_URL
+// with the relay state and a one-time code, and completes the sign in at the callback endpoint like with any other provider.
+// The resulting session continues into /oauth/authorize like a password login, with the multi-factor authentication
+// the provider reports counting towards the acr.
+func SAMLAssertionConsumer(w http.ResponseWriter, r *http.Request) error {
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+	if err := r.ParseForm(); err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	relayState, samlResponse := r.PostForm.Get("RelayState"), r.PostForm.Get("SAMLResponse")
+	if relayState == "" || samlResponse == "" {
+		return responses.ValidationErrorResponse(map[string]string{"SAMLResponse": "SAMLResponse and RelayState are required"})
+	}
+
+	organization := tenancy.GetOrganization(r)
+	state, err := getPendingSAMLState(organization, relayState)
+	if err != nil {
+		return err
+	}
+	provider := state.Provider
+
+	assertion, err := saml.ParseResponse(provider, samlResponse, state.Nonce, time.Now())
+	if err != nil {
+		fmt.Println(fmt.Sprintf("saml response of identity provider %s rejected: %s", provider.Name, err))
+		return redirectToFederationCallback(w, r, organization, url.Values{"state": {relayState}, "error": {samlErrorCode(err)}})
+	}
+
+	if err = database.Connection.Queries.RecordSAMLAssertion(provider.Id, assertion.ID, assertion.ExpiresAt); err != nil {
+		var edbErr edgedb.Error
+		if errors.As(err, &edbErr) && edbErr.Category(edgedb.ConstraintViolationError) {
+			fmt.Println(fmt.Sprintf("saml assertion %s of identity provider %s was replayed", assertion.ID, provider.Name))
+			return redirectToFederationCallback(w, r, organization, url.Values{"state": {relayState}, "error": {"invalid_response"}})
+		}
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	identity, err := json.Marshal(saml.Identity(provider, assertion))
+	if err != nil {
+		return responses.InternalServerErrorResponse()
+	}
+	code, err := federation.NewRandom()
+	if err != nil {
+		return responses.InternalServerErrorResponse()
+	}
+
+	completed, err := database.Connection.Queries.CompleteFederationState(state.Id, utility.HashToken(code), string(identity))
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+	if !completed {
+		return responses.InvalidFederationStateResponse()
+	}
+
+	return redirectToFederationCallback(w, r, organization, url.Values{"state": {relayState}, "code": {code}})
+}
+
+func getPendingSAMLState(organization datatypes.Organization, relayState string) (datatypes.FederationState, error) {
+	state, err := database.Connection.Queries.GetPendingSAMLState(organization.Id, utility.HashToken(relayState))
+	if err != nil {
+		var edbErr edgedb.Error
+		if errors.As(err, &edbErr) && edbErr.Category(edgedb.NoDataError) {
+			return datatypes.FederationState{}, responses.InvalidFederationStateResponse()
+		}
+		fmt.Println(err)
+		return datatypes.FederationState{}, responses.InternalServerErrorResponse()
+	}
+	if !state.Provider.Enabled {
+		return datatypes.FederationState{}, responses.IdentityProviderNotFoundResponse()
+	}
+	return state, nil
+}
+
+// samlErrorCode returns the error the browser passes to the callback endpoint for a rejected response.
+func samlErrorCode(err error) string {
+	if errors.Is(err, saml.ErrUnsuccessful) {
+		return "access_denied"
+	}
+	return "invalid_response"
+}
+
+func redirectToFederationCallback(w http.ResponseWriter, r *http.Request, organization datatypes.Organization, params url.Values) error {
+	callbackURL := federation.RedirectURI(organization)
+	separator := "?"
+	if strings.Contains(callbackURL, "?") {
+		separator = "&"
+	}
+	http.Redirect(w, r, callbackURL+separator+params.Encode(), http.StatusSeeOther)
+	return nil
+}
+
+// samlRequestURL returns the URL sending the browser to the provider with the authentication request of the relay state.
+// With the HTTP-POST binding the browser picks the request up from the single sign-on endpoint, which posts it on.
+func samlRequestURL(provider datatypes.IdentityProvider, relayState, requestID string) (string, error) {
+	if provider.SAMLBinding == datatypes.SAMLBindingPOST {
+		return saml.SingleSignOnURL(provider.Organization, relayState), nil
+	}
+
+	request, err := saml.AuthnRequest(provider, requestID, time.Now())
+	if err != nil {
+		fmt.Println(err)
+		return "", responses.InternalServerErrorResponse()
+	}
+	redirectURL, err := saml.RedirectURL(provider, request, relayState)
+	if err != nil {
+		fmt.Println(err)
+		return "", responses.InternalServerErrorResponse()
+	}
+	return redirectURL, nil
+}
+
+// samlIdentity picks up the identity the assertion consumer stored for the state with the code it sent the browser on with.
+func samlIdentity(state datatypes.FederationState, code string) (federation.Identity, error) {
+	codeHash, isCompleted := state.CodeHash.Get()
+	encoded, _ := state.Identity.Get()
+	if !isCompleted || subtle.ConstantTimeCompare([]byte(utility.HashToken(code)), []byte(codeHash)) != 1 {
+		return federation.Identity{}, errors.New("code does not match the saml response of the state")
+	}
+
+	var identity federation.Identity
+	if err := json.Unmarshal([]byte(encoded), &identity); err != nil {
+		return federation.Identity{}, err
+	}
+	return identity, nil
+}
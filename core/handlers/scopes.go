@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/ghostship-dev/authservice/core/database"
+	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/responses"
+	"github.com/ghostship-dev/authservice/core/scopes"
+)
+
+func ListScopes(w http.ResponseWriter, r *http.Request) error {
+	return responses.NewJSONResponse(w, http.StatusOK, responses.GenericDataResponse{
+		Error: false,
+		Data:  scopes.All(),
+	})
+}
+
+func CreateScope(w http.ResponseWriter, r *http.Request) error {
+	if err := requireServiceAdministrator(r); err != nil {
+		return err
+	}
+
+	var reqData datatypes.ScopeRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	if err := database.Connection.Queries.CreateScope(reqData.ToScope()); err != nil {
+		var edbErr edgedb.Error
+		if errors.As(err, &edbErr) && edbErr.Category(edgedb.ConstraintViolationError) {
+			return responses.ScopeNameInUseErrorResponse()
+		}
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	if err := database.LoadScopeRegistry(); err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	return responses.SendNewOKResponseMessage(w, "scope created successfully")
+}
+
+func UpdateScope(w http.ResponseWriter, r *http.Request) error {
+	if err := requireServiceAdministrator(r); err != nil {
+		return err
+	}
+
+	var reqData datatypes.ScopeRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	if _, found := scopes.Get(reqData.Name); !found {
+		return responses.ScopeNotFoundResponse()
+	}
+
+	if err := database.Connection.Queries.UpdateScope(reqData.ToScope()); err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	if err := database.LoadScopeRegistry(); err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	return responses.SendNewOKResponseMessage(w, "scope updated successfully")
+}
+
+func DeleteScope(w http.ResponseWriter, r *http.Request) error {
+	if err := requireServiceAdministrator(r); err != nil {
+		return err
+	}
+
+	var reqData datatypes.DeleteScopeRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	if _, found := scopes.Get(reqData.Name); !found {
+		return responses.ScopeNotFoundResponse()
+	}
+
+	if err := database.Connection.Queries.DeleteScope(reqData.Name); err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	if err := database.LoadScopeRegistry(); err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	return responses.SendNewOKResponseMessage(w, "scope deleted successfully")
+}
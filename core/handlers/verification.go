@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/ghostship-dev/authservice/core/database"
+	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/responses"
+	"github.com/ghostship-dev/authservice/core/tenancy"
+	"github.com/ghostship-dev/authservice/core/verification"
+)
+
+// VerifyEmail activates the account the verification link was issued for, or completes a change of its email address.
+// The token is read from the query string when the link is opened directly, otherwise from the JSON body.
+func VerifyEmail(w http.ResponseWriter, r *http.Request) error {
+	var reqData datatypes.VerifyEmailRequest
+
+	if r.Method == http.MethodGet {
+		reqData.Token = r.URL.Query().Get("token")
+	} else {
+		if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+			return responses.BadRequestResponse()
+		}
+
+		defer func(Body io.ReadCloser) {
+			_ = Body.Close()
+		}(r.Body)
+	}
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	accountId, email, err := verification.ParseToken(tenancy.GetOrganization(r), reqData.Token)
+	if err != nil {
+		return responses.InvalidVerificationTokenResponse()
+	}
+
+	activated, err := database.Connection.Queries.ActivateAccount(accountId, email)
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	// Links for a changed email address are bound to the pending address instead
+	if !activated {
+		activated, err = database.Connection.Queries.ConfirmEmailChange(accountId, email)
+		if err != nil {
+			var edbErr edgedb.Error
+			if errors.As(err, &edbErr) && edbErr.Category(edgedb.ConstraintViolationError) {
+				return responses.EmailInUseErrorResponse()
+			}
+			fmt.Println(err)
+			return responses.InternalServerErrorResponse()
+		}
+	}
+	if !activated {
+		return responses.InvalidVerificationTokenResponse()
+	}
+
+	return responses.SendEmailVerifiedResponse(w)
+}
+
+func ResendVerificationEmail(w http.ResponseWriter, r *http.Request) error {
+	var reqData datatypes.ResendVerificationEmailRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	account, err := database.Connection.Queries.GetAccountByEmail(tenancy.GetOrganization(r).Id, reqData.Email)
+	if err != nil {
+		var edbErr edgedb.Error
+		if errors.As(err, &edbErr) && edbErr.Category(edgedb.NoDataError) {
+			return responses.SendVerificationEmailQueuedResponse(w)
+		}
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	if err = sendVerificationEmail(account, time.Now().Add(-verification.ResendInterval())); err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	return responses.SendVerificationEmailQueuedResponse(w)
+}
+
+// sendVerificationEmail mails a verification link unless the account is already verified
+// or the previous mail was sent after lastSentBefore.
+func sendVerificationEmail(account datatypes.Account, lastSentBefore time.Time) error {
+	if verification.IsVerified(account) {
+		return nil
+	}
+	marked, err := database.Connection.Queries.MarkVerificationMailSent(account.Id, lastSentBefore)
+	if err != nil || !marked {
+		return err
+	}
+	return verification.SendMail(account)
+}
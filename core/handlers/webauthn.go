@@ -0,0 +1,294 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/ghostship-dev/authservice/core/authn"
+	"github.com/ghostship-dev/authservice/core/authorization"
+	"github.com/ghostship-dev/authservice/core/database"
+	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/passkeys"
+	"github.com/ghostship-dev/authservice/core/responses"
+	"github.com/ghostship-dev/authservice/core/tenancy"
+	"github.com/go-webauthn/webauthn/protocol"
+)
+
+// loadWebAuthnUser loads the account of the organization together with its registered credentials.
+func loadWebAuthnUser(organization datatypes.Organization, accountId edgedb.UUID) (*passkeys.User, error) {
+	account, err := database.Connection.Queries.GetLoginAccount(organization.Id, accountId)
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := database.Connection.Queries.GetWebAuthnCredentials(accountId)
+	if err != nil {
+		return nil, err
+	}
+	return &passkeys.User{Account: account, Credentials: credentials}, nil
+}
+
+// startWebAuthnLogin starts an assertion ceremony, for the user as second factor or for any passkey if user is nil.
+func startWebAuthnLogin(organization datatypes.Organization, user *passkeys.User) (*protocol.CredentialAssertion, error) {
+	relyingParty, err := passkeys.RelyingParty(organization)
+	if err != nil {
+		return nil, err
+	}
+
+	assertion, session, err := passkeys.BeginLogin(relyingParty, user)
+	if err != nil {
+		return nil, err
+	}
+
+	var accountId edgedb.OptionalUUID
+	if user != nil {
+		accountId = edgedb.NewOptionalUUID(user.Account.Id)
+	}
+	if err = storeWebAuthnSession(organization, accountId, passkeys.CeremonyLogin, session.Challenge, session); err != nil {
+		return nil, err
+	}
+	return assertion, nil
+}
+
+func storeWebAuthnSession(organization datatypes.Organization, accountId edgedb.OptionalUUID, ceremony, challenge string, session interface{}) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return database.Connection.Queries.CreateWebAuthnSession(organization.Id, accountId, ceremony, challenge, data, time.Now().Add(passkeys.Timeout()))
+}
+
+// verifyWebAuthnAssertion validates the assertion against the ceremony it answers and records the new sign counter of the credential.
+func verifyWebAuthnAssertion(organization datatypes.Organization, user *passkeys.User, response *protocol.ParsedCredentialAssertionData) error {
+	stored, err := database.Connection.Queries.ConsumeWebAuthnSession(organization.Id, passkeys.CeremonyLogin, response.Response.CollectedClientData.Challenge)
+	if err != nil {
+		return responses.InvalidWebAuthnResponse()
+	}
+	if accountId, isSet := stored.Account.Get(); isSet && accountId != user.Account.Id {
+		return responses.InvalidWebAuthnResponse()
+	}
+
+	session, err := passkeys.DecodeSession(stored.Data)
+	if err != nil {
+		return responses.InternalServerErrorResponse()
+	}
+
+	relyingParty, err := passkeys.RelyingParty(organization)
+	if err != nil {
+		return responses.InternalServerErrorResponse()
+	}
+
+	credential, err := passkeys.FinishLogin(relyingParty, user, session, response)
+	if err != nil {
+		fmt.Println(err)
+		return responses.InvalidWebAuthnResponse()
+	}
+
+	if err = database.Connection.Queries.UpdateWebAuthnCredentialUsage(credential.Id, credential.SignCount, credential.BackupState); err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+	return nil
+}
+
+func BeginWebAuthnRegistration(w http.ResponseWriter, r *http.Request) error {
+	organization := tenancy.GetOrganization(r)
+
+	user, err := loadWebAuthnUser(organization, authorization.GetPrincipal(r).Account.Id)
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	relyingParty, err := passkeys.RelyingParty(organization)
+	if err != nil {
+		return responses.InternalServerErrorResponse()
+	}
+
+	creation, session, err := passkeys.BeginRegistration(relyingParty, user)
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	if err = storeWebAuthnSession(organization, edgedb.NewOptionalUUID(user.Account.Id), passkeys.CeremonyRegistration, session.Challenge, session); err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	return responses.SendWebAuthnOptionsResponse(w, creation)
+}
+
+func FinishWebAuthnRegistration(w http.ResponseWriter, r *http.Request) error {
+	var reqData datatypes.FinishWebAuthnRegistrationRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	response, err := passkeys.ParseRegistrationResponse(bytes.NewReader(reqData.Credential))
+	if err != nil {
+		return responses.ValidationErrorResponse(map[string]string{"credential": "credential is malformed"})
+	}
+
+	organization := tenancy.GetOrganization(r)
+	principal := authorization.GetPrincipal(r)
+
+	stored, err := database.Connection.Queries.ConsumeWebAuthnSession(organization.Id, passkeys.CeremonyRegistration, response.Response.CollectedClientData.Challenge)
+	if err != nil {
+		return responses.InvalidWebAuthnResponse()
+	}
+	if accountId, _ := stored.Account.Get(); accountId != principal.Account.Id {
+		return responses.InvalidWebAuthnResponse()
+	}
+
+	session, err := passkeys.DecodeSession(stored.Data)
+	if err != nil {
+		return responses.InternalServerErrorResponse()
+	}
+
+	user, err := loadWebAuthnUser(organization, principal.Account.Id)
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	relyingParty, err := passkeys.RelyingParty(organization)
+	if err != nil {
+		return responses.InternalServerErrorResponse()
+	}
+
+	credential, err := passkeys.FinishRegistration(relyingParty, user, session, response)
+	if err != nil {
+		fmt.Println(err)
+		return responses.InvalidWebAuthnResponse()
+	}
+	if reqData.Name != "" {
+		credential.Name = edgedb.NewOptionalStr(reqData.Name)
+	}
+
+	if _, err = database.Connection.Queries.CreateWebAuthnCredential(principal.Account.Id, credential); err != nil {
+		var edbErr edgedb.Error
+		if errors.As(err, &edbErr) && edbErr.Category(edgedb.ConstraintViolationError) {
+			return responses.InvalidWebAuthnResponse()
+		}
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	return responses.SendNewOKResponseMessage(w, "webauthn credential registered successfully")
+}
+
+func GetWebAuthnCredentials(w http.ResponseWriter, r *http.Request) error {
+	credentials, err := database.Connection.Queries.GetWebAuthnCredentials(authorization.GetPrincipal(r).Account.Id)
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+	return responses.SendWebAuthnCredentialsResponse(w, credentials)
+}
+
+func DeleteWebAuthnCredential(w http.ResponseWriter, r *http.Request) error {
+	var reqData datatypes.DeleteWebAuthnCredentialRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	credentialId, err := edgedb.ParseUUID(reqData.ID)
+	if err != nil {
+		return responses.ValidationErrorResponse(map[string]string{"id": "id is not a valid uuid"})
+	}
+
+	deleted, err := database.Connection.Queries.DeleteWebAuthnCredential(authorization.GetPrincipal(r).Account.Id, credentialId)
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+	if !deleted {
+		return responses.WebAuthnCredentialNotFoundResponse()
+	}
+
+	return responses.SendNewOKResponseMessage(w, "webauthn credential deleted successfully")
+}
+
+// BeginWebAuthnLogin starts a passwordless login with any passkey of the organization.
+func BeginWebAuthnLogin(w http.ResponseWriter, r *http.Request) error {
+	assertion, err := startWebAuthnLogin(tenancy.GetOrganization(r), nil)
+	if err != nil {
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+	return responses.SendWebAuthnOptionsResponse(w, assertion)
+}
+
+// FinishWebAuthnLogin signs in the account the passkey belongs to. The passkey verified the user,
+// so it replaces both the password and the second factor.
+func FinishWebAuthnLogin(w http.ResponseWriter, r *http.Request) error {
+	var reqData datatypes.FinishWebAuthnLoginRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return responses.BadRequestResponse()
+	}
+
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(r.Body)
+
+	if validationErrors := reqData.Validate(); len(validationErrors) > 0 {
+		return responses.ValidationErrorResponse(validationErrors)
+	}
+
+	response, err := passkeys.ParseLoginResponse(bytes.NewReader(reqData.Credential))
+	if err != nil {
+		return responses.ValidationErrorResponse(map[string]string{"credential": "credential is malformed"})
+	}
+
+	accountId, err := passkeys.UserHandle(response)
+	if err != nil {
+		return responses.InvalidWebAuthnResponse()
+	}
+
+	organization := tenancy.GetOrganization(r)
+	user, err := loadWebAuthnUser(organization, accountId)
+	if err != nil {
+		var edbErr edgedb.Error
+		if errors.As(err, &edbErr) && edbErr.Category(edgedb.NoDataError) {
+			return responses.InvalidWebAuthnResponse()
+		}
+		fmt.Println(err)
+		return responses.InternalServerErrorResponse()
+	}
+
+	if err = verifyWebAuthnAssertion(organization, user, response); err != nil {
+		return err
+	}
+
+	if err = checkAccountAccess(&user.Account); err != nil {
+		return err
+	}
+
+	// Passkeys require user verification, so they prove possession and knowledge or inherence at once
+	return issueLoginTokens(w, user.Account, authn.New(authn.AMRHardwareKey, authn.AMRMultiFactor))
+}
@@ -0,0 +1,168 @@
+package importer
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/ghostship-dev/authservice/core/config"
+	"github.com/ghostship-dev/authservice/core/database"
+	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/passwords"
+)
+
+// Record is one account to import. PasswordHash keeps the format of the source system
+// and is replaced by a native hash on the first successful login.
+type Record struct {
+	Email         string `json:"email"`
+	Username      string `json:"username"`
+	PasswordHash  string `json:"password_hash"`
+	Role          string `json:"role"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
+var emailRegex = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
+
+func (r *Record) Validate() map[string]string {
+	var errors map[string]string = make(map[string]string)
+	if !emailRegex.MatchString(r.Email) {
+		errors["email"] = "email is invalid"
+	}
+	if r.PasswordHash == "" {
+		errors["password_hash"] = "password_hash is required"
+	} else if err := passwords.ValidateHash(r.PasswordHash); err == passwords.ErrExcessiveCost {
+		errors["password_hash"] = "password_hash has parameters exceeding the supported maximum"
+	} else if err != nil {
+		errors["password_hash"] = "password_hash has an unsupported format"
+	}
+	return errors
+}
+
+type RowError struct {
+	Row     int    `json:"row"`
+	Email   string `json:"email"`
+	Message string `json:"message"`
+}
+
+type Result struct {
+	Created int        `json:"created"`
+	Failed  []RowError `json:"failed"`
+}
+
+// ParseJSON reads a JSON array of records.
+func ParseJSON(reader io.Reader) ([]Record, error) {
+	var records []Record
+	err := json.NewDecoder(reader).Decode(&records)
+	return records, err
+}
+
+// ParseCSV reads records from CSV with a header row naming the columns
+// email, username, password_hash, role and email_verified. Only email and password_hash are required.
+func ParseCSV(reader io.Reader) ([]Record, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.TrimLeadingSpace = true
+
+	header, err := csvReader.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"email", "password_hash"} {
+		if _, found := columns[required]; !found {
+			return nil, errors.New("missing column " + required)
+		}
+	}
+
+	column := func(row []string, name string) string {
+		if i, found := columns[name]; found && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	var records []Record
+	for {
+		row, err := csvReader.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		verified, _ := strconv.ParseBool(column(row, "email_verified"))
+		records = append(records, Record{
+			Email:         column(row, "email"),
+			Username:      column(row, "username"),
+			PasswordHash:  column(row, "password_hash"),
+			Role:          column(row, "role"),
+			EmailVerified: verified,
+		})
+	}
+}
+
+// Import creates an account with password for every valid record in the organization.
+// Invalid records, records with an unknown role and records whose email is already taken are reported and skipped.
+func Import(organizationId edgedb.UUID, records []Record) (Result, error) {
+	result := Result{Failed: make([]RowError, 0)}
+	defaultRole := config.GetEnv("DEFAULT_ROLE", datatypes.UserRole)
+
+	roles, err := database.Connection.Queries.GetRoles()
+	if err != nil {
+		return result, err
+	}
+	roleNames := make([]string, 0, len(roles))
+	for _, role := range roles {
+		roleNames = append(roleNames, role.Name)
+	}
+
+	for i, record := range records {
+		record.Email = strings.TrimSpace(record.Email)
+		if validationErrors := record.Validate(); len(validationErrors) > 0 {
+			for _, message := range validationErrors {
+				result.Failed = append(result.Failed, RowError{Row: i + 1, Email: record.Email, Message: message})
+				break
+			}
+			continue
+		}
+		if record.Username == "" {
+			record.Username, _, _ = strings.Cut(record.Email, "@")
+		}
+		if record.Role == "" {
+			record.Role = defaultRole
+		}
+		if !slices.Contains(roleNames, record.Role) {
+			result.Failed = append(result.Failed, RowError{Row: i + 1, Email: record.Email, Message: "role " + record.Role + " does not exist"})
+			continue
+		}
+
+		account, err := database.Connection.Queries.CreateAccount(organizationId, record.Email, record.Username, record.PasswordHash, record.Role)
+		if err != nil {
+			message := "account could not be created"
+			var edbErr edgedb.Error
+			if errors.As(err, &edbErr) && edbErr.Category(edgedb.ConstraintViolationError) && strings.Contains(edbErr.Error(), "violates exclusivity constraint") {
+				message = "email already in use"
+			}
+			result.Failed = append(result.Failed, RowError{Row: i + 1, Email: record.Email, Message: message})
+			continue
+		}
+
+		if record.EmailVerified {
+			if err = database.Connection.Queries.SetAccountStatus(account.Id, datatypes.AccountStatusActive); err != nil {
+				fmt.Println(err)
+			}
+		}
+		result.Created++
+	}
+
+	return result, nil
+}
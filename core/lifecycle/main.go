@@ -0,0 +1,94 @@
+package lifecycle
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/ghostship-dev/authservice/core/config"
+	"github.com/ghostship-dev/authservice/core/database"
+	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/mail"
+)
+
+// Audit actions of the account lifecycle.
+const (
+	ActionSuspended         = "account_suspended"
+	ActionReactivated       = "account_reactivated"
+	ActionDeletionRequested = "account_deletion_requested"
+	ActionDeletionCancelled = "account_deletion_cancelled"
+	ActionErased            = "account_erased"
+)
+
+// GracePeriod is the time between a deletion request and the erasure of the account.
+func GracePeriod() time.Duration {
+	return config.GetEnvDuration("ACCOUNT_DELETION_GRACE_PERIOD", 30*24*time.Hour)
+}
+
+// Pseudonym derives a stable, non-reversible identifier of the account for the audit trail.
+func Pseudonym(accountId edgedb.UUID) string {
+	key := config.GetEnv("AUDIT_PSEUDONYM_KEY", os.Getenv("JWT_SECRET_KEY"))
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(accountId[:])
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Audit records a lifecycle event of the account. actor is the account performing the change, if any.
+func Audit(account datatypes.Account, action string, actor *datatypes.Account, description string) {
+	event := datatypes.AuditEvent{
+		Action:  action,
+		Subject: Pseudonym(account.Id),
+	}
+	if account.Organization.Id != (edgedb.UUID{}) {
+		event.OrganizationID = edgedb.NewOptionalUUID(account.Organization.Id)
+	}
+	if actor != nil {
+		event.Actor = edgedb.NewOptionalStr(Pseudonym(actor.Id))
+	}
+	if description != "" {
+		event.Description = edgedb.NewOptionalStr(description)
+	}
+	if err := database.Connection.Queries.RecordAuditEvent(event); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func SendDeletionScheduledMail(account datatypes.Account, erasureAt time.Time) {
+	mail.Send(mail.Message{
+		To:      account.Email,
+		Subject: "Your account will be deleted",
+		Body: fmt.Sprintf("Hello %s,\r\n\r\nyour account has been scheduled for deletion and will be erased permanently on %s.\r\n\r\nIf you change your mind, sign in before then to keep your account.",
+			account.Username, erasureAt.UTC().Format(time.RFC1123)),
+	})
+}
+
+// EraseDueAccounts erases every account whose deletion grace period has ended.
+func EraseDueAccounts() {
+	accounts, err := database.Connection.Queries.GetAccountsDueForErasure(time.Now())
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, account := range accounts {
+		if err = database.Connection.Queries.EraseAccount(account.Id, Pseudonym(account.Id)); err != nil {
+			fmt.Println(fmt.Sprintf("erasing account %s failed: %s", account.Id, err))
+			continue
+		}
+		Audit(account, ActionErased, nil, "")
+	}
+}
+
+// StartErasureJob runs EraseDueAccounts every ACCOUNT_ERASURE_INTERVAL in the background.
+func StartErasureJob() {
+	interval := config.GetEnvDuration("ACCOUNT_ERASURE_INTERVAL", time.Hour)
+	go func() {
+		for {
+			EraseDueAccounts()
+			time.Sleep(interval)
+		}
+	}()
+}
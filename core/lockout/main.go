@@ -0,0 +1,140 @@
+package lockout
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/ghostship-dev/authservice/core/config"
+	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/mail"
+	"github.com/ghostship-dev/authservice/core/tenancy"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const tokenType = "account_unlock"
+
+// Threshold is the number of consecutive failed attempts after which the account is locked.
+func Threshold() int16 {
+	return int16(config.GetEnvInt("LOCKOUT_THRESHOLD", 5))
+}
+
+// Duration returns how long the account stays locked after the given number of failed attempts.
+// The first lock lasts LOCKOUT_BASE_DURATION and every further failure after it expired doubles it, up to LOCKOUT_MAX_DURATION.
+func Duration(failedAttempts int16) time.Duration {
+	return lockDuration(Threshold(), failedAttempts)
+}
+
+func lockDuration(threshold, failedAttempts int16) time.Duration {
+	if failedAttempts < threshold {
+		return 0
+	}
+	duration := config.GetEnvDuration("LOCKOUT_BASE_DURATION", time.Minute)
+	maxDuration := config.GetEnvDuration("LOCKOUT_MAX_DURATION", 24*time.Hour)
+	for i := threshold; i < failedAttempts && duration < maxDuration; i++ {
+		duration *= 2
+	}
+	if duration > maxDuration {
+		return maxDuration
+	}
+	return duration
+}
+
+// LockedUntil returns the end of the current lock computed from the last failed attempt.
+// Attempts made while the account is locked are rejected without being counted, so an attacker
+// can not extend the lock of a victim indefinitely.
+func LockedUntil(password datatypes.Password) (time.Time, bool) {
+	lastFailedAttempt, isSet := password.LastFailedAttempt.Get()
+	if !isSet {
+		return time.Time{}, false
+	}
+	duration := Duration(password.FailedAttempts)
+	if duration == 0 {
+		return time.Time{}, false
+	}
+	lockedUntil := lastFailedAttempt.Add(duration)
+	return lockedUntil, lockedUntil.After(time.Now())
+}
+
+// OTPThreshold is the number of consecutive invalid one-time passwords after which second factor verification is locked.
+func OTPThreshold() int16 {
+	return int16(config.GetEnvInt("OTP_LOCKOUT_THRESHOLD", 5))
+}
+
+// OTPLockedUntil returns the end of the current one-time password lock of the account. It grows like the password lock.
+func OTPLockedUntil(account datatypes.Account) (time.Time, bool) {
+	lastFailedAttempt, isSet := account.OtpLastFailed.Get()
+	if !isSet {
+		return time.Time{}, false
+	}
+	duration := lockDuration(OTPThreshold(), account.OtpFailedAttempts)
+	if duration == 0 {
+		return time.Time{}, false
+	}
+	lockedUntil := lastFailedAttempt.Add(duration)
+	return lockedUntil, lockedUntil.After(time.Now())
+}
+
+// GenerateUnlockToken creates a signed token unlocking the account, bound to the failed attempt that locked it.
+// Once the account is unlocked or locked again the token is no longer valid.
+func GenerateUnlockToken(account datatypes.Account, lockedAt time.Time, expires time.Time) (string, error) {
+	organization := tenancy.ForAccount(account)
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["iss"] = tenancy.Issuer(organization)
+	claims["sub"] = account.Id.String()
+	claims["typ"] = tokenType
+	claims["locked_at"] = lockedAt.UnixMicro()
+	claims["exp"] = expires.Unix()
+	return token.SignedString(tenancy.SigningKey(organization))
+}
+
+// ParseUnlockToken validates an unlock token issued by the organization.
+func ParseUnlockToken(organization datatypes.Organization, value string) (edgedb.UUID, time.Time, error) {
+	token, err := jwt.Parse(value, func(token *jwt.Token) (interface{}, error) {
+		return tenancy.SigningKey(organization), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer(tenancy.Issuer(organization)), jwt.WithExpirationRequired())
+	if err != nil || !token.Valid {
+		return edgedb.UUID{}, time.Time{}, errors.New("invalid unlock token")
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	lockedAt, isNumber := claims["locked_at"].(float64)
+	if claims["typ"] != tokenType || !isNumber {
+		return edgedb.UUID{}, time.Time{}, errors.New("invalid unlock token")
+	}
+	subject, err := claims.GetSubject()
+	if err != nil {
+		return edgedb.UUID{}, time.Time{}, err
+	}
+	accountId, err := edgedb.ParseUUID(subject)
+	return accountId, time.UnixMicro(int64(lockedAt)), err
+}
+
+func link(organization datatypes.Organization, token string) string {
+	link := config.GetEnv("LOCKOUT_UNLOCK_URL", tenancy.Issuer(organization)+"/account/unlock")
+	separator := "?"
+	if strings.Contains(link, "?") {
+		separator = "&"
+	}
+	return link + separator + url.Values{"token": {token}}.Encode()
+}
+
+// SendLockedMail tells the account owner about the lock and includes a link to unlock the account right away.
+func SendLockedMail(account datatypes.Account, lockedAt time.Time, lockedUntil time.Time) error {
+	token, err := GenerateUnlockToken(account, lockedAt, time.Now().Add(config.GetEnvDuration("LOCKOUT_UNLOCK_TTL", 24*time.Hour)))
+	if err != nil {
+		return err
+	}
+
+	mail.Send(mail.Message{
+		To:      account.Email,
+		Subject: "Your account has been locked",
+		Body: fmt.Sprintf("Hello %s,\r\n\r\nyour account was locked until %s after too many failed sign in attempts.\r\n\r\nIf this was you, you can unlock your account right away:\r\n\r\n%s\r\n\r\nIf it was not you, consider changing your password once you are signed in again.",
+			account.Username, lockedUntil.UTC().Format(time.RFC1123), link(tenancy.ForAccount(account), token)),
+	})
+	return nil
+}
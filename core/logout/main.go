@@ -0,0 +1,97 @@
+package logout
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/tenancy"
+	"github.com/ghostship-dev/authservice/core/utility"
+)
+
+const (
+	maxDeliveryAttempts = 5
+	initialRetryDelay   = 2 * time.Second
+)
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// NotifyBackChannel sends a signed logout token to every client that registered a back-channel logout URI.
+// Delivery happens in the background and is retried with an exponential backoff.
+func NotifyBackChannel(accountId string, clients []datatypes.OAuthClient) {
+	for _, client := range clients {
+		logoutURI, isSet := client.BackchannelLogoutURI.Get()
+		if !isSet || logoutURI == "" {
+			continue
+		}
+
+		logoutToken, err := utility.GenerateLogoutToken(accountId, client)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+
+		go deliverLogoutToken(client.ClientID, logoutURI, logoutToken)
+	}
+}
+
+func deliverLogoutToken(clientID, logoutURI, logoutToken string) {
+	delay := initialRetryDelay
+	for attempt := 1; attempt <= maxDeliveryAttempts; attempt++ {
+		retry, err := postLogoutToken(logoutURI, logoutToken)
+		if err == nil {
+			return
+		}
+		if !retry || attempt == maxDeliveryAttempts {
+			fmt.Println(fmt.Sprintf("back-channel logout for client %s failed after %d attempt(s): %s", clientID, attempt, err))
+			return
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// postLogoutToken delivers the logout token and reports whether a failed delivery is worth retrying.
+func postLogoutToken(logoutURI, logoutToken string) (bool, error) {
+	body := url.Values{"logout_token": {logoutToken}}.Encode()
+	req, err := http.NewRequest(http.MethodPost, logoutURI, strings.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Cache-Control", "no-cache, no-store")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNoContent {
+		return false, nil
+	}
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+}
+
+// FrontChannelURIs returns the front-channel logout URIs to be rendered by the user agent.
+func FrontChannelURIs(clients []datatypes.OAuthClient) []string {
+	var uris []string
+	for _, client := range clients {
+		logoutURI, isSet := client.FrontchannelLogoutURI.Get()
+		if !isSet || logoutURI == "" {
+			continue
+		}
+		parsed, err := url.Parse(logoutURI)
+		if err != nil {
+			continue
+		}
+		query := parsed.Query()
+		query.Set("iss", tenancy.Issuer(tenancy.ForClient(client)))
+		parsed.RawQuery = query.Encode()
+		uris = append(uris, parsed.String())
+	}
+	return uris
+}
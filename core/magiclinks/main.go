@@ -0,0 +1,87 @@
+package magiclinks
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ghostship-dev/authservice/core/config"
+	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/mail"
+	"github.com/ghostship-dev/authservice/core/tenancy"
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+// NonceCookie holds the nonce binding a requested link to the browser which requested it.
+const NonceCookie = "magic_link_nonce"
+
+// TTL is how long a login link stays valid.
+func TTL() time.Duration {
+	return config.GetEnvDuration("MAGIC_LINK_TTL", 15*time.Minute)
+}
+
+// RateLimit returns how many links may be sent to an account, and on requests from a client address, within the window.
+// Links are kept a day after they expired, so the window can be at most a day long.
+func RateLimit() (int64, time.Duration) {
+	window := config.GetEnvDuration("MAGIC_LINK_RATE_WINDOW", time.Hour)
+	if window > 24*time.Hour {
+		window = 24 * time.Hour
+	}
+	return int64(config.GetEnvInt("MAGIC_LINK_RATE_LIMIT", 3)), window
+}
+
+// NewToken returns a random single-use link token. Only its digest is stored.
+func NewToken() (string, error) {
+	return gonanoid.New(48)
+}
+
+// NewNonce returns a random nonce for the cookie of the requesting browser. Only its digest is stored.
+func NewNonce() (string, error) {
+	return gonanoid.New(32)
+}
+
+// Link returns the login link for the token. MAGIC_LINK_URL should point to a frontend which submits
+// the token to the login endpoint from the same browser, so the nonce cookie is sent along.
+func Link(organization datatypes.Organization, token string) string {
+	link := config.GetEnv("MAGIC_LINK_URL", tenancy.Issuer(organization)+"/login/magic-link")
+	separator := "?"
+	if strings.Contains(link, "?") {
+		separator = "&"
+	}
+	return link + separator + url.Values{"token": {token}}.Encode()
+}
+
+func SendLinkMail(account datatypes.Account, token string) {
+	mail.Send(mail.Message{
+		To:      account.Email,
+		Subject: "Your sign in link",
+		Body: fmt.Sprintf("Hello %s,\r\n\r\nopen the following link within %s in the browser you requested it from to sign in:\r\n\r\n%s\r\n\r\nThe link can only be used once. If you did not request it, you can ignore this email.",
+			account.Username, TTL(), Link(tenancy.ForAccount(account), token)),
+	})
+}
+
+// SetNonceCookie stores the nonce in the browser requesting a link until the link expires.
+// MAGIC_LINK_COOKIE_DOMAIN shares the cookie with the subdomains serving the frontend.
+func SetNonceCookie(w http.ResponseWriter, nonce string, expiresAt time.Time) {
+	http.SetCookie(w, nonceCookie(nonce, expiresAt))
+}
+
+// ClearNonceCookie removes the nonce once the link was used.
+func ClearNonceCookie(w http.ResponseWriter) {
+	http.SetCookie(w, nonceCookie("", time.Unix(0, 0)))
+}
+
+func nonceCookie(value string, expiresAt time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     NonceCookie,
+		Value:    value,
+		Path:     "/",
+		Domain:   config.GetEnv("MAGIC_LINK_COOKIE_DOMAIN", ""),
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   config.GetEnvBool("MAGIC_LINK_COOKIE_SECURE", true),
+		SameSite: http.SameSiteLaxMode,
+	}
+}
@@ -0,0 +1,121 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghostship-dev/authservice/core/config"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers messages to their recipients.
+type Sender interface {
+	Send(message Message) error
+}
+
+// SMTPSender delivers messages through an SMTP relay using PLAIN authentication if credentials are set.
+type SMTPSender struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+func (s *SMTPSender) Send(message Message) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+	return smtp.SendMail(net.JoinHostPort(s.Host, strconv.Itoa(s.Port)), auth, s.From, []string{message.To}, format(s.From, message))
+}
+
+// FileSender appends every message to a file, or prints it to stdout if no path is set.
+// It is meant for development and tests.
+type FileSender struct {
+	Path string
+	From string
+	lock sync.Mutex
+}
+
+func (s *FileSender) Send(message Message) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.Path == "" {
+		fmt.Println(string(format(s.From, message)))
+		return nil
+	}
+
+	file, err := os.OpenFile(s.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+
+	_, err = file.Write(append(format(s.From, message), '\n'))
+	return err
+}
+
+func format(from string, message Message) []byte {
+	var builder strings.Builder
+	builder.WriteString("From: " + from + "\r\n")
+	builder.WriteString("To: " + message.To + "\r\n")
+	builder.WriteString("Subject: " + message.Subject + "\r\n")
+	builder.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	builder.WriteString("MIME-Version: 1.0\r\n")
+	builder.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n")
+	builder.WriteString("\r\n")
+	builder.WriteString(message.Body)
+	builder.WriteString("\r\n")
+	return []byte(builder.String())
+}
+
+// NewSenderFromEnv creates the sender selected by MAIL_DRIVER (smtp, file or log).
+func NewSenderFromEnv() (Sender, error) {
+	from := config.GetEnv("MAIL_FROM", "no-reply@localhost")
+	switch config.GetEnv("MAIL_DRIVER", "log") {
+	case "smtp":
+		host := os.Getenv("MAIL_SMTP_HOST")
+		if host == "" {
+			return nil, errors.New("MAIL_SMTP_HOST is required for the smtp mail driver")
+		}
+		return &SMTPSender{
+			Host:     host,
+			Port:     config.GetEnvInt("MAIL_SMTP_PORT", 587),
+			Username: os.Getenv("MAIL_SMTP_USERNAME"),
+			Password: os.Getenv("MAIL_SMTP_PASSWORD"),
+			From:     from,
+		}, nil
+	case "file":
+		return &FileSender{Path: config.GetEnv("MAIL_FILE_PATH", "mail.log"), From: from}, nil
+	case "log":
+		return &FileSender{From: from}, nil
+	}
+	return nil, errors.New("invalid MAIL_DRIVER (available drivers: smtp, file, log)")
+}
+
+var Connection Sender = &FileSender{}
+
+// Send delivers the message in the background so slow mail servers do not block requests.
+func Send(message Message) {
+	go func() {
+		if err := Connection.Send(message); err != nil {
+			fmt.Println(fmt.Sprintf("sending mail to %s failed: %s", message.To, err))
+		}
+	}()
+}
@@ -1,42 +1,271 @@
 package core
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"strings"
 
+	"github.com/ghostship-dev/authservice/core/authorization"
 	"github.com/ghostship-dev/authservice/core/config"
 	"github.com/ghostship-dev/authservice/core/database"
+	"github.com/ghostship-dev/authservice/core/federation"
 	"github.com/ghostship-dev/authservice/core/handlers"
+	"github.com/ghostship-dev/authservice/core/importer"
+	"github.com/ghostship-dev/authservice/core/lifecycle"
+	"github.com/ghostship-dev/authservice/core/mail"
 	"github.com/ghostship-dev/authservice/core/router"
+	"github.com/ghostship-dev/authservice/core/secrets"
+	"github.com/ghostship-dev/authservice/core/sms"
+	"github.com/ghostship-dev/authservice/core/tenancy"
+	"github.com/ghostship-dev/authservice/core/totp"
 	_ "github.com/joho/godotenv/autoload"
 )
 
 func RunService(c *config.Config) {
 	database.Connection = database.ConnectToSelectedDBDriver(c)
 
+	if err := database.LoadOrganizations(); err != nil {
+		panic(err)
+	}
+
+	sender, err := mail.NewSenderFromEnv()
+	if err != nil {
+		panic(err)
+	}
+	mail.Connection = sender
+
+	smsSender, err := sms.NewSenderFromEnv()
+	if err != nil {
+		panic(err)
+	}
+	sms.Connection = smsSender
+
+	loadKeyProvider()
+
+	if err := database.LoadScopeRegistry(); err != nil {
+		panic(err)
+	}
+
+	if err := database.SeedDefaultRoles(os.Getenv("BOOTSTRAP_ADMIN_EMAIL")); err != nil {
+		panic(err)
+	}
+
+	lifecycle.StartErasureJob()
+
 	apiV1Router := router.New().Group("/api/v1")
+	apiV1Router.SetAuthorizer(authorization.New())
+	apiV1Router.Use(tenancy.Middleware)
 
 	// Account management
 	apiV1Router.Post("/login", handlers.LoginHandler)
+	apiV1Router.Post("/login/mfa", handlers.LoginMFAHandler)
+	apiV1Router.Post("/login/magic-link", handlers.RequestMagicLink)
+	apiV1Router.Post("/login/magic-link/verify", handlers.MagicLinkLogin)
+	apiV1Router.Get("/login/federated/providers", handlers.ListLoginProviders)
+	apiV1Router.Get("/login/federated/start", handlers.StartFederatedLogin)
+	apiV1Router.Post("/login/federated/callback", handlers.FederatedLoginCallback)
+	apiV1Router.Get("/saml/metadata", handlers.SAMLMetadata)
+	apiV1Router.Get("/saml/sso", handlers.SAMLSingleSignOn)
+	apiV1Router.Post("/saml/acs", handlers.SAMLAssertionConsumer)
 	apiV1Router.Post("/register", handlers.RegisterHandler)
 
+	// Email verification
+	apiV1Router.Get("/account/verify", handlers.VerifyEmail)
+	apiV1Router.Post("/account/verify", handlers.VerifyEmail)
+	apiV1Router.Post("/account/verify/resend", handlers.ResendVerificationEmail)
+
+	// Password management
+	apiV1Router.Post("/account/password", handlers.ChangePassword, "account_write", authorization.FirstPartySession)
+	apiV1Router.Post("/account/password/reset", handlers.RequestPasswordReset)
+	apiV1Router.Post("/account/password/reset/confirm", handlers.ConfirmPasswordReset)
+
+	// Account profile
+	apiV1Router.Get("/account/me", handlers.GetProfile, "profile")
+	apiV1Router.Patch("/account/me", handlers.UpdateProfile, "account_write")
+
+	// Account lockout
+	apiV1Router.Get("/account/unlock", handlers.UnlockAccountWithToken)
+	apiV1Router.Post("/account/unlock", handlers.UnlockAccountWithToken)
+	apiV1Router.Post("/accounts/unlock", handlers.UnlockAccount, "admin")
+
+	// Account lifecycle
+	apiV1Router.Post("/accounts/suspend", handlers.SuspendAccount, "admin")
+	apiV1Router.Post("/accounts/reactivate", handlers.ReactivateAccount, "admin")
+	apiV1Router.Post("/account/delete", handlers.DeleteOwnAccount, "account_write", authorization.FirstPartySession)
+
+	// WebAuthn / passkeys
+	apiV1Router.Post("/account/webauthn/register/begin", handlers.BeginWebAuthnRegistration, "account_write", authorization.FirstPartySession)
+	apiV1Router.Post("/account/webauthn/register/finish", handlers.FinishWebAuthnRegistration, "account_write", authorization.FirstPartySession)
+	apiV1Router.Get("/account/webauthn/credentials", handlers.GetWebAuthnCredentials, "account_read")
+	apiV1Router.Delete("/account/webauthn/credentials", handlers.DeleteWebAuthnCredential, "account_write", authorization.FirstPartySession, authorization.StepUp)
+	apiV1Router.Post("/login/webauthn/begin", handlers.BeginWebAuthnLogin)
+	apiV1Router.Post("/login/webauthn/finish", handlers.FinishWebAuthnLogin)
+
 	// Time-Based One-Time Password management
-	apiV1Router.Post("/otp", handlers.AccountOTP)
+	apiV1Router.Post("/otp", handlers.AccountOTP, "account_otp_write")
+	apiV1Router.Post("/otp/recovery-codes", handlers.RegenerateRecoveryCodes, "account_otp_write", authorization.FirstPartySession)
+
+	// Email and SMS one-time code factors
+	apiV1Router.Get("/account/otp/factors", handlers.GetOTPFactors, "account_read")
+	apiV1Router.Post("/account/otp/factors", handlers.EnrollOTPFactor, "account_otp_write", authorization.FirstPartySession)
+	apiV1Router.Post("/account/otp/factors/verify", handlers.VerifyOTPFactor, "account_otp_write", authorization.FirstPartySession)
+	apiV1Router.Delete("/account/otp/factors", handlers.DeleteOTPFactor, "account_otp_write", authorization.FirstPartySession, authorization.StepUp)
+
+	// Upstream identity providers and the identities linked through them
+	apiV1Router.Get("/identity-providers", handlers.ListIdentityProviders, "admin")
+	apiV1Router.Post("/identity-providers", handlers.CreateIdentityProvider, "admin")
+	apiV1Router.Patch("/identity-providers", handlers.UpdateIdentityProvider, "admin")
+	apiV1Router.Delete("/identity-providers", handlers.DeleteIdentityProvider, "admin")
+	apiV1Router.Get("/account/federated-identities", handlers.GetFederatedIdentities, "account_read")
+	apiV1Router.Post("/account/federated-identities", handlers.LinkFederatedIdentity, "account_write", authorization.FirstPartySession, authorization.StepUp)
+	apiV1Router.Delete("/account/federated-identities", handlers.UnlinkFederatedIdentity, "account_write", authorization.FirstPartySession)
+
+	// Authorized OAuth2 Client-Application management
+	apiV1Router.Get("/account/applications", handlers.ListAuthorizedApplications, "account_read")
+	apiV1Router.Delete("/account/applications", handlers.RevokeAuthorizedApplication, "account_write", authorization.FirstPartySession)
 
 	// OAuth2 Client-Application management
-	apiV1Router.Post("/oauth/application", handlers.NewOAuthApplication)
-	apiV1Router.Patch("/oauth/application", handlers.UpdateOAuthApplicationKeyValue)
-	apiV1Router.Delete("/oauth/application", handlers.DeleteOAuthClientApplication)
+	apiV1Router.Post("/oauth/application", handlers.NewOAuthApplication, "oauth2_write")
+	apiV1Router.Patch("/oauth/application", handlers.UpdateOAuthApplicationKeyValue, "oauth2_write")
+	apiV1Router.Delete("/oauth/application", handlers.DeleteOAuthClientApplication, "oauth2_delete")
+
+	// Scope registry management
+	apiV1Router.Get("/scopes", handlers.ListScopes)
+	apiV1Router.Post("/scopes", handlers.CreateScope, "admin")
+	apiV1Router.Patch("/scopes", handlers.UpdateScope, "admin")
+	apiV1Router.Delete("/scopes", handlers.DeleteScope, "admin")
+
+	// Role-based access control
+	apiV1Router.Get("/roles", handlers.ListRoles, "admin")
+	apiV1Router.Post("/roles", handlers.CreateRole, "admin")
+	apiV1Router.Patch("/roles", handlers.UpdateRole, "admin")
+	apiV1Router.Delete("/roles", handlers.DeleteRole, "admin")
+	apiV1Router.Post("/roles/assignment", handlers.AssignAccountRole, "admin")
+	apiV1Router.Delete("/roles/assignment", handlers.UnassignAccountRole, "admin")
+
+	// Account import
+	apiV1Router.Post("/accounts/import", handlers.ImportAccounts, "admin")
+
+	// Multi-tenant organizations
+	apiV1Router.Get("/organizations", handlers.ListOrganizations, "admin")
+	apiV1Router.Post("/organizations", handlers.CreateOrganization, "admin")
+	apiV1Router.Patch("/organizations", handlers.UpdateOrganization, "admin")
+	apiV1Router.Delete("/organizations", handlers.DeleteOrganization, "admin")
 
 	// OAuth2 Implementation
 	apiV1Router.Post("/oauth/token/introspect", handlers.IntrospectOAuthToken)
 	apiV1Router.Get("/oauth/authorize", handlers.AuthorizeOAuthApplication)
+	apiV1Router.Get("/oauth/consent", handlers.GetOAuthConsentDetails, authorization.FirstPartySession)
+	apiV1Router.Post("/oauth/consent", handlers.OAuthConsent, authorization.FirstPartySession)
 	apiV1Router.Post("/oauth/token", handlers.OAuthTokenEndpoint)
 	apiV1Router.Post("/oauth/token/revoke", handlers.RevokeOAuthToken)
 
+	// OpenID Connect
+	apiV1Router.Get("/.well-known/openid-configuration", handlers.OpenIDConfiguration)
+	apiV1Router.Get("/oauth/logout", handlers.EndSessionHandler)
+	apiV1Router.Post("/oauth/logout", handlers.EndSessionHandler)
+
 	fmt.Println(fmt.Sprintf("Running Service on: %s:%d", c.Hostname, c.Port))
 
-	err := apiV1Router.ListenAndServe(fmt.Sprintf("%s:%d", c.Hostname, c.Port))
+	err = apiV1Router.ListenAndServe(fmt.Sprintf("%s:%d", c.Hostname, c.Port))
+	if err != nil {
+		panic(err)
+	}
+}
+
+// RunImport imports the accounts of a JSON or CSV file into the organization and exits.
+func RunImport(c *config.Config, path, organizationSlug string) {
+	database.Connection = database.ConnectToSelectedDBDriver(c)
+
+	if err := database.LoadOrganizations(); err != nil {
+		panic(err)
+	}
+
+	if err := database.SeedDefaultRoles(""); err != nil {
+		panic(err)
+	}
+
+	organization, found := tenancy.GetBySlug(organizationSlug)
+	if !found {
+		panic("organization not found: " + organizationSlug)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		panic(err)
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+
+	var records []importer.Record
+	if strings.HasSuffix(strings.ToLower(path), ".csv") {
+		records, err = importer.ParseCSV(file)
+	} else {
+		records, err = importer.ParseJSON(file)
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	result, err := importer.Import(organization.Id, records)
+	if err != nil {
+		panic(err)
+	}
+	for _, failure := range result.Failed {
+		fmt.Println(fmt.Sprintf("row %d (%s): %s", failure.Row, failure.Email, failure.Message))
+	}
+	fmt.Println(fmt.Sprintf("Imported %d of %d accounts into organization %s", result.Created, len(records), organization.Slug))
+}
+
+// loadKeyProvider sets up the master keys secrets are encrypted with. Without a master key configured the service
+// keeps storing secrets as plaintext, unless secrets were encrypted before, which could not be decrypted anymore.
+func loadKeyProvider() {
+	keys, err := secrets.NewKeyProviderFromEnv()
+	if errors.Is(err, secrets.ErrNotConfigured) {
+		encrypted, err := database.Connection.Queries.CountEncryptedSecrets()
+		if err != nil {
+			panic(err)
+		}
+		if encrypted > 0 {
+			fmt.Println(fmt.Sprintf("Invalid configuration: %d stored secrets are encrypted, but no master key is configured. "+
+				"Set SECRETS_MASTER_KEYS, or SECRETS_MASTER_KEY_FILE with SECRETS_KEY_PROVIDER=file, to the master keys they were encrypted with.", encrypted))
+			os.Exit(1)
+		}
+		fmt.Println("No master key is configured (SECRETS_MASTER_KEYS), otp and identity provider client secrets are stored unencrypted")
+		return
+	}
+	if err != nil {
+		panic(err)
+	}
+	secrets.Keys = keys
+}
+
+// RunReencryptSecrets encrypts all stored secrets with the current master key version and exits.
+// It is run after adding a new master key version, before the retired version is removed.
+func RunReencryptSecrets(c *config.Config) {
+	database.Connection = database.ConnectToSelectedDBDriver(c)
+
+	keys, err := secrets.NewKeyProviderFromEnv()
+	if errors.Is(err, secrets.ErrNotConfigured) {
+		fmt.Println(fmt.Sprintf("Re-encrypting secrets requires a master key: %s", err))
+		os.Exit(1)
+	}
+	if err != nil {
+		panic(err)
+	}
+	secrets.Keys = keys
+
+	updated, err := totp.ReencryptSecrets()
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(fmt.Sprintf("Re-encrypted %d otp secrets with master key version %s", updated, keys.CurrentVersion()))
+
+	updated, err = federation.ReencryptSecrets()
 	if err != nil {
 		panic(err)
 	}
+	fmt.Println(fmt.Sprintf("Re-encrypted %d identity provider client secrets with master key version %s", updated, keys.CurrentVersion()))
 }
@@ -0,0 +1,23 @@
+package mfa
+
+import (
+	"time"
+
+	"github.com/ghostship-dev/authservice/core/config"
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+// TokenTTL is how long the second factor of a login transaction can be submitted after the password was checked.
+func TokenTTL() time.Duration {
+	return config.GetEnvDuration("LOGIN_MFA_TOKEN_TTL", 5*time.Minute)
+}
+
+// MaxAttempts is the number of failed factor submissions after which the login has to start over with the password.
+func MaxAttempts() int16 {
+	return int16(config.GetEnvInt("LOGIN_MFA_MAX_ATTEMPTS", 5))
+}
+
+// NewToken returns a random mfa_token. Only its digest is stored.
+func NewToken() (string, error) {
+	return gonanoid.New(48)
+}
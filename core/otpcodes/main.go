@@ -0,0 +1,159 @@
+package otpcodes
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/ghostship-dev/authservice/core/config"
+	"github.com/ghostship-dev/authservice/core/database"
+	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/mail"
+	"github.com/ghostship-dev/authservice/core/sms"
+	"github.com/ghostship-dev/authservice/core/utility"
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+// Purposes a code is sent for. A code is only accepted for the purpose it was sent for.
+const (
+	PurposeLogin      = "login"
+	PurposeEnrollment = "enrollment"
+)
+
+var ErrRateLimited = errors.New("too many codes were requested")
+
+// TTL is how long a delivered code stays valid.
+func TTL() time.Duration {
+	return config.GetEnvDuration("OTP_CODE_TTL", 10*time.Minute)
+}
+
+// MaxAttempts is the number of wrong guesses after which a code is no longer accepted.
+func MaxAttempts() int16 {
+	return int16(config.GetEnvInt("OTP_CODE_MAX_ATTEMPTS", 5))
+}
+
+func codeLength() int {
+	length := config.GetEnvInt("OTP_CODE_LENGTH", 6)
+	if length < 6 || length > 10 {
+		return 6
+	}
+	return length
+}
+
+// rateLimit returns how many codes may be sent to an account, and to a destination, within the window.
+// Challenges are kept a day after they expired, so the window can be at most a day long.
+func rateLimit() (int64, time.Duration) {
+	window := config.GetEnvDuration("OTP_CODE_RATE_WINDOW", time.Hour)
+	if window > 24*time.Hour {
+		window = 24 * time.Hour
+	}
+	return int64(config.GetEnvInt("OTP_CODE_RATE_LIMIT", 5)), window
+}
+
+// Destination returns the address codes of the factor are delivered to. Email codes always
+// go to the current, verified email address of the account.
+func Destination(account datatypes.Account, factor datatypes.OTPFactor) string {
+	if factor.Channel == datatypes.OTPChannelSMS {
+		phoneNumber, _ := factor.PhoneNumber.Get()
+		return phoneNumber
+	}
+	return account.Email
+}
+
+// MaskDestination hides most of the address, so responses confirm where a code went without disclosing it.
+func MaskDestination(channel, destination string) string {
+	if channel == datatypes.OTPChannelSMS {
+		if len(destination) <= 4 {
+			return destination
+		}
+		return strings.Repeat("*", len(destination)-4) + destination[len(destination)-4:]
+	}
+	local, domain, found := strings.Cut(destination, "@")
+	if !found || local == "" {
+		return destination
+	}
+	return local[:1] + strings.Repeat("*", len(local)-1) + "@" + domain
+}
+
+// Send delivers a new code for the purpose through the channel and returns when it expires.
+// Pending codes of the same channel and purpose are no longer accepted afterwards.
+func Send(account datatypes.Account, channel, destination, purpose string) (time.Time, error) {
+	limit, window := rateLimit()
+	count, err := database.Connection.Queries.CountOTPChallenges(account.Id, destination, time.Now().Add(-window))
+	if err != nil {
+		return time.Time{}, err
+	}
+	if count.Account >= limit || count.Destination >= limit {
+		return time.Time{}, ErrRateLimited
+	}
+
+	code, err := gonanoid.Generate("0123456789", codeLength())
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	ttl := TTL()
+	expiresAt := time.Now().Add(ttl)
+	if err = database.Connection.Queries.CreateOTPChallenge(account.Id, channel, purpose, destination, hashCode(account.Id, channel, purpose, code), expiresAt); err != nil {
+		return time.Time{}, err
+	}
+
+	deliver(account, channel, destination, purpose, code, ttl)
+	return expiresAt, nil
+}
+
+// Verify consumes the pending code of the channel and purpose if it matches.
+// Every code can only be used once and is discarded after MaxAttempts wrong guesses.
+func Verify(accountId edgedb.UUID, channel, purpose, code string) (bool, error) {
+	challenge, err := database.Connection.Queries.GetOTPChallenge(accountId, channel, purpose)
+	if err != nil {
+		var edbErr edgedb.Error
+		if errors.As(err, &edbErr) && edbErr.Category(edgedb.NoDataError) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	// The guess is counted before the comparison so concurrent guesses can't get past MaxAttempts
+	if reserved, err := database.Connection.Queries.ReserveOTPChallengeAttempt(challenge.Id, MaxAttempts()); err != nil || !reserved {
+		return false, err
+	}
+
+	codeHash := hashCode(accountId, channel, purpose, strings.TrimSpace(code))
+	if subtle.ConstantTimeCompare([]byte(codeHash), []byte(challenge.CodeHash)) != 1 {
+		return false, nil
+	}
+
+	return database.Connection.Queries.ConsumeOTPChallenge(challenge.Id)
+}
+
+func hashCode(accountId edgedb.UUID, channel, purpose, code string) string {
+	return utility.HashToken(accountId.String() + ":" + channel + ":" + purpose + ":" + code)
+}
+
+func deliver(account datatypes.Account, channel, destination, purpose, code string, ttl time.Duration) {
+	minutes := int(ttl.Minutes())
+	if channel == datatypes.OTPChannelSMS {
+		sms.Send(sms.Message{
+			To:   destination,
+			Body: fmt.Sprintf("%s is your verification code. It expires in %d minutes. Never share it with anyone.", code, minutes),
+		})
+		return
+	}
+
+	subject := "Your sign in code"
+	reason := "to finish signing in to your account"
+	if purpose == PurposeEnrollment {
+		subject = "Confirm your email address for two factor authentication"
+		reason = "to receive sign in codes by email"
+	}
+	mail.Send(mail.Message{
+		To:      destination,
+		Subject: subject,
+		Body: fmt.Sprintf("Hello %s,\r\n\r\nenter the code %s %s. It expires in %d minutes.\r\n\r\nIf you did not request this code, change your password immediately.",
+			account.Username, code, reason, minutes),
+	})
+}
@@ -0,0 +1,219 @@
+package passkeys
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/ghostship-dev/authservice/core/config"
+	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/tenancy"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// Ceremonies a WebAuthnSession is started for.
+const (
+	CeremonyRegistration = "registration"
+	CeremonyLogin        = "login"
+)
+
+// ErrCloneDetected is returned when the sign counter of an assertion did not increase,
+// which indicates that the credential was copied from its authenticator.
+var ErrCloneDetected = errors.New("authenticator sign counter did not increase")
+
+// User adapts an account and its registered credentials to the relying party library.
+// The user handle is the account id, so discoverable credentials identify the account directly.
+type User struct {
+	Account     datatypes.Account
+	Credentials []datatypes.WebAuthnCredential
+}
+
+func (u *User) WebAuthnID() []byte {
+	return u.Account.Id[:]
+}
+
+func (u *User) WebAuthnName() string {
+	return u.Account.Email
+}
+
+func (u *User) WebAuthnDisplayName() string {
+	return u.Account.Username
+}
+
+func (u *User) WebAuthnIcon() string {
+	return ""
+}
+
+func (u *User) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(u.Credentials))
+	for _, credential := range u.Credentials {
+		transports := make([]protocol.AuthenticatorTransport, 0, len(credential.Transports))
+		for _, transport := range credential.Transports {
+			transports = append(transports, protocol.AuthenticatorTransport(transport))
+		}
+		credentials = append(credentials, webauthn.Credential{
+			ID:              credential.CredentialID,
+			PublicKey:       credential.PublicKey,
+			AttestationType: credential.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: credential.BackupEligible,
+				BackupState:    credential.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    credential.AAGUID,
+				SignCount: uint32(credential.SignCount),
+			},
+		})
+	}
+	return credentials
+}
+
+// Find returns the stored credential with the given credential id.
+func (u *User) Find(credentialID []byte) (datatypes.WebAuthnCredential, bool) {
+	for _, credential := range u.Credentials {
+		if string(credential.CredentialID) == string(credentialID) {
+			return credential, true
+		}
+	}
+	return datatypes.WebAuthnCredential{}, false
+}
+
+// Timeout is how long a started ceremony can be finished.
+func Timeout() time.Duration {
+	return config.GetEnvDuration("WEBAUTHN_TIMEOUT", 5*time.Minute)
+}
+
+// RelyingParty returns the relying party of the organization. The RP ID and origin default to the host of the organization issuer
+// and can be set by WEBAUTHN_RP_ID and WEBAUTHN_RP_ORIGINS if the frontend runs on another host.
+func RelyingParty(organization datatypes.Organization) (*webauthn.WebAuthn, error) {
+	issuer, err := url.Parse(tenancy.Issuer(organization))
+	if err != nil {
+		return nil, err
+	}
+
+	origins := []string{issuer.Scheme + "://" + issuer.Host}
+	if configured := config.GetEnv("WEBAUTHN_RP_ORIGINS", ""); configured != "" {
+		origins = strings.Split(configured, ",")
+	}
+
+	timeout := webauthn.TimeoutConfig{Enforce: true, Timeout: Timeout(), TimeoutUVD: Timeout()}
+	return webauthn.New(&webauthn.Config{
+		RPID:          config.GetEnv("WEBAUTHN_RP_ID", issuer.Hostname()),
+		RPDisplayName: config.GetEnv("WEBAUTHN_RP_NAME", organization.Name),
+		RPOrigins:     origins,
+		Timeouts:      webauthn.TimeoutsConfig{Login: timeout, Registration: timeout},
+	})
+}
+
+// BeginRegistration starts registering a new credential for the user. Passkeys are preferred,
+// and authenticators already registered for the user are excluded.
+func BeginRegistration(relyingParty *webauthn.WebAuthn, user *User) (*protocol.CredentialCreation, *webauthn.SessionData, error) {
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(user.Credentials))
+	for _, credential := range user.WebAuthnCredentials() {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+
+	return relyingParty.BeginRegistration(user,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+		webauthn.WithAuthenticatorSelection(protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementPreferred,
+			UserVerification: protocol.VerificationPreferred,
+		}),
+		webauthn.WithConveyancePreference(protocol.PreferNoAttestation),
+	)
+}
+
+// FinishRegistration validates the attestation and returns the credential to store.
+func FinishRegistration(relyingParty *webauthn.WebAuthn, user *User, session webauthn.SessionData, response *protocol.ParsedCredentialCreationData) (datatypes.WebAuthnCredential, error) {
+	credential, err := relyingParty.CreateCredential(user, session, response)
+	if err != nil {
+		return datatypes.WebAuthnCredential{}, err
+	}
+
+	transports := make([]string, 0, len(credential.Transport))
+	for _, transport := range credential.Transport {
+		transports = append(transports, string(transport))
+	}
+
+	return datatypes.WebAuthnCredential{
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       int64(credential.Authenticator.SignCount),
+		Transports:      transports,
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+	}, nil
+}
+
+// BeginLogin starts an assertion. Without a user any discoverable credential may answer and user
+// verification is required, as the passkey is the only factor. For a known user it is a second factor.
+func BeginLogin(relyingParty *webauthn.WebAuthn, user *User) (*protocol.CredentialAssertion, *webauthn.SessionData, error) {
+	if user == nil {
+		return relyingParty.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	}
+	return relyingParty.BeginLogin(user, webauthn.WithUserVerification(protocol.VerificationPreferred))
+}
+
+// FinishLogin validates the assertion of the user and returns the used credential with its updated sign counter and backup state.
+func FinishLogin(relyingParty *webauthn.WebAuthn, user *User, session webauthn.SessionData, response *protocol.ParsedCredentialAssertionData) (datatypes.WebAuthnCredential, error) {
+	var (
+		credential *webauthn.Credential
+		err        error
+	)
+	if session.UserID == nil {
+		credential, err = relyingParty.ValidateDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+			return user, nil
+		}, session, response)
+	} else {
+		credential, err = relyingParty.ValidateLogin(user, session, response)
+	}
+	if err != nil {
+		return datatypes.WebAuthnCredential{}, err
+	}
+	if credential.Authenticator.CloneWarning {
+		return datatypes.WebAuthnCredential{}, ErrCloneDetected
+	}
+
+	stored, _ := user.Find(credential.ID)
+	stored.SignCount = int64(credential.Authenticator.SignCount)
+	stored.BackupState = credential.Flags.BackupState
+	return stored, nil
+}
+
+// ParseRegistrationResponse parses the PublicKeyCredential returned by navigator.credentials.create().
+func ParseRegistrationResponse(body io.Reader) (*protocol.ParsedCredentialCreationData, error) {
+	return protocol.ParseCredentialCreationResponseBody(body)
+}
+
+// ParseLoginResponse parses the PublicKeyCredential returned by navigator.credentials.get().
+func ParseLoginResponse(body io.Reader) (*protocol.ParsedCredentialAssertionData, error) {
+	return protocol.ParseCredentialRequestResponseBody(body)
+}
+
+// UserHandle returns the account id a discoverable credential was registered for.
+func UserHandle(response *protocol.ParsedCredentialAssertionData) (edgedb.UUID, error) {
+	var accountId edgedb.UUID
+	if len(response.Response.UserHandle) != len(accountId) {
+		return accountId, errors.New("invalid user handle")
+	}
+	copy(accountId[:], response.Response.UserHandle)
+	return accountId, nil
+}
+
+func EncodeSession(session *webauthn.SessionData) ([]byte, error) {
+	return json.Marshal(session)
+}
+
+func DecodeSession(data []byte) (webauthn.SessionData, error) {
+	var session webauthn.SessionData
+	return session, json.Unmarshal(data, &session)
+}
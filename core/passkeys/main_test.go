@@ -0,0 +1,394 @@
+package passkeys
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+const (
+	testOrigin = "https://auth.example.com"
+	testRPID   = "auth.example.com"
+)
+
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+// softwareAuthenticator is a platform authenticator holding a single P-256 credential in memory.
+type softwareAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+	origin       string
+}
+
+func newSoftwareAuthenticator(t *testing.T) *softwareAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialID := make([]byte, 16)
+	if _, err = rand.Read(credentialID); err != nil {
+		t.Fatal(err)
+	}
+	return &softwareAuthenticator{key: key, credentialID: credentialID, origin: testOrigin}
+}
+
+func (a *softwareAuthenticator) clientData(t *testing.T, ceremony string, challenge protocol.URLEncodedBase64) []byte {
+	t.Helper()
+	clientData, err := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": challenge.String(),
+		"origin":    a.origin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return clientData
+}
+
+func (a *softwareAuthenticator) authenticatorData(flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	data := append([]byte(nil), rpIDHash[:]...)
+	data = append(data, flags)
+	return binary.BigEndian.AppendUint32(data, a.signCount)
+}
+
+// create answers navigator.credentials.create() with a "none" attestation.
+func (a *softwareAuthenticator) create(t *testing.T, options *protocol.CredentialCreation) *protocol.ParsedCredentialCreationData {
+	t.Helper()
+	a.userHandle = options.Response.User.ID.(protocol.URLEncodedBase64)
+
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  1,
+		XCoord: a.key.PublicKey.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.PublicKey.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authData := a.authenticatorData(flagUserPresent | flagUserVerified | flagAttestedData)
+	authData = append(authData, make([]byte, 16)...)
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.credentialID)))
+	authData = append(authData, a.credentialID...)
+	authData = append(authData, publicKey...)
+
+	attestationObject, err := webauthncbor.Marshal(struct {
+		Format       string                 `cbor:"fmt"`
+		AttStatement map[string]interface{} `cbor:"attStmt"`
+		AuthData     []byte                 `cbor:"authData"`
+	}{Format: "none", AttStatement: map[string]interface{}{}, AuthData: authData})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.parseRegistration(t, map[string]interface{}{
+		"id":    encode(a.credentialID),
+		"rawId": encode(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]interface{}{
+			"clientDataJSON":    encode(a.clientData(t, "webauthn.create", options.Response.Challenge)),
+			"attestationObject": encode(attestationObject),
+			"transports":        []string{"internal"},
+		},
+	})
+}
+
+// get answers navigator.credentials.get() with a signed assertion, counting the signature.
+func (a *softwareAuthenticator) get(t *testing.T, options *protocol.CredentialAssertion) *protocol.ParsedCredentialAssertionData {
+	t.Helper()
+	a.signCount++
+	authData := a.authenticatorData(flagUserPresent | flagUserVerified)
+	clientData := a.clientData(t, "webauthn.get", options.Response.Challenge)
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"id":    encode(a.credentialID),
+		"rawId": encode(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]interface{}{
+			"clientDataJSON":    encode(clientData),
+			"authenticatorData": encode(authData),
+			"signature":         encode(signature),
+			"userHandle":        encode(a.userHandle),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := ParseLoginResponse(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func (a *softwareAuthenticator) parseRegistration(t *testing.T, credential map[string]interface{}) *protocol.ParsedCredentialCreationData {
+	t.Helper()
+	body, err := json.Marshal(credential)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := ParseRegistrationResponse(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func encode(value []byte) string {
+	return base64.RawURLEncoding.EncodeToString(value)
+}
+
+func newTestUser(t *testing.T) *User {
+	t.Helper()
+	accountId, err := edgedb.ParseUUID("8f4d7a2e-6c1b-11ef-9a3c-5b2f0e7d4c11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &User{Account: datatypes.Account{Id: accountId, Email: "jane@example.com", Username: "jane"}}
+}
+
+func newTestRelyingParty(t *testing.T) *webauthn.WebAuthn {
+	t.Helper()
+	relyingParty, err := RelyingParty(datatypes.Organization{
+		Slug:   "example",
+		Name:   "Example",
+		Issuer: edgedb.NewOptionalStr(testOrigin + "/tenants/example"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return relyingParty
+}
+
+// register runs a registration ceremony and stores the resulting credential with the user.
+func register(t *testing.T, relyingParty *webauthn.WebAuthn, user *User, authenticator *softwareAuthenticator) datatypes.WebAuthnCredential {
+	t.Helper()
+	options, session, err := BeginRegistration(relyingParty, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential, err := FinishRegistration(relyingParty, user, *session, authenticator.create(t, options))
+	if err != nil {
+		t.Fatalf("registration failed: %v", err)
+	}
+	user.Credentials = append(user.Credentials, credential)
+	return credential
+}
+
+func TestRegistration(t *testing.T) {
+	relyingParty, user, authenticator := newTestRelyingParty(t), newTestUser(t), newSoftwareAuthenticator(t)
+
+	credential := register(t, relyingParty, user, authenticator)
+
+	if !bytes.Equal(credential.CredentialID, authenticator.credentialID) {
+		t.Errorf("credential id = %x, want %x", credential.CredentialID, authenticator.credentialID)
+	}
+	if credential.AttestationType != "none" {
+		t.Errorf("attestation type = %q, want none", credential.AttestationType)
+	}
+	if len(credential.PublicKey) == 0 {
+		t.Error("public key was not stored")
+	}
+	if len(credential.Transports) != 1 || credential.Transports[0] != "internal" {
+		t.Errorf("transports = %v, want [internal]", credential.Transports)
+	}
+}
+
+func TestRegistrationExcludesRegisteredCredentials(t *testing.T) {
+	relyingParty, user, authenticator := newTestRelyingParty(t), newTestUser(t), newSoftwareAuthenticator(t)
+	register(t, relyingParty, user, authenticator)
+
+	options, _, err := BeginRegistration(relyingParty, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exclusions := options.Response.CredentialExcludeList
+	if len(exclusions) != 1 || !bytes.Equal(exclusions[0].CredentialID, authenticator.credentialID) {
+		t.Errorf("exclusions = %v, want the registered credential", exclusions)
+	}
+}
+
+func TestRegistrationRejectsForeignOrigin(t *testing.T) {
+	relyingParty, user, authenticator := newTestRelyingParty(t), newTestUser(t), newSoftwareAuthenticator(t)
+	authenticator.origin = "https://phishing.example.net"
+
+	options, session, err := BeginRegistration(relyingParty, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = FinishRegistration(relyingParty, user, *session, authenticator.create(t, options)); err == nil {
+		t.Error("registration from a foreign origin was accepted")
+	}
+}
+
+func TestRegistrationRejectsOtherChallenge(t *testing.T) {
+	relyingParty, user, authenticator := newTestRelyingParty(t), newTestUser(t), newSoftwareAuthenticator(t)
+
+	options, _, err := BeginRegistration(relyingParty, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherSession, err := BeginRegistration(relyingParty, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = FinishRegistration(relyingParty, user, *otherSession, authenticator.create(t, options)); err == nil {
+		t.Error("registration answering another challenge was accepted")
+	}
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	relyingParty, user, authenticator := newTestRelyingParty(t), newTestUser(t), newSoftwareAuthenticator(t)
+	register(t, relyingParty, user, authenticator)
+
+	options, session, err := BeginLogin(relyingParty, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.Response.UserVerification != protocol.VerificationRequired {
+		t.Errorf("user verification = %q, want required for passkey login", options.Response.UserVerification)
+	}
+
+	response := authenticator.get(t, options)
+	accountId, err := UserHandle(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accountId != user.Account.Id {
+		t.Fatalf("user handle = %s, want %s", accountId, user.Account.Id)
+	}
+
+	credential, err := FinishLogin(relyingParty, user, *session, response)
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	if credential.SignCount != 1 {
+		t.Errorf("sign count = %d, want 1", credential.SignCount)
+	}
+}
+
+func TestSecondFactorLogin(t *testing.T) {
+	relyingParty, user, authenticator := newTestRelyingParty(t), newTestUser(t), newSoftwareAuthenticator(t)
+	register(t, relyingParty, user, authenticator)
+
+	options, session, err := BeginLogin(relyingParty, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(options.Response.AllowedCredentials) != 1 {
+		t.Fatalf("allowed credentials = %d, want 1", len(options.Response.AllowedCredentials))
+	}
+
+	credential, err := FinishLogin(relyingParty, user, *session, authenticator.get(t, options))
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	if !bytes.Equal(credential.CredentialID, authenticator.credentialID) {
+		t.Errorf("credential id = %x, want %x", credential.CredentialID, authenticator.credentialID)
+	}
+}
+
+func TestLoginRejectsTamperedSignature(t *testing.T) {
+	relyingParty, user, authenticator := newTestRelyingParty(t), newTestUser(t), newSoftwareAuthenticator(t)
+	register(t, relyingParty, user, authenticator)
+
+	options, session, err := BeginLogin(relyingParty, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := authenticator.get(t, options)
+	response.Response.Signature[len(response.Response.Signature)-1] ^= 0xff
+
+	if _, err = FinishLogin(relyingParty, user, *session, response); err == nil {
+		t.Error("assertion with a tampered signature was accepted")
+	}
+}
+
+func TestLoginRejectsSignCountNotIncreasing(t *testing.T) {
+	relyingParty, user, authenticator := newTestRelyingParty(t), newTestUser(t), newSoftwareAuthenticator(t)
+	register(t, relyingParty, user, authenticator)
+
+	// A clone of the authenticator signs with a counter the stored one already went past
+	user.Credentials[0].SignCount = 5
+	authenticator.signCount = 4
+
+	options, session, err := BeginLogin(relyingParty, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = FinishLogin(relyingParty, user, *session, authenticator.get(t, options)); !errors.Is(err, ErrCloneDetected) {
+		t.Errorf("err = %v, want ErrCloneDetected", err)
+	}
+}
+
+func TestLoginAcceptsIncreasingSignCount(t *testing.T) {
+	relyingParty, user, authenticator := newTestRelyingParty(t), newTestUser(t), newSoftwareAuthenticator(t)
+	register(t, relyingParty, user, authenticator)
+
+	user.Credentials[0].SignCount = 5
+	authenticator.signCount = 5
+
+	options, session, err := BeginLogin(relyingParty, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential, err := FinishLogin(relyingParty, user, *session, authenticator.get(t, options))
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	if credential.SignCount != 6 {
+		t.Errorf("sign count = %d, want 6", credential.SignCount)
+	}
+}
+
+func TestSessionRoundTrip(t *testing.T) {
+	relyingParty, user := newTestRelyingParty(t), newTestUser(t)
+	_, session, err := BeginRegistration(relyingParty, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, err := EncodeSession(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeSession(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Challenge != session.Challenge || !bytes.Equal(decoded.UserID, session.UserID) {
+		t.Errorf("decoded session = %+v, want %+v", decoded, session)
+	}
+}
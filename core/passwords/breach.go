@@ -0,0 +1,157 @@
+package passwords
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghostship-dev/authservice/core/config"
+)
+
+// BreachChecker returns how often a password appears in known data breaches.
+type BreachChecker interface {
+	Count(password string) (int, error)
+}
+
+// RangeAPIChecker queries a HaveIBeenPwned compatible range API. Only the first five
+// characters of the SHA-1 digest leave the service (k-anonymity).
+type RangeAPIChecker struct {
+	URL    string
+	Client *http.Client
+}
+
+func (c *RangeAPIChecker) Count(password string) (int, error) {
+	prefix, suffix := splitDigest(password)
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(c.URL, "/")+"/"+prefix, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Add-Padding", "true")
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		hashSuffix, count, found := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if found && strings.EqualFold(hashSuffix, suffix) {
+			return strconv.Atoi(count)
+		}
+	}
+	return 0, scanner.Err()
+}
+
+// FileChecker searches a local file of "SHA1:COUNT" lines sorted by hash,
+// as published by HaveIBeenPwned in the "ordered by hash" format.
+type FileChecker struct {
+	Path string
+}
+
+func (c *FileChecker) Count(password string) (int, error) {
+	prefix, suffix := splitDigest(password)
+	digest := []byte(prefix + suffix)
+
+	file, err := os.Open(c.Path)
+	if err != nil {
+		return 0, err
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	// Binary search for the first line starting at or after an offset whose hash is not smaller than the digest
+	low, high := int64(0), info.Size()
+	for low < high {
+		mid := low + (high-low)/2
+		line, _, err := lineAfter(file, mid)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+		if line == nil || bytes.Compare(hashOf(line), digest) >= 0 {
+			high = mid
+		} else {
+			low = mid + 1
+		}
+	}
+
+	line, _, err := lineAfter(file, low)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0, err
+	}
+	if line == nil || !bytes.Equal(hashOf(line), digest) {
+		return 0, nil
+	}
+	_, count, _ := strings.Cut(string(line), ":")
+	return strconv.Atoi(strings.TrimSpace(count))
+}
+
+// lineAfter returns the first complete line starting at or after offset. Offset zero is the start of the first line.
+func lineAfter(file *os.File, offset int64) ([]byte, int64, error) {
+	start := offset
+	if offset > 0 {
+		start = offset - 1
+	}
+	reader := bufio.NewReader(io.NewSectionReader(file, start, 1<<62))
+	if offset > 0 {
+		skipped, err := reader.ReadBytes('\n')
+		if err != nil {
+			return nil, 0, err
+		}
+		start += int64(len(skipped))
+	}
+	line, err := reader.ReadBytes('\n')
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return nil, start, err
+	}
+	return line, start, nil
+}
+
+func hashOf(line []byte) []byte {
+	hash, _, _ := bytes.Cut(line, []byte(":"))
+	return bytes.ToUpper(hash)
+}
+
+func splitDigest(password string) (string, string) {
+	digest := sha1.Sum([]byte(password))
+	encoded := strings.ToUpper(hex.EncodeToString(digest[:]))
+	return encoded[:5], encoded[5:]
+}
+
+// BreachCheckerFromEnv creates the checker selected by PASSWORD_BREACH_CHECK (off, api or file).
+func BreachCheckerFromEnv() BreachChecker {
+	switch config.GetEnv("PASSWORD_BREACH_CHECK", "off") {
+	case "api":
+		return &RangeAPIChecker{
+			URL:    config.GetEnv("PASSWORD_BREACH_API_URL", "https://api.pwnedpasswords.com/range"),
+			Client: &http.Client{Timeout: config.GetEnvDuration("PASSWORD_BREACH_TIMEOUT", 3*time.Second)},
+		}
+	case "file":
+		return &FileChecker{Path: os.Getenv("PASSWORD_BREACH_FILE")}
+	}
+	return nil
+}
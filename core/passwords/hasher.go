@@ -0,0 +1,355 @@
+package passwords
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ghostship-dev/authservice/core/config"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+var (
+	ErrUnknownHashFormat = errors.New("unknown password hash format")
+	ErrExcessiveCost     = errors.New("password hash parameters exceed the supported maximum")
+)
+
+// Upper bounds of the cost parameters taken from stored hashes. Hashes above them are rejected instead of verified,
+// as a single crafted hash would otherwise take excessive CPU time or memory on every login.
+const (
+	maxArgon2Memory      = 1024 * 1024 // KiB
+	maxArgon2Time        = 16
+	maxArgon2Parallelism = 16
+	maxScryptMemory      = 1 << 30 // bytes, 128 * N * r
+	maxScryptParallelism = 16
+	maxPBKDF2Iterations  = 2_000_000
+	maxBcryptCost        = 16
+	maxKeyLength         = 128
+)
+
+// Hasher creates and verifies password hashes of one algorithm.
+type Hasher interface {
+	// Matches reports whether the encoded hash was created by this algorithm.
+	Matches(encoded string) bool
+	Hash(password string) (string, error)
+	Verify(password, encoded string) (bool, error)
+	// Validate checks the format and the cost parameters of the encoded hash without verifying a password.
+	Validate(encoded string) error
+	// NeedsRehash reports whether the encoded hash uses weaker or different parameters than the hasher.
+	NeedsRehash(encoded string) bool
+}
+
+// Argon2idHasher creates PHC strings like $argon2id$v=19$m=65536,t=3,p=2$salt$hash.
+// Memory is given in KiB.
+type Argon2idHasher struct {
+	Memory      uint32
+	Time        uint32
+	Parallelism uint8
+	SaltLength  int
+	KeyLength   uint32
+}
+
+type argon2Params struct {
+	memory, time uint32
+	parallelism  uint8
+	salt, key    []byte
+}
+
+func (h *Argon2idHasher) Matches(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt, err := randomSalt(h.SaltLength)
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Parallelism, h.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.Memory, h.Time, h.Parallelism, encodeBase64(salt), encodeBase64(key)), nil
+}
+
+func (h *Argon2idHasher) Verify(password, encoded string) (bool, error) {
+	params, err := parseArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	key := argon2.IDKey([]byte(password), params.salt, params.time, params.memory, params.parallelism, uint32(len(params.key)))
+	return subtle.ConstantTimeCompare(key, params.key) == 1, nil
+}
+
+func (h *Argon2idHasher) Validate(encoded string) error {
+	_, err := parseArgon2id(encoded)
+	return err
+}
+
+func (h *Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, err := parseArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return params.memory < h.Memory || params.time < h.Time || params.parallelism != h.Parallelism || uint32(len(params.key)) < h.KeyLength
+}
+
+func parseArgon2id(encoded string) (argon2Params, error) {
+	var params argon2Params
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" || parts[2] != "v="+strconv.Itoa(argon2.Version) {
+		return params, ErrUnknownHashFormat
+	}
+	var parallelism uint32
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &parallelism); err != nil {
+		return params, ErrUnknownHashFormat
+	}
+	if params.time < 1 || parallelism < 1 {
+		return params, ErrUnknownHashFormat
+	}
+	if params.memory > maxArgon2Memory || params.time > maxArgon2Time || parallelism > maxArgon2Parallelism {
+		return params, ErrExcessiveCost
+	}
+	params.parallelism = uint8(parallelism)
+	var err error
+	if params.salt, err = decodeBase64(parts[4]); err != nil {
+		return params, ErrUnknownHashFormat
+	}
+	if params.key, err = decodeBase64(parts[5]); err != nil || len(params.key) < 1 {
+		return params, ErrUnknownHashFormat
+	}
+	if len(params.key) > maxKeyLength {
+		return params, ErrExcessiveCost
+	}
+	return params, nil
+}
+
+// ScryptHasher creates PHC strings like $scrypt$ln=15,r=8,p=1$salt$hash, where ln is log2(N).
+type ScryptHasher struct {
+	LogN       uint8
+	R          int
+	P          int
+	SaltLength int
+	KeyLength  int
+}
+
+type scryptParams struct {
+	logN      uint8
+	r, p      int
+	salt, key []byte
+}
+
+func (h *ScryptHasher) Matches(encoded string) bool {
+	return strings.HasPrefix(encoded, "$scrypt$")
+}
+
+func (h *ScryptHasher) Hash(password string) (string, error) {
+	salt, err := randomSalt(h.SaltLength)
+	if err != nil {
+		return "", err
+	}
+	key, err := scrypt.Key([]byte(password), salt, 1<<h.LogN, h.R, h.P, h.KeyLength)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("$scrypt$ln=%d,r=%d,p=%d$%s$%s", h.LogN, h.R, h.P, encodeBase64(salt), encodeBase64(key)), nil
+}
+
+func (h *ScryptHasher) Verify(password, encoded string) (bool, error) {
+	params, err := parseScrypt(encoded)
+	if err != nil {
+		return false, err
+	}
+	key, err := scrypt.Key([]byte(password), params.salt, 1<<params.logN, params.r, params.p, len(params.key))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(key, params.key) == 1, nil
+}
+
+func (h *ScryptHasher) Validate(encoded string) error {
+	_, err := parseScrypt(encoded)
+	return err
+}
+
+func (h *ScryptHasher) NeedsRehash(encoded string) bool {
+	params, err := parseScrypt(encoded)
+	if err != nil {
+		return true
+	}
+	return params.logN < h.LogN || params.r < h.R || params.p < h.P || len(params.key) < h.KeyLength
+}
+
+func parseScrypt(encoded string) (scryptParams, error) {
+	var params scryptParams
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 || parts[1] != "scrypt" {
+		return params, ErrUnknownHashFormat
+	}
+	if _, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &params.logN, &params.r, &params.p); err != nil || params.logN < 1 || params.logN > 30 {
+		return params, ErrUnknownHashFormat
+	}
+	if err := checkScryptCost(1<<params.logN, params.r, params.p); err != nil {
+		return params, err
+	}
+	var err error
+	if params.salt, err = decodeBase64(parts[3]); err != nil {
+		return params, ErrUnknownHashFormat
+	}
+	if params.key, err = decodeBase64(parts[4]); err != nil || len(params.key) < 1 {
+		return params, ErrUnknownHashFormat
+	}
+	if len(params.key) > maxKeyLength {
+		return params, ErrExcessiveCost
+	}
+	return params, nil
+}
+
+// checkScryptCost bounds the memory scrypt allocates for N and r, and the work p multiplies it with.
+func checkScryptCost(n, r, p int) error {
+	if r < 1 || p < 1 {
+		return ErrUnknownHashFormat
+	}
+	if r > maxScryptMemory/128/n || p > maxScryptParallelism {
+		return ErrExcessiveCost
+	}
+	return nil
+}
+
+// BcryptHasher handles the modular crypt format of bcrypt ($2a$, $2b$, $2y$).
+// bcrypt ignores everything after the first 72 bytes of a password.
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h *BcryptHasher) Matches(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	return string(hash), err
+}
+
+func (h *BcryptHasher) Verify(password, encoded string) (bool, error) {
+	if err := h.Validate(encoded); err != nil {
+		return false, err
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (h *BcryptHasher) Validate(encoded string) error {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return ErrUnknownHashFormat
+	}
+	if cost > maxBcryptCost {
+		return ErrExcessiveCost
+	}
+	return nil
+}
+
+func (h *BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost < h.Cost
+}
+
+func randomSalt(length int) ([]byte, error) {
+	salt := make([]byte, length)
+	_, err := rand.Read(salt)
+	return salt, err
+}
+
+// PHC strings use standard base64 without padding.
+func encodeBase64(value []byte) string {
+	return base64.RawStdEncoding.EncodeToString(value)
+}
+
+func decodeBase64(value string) ([]byte, error) {
+	return base64.RawStdEncoding.DecodeString(strings.TrimRight(value, "="))
+}
+
+// HasherFromEnv creates the hasher selected by PASSWORD_HASHER (argon2id, scrypt or bcrypt).
+func HasherFromEnv() Hasher {
+	switch config.GetEnv("PASSWORD_HASHER", "argon2id") {
+	case "scrypt":
+		return &ScryptHasher{
+			LogN:       uint8(config.GetEnvInt("PASSWORD_SCRYPT_LOG_N", 15)),
+			R:          config.GetEnvInt("PASSWORD_SCRYPT_R", 8),
+			P:          config.GetEnvInt("PASSWORD_SCRYPT_P", 1),
+			SaltLength: 16,
+			KeyLength:  32,
+		}
+	case "bcrypt":
+		return &BcryptHasher{Cost: config.GetEnvInt("PASSWORD_BCRYPT_COST", bcrypt.DefaultCost)}
+	}
+	return &Argon2idHasher{
+		Memory:      uint32(config.GetEnvInt("PASSWORD_ARGON2_MEMORY", 64*1024)),
+		Time:        uint32(config.GetEnvInt("PASSWORD_ARGON2_TIME", 3)),
+		Parallelism: uint8(config.GetEnvInt("PASSWORD_ARGON2_PARALLELISM", 2)),
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+var (
+	hashersOnce   sync.Once
+	defaultHasher Hasher
+	hashers       []Hasher
+)
+
+// loadHashers sets up the configured hasher, the hashers able to verify older native hashes
+// and the verify-only hashers of imported formats.
+func loadHashers() {
+	hashersOnce.Do(func() {
+		defaultHasher = HasherFromEnv()
+		hashers = []Hasher{
+			defaultHasher,
+			&Argon2idHasher{},
+			&ScryptHasher{},
+			&BcryptHasher{Cost: bcrypt.DefaultCost},
+			&ImportedArgon2Hasher{},
+			&DjangoPBKDF2Hasher{},
+			&DjangoScryptHasher{},
+			&DjangoBcryptHasher{},
+			&SaltedSHAHasher{},
+		}
+	})
+}
+
+// Hash hashes the password with the configured hasher.
+func Hash(password string) (string, error) {
+	loadHashers()
+	return defaultHasher.Hash(password)
+}
+
+// Verify checks the password against a hash of any supported format. needsRehash is set when the
+// password matched but the hash was not created by the configured hasher or uses weaker parameters.
+func Verify(password, encoded string) (matches bool, needsRehash bool, err error) {
+	loadHashers()
+	for _, hasher := range hashers {
+		if !hasher.Matches(encoded) {
+			continue
+		}
+		matches, err = hasher.Verify(password, encoded)
+		if err != nil || !matches {
+			return false, false, err
+		}
+		return true, hasher != defaultHasher || defaultHasher.NeedsRehash(encoded), nil
+	}
+	return false, false, ErrUnknownHashFormat
+}
+
+// UsesBcrypt reports whether new hashes are bcrypt hashes, which limits passwords to 72 bytes.
+func UsesBcrypt() bool {
+	loadHashers()
+	_, isBcrypt := defaultHasher.(*BcryptHasher)
+	return isBcrypt
+}
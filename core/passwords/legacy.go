@@ -0,0 +1,312 @@
+package passwords
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// errVerifyOnly is returned by hashers of imported formats, which are only verified and never created.
+var errVerifyOnly = errors.New("password hash format is only supported for verification")
+
+// DjangoPBKDF2Hasher verifies Django hashes like pbkdf2_sha256$<iterations>$<salt>$<base64 hash>.
+type DjangoPBKDF2Hasher struct{}
+
+func (h *DjangoPBKDF2Hasher) Matches(encoded string) bool {
+	return strings.HasPrefix(encoded, "pbkdf2_sha256$") || strings.HasPrefix(encoded, "pbkdf2_sha1$")
+}
+
+func (h *DjangoPBKDF2Hasher) Hash(string) (string, error) {
+	return "", errVerifyOnly
+}
+
+func (h *DjangoPBKDF2Hasher) Verify(password, encoded string) (bool, error) {
+	params, err := parseDjangoPBKDF2(encoded)
+	if err != nil {
+		return false, err
+	}
+	digest := sha256.New
+	if params.algorithm == "pbkdf2_sha1" {
+		digest = sha1.New
+	}
+	key := pbkdf2.Key([]byte(password), params.salt, params.iterations, len(params.key), digest)
+	return subtle.ConstantTimeCompare(key, params.key) == 1, nil
+}
+
+func (h *DjangoPBKDF2Hasher) Validate(encoded string) error {
+	_, err := parseDjangoPBKDF2(encoded)
+	return err
+}
+
+type pbkdf2Params struct {
+	algorithm  string
+	iterations int
+	salt, key  []byte
+}
+
+func parseDjangoPBKDF2(encoded string) (pbkdf2Params, error) {
+	var params pbkdf2Params
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 {
+		return params, ErrUnknownHashFormat
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return params, ErrUnknownHashFormat
+	}
+	if iterations > maxPBKDF2Iterations {
+		return params, ErrExcessiveCost
+	}
+	key, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil || len(key) < 1 {
+		return params, ErrUnknownHashFormat
+	}
+	// Every block of the derived key takes all iterations again
+	if len(key) > 64 {
+		return params, ErrExcessiveCost
+	}
+	return pbkdf2Params{algorithm: parts[0], iterations: iterations, salt: []byte(parts[2]), key: key}, nil
+}
+
+func (h *DjangoPBKDF2Hasher) NeedsRehash(string) bool {
+	return true
+}
+
+// DjangoScryptHasher verifies Django hashes like scrypt$<N>$<salt>$<r>$<p>$<base64 hash>.
+type DjangoScryptHasher struct{}
+
+func (h *DjangoScryptHasher) Matches(encoded string) bool {
+	return strings.HasPrefix(encoded, "scrypt$")
+}
+
+func (h *DjangoScryptHasher) Hash(string) (string, error) {
+	return "", errVerifyOnly
+}
+
+func (h *DjangoScryptHasher) Verify(password, encoded string) (bool, error) {
+	params, n, err := parseDjangoScrypt(encoded)
+	if err != nil {
+		return false, err
+	}
+	key, err := scrypt.Key([]byte(password), params.salt, n, params.r, params.p, len(params.key))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(key, params.key) == 1, nil
+}
+
+func (h *DjangoScryptHasher) Validate(encoded string) error {
+	_, _, err := parseDjangoScrypt(encoded)
+	return err
+}
+
+// parseDjangoScrypt returns the parameters of the hash along with N, which Django stores as is rather than as log2(N).
+func parseDjangoScrypt(encoded string) (scryptParams, int, error) {
+	var params scryptParams
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return params, 0, ErrUnknownHashFormat
+	}
+	var values [3]int
+	for i, value := range []string{parts[1], parts[3], parts[4]} {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			return params, 0, ErrUnknownHashFormat
+		}
+		values[i] = parsed
+	}
+	n := values[0]
+	if n < 2 || n&(n-1) != 0 {
+		return params, 0, ErrUnknownHashFormat
+	}
+	if err := checkScryptCost(n, values[1], values[2]); err != nil {
+		return params, 0, err
+	}
+	key, err := base64.StdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) < 1 {
+		return params, 0, ErrUnknownHashFormat
+	}
+	if len(key) > maxKeyLength {
+		return params, 0, ErrExcessiveCost
+	}
+	return scryptParams{r: values[1], p: values[2], salt: []byte(parts[2]), key: key}, n, nil
+}
+
+func (h *DjangoScryptHasher) NeedsRehash(string) bool {
+	return true
+}
+
+// ImportedArgon2Hasher verifies argon2i and argon2id PHC strings, including Django's "argon2$" prefixed variant.
+type ImportedArgon2Hasher struct{}
+
+func (h *ImportedArgon2Hasher) Matches(encoded string) bool {
+	encoded = strings.TrimPrefix(encoded, "argon2")
+	return strings.HasPrefix(encoded, "$argon2i$") || strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func (h *ImportedArgon2Hasher) Hash(string) (string, error) {
+	return "", errVerifyOnly
+}
+
+func (h *ImportedArgon2Hasher) Verify(password, encoded string) (bool, error) {
+	encoded = strings.TrimPrefix(encoded, "argon2")
+	if !strings.HasPrefix(encoded, "$argon2i$") {
+		return (&Argon2idHasher{}).Verify(password, encoded)
+	}
+	params, err := parseArgon2id(strings.Replace(encoded, "$argon2i$", "$argon2id$", 1))
+	if err != nil {
+		return false, err
+	}
+	key := argon2.Key([]byte(password), params.salt, params.time, params.memory, params.parallelism, uint32(len(params.key)))
+	return subtle.ConstantTimeCompare(key, params.key) == 1, nil
+}
+
+func (h *ImportedArgon2Hasher) Validate(encoded string) error {
+	encoded = strings.TrimPrefix(encoded, "argon2")
+	_, err := parseArgon2id(strings.Replace(encoded, "$argon2i$", "$argon2id$", 1))
+	return err
+}
+
+func (h *ImportedArgon2Hasher) NeedsRehash(string) bool {
+	return true
+}
+
+// DjangoBcryptHasher verifies Django's bcrypt$<hash> and bcrypt_sha256$<hash> formats.
+// The latter hashes the password with SHA-256 first to work around the 72 byte limit.
+type DjangoBcryptHasher struct{}
+
+func (h *DjangoBcryptHasher) Matches(encoded string) bool {
+	return strings.HasPrefix(encoded, "bcrypt$") || strings.HasPrefix(encoded, "bcrypt_sha256$")
+}
+
+func (h *DjangoBcryptHasher) Hash(string) (string, error) {
+	return "", errVerifyOnly
+}
+
+func (h *DjangoBcryptHasher) Verify(password, encoded string) (bool, error) {
+	if err := h.Validate(encoded); err != nil {
+		return false, err
+	}
+	algorithm, bcryptHash, _ := strings.Cut(encoded, "$")
+	if algorithm == "bcrypt_sha256" {
+		digest := sha256.Sum256([]byte(password))
+		password = hex.EncodeToString(digest[:])
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(bcryptHash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (h *DjangoBcryptHasher) Validate(encoded string) error {
+	_, bcryptHash, _ := strings.Cut(encoded, "$")
+	return (&BcryptHasher{}).Validate(bcryptHash)
+}
+
+func (h *DjangoBcryptHasher) NeedsRehash(string) bool {
+	return true
+}
+
+// SaltedSHAHasher verifies LDAP style {SSHA}, {SSHA256}, {SSHA512} and {SHA} hashes, where the salt is
+// appended to the digest before base64 encoding, and Django's legacy sha1$<salt>$<hex digest> format.
+type SaltedSHAHasher struct{}
+
+var saltedSHASchemes = map[string]func() hash.Hash{
+	"{SHA}":     sha1.New,
+	"{SSHA}":    sha1.New,
+	"{SSHA256}": sha256.New,
+	"{SSHA512}": sha512.New,
+}
+
+func (h *SaltedSHAHasher) Matches(encoded string) bool {
+	if strings.HasPrefix(encoded, "sha1$") {
+		return true
+	}
+	for scheme := range saltedSHASchemes {
+		if strings.HasPrefix(strings.ToUpper(encoded), scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *SaltedSHAHasher) Hash(string) (string, error) {
+	return "", errVerifyOnly
+}
+
+func (h *SaltedSHAHasher) Verify(password, encoded string) (bool, error) {
+	if strings.HasPrefix(encoded, "sha1$") {
+		parts := strings.Split(encoded, "$")
+		if len(parts) != 3 {
+			return false, ErrUnknownHashFormat
+		}
+		digest := sha1.Sum([]byte(parts[1] + password))
+		return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(digest[:])), []byte(strings.ToLower(parts[2]))) == 1, nil
+	}
+
+	digest, expected, salt, err := parseSaltedSHA(encoded)
+	if err != nil {
+		return false, err
+	}
+	digest.Write([]byte(password))
+	digest.Write(salt)
+	return subtle.ConstantTimeCompare(digest.Sum(nil), expected) == 1, nil
+}
+
+func (h *SaltedSHAHasher) Validate(encoded string) error {
+	if strings.HasPrefix(encoded, "sha1$") {
+		if strings.Count(encoded, "$") != 2 {
+			return ErrUnknownHashFormat
+		}
+		return nil
+	}
+	_, _, _, err := parseSaltedSHA(encoded)
+	return err
+}
+
+// parseSaltedSHA returns a new digest of the scheme along with the expected digest and the salt of the hash.
+func parseSaltedSHA(encoded string) (hash.Hash, []byte, []byte, error) {
+	scheme, value, found := strings.Cut(encoded, "}")
+	newDigest, known := saltedSHASchemes[strings.ToUpper(scheme)+"}"]
+	if !found || !known {
+		return nil, nil, nil, ErrUnknownHashFormat
+	}
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, nil, nil, ErrUnknownHashFormat
+	}
+	digest := newDigest()
+	if len(decoded) < digest.Size() {
+		return nil, nil, nil, ErrUnknownHashFormat
+	}
+	return digest, decoded[:digest.Size()], decoded[digest.Size():], nil
+}
+
+func (h *SaltedSHAHasher) NeedsRehash(string) bool {
+	return true
+}
+
+// ValidateHash checks whether a hash can be verified, e.g. before importing it. It fails with ErrUnknownHashFormat
+// for unsupported or malformed hashes and with ErrExcessiveCost for hashes whose parameters exceed the supported maximum.
+func ValidateHash(encoded string) error {
+	loadHashers()
+	for _, hasher := range hashers {
+		if hasher.Matches(encoded) {
+			return hasher.Validate(encoded)
+		}
+	}
+	return ErrUnknownHashFormat
+}
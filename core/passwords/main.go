@@ -0,0 +1,180 @@
+package passwords
+
+import (
+	_ "embed"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/ghostship-dev/authservice/core/config"
+)
+
+//go:embed common_passwords.txt
+var commonPasswordList string
+
+var commonPasswords = func() map[string]bool {
+	passwords := make(map[string]bool)
+	for _, password := range strings.Split(commonPasswordList, "\n") {
+		if password = strings.TrimSpace(password); password != "" {
+			passwords[password] = true
+		}
+	}
+	return passwords
+}()
+
+// Policy describes the requirements new passwords have to meet.
+type Policy struct {
+	MinLength          int
+	MaxLength          int
+	RequireLowercase   bool
+	RequireUppercase   bool
+	RequireDigit       bool
+	RequireSymbol      bool
+	RejectCommon       bool
+	RejectPersonalInfo bool
+	HistorySize        int
+	Breaches           BreachChecker
+	BreachThreshold    int
+	BreachFailOpen     bool
+}
+
+var (
+	breachCheckerOnce sync.Once
+	breachChecker     BreachChecker
+)
+
+// PolicyFromEnv reads the policy from the PASSWORD_* environment variables.
+// bcrypt ignores everything after 72 bytes, so longer passwords are rejected by default when it is the configured hasher.
+func PolicyFromEnv() Policy {
+	maxLength := 256
+	if UsesBcrypt() {
+		maxLength = 72
+	}
+	return Policy{
+		MinLength:          config.GetEnvInt("PASSWORD_MIN_LENGTH", 8),
+		MaxLength:          config.GetEnvInt("PASSWORD_MAX_LENGTH", maxLength),
+		RequireLowercase:   config.GetEnvBool("PASSWORD_REQUIRE_LOWERCASE", false),
+		RequireUppercase:   config.GetEnvBool("PASSWORD_REQUIRE_UPPERCASE", false),
+		RequireDigit:       config.GetEnvBool("PASSWORD_REQUIRE_DIGIT", false),
+		RequireSymbol:      config.GetEnvBool("PASSWORD_REQUIRE_SYMBOL", false),
+		RejectCommon:       config.GetEnvBool("PASSWORD_REJECT_COMMON", true),
+		RejectPersonalInfo: config.GetEnvBool("PASSWORD_REJECT_PERSONAL_INFO", true),
+		HistorySize:        config.GetEnvInt("PASSWORD_HISTORY_SIZE", 5),
+		Breaches:           sharedBreachChecker(),
+		BreachThreshold:    config.GetEnvInt("PASSWORD_BREACH_THRESHOLD", 1),
+		BreachFailOpen:     config.GetEnvBool("PASSWORD_BREACH_FAIL_OPEN", true),
+	}
+}
+
+// sharedBreachChecker creates the breach checker on first use, after the environment has been loaded.
+func sharedBreachChecker() BreachChecker {
+	breachCheckerOnce.Do(func() {
+		breachChecker = BreachCheckerFromEnv()
+	})
+	return breachChecker
+}
+
+// Check returns the requirements the password violates. Email and username are used to reject
+// passwords containing personal information and may be empty.
+func (p Policy) Check(password, email, username string) []string {
+	var violations []string
+
+	if len(password) < p.MinLength {
+		violations = append(violations, "password must be at least "+strconv.Itoa(p.MinLength)+" characters long")
+	}
+	if p.MaxLength > 0 && len(password) > p.MaxLength {
+		violations = append(violations, "password must be at most "+strconv.Itoa(p.MaxLength)+" bytes long")
+	}
+
+	var hasLower, hasUpper, hasDigit, hasSymbol bool
+	for _, char := range password {
+		switch {
+		case unicode.IsLower(char):
+			hasLower = true
+		case unicode.IsUpper(char):
+			hasUpper = true
+		case unicode.IsDigit(char):
+			hasDigit = true
+		default:
+			hasSymbol = true
+		}
+	}
+	if p.RequireLowercase && !hasLower {
+		violations = append(violations, "password must contain a lowercase letter")
+	}
+	if p.RequireUppercase && !hasUpper {
+		violations = append(violations, "password must contain an uppercase letter")
+	}
+	if p.RequireDigit && !hasDigit {
+		violations = append(violations, "password must contain a digit")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		violations = append(violations, "password must contain a symbol")
+	}
+
+	lowered := strings.ToLower(password)
+	if p.RejectCommon && commonPasswords[lowered] {
+		violations = append(violations, "password is too common")
+	}
+
+	if p.RejectPersonalInfo {
+		localPart, _, _ := strings.Cut(strings.ToLower(email), "@")
+		for _, personal := range []string{localPart, strings.ToLower(username)} {
+			// Very short names would reject too many unrelated passwords
+			if len(personal) >= 3 && strings.Contains(lowered, personal) {
+				violations = append(violations, "password must not contain your email address or username")
+				break
+			}
+		}
+	}
+
+	// The breach check is the most expensive one and only worth it for otherwise acceptable passwords
+	if p.Breaches != nil && len(violations) < 1 {
+		if violation := p.checkBreaches(password); violation != "" {
+			violations = append(violations, violation)
+		}
+	}
+
+	return violations
+}
+
+// checkBreaches rejects passwords seen in at least BreachThreshold breaches. If the breach source is
+// unavailable the password is accepted unless BreachFailOpen is disabled.
+func (p Policy) checkBreaches(password string) string {
+	count, err := p.Breaches.Count(password)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("password breach check unavailable: %s", err))
+		if p.BreachFailOpen {
+			return ""
+		}
+		return "password could not be checked against known data breaches, please try again later"
+	}
+	if count >= p.BreachThreshold && count > 0 {
+		return "password appeared in a known data breach"
+	}
+	return ""
+}
+
+// IsReused reports whether the password matches the current hash or one of the previous hashes.
+func (p Policy) IsReused(password string, hashes []string) bool {
+	for i, hash := range hashes {
+		if i > p.HistorySize {
+			break
+		}
+		if matches, _, _ := Verify(password, hash); matches {
+			return true
+		}
+	}
+	return false
+}
+
+// History returns the previous password hashes to keep after replacing currentHash.
+func (p Policy) History(currentHash string, previous []string) []string {
+	history := append([]string{currentHash}, previous...)
+	if len(history) > p.HistorySize {
+		history = history[:p.HistorySize]
+	}
+	return history
+}
@@ -0,0 +1,79 @@
+package profile
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ghostship-dev/authservice/core/config"
+	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/mail"
+)
+
+const maxAvatarURILength = 2048
+
+var (
+	usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+	// emailPattern matches the constraint of Account.email in the schema
+	emailPattern = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
+)
+
+// ValidateUsername checks the username against the configured length, character and reserved name rules.
+// It returns an empty string if the username is acceptable. Uniqueness is checked by the database.
+func ValidateUsername(username string) string {
+	minLength := config.GetEnvInt("PROFILE_USERNAME_MIN_LENGTH", 3)
+	maxLength := config.GetEnvInt("PROFILE_USERNAME_MAX_LENGTH", 32)
+	if len(username) < minLength || len(username) > maxLength {
+		return fmt.Sprintf("username must be between %d and %d characters long", minLength, maxLength)
+	}
+	if !usernamePattern.MatchString(username) {
+		return "username may only contain letters, digits, '.', '_' and '-' and must start with a letter or digit"
+	}
+	reserved := strings.Split(config.GetEnv("PROFILE_RESERVED_USERNAMES", "admin,administrator,root,system,support,erased"), ",")
+	for _, name := range reserved {
+		if strings.EqualFold(strings.TrimSpace(name), username) {
+			return "username is reserved"
+		}
+	}
+	return ""
+}
+
+// ValidateAvatarURI checks that the avatar is an absolute https URL on one of the PROFILE_AVATAR_ALLOWED_HOSTS, if configured.
+// It returns an empty string if the URL is acceptable.
+func ValidateAvatarURI(avatarURI string) string {
+	if len(avatarURI) > maxAvatarURILength {
+		return fmt.Sprintf("avatar_uri must not be longer than %d characters", maxAvatarURILength)
+	}
+	parsed, err := url.Parse(avatarURI)
+	if err != nil || !parsed.IsAbs() || parsed.Host == "" {
+		return "avatar_uri must be an absolute URL"
+	}
+	if parsed.Scheme != "https" && !(parsed.Scheme == "http" && config.GetEnvBool("PROFILE_AVATAR_ALLOW_HTTP", false)) {
+		return "avatar_uri must use https"
+	}
+	if parsed.User != nil {
+		return "avatar_uri must not contain credentials"
+	}
+	allowedHosts := config.GetEnv("PROFILE_AVATAR_ALLOWED_HOSTS", "")
+	if allowedHosts != "" && !slices.Contains(strings.Split(allowedHosts, ","), parsed.Hostname()) {
+		return "avatar_uri host is not allowed"
+	}
+	return ""
+}
+
+func IsValidEmail(email string) bool {
+	return emailPattern.MatchString(email)
+}
+
+// SendEmailChangeNoticeMail tells the current address of the account that a change to newEmail was requested.
+func SendEmailChangeNoticeMail(account datatypes.Account, newEmail string) {
+	mail.Send(mail.Message{
+		To:      account.Email,
+		Subject: "Your email address is being changed",
+		Body: fmt.Sprintf("Hello %s,\r\n\r\non %s a change of the email address of your account to %s was requested. The change takes effect once the new address is verified.\r\n\r\nIf you did not do this, change your password immediately and contact support.",
+			account.Username, time.Now().UTC().Format(time.RFC1123), newEmail),
+	})
+}
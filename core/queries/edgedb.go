@@ -3,13 +3,13 @@ package queries
 import (
 	"context"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/edgedb/edgedb-go"
 	"github.com/ghostship-dev/authservice/core/config"
 	"github.com/ghostship-dev/authservice/core/datatypes"
 	"github.com/ghostship-dev/authservice/core/responses"
-	"golang.org/x/crypto/bcrypt"
 )
 
 func connectToEdgeDB(c *config.Config) (*edgedb.Client, error) {
@@ -26,6 +26,24 @@ func connectToEdgeDB(c *config.Config) (*edgedb.Client, error) {
 	return client, err
 }
 
+// accountRolesShape selects the roles of an account together with the names of their permissions.
+const accountRolesShape = "roles: { id, name, description, permission_names := .permissions.name }"
+
+// accountOTPShape selects the TOTP secret of an account with the settings it was enrolled with and its failed attempts.
+const accountOTPShape = "otp_secret, otp_state, otp_algorithm, otp_digits, otp_period, otp_failed_attempts, otp_last_failed_attempt"
+
+// authenticationParams returns the auth_time, acr and amr query arguments of the authentication, unset if it is unknown.
+func authenticationParams(authentication datatypes.Authentication) (edgedb.OptionalDateTime, edgedb.OptionalStr, []string) {
+	if !authentication.IsSet() {
+		return edgedb.OptionalDateTime{}, edgedb.OptionalStr{}, []string{}
+	}
+	amr := authentication.AMR
+	if amr == nil {
+		amr = []string{}
+	}
+	return edgedb.NewOptionalDateTime(authentication.Time), edgedb.NewOptionalStr(authentication.ACR), amr
+}
+
 type EdgeDBQueries struct {
 	client  *edgedb.Client
 	context context.Context
@@ -42,30 +60,25 @@ func NewEdgeDBQueryImplementation(c *config.Config) *EdgeDBQueries {
 	}
 }
 
-func (edb *EdgeDBQueries) GetPasswordByEmail(email string) (datatypes.Password, error) {
+func (edb *EdgeDBQueries) GetPasswordByEmail(organizationId edgedb.UUID, email string) (datatypes.Password, error) {
 	var password datatypes.Password
-	query := "SELECT Password{password, failed_attempts, account: { id, otp_secret, otp_state }} filter .email = <str>$0 LIMIT 1"
-	err := edb.client.QuerySingle(edb.context, query, &password, email)
+	query := "SELECT Password{id, password, failed_attempts, last_failed_attempt, account: { id, username, email, status, deletion_scheduled_at, " + accountOTPShape + ", organization: { id }, " + accountRolesShape + " }} filter .email = <str>$0 and .account.organization.id = <uuid>$1 LIMIT 1"
+	err := edb.client.QuerySingle(edb.context, query, &password, email, organizationId)
 	return password, err
 }
 
-func (edb *EdgeDBQueries) CreateAccount(email, username, password string) (datatypes.Account, error) {
+func (edb *EdgeDBQueries) CreateAccount(organizationId edgedb.UUID, email, username, passwordHash, role string) (datatypes.Account, error) {
 	var account datatypes.Account
 	err := edb.client.Tx(edb.context, func(ctx context.Context, tx *edgedb.Tx) error {
-		accountCreationQuery := "INSERT Account { username := <str>$0, email := <str>$1 }"
+		accountCreationQuery := "INSERT Account { organization := <Organization>$3, username := <str>$0, email := <str>$1, roles := (SELECT Role filter .name = <str>$2), created_at := datetime_current() }"
 		passwordCreationQuery := "INSERT Password { account := <Account>$0, email := <str>$1, password := <str>$2 }"
 
-		err := tx.QuerySingle(ctx, accountCreationQuery, &account, username, email)
+		err := tx.QuerySingle(ctx, accountCreationQuery, &account, username, email, role, organizationId)
 		if err != nil {
 			return err
 		}
 
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-		if err != nil {
-			return err
-		}
-
-		err = tx.Execute(ctx, passwordCreationQuery, account.Id, email, string(hashedPassword))
+		err = tx.Execute(ctx, passwordCreationQuery, account.Id, email, passwordHash)
 		if err != nil {
 			return err
 		}
@@ -86,14 +99,170 @@ func (edb *EdgeDBQueries) GetAccountById(id string) (datatypes.Account, error) {
 	return account, err
 }
 
-func (edb *EdgeDBQueries) IncrementFailedPasswordLoginAttempts(email string) error {
-	query := "UPDATE Password filter .email = <str>$0 set { failed_attempts := .failed_attempts +1, last_failed_attempt := <datetime>$1 }"
-	return edb.client.Execute(edb.context, query, email, time.Now())
+func (edb *EdgeDBQueries) GetAccountByEmail(organizationId edgedb.UUID, email string) (datatypes.Account, error) {
+	var account datatypes.Account
+	query := "SELECT Account { id, username, email, status, organization: { id } } filter .email = <str>$0 and .organization.id = <uuid>$1 LIMIT 1"
+	return account, edb.client.QuerySingle(edb.context, query, &account, email, organizationId)
+}
+
+func (edb *EdgeDBQueries) MarkVerificationMailSent(accountId edgedb.UUID, lastSentBefore time.Time) (bool, error) {
+	var result []edgedb.UUID
+	query := "SELECT (UPDATE Account filter .id = <uuid>$0 and .status = 'created' and (not exists .verification_sent_at or .verification_sent_at < <datetime>$1) set { verification_sent_at := datetime_current() }).id"
+	if err := edb.client.Query(edb.context, query, &result, accountId, lastSentBefore); err != nil {
+		return false, err
+	}
+	return len(result) > 0, nil
+}
+
+func (edb *EdgeDBQueries) ActivateAccount(accountId edgedb.UUID, email string) (bool, error) {
+	var result []edgedb.UUID
+	query := "SELECT (UPDATE Account filter .id = <uuid>$0 and .email = <str>$1 and .status = 'created' set { status := 'active', status_changed := datetime_current() }).id"
+	if err := edb.client.Query(edb.context, query, &result, accountId, email); err != nil {
+		return false, err
+	}
+	return len(result) > 0, nil
+}
+
+func (edb *EdgeDBQueries) GetProfile(accountId edgedb.UUID) (datatypes.Account, error) {
+	var account datatypes.Account
+	query := "SELECT Account { id, username, email, pending_email, avatar_uri, status, status_description, status_changed, created_at, otp_state, organization: { id }, " + accountRolesShape + " } filter .id = <uuid>$0 LIMIT 1"
+	return account, edb.client.QuerySingle(edb.context, query, &account, accountId)
+}
+
+// IsUsernameTaken reports whether another account of the organization uses the username, ignoring case.
+func (edb *EdgeDBQueries) IsUsernameTaken(organizationId edgedb.UUID, username string, exceptAccountId edgedb.OptionalUUID) (bool, error) {
+	var taken bool
+	query := "SELECT exists (SELECT Account filter .organization.id = <uuid>$0 and str_lower(.username) = str_lower(<str>$1) and .id ?!= <optional uuid>$2)"
+	return taken, edb.client.QuerySingle(edb.context, query, &taken, organizationId, username, exceptAccountId)
+}
+
+// UpdateProfile changes the username if set, and the avatar if updateAvatar is true. An unset avatarURI removes the avatar.
+func (edb *EdgeDBQueries) UpdateProfile(accountId edgedb.UUID, username, avatarURI edgedb.OptionalStr, updateAvatar bool) error {
+	query := "UPDATE Account filter .id = <uuid>$0 set { username := <optional str>$1 ?? .username, avatar_uri := <optional str>$2 if <bool>$3 else .avatar_uri }"
+	return edb.client.Execute(edb.context, query, accountId, username, avatarURI, updateAvatar)
+}
+
+func (edb *EdgeDBQueries) SetPendingEmail(accountId edgedb.UUID, email string) error {
+	query := "UPDATE Account filter .id = <uuid>$0 set { pending_email := <str>$1 }"
+	return edb.client.Execute(edb.context, query, accountId, email)
+}
+
+// ConfirmEmailChange replaces the email of the account with its pending email if it matches the verified one.
+// Proving ownership of the new address also verifies accounts that were still unverified.
+func (edb *EdgeDBQueries) ConfirmEmailChange(accountId edgedb.UUID, email string) (bool, error) {
+	var result []edgedb.UUID
+	err := edb.client.Tx(edb.context, func(ctx context.Context, tx *edgedb.Tx) error {
+		query := `SELECT (UPDATE Account filter .id = <uuid>$0 and .pending_email = <str>$1 set {
+			email := <str>$1,
+			pending_email := {},
+			status := 'active' if .status = 'created' else .status,
+			status_changed := datetime_current() if .status = 'created' else .status_changed,
+		}).id`
+		if err := tx.Query(ctx, query, &result, accountId, email); err != nil {
+			return err
+		}
+		if len(result) == 0 {
+			return nil
+		}
+		return tx.Execute(ctx, "UPDATE Password filter .account.id = <uuid>$0 set { email := <str>$1 }", accountId, email)
+	})
+	return len(result) > 0, err
+}
+
+func (edb *EdgeDBQueries) SetAccountStatus(accountId edgedb.UUID, status string) error {
+	query := "UPDATE Account filter .id = <uuid>$0 set { status := <str>$1, status_changed := datetime_current() }"
+	return edb.client.Execute(edb.context, query, accountId, status)
+}
+
+func (edb *EdgeDBQueries) CreatePasswordResetToken(accountId edgedb.UUID, tokenHash, requestedFrom string, expiresAt time.Time) error {
+	query := "INSERT PasswordResetToken { account := <Account>$0, token_hash := <str>$1, requested_from := <str>$2, expires_at := <datetime>$3 }"
+	return edb.client.Execute(edb.context, query, accountId, tokenHash, requestedFrom, expiresAt)
+}
+
+// CountPasswordResetTokens counts the reset links sent to the account and on requests from the address since the given time.
+func (edb *EdgeDBQueries) CountPasswordResetTokens(accountId edgedb.UUID, requestedFrom string, since time.Time) (datatypes.RequestCount, error) {
+	var count datatypes.RequestCount
+	query := `SELECT {
+		account := count(PasswordResetToken filter .account.id = <uuid>$0 and .created_at > <datetime>$2),
+		address := count(PasswordResetToken filter .requested_from = <str>$1 and .created_at > <datetime>$2),
+	}`
+	return count, edb.client.QuerySingle(edb.context, query, &count, accountId, requestedFrom, since)
+}
+
+func (edb *EdgeDBQueries) GetPasswordByAccountId(accountId edgedb.UUID) (datatypes.Password, error) {
+	var password datatypes.Password
+	query := "SELECT Password { id, password, previous_passwords, failed_attempts, last_failed_attempt, account: { id, username, email, " + accountOTPShape + ", organization: { id } } } filter .account.id = <uuid>$0 LIMIT 1"
+	return password, edb.client.QuerySingle(edb.context, query, &password, accountId)
+}
+
+func (edb *EdgeDBQueries) GetPasswordResetTokenAccountId(organizationId edgedb.UUID, tokenHash string) (edgedb.UUID, error) {
+	var accountId edgedb.UUID
+	query := "SELECT (SELECT PasswordResetToken filter .token_hash = <str>$0 and .account.organization.id = <uuid>$1 and not exists .used_at and .expires_at > datetime_current() LIMIT 1).account.id"
+	return accountId, edb.client.QuerySingle(edb.context, query, &accountId, tokenHash, organizationId)
+}
+
+func (edb *EdgeDBQueries) UpdatePasswordHash(passwordId edgedb.UUID, passwordHash string) error {
+	query := "UPDATE Password filter .id = <uuid>$0 set { password := <str>$1 }"
+	return edb.client.Execute(edb.context, query, passwordId, passwordHash)
+}
+
+// UpdatePassword sets the new password and revokes every token of the account, so sessions opened with the old password end.
+func (edb *EdgeDBQueries) UpdatePassword(accountId edgedb.UUID, passwordHash string, previousPasswords []string) error {
+	query := `
+		UPDATE Password filter .account.id = <uuid>$0 set { password := <str>$1, previous_passwords := <array<str>>$2, failed_attempts := 0, last_failed_attempt := {} };
+		DELETE Token filter .account.id = <uuid>$0;
+	`
+	return edb.client.Execute(edb.context, query, accountId, passwordHash, previousPasswords)
+}
+
+func (edb *EdgeDBQueries) ResetPasswordWithToken(organizationId edgedb.UUID, tokenHash, passwordHash string, previousPasswords []string) (datatypes.Account, error) {
+	var account datatypes.Account
+	err := edb.client.Tx(edb.context, func(ctx context.Context, tx *edgedb.Tx) error {
+		var resetToken struct {
+			Id      edgedb.UUID       `edgedb:"id"`
+			Account datatypes.Account `edgedb:"account"`
+		}
+		query := "SELECT PasswordResetToken { id, account: { id, username, email, organization: { id } } } filter .token_hash = <str>$0 and .account.organization.id = <uuid>$1 and not exists .used_at and .expires_at > datetime_current() LIMIT 1"
+		if err := tx.QuerySingle(ctx, query, &resetToken, tokenHash, organizationId); err != nil {
+			return err
+		}
+		account = resetToken.Account
+
+		// Every outstanding reset token of the account is consumed, not only the one used
+		query = "UPDATE PasswordResetToken filter .account.id = <uuid>$0 and not exists .used_at set { used_at := datetime_current() }"
+		if err := tx.Execute(ctx, query, account.Id); err != nil {
+			return err
+		}
+
+		query = "UPDATE Password filter .account.id = <uuid>$0 set { password := <str>$1, previous_passwords := <array<str>>$2, failed_attempts := 0, last_failed_attempt := {} }"
+		if err := tx.Execute(ctx, query, account.Id, passwordHash, previousPasswords); err != nil {
+			return err
+		}
+
+		query = "DELETE Token filter .account.id = <uuid>$0"
+		return tx.Execute(ctx, query, account.Id)
+	})
+	return account, err
+}
+
+func (edb *EdgeDBQueries) IncrementFailedPasswordLoginAttempts(passwordId edgedb.UUID) (datatypes.Password, error) {
+	var password datatypes.Password
+	query := "SELECT (UPDATE Password filter .id = <uuid>$0 set { failed_attempts := .failed_attempts +1, last_failed_attempt := <datetime>$1 }) { id, failed_attempts, last_failed_attempt }"
+	return password, edb.client.QuerySingle(edb.context, query, &password, passwordId, time.Now())
 }
 
-func (edb *EdgeDBQueries) ResetFailedPasswordLoginAttempts(email string) error {
-	query := "UPDATE Password filter .email = <str>$0 set { failed_attempts := 0, last_failed_attempt := {} }"
-	return edb.client.Execute(edb.context, query, email)
+func (edb *EdgeDBQueries) UnlockPassword(organizationId, accountId edgedb.UUID, lockedAt edgedb.OptionalDateTime) (bool, error) {
+	var result []edgedb.UUID
+	query := "SELECT (UPDATE Password filter .account.id = <uuid>$0 and .account.organization.id = <uuid>$2 and .failed_attempts > 0 and (not exists <optional datetime>$1 or .last_failed_attempt = <optional datetime>$1) set { failed_attempts := 0, last_failed_attempt := {} }).id"
+	if err := edb.client.Query(edb.context, query, &result, accountId, lockedAt, organizationId); err != nil {
+		return false, err
+	}
+	return len(result) > 0, nil
+}
+
+func (edb *EdgeDBQueries) ResetFailedPasswordLoginAttempts(passwordId edgedb.UUID) error {
+	query := "UPDATE Password filter .id = <uuid>$0 set { failed_attempts := 0, last_failed_attempt := {} }"
+	return edb.client.Execute(edb.context, query, passwordId)
 }
 
 func (edb *EdgeDBQueries) AddNewToken(accountId edgedb.UUID, value, variant string, expiresAt time.Time, scope []string) error {
@@ -101,25 +270,96 @@ func (edb *EdgeDBQueries) AddNewToken(accountId edgedb.UUID, value, variant stri
 	return edb.client.Execute(edb.context, query, accountId, variant, scope, value, false, expiresAt)
 }
 
-func (edb *EdgeDBQueries) AddNewTokenPair(accountId edgedb.UUID, accessTokenValue, refreshTokenValue string, accessTokenExpiresAt, refreshTokenExpiresAt time.Time, scope []string) error {
-	query := "INSERT Token { account := <Account>$0, variant := <str>$1, scope := <array<str>>$2, value := <str>$3, revoked := <bool>$4, expires_at := <datetime>$5 }; INSERT Token { account := <Account>$6, variant := <str>$7, scope := <array<str>>$8, value := <str>$9, revoked := <bool>$10, expires_at := <datetime>$11 }"
-	return edb.client.Execute(edb.context, query, accountId, "access_token", scope, accessTokenValue, false, accessTokenExpiresAt, accountId, "refresh_token", scope, refreshTokenValue, false, refreshTokenExpiresAt)
+func (edb *EdgeDBQueries) AddNewTokenPair(accountId edgedb.UUID, applicationId edgedb.OptionalUUID, accessTokenValue, refreshTokenValue string, accessTokenExpiresAt, refreshTokenExpiresAt time.Time, scope []string, authentication datatypes.Authentication) error {
+	authTime, acr, amr := authenticationParams(authentication)
+	query := "INSERT Token { account := <Account>$0, variant := <str>$1, scope := <array<str>>$2, value := <str>$3, revoked := <bool>$4, expires_at := <datetime>$5, application := <OAuthApplication><optional uuid>$12, auth_time := <optional datetime>$13, acr := <optional str>$14, amr := <array<str>>$15 }; INSERT Token { account := <Account>$6, variant := <str>$7, scope := <array<str>>$8, value := <str>$9, revoked := <bool>$10, expires_at := <datetime>$11, application := <OAuthApplication><optional uuid>$12, auth_time := <optional datetime>$13, acr := <optional str>$14, amr := <array<str>>$15 }"
+	return edb.client.Execute(edb.context, query, accountId, "access_token", scope, accessTokenValue, false, accessTokenExpiresAt, accountId, "refresh_token", scope, refreshTokenValue, false, refreshTokenExpiresAt, applicationId, authTime, acr, amr)
 }
 
 func (edb *EdgeDBQueries) GetToken(tokenValue string) (datatypes.Token, error) {
 	var token datatypes.Token
-	query := "SELECT Token { value, scope, revoked, variant, expires_at, account: { id, username, otp_secret, otp_state } } filter .value = <str>$0 LIMIT 1"
+	query := "SELECT Token { value, scope, revoked, variant, expires_at, auth_time, acr, amr, application_id := .application.id, account: { id, username, status, otp_state, organization: { id }, " + accountRolesShape + " } } filter .value = <str>$0 LIMIT 1"
 	return token, edb.client.QuerySingle(edb.context, query, &token, tokenValue)
 }
 
 func (edb *EdgeDBQueries) ResetOTP(accountId edgedb.UUID) error {
-	query := "UPDATE Account filter .id = <uuid>$0 set { otp_secret := <str>{}, otp_state := <str>'disabled' }"
+	query := `
+		UPDATE Account filter .id = <uuid>$0 set {
+			otp_secret := <str>{},
+			otp_state := <str>'disabled',
+			otp_algorithm := {},
+			otp_digits := {},
+			otp_period := {},
+			otp_last_step := {},
+			otp_failed_attempts := 0,
+			otp_last_failed_attempt := {},
+		};
+		DELETE RecoveryCode filter .account.id = <uuid>$0;
+	`
 	return edb.client.Execute(edb.context, query, accountId)
 }
 
-func (edb *EdgeDBQueries) SetOTPSecret(accountId edgedb.UUID, otpSecret string) error {
-	query := "UPDATE Account filter .id = <uuid>$0 set { otp_secret := <str>$1, otp_state := <str>$2 }"
-	return edb.client.Execute(edb.context, query, accountId, otpSecret, "verifying")
+// SetOTPSecret starts a new enrollment with the secret and the settings the authenticator app generates codes with.
+func (edb *EdgeDBQueries) SetOTPSecret(accountId edgedb.UUID, otpSecret, algorithm string, digits, period int16) error {
+	query := `UPDATE Account filter .id = <uuid>$0 set {
+		otp_secret := <str>$1,
+		otp_state := <str>$2,
+		otp_algorithm := <str>$3,
+		otp_digits := <int16>$4,
+		otp_period := <int16>$5,
+		otp_last_step := {},
+		otp_failed_attempts := 0,
+		otp_last_failed_attempt := {},
+	}`
+	return edb.client.Execute(edb.context, query, accountId, otpSecret, "verifying", algorithm, digits, period)
+}
+
+// GetOTPSecrets selects the id and stored otp secret of every account which has one.
+func (edb *EdgeDBQueries) GetOTPSecrets() ([]datatypes.Account, error) {
+	var accounts []datatypes.Account
+	query := "SELECT Account { id, otp_secret } filter exists .otp_secret"
+	return accounts, edb.client.Query(edb.context, query, &accounts)
+}
+
+// CountEncryptedSecrets counts the stored otp and identity provider client secrets which are encrypted with a master key.
+func (edb *EdgeDBQueries) CountEncryptedSecrets() (int64, error) {
+	var count int64
+	query := `
+		SELECT count(Account filter .otp_secret like 'enc:v1:%')
+			+ count(IdentityProvider filter .client_secret like 'enc:v1:%')`
+	return count, edb.client.QuerySingle(edb.context, query, &count)
+}
+
+// ReplaceOTPSecret replaces the stored otp secret of the account if it still is the previous one.
+func (edb *EdgeDBQueries) ReplaceOTPSecret(accountId edgedb.UUID, previousSecret, otpSecret string) (bool, error) {
+	var result []edgedb.UUID
+	query := "SELECT (UPDATE Account filter .id = <uuid>$0 and .otp_secret = <str>$1 set { otp_secret := <str>$2 }).id"
+	if err := edb.client.Query(edb.context, query, &result, accountId, previousSecret, otpSecret); err != nil {
+		return false, err
+	}
+	return len(result) > 0, nil
+}
+
+// ClaimOTPStep records the time-step of an accepted code. It reports false if a code of this
+// or a later step was already accepted, so every code can only be used once.
+func (edb *EdgeDBQueries) ClaimOTPStep(accountId edgedb.UUID, step int64) (bool, error) {
+	var result []edgedb.UUID
+	query := "SELECT (UPDATE Account filter .id = <uuid>$0 and (not exists .otp_last_step or .otp_last_step < <int64>$1) set { otp_last_step := <int64>$1 }).id"
+	if err := edb.client.Query(edb.context, query, &result, accountId, step); err != nil {
+		return false, err
+	}
+	return len(result) > 0, nil
+}
+
+func (edb *EdgeDBQueries) IncrementFailedOTPAttempts(accountId edgedb.UUID) (datatypes.Account, error) {
+	var account datatypes.Account
+	query := "SELECT (UPDATE Account filter .id = <uuid>$0 set { otp_failed_attempts := .otp_failed_attempts + 1, otp_last_failed_attempt := datetime_current() }) { id, otp_failed_attempts, otp_last_failed_attempt }"
+	return account, edb.client.QuerySingle(edb.context, query, &account, accountId)
+}
+
+func (edb *EdgeDBQueries) ResetFailedOTPAttempts(accountId edgedb.UUID) error {
+	query := "UPDATE Account filter .id = <uuid>$0 set { otp_failed_attempts := 0, otp_last_failed_attempt := {} }"
+	return edb.client.Execute(edb.context, query, accountId)
 }
 
 func (edb *EdgeDBQueries) SetOTPState(accountId edgedb.UUID, otpState string) error {
@@ -148,6 +388,11 @@ func (edb *EdgeDBQueries) CreateNewOAuthClientApplication(oauthClient datatypes.
 			client_privacy_url := <str>$12,
 			client_registration_date := <datetime>$13,
 			client_status := <str>$14,
+			organization := <Organization>$19,
+			post_logout_redirect_uris := <array<str>>$15,
+			frontchannel_logout_uri := <str>$16,
+			backchannel_logout_uri := <str>$17,
+			first_party := <bool>$18,
 		}
 	`
 
@@ -167,30 +412,73 @@ func (edb *EdgeDBQueries) CreateNewOAuthClientApplication(oauthClient datatypes.
 		oauthClient.ClientPrivacyUrl,
 		oauthClient.ClientRegistrationDate,
 		oauthClient.ClientStatus,
+		oauthClient.PostLogoutRedirectURIs,
+		oauthClient.FrontchannelLogoutURI,
+		oauthClient.BackchannelLogoutURI,
+		oauthClient.FirstParty,
+		oauthClient.Organization.Id,
 	)
 }
 
-func (edb *EdgeDBQueries) UpdateOAuth2ClientApplicationKeyValue(updateRequestData datatypes.UpdateOAuth2ClientKeyValueRequest) error {
+// ownedOAuthApplicationFilter selects the application with the client id $0 of the organization $1 owned by the account $2.
+const ownedOAuthApplicationFilter = "filter .client_id = <str>$0 and .organization.id = <uuid>$1 and .client_owner.id = <uuid>$2"
+
+// logoutURIUpdateQueries update the logout uris of an application, with the value passed as parameter.
+var logoutURIUpdateQueries = map[string]string{
+	"post_logout_redirect_uris": "SELECT (UPDATE OAuthApplication " + ownedOAuthApplicationFilter + " set { post_logout_redirect_uris := <array<str>>$3 }).id",
+	"frontchannel_logout_uri":   "SELECT (UPDATE OAuthApplication " + ownedOAuthApplicationFilter + " set { frontchannel_logout_uri := <str>$3 }).id",
+	"backchannel_logout_uri":    "SELECT (UPDATE OAuthApplication " + ownedOAuthApplicationFilter + " set { backchannel_logout_uri := <str>$3 }).id",
+}
+
+// UpdateOAuth2ClientApplicationKeyValue updates the key of the application of the organization owned by the account.
+// It reports false if there is no such application.
+func (edb *EdgeDBQueries) UpdateOAuth2ClientApplicationKeyValue(organizationId, ownerId edgedb.UUID, updateRequestData datatypes.UpdateOAuth2ClientKeyValueRequest) (bool, error) {
+	var result []edgedb.UUID
+	if query, isLogoutURI := logoutURIUpdateQueries[updateRequestData.Key]; isLogoutURI {
+		var value interface{} = strings.TrimSpace(updateRequestData.Value)
+		if updateRequestData.Key == "post_logout_redirect_uris" {
+			value = updateRequestData.ListValue()
+		}
+		if err := edb.client.Query(edb.context, query, &result, updateRequestData.ClientID, organizationId, ownerId, value); err != nil {
+			return false, err
+		}
+		return len(result) > 0, nil
+	}
+
 	keyType, err := updateRequestData.GetKeyType()
 	if err != nil {
-		return responses.BadRequestResponse()
+		return false, responses.BadRequestResponse()
+	}
+	query := "SELECT (UPDATE OAuthApplication " + ownedOAuthApplicationFilter + " set { " + updateRequestData.Key + " := " + keyType + "'" + updateRequestData.Value + "' }).id"
+	if err = edb.client.Query(edb.context, query, &result, updateRequestData.ClientID, organizationId, ownerId); err != nil {
+		return false, err
 	}
-	query := "UPDATE OAuthApplication filter .client_id = <str>$0 set { " + updateRequestData.Key + " := " + keyType + "'" + updateRequestData.Value + "' }"
-	return edb.client.Execute(edb.context, query, updateRequestData.ClientID)
+	return len(result) > 0, nil
 }
 
-func (edb *EdgeDBQueries) DeleteOAuth2ClientApplication(clientId string) error {
-	query := "DELETE OAuthApplication filter .client_id = <str>$0"
-	return edb.client.Execute(edb.context, query, clientId)
+// DeleteOAuth2ClientApplication deletes the application of the organization owned by the account.
+// It reports false if there is no such application.
+func (edb *EdgeDBQueries) DeleteOAuth2ClientApplication(organizationId, ownerId edgedb.UUID, clientId string) (bool, error) {
+	var result []edgedb.UUID
+	query := "SELECT (DELETE OAuthApplication " + ownedOAuthApplicationFilter + ").id"
+	if err := edb.client.Query(edb.context, query, &result, clientId, organizationId, ownerId); err != nil {
+		return false, err
+	}
+	return len(result) > 0, nil
 }
 
 func (edb *EdgeDBQueries) GetOAuth2ClientApplication(clientID string) (datatypes.OAuthClient, error) {
 	var oauthClient datatypes.OAuthClient
 	query := `SELECT OAuthApplication {
+	id,
 	client_id,
 	client_secret,
 	client_name,
 	client_type,
+	first_party,
+	organization: {
+		id
+	},
 	redirect_uris,
 	grant_types,
 	scope,
@@ -203,7 +491,10 @@ func (edb *EdgeDBQueries) GetOAuth2ClientApplication(clientID string) (datatypes
 	client_tos_url,
 	client_privacy_url,
 	client_registration_date,
-	client_status } filter .client_id = <str>$0 LIMIT 1`
+	client_status,
+	post_logout_redirect_uris,
+	frontchannel_logout_uri,
+	backchannel_logout_uri } filter .client_id = <str>$0 LIMIT 1`
 	return oauthClient, edb.client.QuerySingle(edb.context, query, &oauthClient, clientID)
 }
 
@@ -218,8 +509,15 @@ func (edb *EdgeDBQueries) CreateNewOAuth2AuthorizationCode(authorizationCode dat
 			expires_at := <datetime>$5,
 			consented := <bool>$6,
 			redirect_uri := <str>$7,
+			state := <str>$8,
+			acr_values := <array<str>>$9,
+			max_age := <optional int64>$10,
 		}
 	`
+	acrValues := authorizationCode.ACRValues
+	if acrValues == nil {
+		acrValues = []string{}
+	}
 	return edb.client.Execute(edb.context, query,
 		authorizationCode.Code,
 		authorizationCode.Application.ID,
@@ -229,6 +527,9 @@ func (edb *EdgeDBQueries) CreateNewOAuth2AuthorizationCode(authorizationCode dat
 		authorizationCode.ExpiresAt,
 		authorizationCode.Consented,
 		authorizationCode.RedirectURI,
+		authorizationCode.State,
+		acrValues,
+		authorizationCode.MaxAge,
 	)
 }
 
@@ -245,6 +546,10 @@ func (edb *EdgeDBQueries) GetOAuth2ClientApplicationAndUserAccount(clientID stri
 		client_secret,
 		client_name,
 		client_type,
+		first_party,
+		organization: {
+			id
+		},
 		redirect_uris,
 		grant_types,
 		scope,
@@ -255,7 +560,7 @@ func (edb *EdgeDBQueries) GetOAuth2ClientApplicationAndUserAccount(clientID stri
 		client_privacy_url,
 		client_registration_date,
 		client_status } filter .client_id = <str>$0 LIMIT 1),
-		account := (SELECT Account filter .id = <uuid>$1 LIMIT 1)
+		account := (SELECT Account { id, status, organization: { id } } filter .id = <uuid>$1 LIMIT 1)
 		)`
 
 	return result.OAuthClient, result.Account, edb.client.QuerySingle(
@@ -277,6 +582,10 @@ func (edb *EdgeDBQueries) GetOAuth2AuthorizationCode(code string) (datatypes.OAu
 		client_secret,
 		client_name,
 		client_type,
+		first_party,
+		organization: {
+			id
+		},
 		redirect_uris,
 		grant_types,
 		scope,
@@ -292,14 +601,24 @@ func (edb *EdgeDBQueries) GetOAuth2AuthorizationCode(code string) (datatypes.OAu
 		id,
 		username,
 		email,
-		otp_secret,
-		otp_state
+		status,
+		otp_state,
+		organization: {
+			id
+		},
+		` + accountRolesShape + `
 	},
 	requested_scope,
 	granted_scope,
 	expires_at,
 	consented,
-	redirect_uri
+	redirect_uri,
+	state,
+	acr_values,
+	max_age,
+	auth_time,
+	acr,
+	amr
 	} filter .code = <str>$0 LIMIT 1`
 	return authorizationCode, edb.client.QuerySingle(edb.context, query, &authorizationCode, code)
 }
@@ -318,8 +637,17 @@ func (edb *EdgeDBQueries) GetRefreshToken(value string) (datatypes.Token, error)
 		expires_at,
 		revoked,
 		variant,
+		auth_time,
+		acr,
+		amr,
+		application_id := .application.id,
 		account: {
-			id
+			id,
+			status,
+			organization: {
+				id
+			},
+			` + accountRolesShape + `
 		}} filter .value = <str>$0 LIMIT 1`
 	return refreshToken, edb.client.QuerySingle(edb.context, query, &refreshToken, value)
 }
@@ -338,3 +666,861 @@ func (edb *EdgeDBQueries) DeleteTokensByValue(tokens []string) error {
 	query := "DELETE Token filter .value IN array_unpack(<array<str>>$0)"
 	return edb.client.Execute(edb.context, query, tokens)
 }
+
+func (edb *EdgeDBQueries) GetActiveOAuth2ApplicationsForAccount(accountId edgedb.UUID) ([]datatypes.OAuthClient, error) {
+	var oauthClients []datatypes.OAuthClient
+	query := `SELECT (
+		SELECT Token filter .account.id = <uuid>$0 and .revoked = false and .expires_at > datetime_current()
+	).application {
+		id,
+		client_id,
+		client_secret,
+		client_name,
+		organization: {
+			id
+		},
+		frontchannel_logout_uri,
+		backchannel_logout_uri
+	}`
+	return oauthClients, edb.client.Query(edb.context, query, &oauthClients, accountId)
+}
+
+func (edb *EdgeDBQueries) DeleteAccountTokens(accountId edgedb.UUID) error {
+	query := "DELETE Token filter .account.id = <uuid>$0"
+	return edb.client.Execute(edb.context, query, accountId)
+}
+
+// ConsentToOAuth2AuthorizationCode grants the scope and records the authentication of the consenting session for the tokens issued for the code.
+func (edb *EdgeDBQueries) ConsentToOAuth2AuthorizationCode(code string, grantedScope []string, authentication datatypes.Authentication) error {
+	authTime, acr, amr := authenticationParams(authentication)
+	query := "UPDATE Authcode filter .code = <str>$0 set { consented := true, granted_scope := <array<str>>$1, auth_time := <optional datetime>$2, acr := <optional str>$3, amr := <array<str>>$4 }"
+	return edb.client.Execute(edb.context, query, code, grantedScope, authTime, acr, amr)
+}
+
+func (edb *EdgeDBQueries) GetOAuth2Consent(accountId, applicationId edgedb.UUID) (datatypes.Consent, error) {
+	var consent datatypes.Consent
+	query := "SELECT Consent { id, granted_scope, created_at, updated_at } filter .account.id = <uuid>$0 and .application.id = <uuid>$1 LIMIT 1"
+	return consent, edb.client.QuerySingle(edb.context, query, &consent, accountId, applicationId)
+}
+
+func (edb *EdgeDBQueries) SaveOAuth2Consent(accountId, applicationId edgedb.UUID, grantedScope []string) error {
+	query := `
+		INSERT Consent {
+			account := <Account>$0,
+			application := <OAuthApplication>$1,
+			granted_scope := <array<str>>$2,
+		}
+		unless conflict on (.account, .application)
+		else (
+			UPDATE Consent set {
+				granted_scope := array_agg(DISTINCT (array_unpack(.granted_scope) union array_unpack(<array<str>>$2))),
+				updated_at := datetime_current(),
+			}
+		)
+	`
+	return edb.client.Execute(edb.context, query, accountId, applicationId, grantedScope)
+}
+
+func (edb *EdgeDBQueries) GetAccountOAuth2Consents(accountId edgedb.UUID) ([]datatypes.Consent, error) {
+	var consents []datatypes.Consent
+	query := `SELECT Consent {
+		id,
+		granted_scope,
+		created_at,
+		updated_at,
+		application: {
+			client_id,
+			client_name,
+			client_description,
+			client_homepage_url,
+			client_logo_url,
+			client_tos_url,
+			client_privacy_url
+		}
+	} filter .account.id = <uuid>$0 order by .created_at`
+	return consents, edb.client.Query(edb.context, query, &consents, accountId)
+}
+
+func (edb *EdgeDBQueries) RevokeOAuth2Consent(accountId edgedb.UUID, clientID string) error {
+	return edb.client.Tx(edb.context, func(ctx context.Context, tx *edgedb.Tx) error {
+		queries := []string{
+			"DELETE Consent filter .account.id = <uuid>$0 and .application.client_id = <str>$1",
+			"DELETE Authcode filter .account.id = <uuid>$0 and .application.client_id = <str>$1",
+			"DELETE Token filter .account.id = <uuid>$0 and .application.client_id = <str>$1",
+		}
+		for _, query := range queries {
+			if err := tx.Execute(ctx, query, accountId, clientID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (edb *EdgeDBQueries) GetScopes() ([]datatypes.Scope, error) {
+	var scopes []datatypes.Scope
+	query := "SELECT Scope { id, name, description, parent_name := .parent.name, first_party_only, requires_admin } order by .name"
+	return scopes, edb.client.Query(edb.context, query, &scopes)
+}
+
+func (edb *EdgeDBQueries) CreateScope(scope datatypes.Scope) error {
+	query := `
+		INSERT Scope {
+			name := <str>$0,
+			description := <str>$1,
+			parent := (SELECT Scope filter .name = <optional str>$2 LIMIT 1),
+			first_party_only := <bool>$3,
+			requires_admin := <bool>$4,
+		}
+	`
+	return edb.client.Execute(edb.context, query, scope.Name, scope.Description, scope.ParentName, scope.FirstPartyOnly, scope.RequiresAdmin)
+}
+
+func (edb *EdgeDBQueries) CreateScopes(scopes []datatypes.Scope) error {
+	return edb.client.Tx(edb.context, func(ctx context.Context, tx *edgedb.Tx) error {
+		insertQuery := "INSERT Scope { name := <str>$0, description := <str>$1, first_party_only := <bool>$2, requires_admin := <bool>$3 } unless conflict on .name"
+		parentQuery := "UPDATE Scope filter .name = <str>$0 set { parent := (SELECT DETACHED Scope filter .name = <str>$1 LIMIT 1) }"
+		for _, scope := range scopes {
+			if err := tx.Execute(ctx, insertQuery, scope.Name, scope.Description, scope.FirstPartyOnly, scope.RequiresAdmin); err != nil {
+				return err
+			}
+		}
+		for _, scope := range scopes {
+			if parent, isSet := scope.ParentName.Get(); isSet {
+				if err := tx.Execute(ctx, parentQuery, scope.Name, parent); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (edb *EdgeDBQueries) UpdateScope(scope datatypes.Scope) error {
+	query := `
+		UPDATE Scope filter .name = <str>$0 set {
+			description := <str>$1,
+			parent := (SELECT DETACHED Scope filter .name = <optional str>$2 LIMIT 1),
+			first_party_only := <bool>$3,
+			requires_admin := <bool>$4,
+		}
+	`
+	return edb.client.Execute(edb.context, query, scope.Name, scope.Description, scope.ParentName, scope.FirstPartyOnly, scope.RequiresAdmin)
+}
+
+func (edb *EdgeDBQueries) DeleteScope(name string) error {
+	query := "DELETE Scope filter .name = <str>$0"
+	return edb.client.Execute(edb.context, query, name)
+}
+
+func (edb *EdgeDBQueries) GetRoles() ([]datatypes.Role, error) {
+	var roles []datatypes.Role
+	query := "SELECT Role { id, name, description, permission_names := .permissions.name } order by .name"
+	return roles, edb.client.Query(edb.context, query, &roles)
+}
+
+func (edb *EdgeDBQueries) CreateRole(role datatypes.Role) error {
+	query := `
+		INSERT Role {
+			name := <str>$0,
+			description := <optional str>$1,
+			permissions := (SELECT Scope filter .name IN array_unpack(<array<str>>$2)),
+		}
+	`
+	return edb.client.Execute(edb.context, query, role.Name, role.Description, role.Permissions)
+}
+
+func (edb *EdgeDBQueries) CreateRoles(roles []datatypes.Role) error {
+	return edb.client.Tx(edb.context, func(ctx context.Context, tx *edgedb.Tx) error {
+		query := `
+			INSERT Role {
+				name := <str>$0,
+				description := <optional str>$1,
+				permissions := (SELECT Scope filter .name IN array_unpack(<array<str>>$2)),
+			} unless conflict on .name
+		`
+		for _, role := range roles {
+			if err := tx.Execute(ctx, query, role.Name, role.Description, role.Permissions); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (edb *EdgeDBQueries) UpdateRole(role datatypes.Role) error {
+	query := `
+		UPDATE Role filter .name = <str>$0 set {
+			description := <optional str>$1,
+			permissions := (SELECT Scope filter .name IN array_unpack(<array<str>>$2)),
+		}
+	`
+	return edb.client.Execute(edb.context, query, role.Name, role.Description, role.Permissions)
+}
+
+func (edb *EdgeDBQueries) DeleteRole(name string) error {
+	query := "DELETE Role filter .name = <str>$0"
+	return edb.client.Execute(edb.context, query, name)
+}
+
+func (edb *EdgeDBQueries) AssignRole(organizationId, accountId edgedb.UUID, roleName string) (bool, error) {
+	var result []edgedb.UUID
+	query := "SELECT (UPDATE Account filter .id = <uuid>$0 and .organization.id = <uuid>$2 set { roles += (SELECT Role filter .name = <str>$1) }).id"
+	if err := edb.client.Query(edb.context, query, &result, accountId, roleName, organizationId); err != nil {
+		return false, err
+	}
+	return len(result) > 0, nil
+}
+
+func (edb *EdgeDBQueries) AssignRoleByEmail(organizationId edgedb.UUID, email, roleName string) error {
+	query := "UPDATE Account filter .email = <str>$0 and .organization.id = <uuid>$2 set { roles += (SELECT Role filter .name = <str>$1) }"
+	return edb.client.Execute(edb.context, query, email, roleName, organizationId)
+}
+
+func (edb *EdgeDBQueries) UnassignRole(organizationId, accountId edgedb.UUID, roleName string) (bool, error) {
+	var result []edgedb.UUID
+	query := "SELECT (UPDATE Account filter .id = <uuid>$0 and .organization.id = <uuid>$2 set { roles -= (SELECT Role filter .name = <str>$1) }).id"
+	if err := edb.client.Query(edb.context, query, &result, accountId, roleName, organizationId); err != nil {
+		return false, err
+	}
+	return len(result) > 0, nil
+}
+
+func (edb *EdgeDBQueries) AssignRoleToAccountsWithoutRoles(roleName string) error {
+	query := "UPDATE Account filter not exists .roles set { roles := (SELECT Role filter .name = <str>$0) }"
+	return edb.client.Execute(edb.context, query, roleName)
+}
+
+func (edb *EdgeDBQueries) GetOrganizations() ([]datatypes.Organization, error) {
+	var organizations []datatypes.Organization
+	query := "SELECT Organization { id, slug, name, domains, issuer, signing_key, created_at } ORDER BY .slug"
+	return organizations, edb.client.Query(edb.context, query, &organizations)
+}
+
+func (edb *EdgeDBQueries) CreateOrganization(organization datatypes.Organization) error {
+	query := "INSERT Organization { slug := <str>$0, name := <str>$1, domains := <array<str>>$2, issuer := <optional str>$3, signing_key := <optional str>$4 }"
+	return edb.client.Execute(edb.context, query, organization.Slug, organization.Name, organization.Domains, organization.Issuer, organization.SigningKey)
+}
+
+func (edb *EdgeDBQueries) UpdateOrganization(organization datatypes.Organization) error {
+	var result []edgedb.UUID
+	query := "SELECT (UPDATE Organization filter .slug = <str>$0 set { name := <str>$1, domains := <array<str>>$2, issuer := <optional str>$3 }).id"
+	if err := edb.client.Query(edb.context, query, &result, organization.Slug, organization.Name, organization.Domains, organization.Issuer); err != nil {
+		return err
+	}
+	if len(result) < 1 {
+		return errors.New("organization not found")
+	}
+	return nil
+}
+
+func (edb *EdgeDBQueries) DeleteOrganization(slug string) error {
+	query := "DELETE Organization filter .slug = <str>$0"
+	return edb.client.Execute(edb.context, query, slug)
+}
+
+func (edb *EdgeDBQueries) SuspendAccount(organizationId, accountId edgedb.UUID, reason string) (bool, error) {
+	var result []edgedb.UUID
+	err := edb.client.Tx(edb.context, func(ctx context.Context, tx *edgedb.Tx) error {
+		query := "SELECT (UPDATE Account filter .id = <uuid>$0 and .organization.id = <uuid>$1 and .status != 'deleted' set { status := 'suspended', status_description := <str>$2, status_changed := datetime_current() }).id"
+		if err := tx.Query(ctx, query, &result, accountId, organizationId, reason); err != nil || len(result) < 1 {
+			return err
+		}
+		query = "DELETE Token filter .account.id = <uuid>$0; DELETE Authcode filter .account.id = <uuid>$0"
+		return tx.Execute(ctx, query, accountId)
+	})
+	return len(result) > 0, err
+}
+
+func (edb *EdgeDBQueries) ReactivateAccount(organizationId, accountId edgedb.UUID) (bool, error) {
+	var result []edgedb.UUID
+	query := "SELECT (UPDATE Account filter .id = <uuid>$0 and .organization.id = <uuid>$1 and .status = 'suspended' set { status := 'active', status_description := {}, status_changed := datetime_current() }).id"
+	if err := edb.client.Query(edb.context, query, &result, accountId, organizationId); err != nil {
+		return false, err
+	}
+	return len(result) > 0, nil
+}
+
+func (edb *EdgeDBQueries) ScheduleAccountDeletion(accountId edgedb.UUID, scheduledAt time.Time) error {
+	return edb.client.Tx(edb.context, func(ctx context.Context, tx *edgedb.Tx) error {
+		query := "UPDATE Account filter .id = <uuid>$0 set { status := 'deleted', deletion_scheduled_at := <datetime>$1, status_changed := datetime_current() }"
+		if err := tx.Execute(ctx, query, accountId, scheduledAt); err != nil {
+			return err
+		}
+		query = "DELETE Token filter .account.id = <uuid>$0; DELETE Authcode filter .account.id = <uuid>$0"
+		return tx.Execute(ctx, query, accountId)
+	})
+}
+
+func (edb *EdgeDBQueries) CancelAccountDeletion(accountId edgedb.UUID) error {
+	query := "UPDATE Account filter .id = <uuid>$0 and .status = 'deleted' and not exists .erased_at set { status := 'active', deletion_scheduled_at := {}, status_changed := datetime_current() }"
+	return edb.client.Execute(edb.context, query, accountId)
+}
+
+func (edb *EdgeDBQueries) GetAccountsDueForErasure(before time.Time) ([]datatypes.Account, error) {
+	var accounts []datatypes.Account
+	query := "SELECT Account { id, organization: { id } } filter .status = 'deleted' and .deletion_scheduled_at < <datetime>$0 and not exists .erased_at"
+	return accounts, edb.client.Query(edb.context, query, &accounts, before)
+}
+
+// EraseAccount removes every credential and personal detail of the account. The account row itself is kept
+// with pseudonymized values, as OAuth2 applications and audit events may still reference it.
+func (edb *EdgeDBQueries) EraseAccount(accountId edgedb.UUID, pseudonym string) error {
+	return edb.client.Tx(edb.context, func(ctx context.Context, tx *edgedb.Tx) error {
+		query := `
+			DELETE Token filter .account.id = <uuid>$0;
+			DELETE Authcode filter .account.id = <uuid>$0;
+			DELETE Consent filter .account.id = <uuid>$0;
+			DELETE PasswordResetToken filter .account.id = <uuid>$0;
+			DELETE WebAuthnCredential filter .account.id = <uuid>$0;
+			DELETE RecoveryCode filter .account.id = <uuid>$0;
+			DELETE WebAuthnSession filter .account.id = <uuid>$0;
+			DELETE OTPChallenge filter .account.id = <uuid>$0;
+			DELETE LoginTransaction filter .account.id = <uuid>$0;
+			DELETE MagicLink filter .account.id = <uuid>$0;
+			DELETE FederationState filter .link_account.id = <uuid>$0;
+			DELETE FederatedIdentity filter .account.id = <uuid>$0;
+			DELETE OTPFactor filter .account.id = <uuid>$0;
+			DELETE Password filter .account.id = <uuid>$0;
+		`
+		if err := tx.Execute(ctx, query, accountId); err != nil {
+			return err
+		}
+		query = `UPDATE Account filter .id = <uuid>$0 set {
+			username := 'erased-' ++ <str>$1,
+			email := <str>$1 ++ '@erased.invalid',
+			pending_email := {},
+			avatar_uri := {},
+			status_description := {},
+			otp_secret := {},
+			otp_state := 'disabled',
+			roles := {},
+			verification_sent_at := {},
+			erased_at := datetime_current(),
+		}`
+		return tx.Execute(ctx, query, accountId, pseudonym)
+	})
+}
+
+func (edb *EdgeDBQueries) RecordAuditEvent(event datatypes.AuditEvent) error {
+	query := "INSERT AuditEvent { organization := <Organization><optional uuid>$0, action := <str>$1, subject := <str>$2, actor := <optional str>$3, description := <optional str>$4 }"
+	return edb.client.Execute(edb.context, query, event.OrganizationID, event.Action, event.Subject, event.Actor, event.Description)
+}
+
+// GetLoginAccount selects the account in the shape the login handlers issue tokens for.
+func (edb *EdgeDBQueries) GetLoginAccount(organizationId, accountId edgedb.UUID) (datatypes.Account, error) {
+	var account datatypes.Account
+	query := "SELECT Account { id, username, email, status, deletion_scheduled_at, " + accountOTPShape + ", organization: { id }, " + accountRolesShape + " } filter .id = <uuid>$0 and .organization.id = <uuid>$1 LIMIT 1"
+	return account, edb.client.QuerySingle(edb.context, query, &account, accountId, organizationId)
+}
+
+const webAuthnCredentialShape = "id, credential_id, public_key, attestation_type, aaguid, sign_count, transports, backup_eligible, backup_state, name, created_at, last_used_at"
+
+func (edb *EdgeDBQueries) GetWebAuthnCredentials(accountId edgedb.UUID) ([]datatypes.WebAuthnCredential, error) {
+	var credentials []datatypes.WebAuthnCredential
+	query := "SELECT WebAuthnCredential { " + webAuthnCredentialShape + " } filter .account.id = <uuid>$0 order by .created_at"
+	return credentials, edb.client.Query(edb.context, query, &credentials, accountId)
+}
+
+func (edb *EdgeDBQueries) CreateWebAuthnCredential(accountId edgedb.UUID, credential datatypes.WebAuthnCredential) (edgedb.UUID, error) {
+	var result struct {
+		Id edgedb.UUID `edgedb:"id"`
+	}
+	query := `INSERT WebAuthnCredential {
+		account := <Account><uuid>$0,
+		credential_id := <bytes>$1,
+		public_key := <bytes>$2,
+		attestation_type := <str>$3,
+		aaguid := <bytes>$4,
+		sign_count := <int64>$5,
+		transports := <array<str>>$6,
+		backup_eligible := <bool>$7,
+		backup_state := <bool>$8,
+		name := <optional str>$9,
+	}`
+	err := edb.client.QuerySingle(edb.context, query, &result, accountId, credential.CredentialID, credential.PublicKey, credential.AttestationType,
+		credential.AAGUID, credential.SignCount, credential.Transports, credential.BackupEligible, credential.BackupState, credential.Name)
+	return result.Id, err
+}
+
+// UpdateWebAuthnCredentialUsage stores the sign counter and backup state reported by the latest assertion.
+func (edb *EdgeDBQueries) UpdateWebAuthnCredentialUsage(credentialId edgedb.UUID, signCount int64, backupState bool) error {
+	query := "UPDATE WebAuthnCredential filter .id = <uuid>$0 set { sign_count := <int64>$1, backup_state := <bool>$2, last_used_at := datetime_current() }"
+	return edb.client.Execute(edb.context, query, credentialId, signCount, backupState)
+}
+
+func (edb *EdgeDBQueries) DeleteWebAuthnCredential(accountId, credentialId edgedb.UUID) (bool, error) {
+	var result []edgedb.UUID
+	query := "SELECT (DELETE WebAuthnCredential filter .id = <uuid>$0 and .account.id = <uuid>$1).id"
+	if err := edb.client.Query(edb.context, query, &result, credentialId, accountId); err != nil {
+		return false, err
+	}
+	return len(result) > 0, nil
+}
+
+// CreateWebAuthnSession stores the state of a started ceremony and drops the expired ones.
+func (edb *EdgeDBQueries) CreateWebAuthnSession(organizationId edgedb.UUID, accountId edgedb.OptionalUUID, ceremony, challenge string, data []byte, expiresAt time.Time) error {
+	query := `
+		DELETE WebAuthnSession filter .expires_at < datetime_current();
+		INSERT WebAuthnSession {
+			organization := <Organization><uuid>$0,
+			account := <Account><optional uuid>$1,
+			ceremony := <str>$2,
+			challenge := <str>$3,
+			data := <json>$4,
+			expires_at := <datetime>$5,
+		};
+	`
+	return edb.client.Execute(edb.context, query, organizationId, accountId, ceremony, challenge, data, expiresAt)
+}
+
+// ConsumeWebAuthnSession deletes and returns the unexpired session of the ceremony, so every challenge can only be answered once.
+func (edb *EdgeDBQueries) ConsumeWebAuthnSession(organizationId edgedb.UUID, ceremony, challenge string) (datatypes.WebAuthnSession, error) {
+	var session datatypes.WebAuthnSession
+	query := `SELECT (
+		DELETE WebAuthnSession filter .challenge = <str>$0 and .ceremony = <str>$1 and .organization.id = <uuid>$2 and .expires_at > datetime_current()
+	) { id, account_id := .account.id, data } LIMIT 1`
+	return session, edb.client.QuerySingle(edb.context, query, &session, challenge, ceremony, organizationId)
+}
+
+// ReplaceRecoveryCodes invalidates every recovery code of the account and stores the digests of the new ones.
+func (edb *EdgeDBQueries) ReplaceRecoveryCodes(accountId edgedb.UUID, codeHashes []string) error {
+	query := `
+		DELETE RecoveryCode filter .account.id = <uuid>$0;
+		FOR code_hash IN array_unpack(<array<str>>$1) UNION (
+			INSERT RecoveryCode { account := <Account><uuid>$0, code_hash := code_hash }
+		);
+	`
+	return edb.client.Execute(edb.context, query, accountId, codeHashes)
+}
+
+// UseRecoveryCode marks the unused recovery code as used and reports whether it existed.
+func (edb *EdgeDBQueries) UseRecoveryCode(accountId edgedb.UUID, codeHash string) (bool, error) {
+	var result []edgedb.UUID
+	query := "SELECT (UPDATE RecoveryCode filter .account.id = <uuid>$0 and .code_hash = <str>$1 and not exists .used_at set { used_at := datetime_current() }).id"
+	if err := edb.client.Query(edb.context, query, &result, accountId, codeHash); err != nil {
+		return false, err
+	}
+	return len(result) > 0, nil
+}
+
+func (edb *EdgeDBQueries) CountRecoveryCodes(accountId edgedb.UUID) (int64, error) {
+	var count int64
+	query := "SELECT count(RecoveryCode filter .account.id = <uuid>$0 and not exists .used_at)"
+	return count, edb.client.QuerySingle(edb.context, query, &count, accountId)
+}
+
+func (edb *EdgeDBQueries) GetOTPFactors(accountId edgedb.UUID) ([]datatypes.OTPFactor, error) {
+	var factors []datatypes.OTPFactor
+	query := "SELECT OTPFactor { id, channel, phone_number, verified, created_at, last_used_at } filter .account.id = <uuid>$0 order by .channel"
+	return factors, edb.client.Query(edb.context, query, &factors, accountId)
+}
+
+// SaveOTPFactor starts the enrollment of the channel. An unverified enrollment of the same channel is replaced.
+func (edb *EdgeDBQueries) SaveOTPFactor(accountId edgedb.UUID, channel string, phoneNumber edgedb.OptionalStr) error {
+	query := `
+		INSERT OTPFactor {
+			account := <Account><uuid>$0,
+			channel := <str>$1,
+			phone_number := <optional str>$2,
+		} UNLESS CONFLICT ON ((.account, .channel)) ELSE (
+			UPDATE OTPFactor set {
+				phone_number := <optional str>$2,
+				verified := false,
+				created_at := datetime_current(),
+			}
+		)
+	`
+	return edb.client.Execute(edb.context, query, accountId, channel, phoneNumber)
+}
+
+func (edb *EdgeDBQueries) VerifyOTPFactor(accountId edgedb.UUID, channel string) error {
+	query := "UPDATE OTPFactor filter .account.id = <uuid>$0 and .channel = <str>$1 set { verified := true }"
+	return edb.client.Execute(edb.context, query, accountId, channel)
+}
+
+func (edb *EdgeDBQueries) UpdateOTPFactorUsage(accountId edgedb.UUID, channel string) error {
+	query := "UPDATE OTPFactor filter .account.id = <uuid>$0 and .channel = <str>$1 set { last_used_at := datetime_current() }"
+	return edb.client.Execute(edb.context, query, accountId, channel)
+}
+
+// DeleteOTPFactor removes the factor together with its pending codes and reports whether it existed.
+func (edb *EdgeDBQueries) DeleteOTPFactor(accountId edgedb.UUID, channel string) (bool, error) {
+	var result []edgedb.UUID
+	query := `
+		WITH
+			deleted := (DELETE OTPFactor filter .account.id = <uuid>$0 and .channel = <str>$1),
+			challenges := (DELETE OTPChallenge filter .account.id = <uuid>$0 and .channel = <str>$1)
+		SELECT deleted.id
+	`
+	if err := edb.client.Query(edb.context, query, &result, accountId, channel); err != nil {
+		return false, err
+	}
+	return len(result) > 0, nil
+}
+
+// CountOTPChallenges counts the codes sent to the account and to the destination since the given time.
+func (edb *EdgeDBQueries) CountOTPChallenges(accountId edgedb.UUID, destination string, since time.Time) (datatypes.OTPChallengeCount, error) {
+	var count datatypes.OTPChallengeCount
+	query := `SELECT {
+		account := count(OTPChallenge filter .account.id = <uuid>$0 and .created_at > <datetime>$2),
+		destination := count(OTPChallenge filter .destination = <str>$1 and .created_at > <datetime>$2),
+	}`
+	return count, edb.client.QuerySingle(edb.context, query, &count, accountId, destination, since)
+}
+
+// CreateOTPChallenge stores the digest of a delivered code. Pending codes of the same channel and purpose are superseded,
+// and codes which expired a day ago are dropped, so they no longer count towards the rate limit.
+func (edb *EdgeDBQueries) CreateOTPChallenge(accountId edgedb.UUID, channel, purpose, destination, codeHash string, expiresAt time.Time) error {
+	query := `
+		DELETE OTPChallenge filter .expires_at < datetime_current() - <duration>'24 hours';
+		UPDATE OTPChallenge filter .account.id = <uuid>$0 and .channel = <str>$1 and .purpose = <str>$2 and not exists .consumed_at set {
+			consumed_at := datetime_current()
+		};
+		INSERT OTPChallenge {
+			account := <Account><uuid>$0,
+			channel := <str>$1,
+			purpose := <str>$2,
+			destination := <str>$3,
+			code_hash := <str>$4,
+			expires_at := <datetime>$5,
+		};
+	`
+	return edb.client.Execute(edb.context, query, accountId, channel, purpose, destination, codeHash, expiresAt)
+}
+
+// GetOTPChallenge selects the pending unexpired code of the channel and purpose.
+func (edb *EdgeDBQueries) GetOTPChallenge(accountId edgedb.UUID, channel, purpose string) (datatypes.OTPChallenge, error) {
+	var challenge datatypes.OTPChallenge
+	query := `SELECT OTPChallenge { id, code_hash, attempts, expires_at }
+		filter .account.id = <uuid>$0 and .channel = <str>$1 and .purpose = <str>$2 and not exists .consumed_at and .expires_at > datetime_current()
+		order by .created_at desc LIMIT 1`
+	return challenge, edb.client.QuerySingle(edb.context, query, &challenge, accountId, channel, purpose)
+}
+
+// ReserveOTPChallengeAttempt counts a guess before the code is compared and reports false if the code already
+// had maxAttempts guesses or was used, so concurrent guesses can't exceed the limit.
+func (edb *EdgeDBQueries) ReserveOTPChallengeAttempt(challengeId edgedb.UUID, maxAttempts int16) (bool, error) {
+	var result []edgedb.UUID
+	query := "SELECT (UPDATE OTPChallenge filter .id = <uuid>$0 and .attempts < <int16>$1 and not exists .consumed_at set { attempts := .attempts + 1 }).id"
+	if err := edb.client.Query(edb.context, query, &result, challengeId, maxAttempts); err != nil {
+		return false, err
+	}
+	return len(result) > 0, nil
+}
+
+// ConsumeOTPChallenge marks the code as used and reports false if it already was.
+func (edb *EdgeDBQueries) ConsumeOTPChallenge(challengeId edgedb.UUID) (bool, error) {
+	var result []edgedb.UUID
+	query := "SELECT (UPDATE OTPChallenge filter .id = <uuid>$0 and not exists .consumed_at set { consumed_at := datetime_current() }).id"
+	if err := edb.client.Query(edb.context, query, &result, challengeId); err != nil {
+		return false, err
+	}
+	return len(result) > 0, nil
+}
+
+// CreateLoginTransaction stores a login waiting for its second factor and drops the expired ones.
+func (edb *EdgeDBQueries) CreateLoginTransaction(organizationId, accountId edgedb.UUID, tokenHash, firstFactor string, expiresAt time.Time) error {
+	query := `
+		DELETE LoginTransaction filter .expires_at < datetime_current();
+		INSERT LoginTransaction {
+			organization := <Organization><uuid>$0,
+			account := <Account><uuid>$1,
+			token_hash := <str>$2,
+			first_factor := <str>$3,
+			expires_at := <datetime>$4,
+		};
+	`
+	return edb.client.Execute(edb.context, query, organizationId, accountId, tokenHash, firstFactor, expiresAt)
+}
+
+func (edb *EdgeDBQueries) GetLoginTransaction(organizationId edgedb.UUID, tokenHash string) (datatypes.LoginTransaction, error) {
+	var transaction datatypes.LoginTransaction
+	query := "SELECT LoginTransaction { id, account_id := .account.id, first_factor, attempts, expires_at } filter .token_hash = <str>$0 and .organization.id = <uuid>$1 and .expires_at > datetime_current() LIMIT 1"
+	return transaction, edb.client.QuerySingle(edb.context, query, &transaction, tokenHash, organizationId)
+}
+
+// ReserveLoginTransactionAttempt counts a factor submission before it is verified and reports false if the
+// transaction already had maxAttempts submissions, so concurrent submissions can't exceed the limit.
+func (edb *EdgeDBQueries) ReserveLoginTransactionAttempt(transactionId edgedb.UUID, maxAttempts int16) (bool, error) {
+	var result []edgedb.UUID
+	query := "SELECT (UPDATE LoginTransaction filter .id = <uuid>$0 and .attempts < <int16>$1 set { attempts := .attempts + 1 }).id"
+	if err := edb.client.Query(edb.context, query, &result, transactionId, maxAttempts); err != nil {
+		return false, err
+	}
+	return len(result) > 0, nil
+}
+
+// DeleteLoginTransaction ends the transaction and reports false if it already ended, so every mfa_token completes one login at most.
+func (edb *EdgeDBQueries) DeleteLoginTransaction(transactionId edgedb.UUID) (bool, error) {
+	var result []edgedb.UUID
+	query := "SELECT (DELETE LoginTransaction filter .id = <uuid>$0).id"
+	if err := edb.client.Query(edb.context, query, &result, transactionId); err != nil {
+		return false, err
+	}
+	return len(result) > 0, nil
+}
+
+// CreateMagicLink stores a login link bound to the nonce of the requesting browser and drops the ones which expired a day ago.
+func (edb *EdgeDBQueries) CreateMagicLink(accountId edgedb.UUID, tokenHash, nonceHash, requestedFrom string, expiresAt time.Time) error {
+	query := `
+		DELETE MagicLink filter .expires_at < datetime_current() - <duration>'24 hours';
+		INSERT MagicLink {
+			account := <Account><uuid>$0,
+			token_hash := <str>$1,
+			nonce_hash := <str>$2,
+			requested_from := <str>$3,
+			expires_at := <datetime>$4,
+		};
+	`
+	return edb.client.Execute(edb.context, query, accountId, tokenHash, nonceHash, requestedFrom, expiresAt)
+}
+
+// CountMagicLinks counts the login links sent to the account and on requests from the address since the given time.
+func (edb *EdgeDBQueries) CountMagicLinks(accountId edgedb.UUID, requestedFrom string, since time.Time) (datatypes.RequestCount, error) {
+	var count datatypes.RequestCount
+	query := `SELECT {
+		account := count(MagicLink filter .account.id = <uuid>$0 and .created_at > <datetime>$2),
+		address := count(MagicLink filter .requested_from = <str>$1 and .created_at > <datetime>$2),
+	}`
+	return count, edb.client.QuerySingle(edb.context, query, &count, accountId, requestedFrom, since)
+}
+
+// UseMagicLink consumes the link if it is valid and was requested with the nonce, and returns the account it signs in.
+// Every outstanding link of the account is consumed, not only the one used.
+func (edb *EdgeDBQueries) UseMagicLink(organizationId edgedb.UUID, tokenHash, nonceHash string) (edgedb.UUID, error) {
+	var accountId edgedb.UUID
+	err := edb.client.Tx(edb.context, func(ctx context.Context, tx *edgedb.Tx) error {
+		query := "SELECT (SELECT MagicLink filter .token_hash = <str>$0 and .nonce_hash = <str>$1 and .account.organization.id = <uuid>$2 and not exists .used_at and .expires_at > datetime_current() LIMIT 1).account.id"
+		if err := tx.QuerySingle(ctx, query, &accountId, tokenHash, nonceHash, organizationId); err != nil {
+			return err
+		}
+		query = "UPDATE MagicLink filter .account.id = <uuid>$0 and not exists .used_at set { used_at := datetime_current() }"
+		return tx.Execute(ctx, query, accountId)
+	})
+	return accountId, err
+}
+
+const identityProviderShape = "id, name, display_name, kind, issuer, authorization_endpoint, token_endpoint, userinfo_endpoint, jwks_uri, client_id, client_secret, scope, saml_certificates, saml_binding, email_attribute, username_attribute, allow_signup, enabled, created_at, organization: { id, slug, name, domains, issuer }"
+
+func (edb *EdgeDBQueries) GetIdentityProviders(organizationId edgedb.UUID) ([]datatypes.IdentityProvider, error) {
+	var providers []datatypes.IdentityProvider
+	query := "SELECT IdentityProvider { " + identityProviderShape + " } filter .organization.id = <uuid>$0 order by .name"
+	return providers, edb.client.Query(edb.context, query, &providers, organizationId)
+}
+
+func (edb *EdgeDBQueries) GetIdentityProvider(organizationId edgedb.UUID, name string) (datatypes.IdentityProvider, error) {
+	var provider datatypes.IdentityProvider
+	query := "SELECT IdentityProvider { " + identityProviderShape + " } filter .organization.id = <uuid>$0 and .name = <str>$1 LIMIT 1"
+	return provider, edb.client.QuerySingle(edb.context, query, &provider, organizationId, name)
+}
+
+// GetIdentityProviderSecrets selects the stored client secret of the providers of every organization.
+func (edb *EdgeDBQueries) GetIdentityProviderSecrets() ([]datatypes.IdentityProvider, error) {
+	var providers []datatypes.IdentityProvider
+	query := "SELECT IdentityProvider { id, name, client_secret, organization: { id } }"
+	return providers, edb.client.Query(edb.context, query, &providers)
+}
+
+func (edb *EdgeDBQueries) CreateIdentityProvider(provider datatypes.IdentityProvider) error {
+	query := `
+		INSERT IdentityProvider {
+			organization := <Organization><uuid>$0,
+			name := <str>$1,
+			display_name := <str>$2,
+			kind := <str>$3,
+			issuer := <optional str>$4,
+			authorization_endpoint := <optional str>$5,
+			token_endpoint := <optional str>$6,
+			userinfo_endpoint := <optional str>$7,
+			jwks_uri := <optional str>$8,
+			client_id := <str>$9,
+			client_secret := <str>$10,
+			scope := <array<str>>$11,
+			allow_signup := <bool>$12,
+			enabled := <bool>$13,
+			saml_certificates := <array<str>>$14,
+			saml_binding := <str>$15,
+			email_attribute := <optional str>$16,
+			username_attribute := <optional str>$17,
+		}
+	`
+	return edb.client.Execute(edb.context, query, provider.Organization.Id, provider.Name, provider.DisplayName, provider.Kind,
+		provider.Issuer, provider.AuthorizationEndpoint, provider.TokenEndpoint, provider.UserinfoEndpoint, provider.JWKSURI,
+		provider.ClientID, provider.ClientSecret, provider.Scope, provider.AllowSignup, provider.Enabled,
+		provider.SAMLCertificates, provider.SAMLBinding, provider.EmailAttribute, provider.UsernameAttribute)
+}
+
+func (edb *EdgeDBQueries) UpdateIdentityProvider(provider datatypes.IdentityProvider) (bool, error) {
+	var result []edgedb.UUID
+	query := `
+		SELECT (UPDATE IdentityProvider filter .organization.id = <uuid>$0 and .name = <str>$1 set {
+			display_name := <str>$2,
+			kind := <str>$3,
+			issuer := <optional str>$4,
+			authorization_endpoint := <optional str>$5,
+			token_endpoint := <optional str>$6,
+			userinfo_endpoint := <optional str>$7,
+			jwks_uri := <optional str>$8,
+			client_id := <str>$9,
+			client_secret := <str>$10,
+			scope := <array<str>>$11,
+			allow_signup := <bool>$12,
+			enabled := <bool>$13,
+			saml_certificates := <array<str>>$14,
+			saml_binding := <str>$15,
+			email_attribute := <optional str>$16,
+			username_attribute := <optional str>$17,
+		}).id
+	`
+	if err := edb.client.Query(edb.context, query, &result, provider.Organization.Id, provider.Name, provider.DisplayName, provider.Kind,
+		provider.Issuer, provider.AuthorizationEndpoint, provider.TokenEndpoint, provider.UserinfoEndpoint, provider.JWKSURI,
+		provider.ClientID, provider.ClientSecret, provider.Scope, provider.AllowSignup, provider.Enabled,
+		provider.SAMLCertificates, provider.SAMLBinding, provider.EmailAttribute, provider.UsernameAttribute); err != nil {
+		return false, err
+	}
+	return len(result) > 0, nil
+}
+
+// DeleteIdentityProvider deletes the provider together with the identities linked through it.
+func (edb *EdgeDBQueries) DeleteIdentityProvider(organizationId edgedb.UUID, name string) (bool, error) {
+	var result []edgedb.UUID
+	query := "SELECT (DELETE IdentityProvider filter .organization.id = <uuid>$0 and .name = <str>$1).id"
+	if err := edb.client.Query(edb.context, query, &result, organizationId, name); err != nil {
+		return false, err
+	}
+	return len(result) > 0, nil
+}
+
+// ReplaceIdentityProviderSecret replaces the stored client secret of the provider if it still is the previous one.
+func (edb *EdgeDBQueries) ReplaceIdentityProviderSecret(providerId edgedb.UUID, previousSecret, clientSecret string) (bool, error) {
+	var result []edgedb.UUID
+	query := "SELECT (UPDATE IdentityProvider filter .id = <uuid>$0 and .client_secret = <str>$1 set { client_secret := <str>$2 }).id"
+	if err := edb.client.Query(edb.context, query, &result, providerId, previousSecret, clientSecret); err != nil {
+		return false, err
+	}
+	return len(result) > 0, nil
+}
+
+const federationStateShape = "id, nonce, code_verifier, link_account_id := .link_account.id, code_hash, identity, expires_at, provider: { " + identityProviderShape + " }"
+
+// CreateFederationState stores an outbound authorization request and drops the expired ones.
+func (edb *EdgeDBQueries) CreateFederationState(providerId edgedb.UUID, stateHash, browserHash, nonce, codeVerifier string, linkAccountId edgedb.OptionalUUID, expiresAt time.Time) error {
+	query := `
+		DELETE FederationState filter .expires_at < datetime_current();
+		INSERT FederationState {
+			provider := <IdentityProvider><uuid>$0,
+			state_hash := <str>$1,
+			browser_hash := <str>$2,
+			nonce := <str>$3,
+			code_verifier := <str>$4,
+			link_account := <Account><optional uuid>$5,
+			expires_at := <datetime>$6,
+		};
+	`
+	return edb.client.Execute(edb.context, query, providerId, stateHash, browserHash, nonce, codeVerifier, linkAccountId, expiresAt)
+}
+
+// ConsumeFederationState deletes the authorization request of the state started by the browser and returns it
+// unless it expired, so every state completes one callback at most.
+func (edb *EdgeDBQueries) ConsumeFederationState(organizationId edgedb.UUID, stateHash, browserHash string) (datatypes.FederationState, error) {
+	var state datatypes.FederationState
+	query := `
+		SELECT (
+			DELETE FederationState filter .state_hash = <str>$0 and .browser_hash = <str>$1 and .provider.organization.id = <uuid>$2
+		) { ` + federationStateShape + ` }
+		filter .expires_at > datetime_current() LIMIT 1
+	`
+	return state, edb.client.QuerySingle(edb.context, query, &state, stateHash, browserHash, organizationId)
+}
+
+// GetPendingSAMLState selects the authentication request of the relay state to a SAML provider,
+// unless it expired or a response to it was consumed already.
+func (edb *EdgeDBQueries) GetPendingSAMLState(organizationId edgedb.UUID, stateHash string) (datatypes.FederationState, error) {
+	var state datatypes.FederationState
+	query := `
+		SELECT FederationState { ` + federationStateShape + ` }
+		filter .state_hash = <str>$0 and .provider.organization.id = <uuid>$1 and .provider.kind = "saml"
+			and not exists .code_hash and .expires_at > datetime_current()
+		LIMIT 1
+	`
+	return state, edb.client.QuerySingle(edb.context, query, &state, stateHash, organizationId)
+}
+
+// CompleteFederationState stores the identity the provider authenticated with the hash of the code the browser
+// picks it up with. A state is completed once at most.
+func (edb *EdgeDBQueries) CompleteFederationState(stateId edgedb.UUID, codeHash, identity string) (bool, error) {
+	var result []edgedb.UUID
+	query := "SELECT (UPDATE FederationState filter .id = <uuid>$0 and not exists .code_hash set { code_hash := <str>$1, identity := <str>$2 }).id"
+	if err := edb.client.Query(edb.context, query, &result, stateId, codeHash, identity); err != nil {
+		return false, err
+	}
+	return len(result) > 0, nil
+}
+
+// RecordSAMLAssertion remembers the assertion of the provider until it expires and drops the expired ones.
+// Recording an assertion twice violates a constraint.
+func (edb *EdgeDBQueries) RecordSAMLAssertion(providerId edgedb.UUID, assertionId string, expiresAt time.Time) error {
+	query := `
+		DELETE SAMLAssertion filter .expires_at < datetime_current();
+		INSERT SAMLAssertion {
+			provider := <IdentityProvider><uuid>$0,
+			assertion_id := <str>$1,
+			expires_at := <datetime>$2,
+		};
+	`
+	return edb.client.Execute(edb.context, query, providerId, assertionId, expiresAt)
+}
+
+func (edb *EdgeDBQueries) GetFederatedIdentity(providerId edgedb.UUID, subject string) (datatypes.FederatedIdentity, error) {
+	var identity datatypes.FederatedIdentity
+	query := "SELECT FederatedIdentity { id, account_id := .account.id, subject, email, created_at, last_login_at } filter .provider.id = <uuid>$0 and .subject = <str>$1 LIMIT 1"
+	return identity, edb.client.QuerySingle(edb.context, query, &identity, providerId, subject)
+}
+
+func (edb *EdgeDBQueries) GetFederatedIdentities(accountId edgedb.UUID) ([]datatypes.FederatedIdentity, error) {
+	var identities []datatypes.FederatedIdentity
+	query := "SELECT FederatedIdentity { id, account_id := .account.id, subject, email, created_at, last_login_at, provider: { name, display_name, kind } } filter .account.id = <uuid>$0 order by .created_at"
+	return identities, edb.client.Query(edb.context, query, &identities, accountId)
+}
+
+func (edb *EdgeDBQueries) CreateFederatedIdentity(accountId, providerId edgedb.UUID, subject string, email edgedb.OptionalStr) error {
+	query := "INSERT FederatedIdentity { account := <Account><uuid>$0, provider := <IdentityProvider><uuid>$1, subject := <str>$2, email := <optional str>$3 }"
+	return edb.client.Execute(edb.context, query, accountId, providerId, subject, email)
+}
+
+// UpdateFederatedIdentityLogin records a sign in with the identity and the email the provider currently reports.
+func (edb *EdgeDBQueries) UpdateFederatedIdentityLogin(identityId edgedb.UUID, email edgedb.OptionalStr) error {
+	query := "UPDATE FederatedIdentity filter .id = <uuid>$0 set { last_login_at := datetime_current(), email := <optional str>$1 }"
+	return edb.client.Execute(edb.context, query, identityId, email)
+}
+
+func (edb *EdgeDBQueries) DeleteFederatedIdentity(accountId edgedb.UUID, providerName string) (bool, error) {
+	var result []edgedb.UUID
+	query := "SELECT (DELETE FederatedIdentity filter .account.id = <uuid>$0 and .provider.name = <str>$1).id"
+	if err := edb.client.Query(edb.context, query, &result, accountId, providerName); err != nil {
+		return false, err
+	}
+	return len(result) > 0, nil
+}
+
+// CreateFederatedAccount creates an account for an identity of an upstream provider and links the identity to it.
+// The password is random, the owner can set one with a password reset.
+func (edb *EdgeDBQueries) CreateFederatedAccount(organizationId edgedb.UUID, email, username, passwordHash, role, status string, providerId edgedb.UUID, subject string) (datatypes.Account, error) {
+	var account datatypes.Account
+	err := edb.client.Tx(edb.context, func(ctx context.Context, tx *edgedb.Tx) error {
+		query := "INSERT Account { organization := <Organization>$3, username := <str>$0, email := <str>$1, roles := (SELECT Role filter .name = <str>$2), status := <str>$4, status_changed := datetime_current(), created_at := datetime_current() }"
+		if err := tx.QuerySingle(ctx, query, &account, username, email, role, organizationId, status); err != nil {
+			return err
+		}
+
+		query = "INSERT Password { account := <Account>$0, email := <str>$1, password := <str>$2 }"
+		if err := tx.Execute(ctx, query, account.Id, email, passwordHash); err != nil {
+			return err
+		}
+
+		query = "INSERT FederatedIdentity { account := <Account><uuid>$0, provider := <IdentityProvider><uuid>$1, subject := <str>$2, email := <str>$3, last_login_at := datetime_current() }"
+		return tx.Execute(ctx, query, account.Id, providerId, subject, email)
+	})
+	return account, err
+}
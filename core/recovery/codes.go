@@ -0,0 +1,51 @@
+package recovery
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/ghostship-dev/authservice/core/config"
+	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/mail"
+	"github.com/ghostship-dev/authservice/core/utility"
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+// recoveryCodeAlphabet leaves out characters which are easily confused when written down.
+const recoveryCodeAlphabet = "23456789abcdefghjkmnpqrstuvwxyz"
+
+const recoveryCodeLength = 12
+
+// NewRecoveryCodes returns RECOVERY_CODES_COUNT random codes formatted as xxxx-xxxx-xxxx and the digests to store for the account.
+func NewRecoveryCodes(accountId edgedb.UUID) ([]string, []string, error) {
+	count := config.GetEnvInt("RECOVERY_CODES_COUNT", 10)
+	codes := make([]string, 0, count)
+	hashes := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		code, err := gonanoid.Generate(recoveryCodeAlphabet, recoveryCodeLength)
+		if err != nil {
+			return nil, nil, err
+		}
+		codes = append(codes, code[0:4]+"-"+code[4:8]+"-"+code[8:12])
+		hashes = append(hashes, HashRecoveryCode(accountId, code))
+	}
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode returns the digest of a recovery code as entered by the user. Dashes, spaces and case are ignored,
+// and the account id is mixed in so equal codes of different accounts have different digests.
+func HashRecoveryCode(accountId edgedb.UUID, code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return utility.HashToken(accountId.String() + ":" + normalized)
+}
+
+// SendRecoveryCodeUsedMail notifies the account owner that a recovery code replaced the second factor.
+func SendRecoveryCodeUsedMail(account datatypes.Account, remaining int64) {
+	mail.Send(mail.Message{
+		To:      account.Email,
+		Subject: "A recovery code was used to sign in",
+		Body: fmt.Sprintf("Hello %s,\r\n\r\na recovery code was used to sign in to your account. %d recovery codes are left.\r\n\r\nIf you lost your authenticator, set up two factor authentication again and generate new recovery codes. If you did not sign in, change your password immediately.",
+			account.Username, remaining),
+	})
+}
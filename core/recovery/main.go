@@ -0,0 +1,59 @@
+package recovery
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ghostship-dev/authservice/core/config"
+	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/mail"
+	"github.com/ghostship-dev/authservice/core/tenancy"
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+// ResetTokenTTL is how long a password reset link stays valid.
+func ResetTokenTTL() time.Duration {
+	return config.GetEnvDuration("PASSWORD_RESET_TTL", 30*time.Minute)
+}
+
+// ResetRateLimit returns how many reset links may be sent to an account, and on requests from a client address, within the window.
+func ResetRateLimit() (int64, time.Duration) {
+	return int64(config.GetEnvInt("PASSWORD_RESET_RATE_LIMIT", 3)), config.GetEnvDuration("PASSWORD_RESET_RATE_WINDOW", time.Hour)
+}
+
+// NewResetToken returns a random single-use reset token. Only its digest is stored.
+func NewResetToken() (string, error) {
+	return gonanoid.New(48)
+}
+
+// Link returns the reset link for the token. PASSWORD_RESET_URL should point to a frontend
+// which submits the new password to the confirm endpoint.
+func Link(organization datatypes.Organization, token string) string {
+	link := config.GetEnv("PASSWORD_RESET_URL", tenancy.Issuer(organization)+"/account/password/reset")
+	separator := "?"
+	if strings.Contains(link, "?") {
+		separator = "&"
+	}
+	return link + separator + url.Values{"token": {token}}.Encode()
+}
+
+func SendResetMail(account datatypes.Account, token string) {
+	mail.Send(mail.Message{
+		To:      account.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello %s,\r\n\r\nsomeone requested to reset the password of your account. Open the following link within %s to choose a new password:\r\n\r\n%s\r\n\r\nIf you did not request this, you can ignore this email.",
+			account.Username, ResetTokenTTL(), Link(tenancy.ForAccount(account), token)),
+	})
+}
+
+// SendPasswordChangedMail notifies the account owner that the password was changed and every session was signed out.
+func SendPasswordChangedMail(account datatypes.Account) {
+	mail.Send(mail.Message{
+		To:      account.Email,
+		Subject: "Your password was changed",
+		Body: fmt.Sprintf("Hello %s,\r\n\r\nthe password of your account was changed on %s.\r\n\r\nIf you did not do this, reset your password immediately and contact support.",
+			account.Username, time.Now().UTC().Format(time.RFC1123)),
+	})
+}
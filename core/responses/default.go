@@ -3,7 +3,10 @@ package responses
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"github.com/edgedb/edgedb-go"
 	"github.com/ghostship-dev/authservice/core/datatypes"
 )
 
@@ -103,3 +106,42 @@ func SendNewOKResponseMessage(w http.ResponseWriter, message string) error {
 	}
 	return nil
 }
+
+const bearerRealm = `Bearer realm="authservice"`
+
+func makeAuthorizationErrorResponse(statusCode int, message, challenge string) error {
+	response := UnauthorizedErrorResponseType{
+		Error:   true,
+		Message: message,
+	}
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		return InternalServerErrorResponse()
+	}
+	return datatypes.NewAuthorizationError(statusCode, string(jsonResponse), challenge)
+}
+
+func MissingBearerTokenResponse() error {
+	return makeAuthorizationErrorResponse(http.StatusUnauthorized, "missing bearer token", bearerRealm)
+}
+
+func InvalidBearerTokenResponse(message string) error {
+	return makeAuthorizationErrorResponse(http.StatusUnauthorized, message, bearerRealm+`, error="invalid_token", error_description="`+message+`"`)
+}
+
+func InsufficientScopeResponse(permissions []string) error {
+	return makeAuthorizationErrorResponse(http.StatusForbidden, "missing required permission", bearerRealm+`, error="insufficient_scope", scope="`+strings.Join(permissions, " ")+`"`)
+}
+
+// InsufficientUserAuthenticationResponse asks the client to authenticate the account again, strongly or recently
+// enough for the acr values and max age (RFC 9470).
+func InsufficientUserAuthenticationResponse(acrValues []string, maxAge edgedb.OptionalInt64) error {
+	challenge := bearerRealm + `, error="insufficient_user_authentication", error_description="a stronger or more recent authentication is required"`
+	if len(acrValues) > 0 {
+		challenge += `, acr_values="` + strings.Join(acrValues, " ") + `"`
+	}
+	if seconds, isSet := maxAge.Get(); isSet {
+		challenge += `, max_age=` + strconv.FormatInt(seconds, 10)
+	}
+	return makeAuthorizationErrorResponse(http.StatusUnauthorized, "reauthentication required", challenge)
+}
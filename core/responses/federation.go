@@ -0,0 +1,190 @@
+package responses
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ghostship-dev/authservice/core/datatypes"
+)
+
+type identityProviderResponse struct {
+	Name                  string    `json:"name"`
+	DisplayName           string    `json:"display_name"`
+	Kind                  string    `json:"kind"`
+	Issuer                string    `json:"issuer,omitempty"`
+	AuthorizationEndpoint string    `json:"authorization_endpoint,omitempty"`
+	TokenEndpoint         string    `json:"token_endpoint,omitempty"`
+	UserinfoEndpoint      string    `json:"userinfo_endpoint,omitempty"`
+	JWKSURI               string    `json:"jwks_uri,omitempty"`
+	ClientID              string    `json:"client_id"`
+	Scope                 []string  `json:"scope"`
+	SAMLCertificates      []string  `json:"saml_certificates,omitempty"`
+	SAMLBinding           string    `json:"saml_binding,omitempty"`
+	EmailAttribute        string    `json:"email_attribute,omitempty"`
+	UsernameAttribute     string    `json:"username_attribute,omitempty"`
+	AllowSignup           bool      `json:"allow_signup"`
+	Enabled               bool      `json:"enabled"`
+	CreatedAt             time.Time `json:"created_at"`
+}
+
+// SendIdentityProvidersResponse lists the configuration of the providers without their client secrets.
+func SendIdentityProvidersResponse(w http.ResponseWriter, providers []datatypes.IdentityProvider) error {
+	data := make([]identityProviderResponse, 0, len(providers))
+	for _, provider := range providers {
+		issuer, _ := provider.Issuer.Get()
+		authorizationEndpoint, _ := provider.AuthorizationEndpoint.Get()
+		tokenEndpoint, _ := provider.TokenEndpoint.Get()
+		userinfoEndpoint, _ := provider.UserinfoEndpoint.Get()
+		jwksURI, _ := provider.JWKSURI.Get()
+		emailAttribute, _ := provider.EmailAttribute.Get()
+		usernameAttribute, _ := provider.UsernameAttribute.Get()
+		samlBinding := ""
+		if provider.Kind == datatypes.IdentityProviderSAML {
+			samlBinding = provider.SAMLBinding
+		}
+		data = append(data, identityProviderResponse{
+			Name:                  provider.Name,
+			DisplayName:           provider.DisplayName,
+			Kind:                  provider.Kind,
+			Issuer:                issuer,
+			AuthorizationEndpoint: authorizationEndpoint,
+			TokenEndpoint:         tokenEndpoint,
+			UserinfoEndpoint:      userinfoEndpoint,
+			JWKSURI:               jwksURI,
+			ClientID:              provider.ClientID,
+			Scope:                 provider.Scope,
+			SAMLCertificates:      provider.SAMLCertificates,
+			SAMLBinding:           samlBinding,
+			EmailAttribute:        emailAttribute,
+			UsernameAttribute:     usernameAttribute,
+			AllowSignup:           provider.AllowSignup,
+			Enabled:               provider.Enabled,
+			CreatedAt:             provider.CreatedAt,
+		})
+	}
+	err := NewJSONResponse(w, http.StatusOK, GenericDataResponse{
+		Error: false,
+		Data:  data,
+	})
+	if err != nil {
+		return InternalServerErrorResponse()
+	}
+	return nil
+}
+
+type loginProviderResponse struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	Kind        string `json:"kind"`
+}
+
+// SendLoginProvidersResponse lists the enabled providers for the login page.
+func SendLoginProvidersResponse(w http.ResponseWriter, providers []datatypes.IdentityProvider) error {
+	data := make([]loginProviderResponse, 0, len(providers))
+	for _, provider := range providers {
+		if provider.Enabled {
+			data = append(data, loginProviderResponse{Name: provider.Name, DisplayName: provider.DisplayName, Kind: provider.Kind})
+		}
+	}
+	err := NewJSONResponse(w, http.StatusOK, GenericDataResponse{
+		Error: false,
+		Data:  data,
+	})
+	if err != nil {
+		return InternalServerErrorResponse()
+	}
+	return nil
+}
+
+type federatedIdentityResponse struct {
+	Provider    string     `json:"provider"`
+	DisplayName string     `json:"display_name"`
+	Kind        string     `json:"kind"`
+	Email       string     `json:"email,omitempty"`
+	LinkedAt    time.Time  `json:"linked_at"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty"`
+}
+
+func SendFederatedIdentitiesResponse(w http.ResponseWriter, identities []datatypes.FederatedIdentity) error {
+	data := make([]federatedIdentityResponse, 0, len(identities))
+	for _, identity := range identities {
+		email, _ := identity.Email.Get()
+		response := federatedIdentityResponse{
+			Provider:    identity.Provider.Name,
+			DisplayName: identity.Provider.DisplayName,
+			Kind:        identity.Provider.Kind,
+			Email:       email,
+			LinkedAt:    identity.CreatedAt,
+		}
+		if lastLoginAt, isSet := identity.LastLoginAt.Get(); isSet {
+			response.LastLoginAt = &lastLoginAt
+		}
+		data = append(data, response)
+	}
+	err := NewJSONResponse(w, http.StatusOK, GenericDataResponse{
+		Error: false,
+		Data:  data,
+	})
+	if err != nil {
+		return InternalServerErrorResponse()
+	}
+	return nil
+}
+
+// SendFederationAuthorizationURLResponse returns the URL a signed in account is sent to for linking a provider.
+func SendFederationAuthorizationURLResponse(w http.ResponseWriter, authorizationURL string) error {
+	err := NewJSONResponse(w, http.StatusOK, GenericDataResponse{
+		Error: false,
+		Data: struct {
+			AuthorizationURL string `json:"authorization_url"`
+		}{
+			AuthorizationURL: authorizationURL,
+		},
+	})
+	if err != nil {
+		return InternalServerErrorResponse()
+	}
+	return nil
+}
+
+func IdentityProviderNotFoundResponse() error {
+	return makeResponse(http.StatusNotFound, "identity provider not found")
+}
+
+func IdentityProviderNameInUseResponse() error {
+	return makeResponse(http.StatusConflict, "identity provider name is already in use")
+}
+
+func IdentityProviderUnavailableResponse() error {
+	return makeResponse(http.StatusBadGateway, "identity provider is unavailable or misconfigured")
+}
+
+func InvalidFederationStateResponse() error {
+	return makeResponse(http.StatusBadRequest, "sign in request is invalid, expired, was already used or was started from another browser")
+}
+
+func FederatedLoginFailedResponse() error {
+	return makeResponse(http.StatusUnauthorized, "signing in with the identity provider failed")
+}
+
+func FederatedIdentityNotLinkedResponse() error {
+	return makeResponse(http.StatusNotFound, "no account is linked to this identity")
+}
+
+// FederatedAccountExistsResponse is returned instead of linking an identity to an existing account by its email,
+// which would let anyone controlling the email at the provider take over the account.
+func FederatedAccountExistsResponse() error {
+	return makeResponse(http.StatusConflict, "an account with this email already exists, sign in and link the identity provider in the account settings")
+}
+
+func FederatedIdentityAlreadyLinkedResponse() error {
+	return makeResponse(http.StatusConflict, "this identity or provider is already linked to an account")
+}
+
+func FederatedIdentityNotFoundResponse() error {
+	return makeResponse(http.StatusNotFound, "identity provider is not linked to this account")
+}
+
+func FederatedEmailMissingResponse() error {
+	return makeResponse(http.StatusBadRequest, "the identity provider did not share a valid email address")
+}
@@ -0,0 +1,45 @@
+package responses
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/ghostship-dev/authservice/core/datatypes"
+)
+
+func AccountSuspendedResponse() error {
+	response := LoginErrorResponse{
+		Error:       true,
+		Message:     "account_suspended",
+		Description: "Account has been suspended",
+	}
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		return InternalServerErrorResponse()
+	}
+	return datatypes.NewRequestError(http.StatusForbidden, string(jsonResponse))
+}
+
+func AccountNotSuspendableResponse() error {
+	return makeResponse(http.StatusNotFound, "account not found or already deleted")
+}
+
+func AccountNotSuspendedResponse() error {
+	return makeResponse(http.StatusNotFound, "account not found or not suspended")
+}
+
+type accountDeletionScheduledResponse struct {
+	Error     bool      `json:"error"`
+	Message   string    `json:"message"`
+	ErasureAt time.Time `json:"erasure_at"`
+}
+
+// SendAccountDeletionScheduledResponse tells the caller until when the deletion can still be cancelled by signing in.
+func SendAccountDeletionScheduledResponse(w http.ResponseWriter, erasureAt time.Time) error {
+	return NewJSONResponse(w, http.StatusOK, accountDeletionScheduledResponse{
+		Error:     false,
+		Message:   "account scheduled for deletion, sign in before the erasure date to cancel",
+		ErasureAt: erasureAt,
+	})
+}
@@ -47,11 +47,11 @@ func AccountNotFoundResponse() error {
 	return datatypes.NewRequestError(http.StatusOK, string(jsonResponse))
 }
 
-func ToManyFailedAttemptsResponse() error {
+func AccountLockedResponse(lockedUntil time.Time) error {
 	response := LoginErrorResponse{
 		Error:       true,
 		Message:     "to_many_failed_attempts",
-		Description: "Account has been suspended due to too many failed login attempts",
+		Description: "Account is locked due to too many failed login attempts until " + lockedUntil.UTC().Format(time.RFC3339),
 	}
 	jsonResponse, err := json.Marshal(response)
 	if err != nil {
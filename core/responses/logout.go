@@ -0,0 +1,72 @@
+package responses
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+)
+
+var frontChannelLogoutPage = template.Must(template.New("logout").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>Signing out</title>
+	{{if .RedirectURI}}<meta http-equiv="refresh" content="3;url={{.RedirectURI}}">{{end}}
+</head>
+<body>
+	<p>You have been signed out.</p>
+	{{range .LogoutURIs}}<iframe src="{{.}}" style="display:none"></iframe>
+	{{end}}
+</body>
+</html>`))
+
+func InvalidIDTokenHintResponse() error {
+	return makeResponse(http.StatusBadRequest, "id_token_hint is invalid")
+}
+
+func OAuth2PostLogoutRedirectURIDoesNotMatch() error {
+	return makeResponse(http.StatusBadRequest, "post_logout_redirect_uri does not match one of the registered post logout redirect URIs for this client")
+}
+
+func buildPostLogoutRedirectURI(redirectURI, state string) string {
+	if redirectURI == "" || state == "" {
+		return redirectURI
+	}
+	parsed, err := url.Parse(redirectURI)
+	if err != nil {
+		return redirectURI
+	}
+	query := parsed.Query()
+	query.Set("state", state)
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+// SendEndSessionResponse renders the front-channel logout iframes if needed and
+// sends the user agent on to the post logout redirect URI.
+func SendEndSessionResponse(w http.ResponseWriter, r *http.Request, frontChannelURIs []string, redirectURI, state string) error {
+	redirectURI = buildPostLogoutRedirectURI(redirectURI, state)
+
+	if len(frontChannelURIs) < 1 {
+		if redirectURI != "" {
+			http.Redirect(w, r, redirectURI, http.StatusSeeOther)
+			return nil
+		}
+		return SendNewOKResponseMessage(w, "logged out successfully")
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache, no-store")
+	w.WriteHeader(http.StatusOK)
+	err := frontChannelLogoutPage.Execute(w, struct {
+		LogoutURIs  []string
+		RedirectURI string
+	}{
+		LogoutURIs:  frontChannelURIs,
+		RedirectURI: redirectURI,
+	})
+	if err != nil {
+		return InternalServerErrorResponse()
+	}
+	return nil
+}
@@ -3,11 +3,13 @@ package responses
 import (
 	"encoding/json"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
 	"time"
 
 	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/scopes"
 )
 
 type genericOAuth2ErrorResponse struct {
@@ -52,20 +54,99 @@ func ReturnRedirectResponseToConsentPage(w http.ResponseWriter, r *http.Request,
 	return nil
 }
 
+func buildClientRedirectURI(authCode datatypes.OAuthAuthorizationCode, params url.Values) string {
+	if state, isSet := authCode.State.Get(); isSet && state != "" {
+		params.Set("state", state)
+	}
+	separator := "?"
+	if strings.Contains(authCode.RedirectURI, "?") {
+		separator = "&"
+	}
+	return authCode.RedirectURI + separator + params.Encode()
+}
+
+func ReturnRedirectResponseToClient(w http.ResponseWriter, r *http.Request, authCode datatypes.OAuthAuthorizationCode) error {
+	http.Redirect(w, r, buildClientRedirectURI(authCode, url.Values{"code": {authCode.Code}}), http.StatusSeeOther)
+	return nil
+}
+
+type consentResult struct {
+	Error       bool   `json:"error"`
+	RedirectURI string `json:"redirect_uri"`
+}
+
+func SendOAuthConsentGrantedResponse(w http.ResponseWriter, authCode datatypes.OAuthAuthorizationCode) error {
+	err := NewJSONResponse(w, http.StatusOK, consentResult{
+		Error:       false,
+		RedirectURI: buildClientRedirectURI(authCode, url.Values{"code": {authCode.Code}}),
+	})
+	if err != nil {
+		return InternalServerErrorResponse()
+	}
+	return nil
+}
+
+func SendOAuthConsentDeniedResponse(w http.ResponseWriter, authCode datatypes.OAuthAuthorizationCode) error {
+	err := NewJSONResponse(w, http.StatusOK, consentResult{
+		Error:       false,
+		RedirectURI: buildClientRedirectURI(authCode, url.Values{"error": {"access_denied"}}),
+	})
+	if err != nil {
+		return InternalServerErrorResponse()
+	}
+	return nil
+}
+
+func OAuth2ApplicationNotAuthorizedResponse() error {
+	return makeResponse(http.StatusNotFound, "application has not been authorized by this account")
+}
+
+type tokenIntrospection struct {
+	Error     bool       `json:"error"`
+	Message   string     `json:"message"`
+	Active    bool       `json:"active"`
+	Scope     []string   `json:"scope"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	AuthTime  *time.Time `json:"auth_time,omitempty"`
+	ACR       string     `json:"acr,omitempty"`
+	AMR       []string   `json:"amr,omitempty"`
+}
+
+func SendTokenIntrospectionResponse(w http.ResponseWriter, scope []string, expiresAt time.Time, authentication datatypes.Authentication) error {
+	response := tokenIntrospection{
+		Error:     false,
+		Message:   "token is valid",
+		Active:    true,
+		Scope:     scope,
+		ExpiresAt: expiresAt,
+	}
+	if authentication.IsSet() {
+		response.AuthTime = &authentication.Time
+		response.ACR = authentication.ACR
+		response.AMR = authentication.AMR
+	}
+	if err := NewJSONResponse(w, http.StatusOK, response); err != nil {
+		return InternalServerErrorResponse()
+	}
+	return nil
+}
+
 type tokenExchangeSuccess struct {
 	AccessToken  string    `json:"access_token"`
 	TokenType    string    `json:"token_type"`
 	ExpiresAt    time.Time `json:"expires_at"`
 	RefreshToken string    `json:"refresh_token,omitempty"`
+	IDToken      string    `json:"id_token,omitempty"`
 	Scope        []string  `json:"scope,omitempty"`
 }
 
-func SendTokenExchangeSuccessResponse(accessToken, refreshToken datatypes.Token, w http.ResponseWriter) error {
+func SendTokenExchangeSuccessResponse(accessToken, refreshToken datatypes.Token, idToken string, w http.ResponseWriter) error {
 	err := NewJSONResponse(w, http.StatusOK, tokenExchangeSuccess{
 		AccessToken:  accessToken.Value,
 		TokenType:    "Bearer",
 		ExpiresAt:    accessToken.ExpiresAt,
 		RefreshToken: refreshToken.Value,
+		IDToken:      idToken,
 		Scope:        accessToken.Scope,
 	})
 	if err != nil {
@@ -73,3 +154,107 @@ func SendTokenExchangeSuccessResponse(accessToken, refreshToken datatypes.Token,
 	}
 	return nil
 }
+
+type authorizedApplication struct {
+	ClientID          string    `json:"client_id"`
+	ClientName        string    `json:"client_name"`
+	ClientDescription string    `json:"client_description,omitempty"`
+	ClientHomepageUrl string    `json:"client_homepage_url,omitempty"`
+	ClientLogoUrl     string    `json:"client_logo_url,omitempty"`
+	ClientTosUrl      string    `json:"client_tos_url,omitempty"`
+	ClientPrivacyUrl  string    `json:"client_privacy_url,omitempty"`
+	GrantedScope      []string  `json:"granted_scope"`
+	AuthorizedAt      time.Time `json:"authorized_at"`
+}
+
+func SendAuthorizedApplicationsResponse(w http.ResponseWriter, consents []datatypes.Consent) error {
+	applications := make([]authorizedApplication, 0, len(consents))
+	for _, consent := range consents {
+		description, _ := consent.Application.ClientDescription.Get()
+		homepageUrl, _ := consent.Application.ClientHomepageUrl.Get()
+		logoUrl, _ := consent.Application.ClientLogoUrl.Get()
+		tosUrl, _ := consent.Application.ClientTosUrl.Get()
+		privacyUrl, _ := consent.Application.ClientPrivacyUrl.Get()
+		applications = append(applications, authorizedApplication{
+			ClientID:          consent.Application.ClientID,
+			ClientName:        consent.Application.ClientName,
+			ClientDescription: description,
+			ClientHomepageUrl: homepageUrl,
+			ClientLogoUrl:     logoUrl,
+			ClientTosUrl:      tosUrl,
+			ClientPrivacyUrl:  privacyUrl,
+			GrantedScope:      consent.GrantedScope,
+			AuthorizedAt:      consent.CreatedAt,
+		})
+	}
+	err := NewJSONResponse(w, http.StatusOK, GenericDataResponse{
+		Error: false,
+		Data:  applications,
+	})
+	if err != nil {
+		return InternalServerErrorResponse()
+	}
+	return nil
+}
+
+type consentDetails struct {
+	ClientID          string              `json:"client_id"`
+	ClientName        string              `json:"client_name"`
+	ClientDescription string              `json:"client_description,omitempty"`
+	ClientHomepageUrl string              `json:"client_homepage_url,omitempty"`
+	ClientLogoUrl     string              `json:"client_logo_url,omitempty"`
+	ClientTosUrl      string              `json:"client_tos_url,omitempty"`
+	ClientPrivacyUrl  string              `json:"client_privacy_url,omitempty"`
+	RequestedScope    []scopes.Definition `json:"requested_scope"`
+	ACRValues         []string            `json:"acr_values,omitempty"`
+	MaxAge            *int64              `json:"max_age,omitempty"`
+	Reauthenticate    bool                `json:"reauthentication_required"`
+}
+
+func SendOAuthConsentDetailsResponse(w http.ResponseWriter, authCode datatypes.OAuthAuthorizationCode, reauthenticate bool) error {
+	requestedScope := make([]scopes.Definition, 0, len(authCode.RequestedScope))
+	for _, scope := range authCode.RequestedScope {
+		definition, found := scopes.Get(scope)
+		if !found {
+			definition = scopes.Definition{Name: scope, Description: scope}
+		}
+		requestedScope = append(requestedScope, definition)
+	}
+	description, _ := authCode.Application.ClientDescription.Get()
+	homepageUrl, _ := authCode.Application.ClientHomepageUrl.Get()
+	logoUrl, _ := authCode.Application.ClientLogoUrl.Get()
+	tosUrl, _ := authCode.Application.ClientTosUrl.Get()
+	privacyUrl, _ := authCode.Application.ClientPrivacyUrl.Get()
+	var maxAge *int64
+	if seconds, isSet := authCode.MaxAge.Get(); isSet {
+		maxAge = &seconds
+	}
+	err := NewJSONResponse(w, http.StatusOK, GenericDataResponse{
+		Error: false,
+		Data: consentDetails{
+			ClientID:          authCode.Application.ClientID,
+			ClientName:        authCode.Application.ClientName,
+			ClientDescription: description,
+			ClientHomepageUrl: homepageUrl,
+			ClientLogoUrl:     logoUrl,
+			ClientTosUrl:      tosUrl,
+			ClientPrivacyUrl:  privacyUrl,
+			RequestedScope:    requestedScope,
+			ACRValues:         authCode.ACRValues,
+			MaxAge:            maxAge,
+			Reauthenticate:    reauthenticate,
+		},
+	})
+	if err != nil {
+		return InternalServerErrorResponse()
+	}
+	return nil
+}
+
+func ScopeNotFoundResponse() error {
+	return makeResponse(http.StatusNotFound, "scope not found")
+}
+
+func ScopeNameInUseErrorResponse() error {
+	return makeResponse(http.StatusConflict, "scope name is already in use")
+}
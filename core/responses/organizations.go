@@ -0,0 +1,51 @@
+package responses
+
+import (
+	"net/http"
+
+	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/tenancy"
+)
+
+type organizationResponse struct {
+	Slug    string   `json:"slug"`
+	Name    string   `json:"name"`
+	Domains []string `json:"domains"`
+	Issuer  string   `json:"issuer"`
+}
+
+func SendOrganizationsResponse(w http.ResponseWriter, organizations []datatypes.Organization) error {
+	data := make([]organizationResponse, 0, len(organizations))
+	for _, organization := range organizations {
+		domains := organization.Domains
+		if domains == nil {
+			domains = make([]string, 0)
+		}
+		data = append(data, organizationResponse{
+			Slug:    organization.Slug,
+			Name:    organization.Name,
+			Domains: domains,
+			Issuer:  tenancy.Issuer(organization),
+		})
+	}
+	err := NewJSONResponse(w, http.StatusOK, GenericDataResponse{
+		Error: false,
+		Data:  data,
+	})
+	if err != nil {
+		return InternalServerErrorResponse()
+	}
+	return nil
+}
+
+func OrganizationNotFoundResponse() error {
+	return makeResponse(http.StatusNotFound, "organization not found")
+}
+
+func OrganizationSlugInUseErrorResponse() error {
+	return makeResponse(http.StatusConflict, "organization slug is already in use")
+}
+
+func OrganizationInUseErrorResponse() error {
+	return makeResponse(http.StatusConflict, "organization still has accounts or applications")
+}
@@ -25,10 +25,18 @@ func SendActivateTotpSuccessResponse(secret, uri string, w http.ResponseWriter)
 	return nil
 }
 
-func SendEnableTotpSuccessResponse(w http.ResponseWriter) error {
-	err := NewJSONResponse(w, http.StatusOK, GenericResponse{
-		Error:   false,
-		Message: "totp enabled",
+type RecoveryCodesResponse struct {
+	Error         bool     `json:"error"`
+	Message       string   `json:"message"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// SendEnableTotpSuccessResponse returns the recovery codes generated for the account. They are not shown again.
+func SendEnableTotpSuccessResponse(w http.ResponseWriter, recoveryCodes []string) error {
+	err := NewJSONResponse(w, http.StatusOK, RecoveryCodesResponse{
+		Error:         false,
+		Message:       "totp enabled",
+		RecoveryCodes: recoveryCodes,
 	})
 	if err != nil {
 		return InternalServerErrorResponse()
@@ -78,3 +86,15 @@ func TwoFactorAuthenticationRequiredResponse() error {
 	}
 	return datatypes.NewRequestError(http.StatusOK, string(jsonResponse))
 }
+
+func SendRecoveryCodesRegeneratedResponse(w http.ResponseWriter, recoveryCodes []string) error {
+	err := NewJSONResponse(w, http.StatusOK, RecoveryCodesResponse{
+		Error:         false,
+		Message:       "recovery codes regenerated, previous codes are no longer valid",
+		RecoveryCodes: recoveryCodes,
+	})
+	if err != nil {
+		return InternalServerErrorResponse()
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package responses
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ghostship-dev/authservice/core/datatypes"
+)
+
+type OTPCodeSentResponse struct {
+	Error         bool      `json:"error"`
+	Message       string    `json:"message"`
+	CodeSentTo    string    `json:"code_sent_to"`
+	CodeExpiresAt time.Time `json:"code_expires_at"`
+}
+
+// SendOTPCodeSentResponse confirms the delivery of a code to the masked destination.
+func SendOTPCodeSentResponse(w http.ResponseWriter, codeSentTo string, expiresAt time.Time) error {
+	err := NewJSONResponse(w, http.StatusOK, OTPCodeSentResponse{
+		Error:         false,
+		Message:       "code sent",
+		CodeSentTo:    codeSentTo,
+		CodeExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return InternalServerErrorResponse()
+	}
+	return nil
+}
+
+func SendOTPFactorsResponse(w http.ResponseWriter, factors []datatypes.OTPFactor) error {
+	if factors == nil {
+		factors = []datatypes.OTPFactor{}
+	}
+	return NewJSONResponse(w, http.StatusOK, GenericDataResponse{
+		Error: false,
+		Data:  factors,
+	})
+}
+
+func SendOTPFactorEnabledResponse(w http.ResponseWriter) error {
+	return SendNewOKResponseMessage(w, "factor enabled, codes are sent through it when signing in")
+}
+
+func OTPFactorAlreadyEnrolledResponse() error {
+	return makeResponse(http.StatusConflict, "factor is already enabled, remove it before enrolling again")
+}
+
+func OTPFactorNotFoundResponse() error {
+	return makeResponse(http.StatusNotFound, "factor not found")
+}
+
+func OTPCodeRateLimitedResponse() error {
+	return makeResponse(http.StatusTooManyRequests, "too many codes were requested, try again later")
+}
+
+func InvalidPhoneNumberResponse() error {
+	return ValidationErrorResponse(map[string]string{"phone_number": "phone_number must be in E.164 format, e.g. +491701234567"})
+}
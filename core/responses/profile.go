@@ -0,0 +1,51 @@
+package responses
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/ghostship-dev/authservice/core/datatypes"
+)
+
+// ProfileResponse holds the profile of the account. Fields beyond the profile scope are omitted
+// unless the token was granted the email or account_read scope.
+type ProfileResponse struct {
+	ID                string     `json:"id"`
+	Username          string     `json:"username"`
+	AvatarURI         string     `json:"avatar_uri,omitempty"`
+	Email             string     `json:"email,omitempty"`
+	EmailVerified     *bool      `json:"email_verified,omitempty"`
+	PendingEmail      string     `json:"pending_email,omitempty"`
+	Status            string     `json:"status,omitempty"`
+	StatusDescription string     `json:"status_description,omitempty"`
+	OtpEnabled        *bool      `json:"otp_enabled,omitempty"`
+	RecoveryCodesLeft *int64     `json:"recovery_codes_remaining,omitempty"`
+	Roles             []string   `json:"roles,omitempty"`
+	CreatedAt         *time.Time `json:"created_at,omitempty"`
+}
+
+func SendProfileResponse(w http.ResponseWriter, profile ProfileResponse) error {
+	return NewJSONResponse(w, http.StatusOK, GenericDataResponse{
+		Error: false,
+		Data:  profile,
+	})
+}
+
+func UsernameInUseErrorResponse() error {
+	response := LoginErrorResponse{
+		Error:       true,
+		Message:     "username_in_use",
+		Description: "Username already in use",
+	}
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		return InternalServerErrorResponse()
+	}
+	return datatypes.NewRequestError(http.StatusOK, string(jsonResponse))
+}
+
+// SendEmailChangeRequestedResponse is returned once the verification link was sent to the new address.
+func SendEmailChangeRequestedResponse(w http.ResponseWriter) error {
+	return SendNewOKResponseMessage(w, "profile updated, the new email address takes effect once it is verified")
+}
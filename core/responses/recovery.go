@@ -0,0 +1,35 @@
+package responses
+
+import (
+	"net/http"
+)
+
+func InvalidPasswordResetTokenResponse() error {
+	return makeResponse(http.StatusBadRequest, "password reset link is invalid, expired or was already used")
+}
+
+// SendPasswordResetRequestedResponse does not reveal whether an account with the email exists.
+func SendPasswordResetRequestedResponse(w http.ResponseWriter) error {
+	return SendNewOKResponseMessage(w, "if an account with this email exists, a password reset link has been sent")
+}
+
+func SendPasswordResetSuccessResponse(w http.ResponseWriter) error {
+	return SendNewOKResponseMessage(w, "password has been reset successfully")
+}
+
+func InvalidUnlockTokenResponse() error {
+	return makeResponse(http.StatusBadRequest, "unlock link is invalid, expired or was already used")
+}
+
+func AccountNotLockedResponse() error {
+	return makeResponse(http.StatusNotFound, "account not found or not locked")
+}
+
+// SendMagicLinkRequestedResponse does not reveal whether an account with the email exists.
+func SendMagicLinkRequestedResponse(w http.ResponseWriter) error {
+	return SendNewOKResponseMessage(w, "if an account with this email exists, a login link has been sent")
+}
+
+func InvalidMagicLinkResponse() error {
+	return makeResponse(http.StatusBadRequest, "login link is invalid, expired, was already used or was requested from another browser")
+}
@@ -0,0 +1,49 @@
+package responses
+
+import (
+	"net/http"
+
+	"github.com/ghostship-dev/authservice/core/datatypes"
+)
+
+type roleResponse struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Permissions []string `json:"permissions"`
+}
+
+func SendRolesResponse(w http.ResponseWriter, roles []datatypes.Role) error {
+	data := make([]roleResponse, 0, len(roles))
+	for _, role := range roles {
+		description, _ := role.Description.Get()
+		permissions := role.Permissions
+		if permissions == nil {
+			permissions = make([]string, 0)
+		}
+		data = append(data, roleResponse{
+			Name:        role.Name,
+			Description: description,
+			Permissions: permissions,
+		})
+	}
+	err := NewJSONResponse(w, http.StatusOK, GenericDataResponse{
+		Error: false,
+		Data:  data,
+	})
+	if err != nil {
+		return InternalServerErrorResponse()
+	}
+	return nil
+}
+
+func RoleNotFoundResponse() error {
+	return makeResponse(http.StatusNotFound, "role not found")
+}
+
+func RoleNameInUseErrorResponse() error {
+	return makeResponse(http.StatusConflict, "role name is already in use")
+}
+
+func RoleAccountNotFoundResponse() error {
+	return makeResponse(http.StatusNotFound, "account not found")
+}
@@ -0,0 +1,34 @@
+package responses
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ghostship-dev/authservice/core/datatypes"
+)
+
+func EmailNotVerifiedResponse() error {
+	response := LoginErrorResponse{
+		Error:       true,
+		Message:     "email_not_verified",
+		Description: "Email address has not been verified yet",
+	}
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		return InternalServerErrorResponse()
+	}
+	return datatypes.NewRequestError(http.StatusForbidden, string(jsonResponse))
+}
+
+func InvalidVerificationTokenResponse() error {
+	return makeResponse(http.StatusBadRequest, "verification link is invalid, expired or was already used")
+}
+
+func SendEmailVerifiedResponse(w http.ResponseWriter) error {
+	return SendNewOKResponseMessage(w, "email address verified successfully")
+}
+
+// SendVerificationEmailQueuedResponse does not reveal whether the account exists or a mail was actually sent.
+func SendVerificationEmailQueuedResponse(w http.ResponseWriter) error {
+	return SendNewOKResponseMessage(w, "if an unverified account with this email exists, a new verification link has been sent")
+}
@@ -0,0 +1,72 @@
+package responses
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/ghostship-dev/authservice/core/datatypes"
+)
+
+// SendWebAuthnOptionsResponse returns the options to pass to navigator.credentials.create() or navigator.credentials.get().
+func SendWebAuthnOptionsResponse(w http.ResponseWriter, options interface{}) error {
+	return NewJSONResponse(w, http.StatusOK, GenericDataResponse{
+		Error: false,
+		Data:  options,
+	})
+}
+
+// SecondFactorChallenge lists the second factors of the account and the mfa_token to submit one of them with.
+// If a security key is registered, it carries the assertion options to sign, and if a code was requested, where it was sent to.
+type SecondFactorChallenge struct {
+	MFAToken          string      `json:"mfa_token,omitempty"`
+	MFATokenExpiresAt *time.Time  `json:"mfa_token_expires_at,omitempty"`
+	Factors           []string    `json:"factors"`
+	WebAuthn          interface{} `json:"webauthn,omitempty"`
+	CodeSentTo        string      `json:"code_sent_to,omitempty"`
+	CodeExpiresAt     *time.Time  `json:"code_expires_at,omitempty"`
+}
+
+type secondFactorRequiredResponse struct {
+	Error       bool   `json:"error"`
+	Message     string `json:"message"`
+	Description string `json:"description"`
+	SecondFactorChallenge
+}
+
+// SecondFactorRequiredResponse asks to submit one of the factors of the challenge.
+func SecondFactorRequiredResponse(challenge SecondFactorChallenge) error {
+	response := secondFactorRequiredResponse{
+		Error:                 true,
+		Message:               "two_factor_authentication_required",
+		Description:           "two factor authentication is required",
+		SecondFactorChallenge: challenge,
+	}
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		return InternalServerErrorResponse()
+	}
+	return datatypes.NewRequestError(http.StatusOK, string(jsonResponse))
+}
+
+func InvalidMFATokenResponse() error {
+	return makeResponse(http.StatusUnauthorized, "mfa_token is invalid or expired, sign in again")
+}
+
+func InvalidWebAuthnResponse() error {
+	return makeResponse(http.StatusUnauthorized, "webauthn response is invalid or the ceremony expired")
+}
+
+func WebAuthnCredentialNotFoundResponse() error {
+	return makeResponse(http.StatusNotFound, "webauthn credential not found")
+}
+
+func SendWebAuthnCredentialsResponse(w http.ResponseWriter, credentials []datatypes.WebAuthnCredential) error {
+	if credentials == nil {
+		credentials = []datatypes.WebAuthnCredential{}
+	}
+	return NewJSONResponse(w, http.StatusOK, GenericDataResponse{
+		Error: false,
+		Data:  credentials,
+	})
+}
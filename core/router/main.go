@@ -10,9 +10,16 @@ import (
 
 type HandlerFunc func(http.ResponseWriter, *http.Request) error
 
+// Authorizer resolves the caller of a request and decides whether it holds the required permissions.
+// On success it returns the request with the resolved principal attached to its context.
+type Authorizer interface {
+	Authorize(req *http.Request, permissions []string) (*http.Request, error)
+}
+
 type Router struct {
 	mux        *http.ServeMux
 	middleware []func(http.Handler) http.Handler
+	authorizer Authorizer
 }
 
 func New() *Router {
@@ -37,38 +44,71 @@ func (r *Router) Use(mw func(http.Handler) http.Handler) {
 	r.middleware = append(r.middleware, mw)
 }
 
-func (r *Router) Get(pattern string, handler HandlerFunc) {
+func (r *Router) Get(pattern string, handler HandlerFunc, permissions ...string) {
+	handler = r.authorize(handler, permissions)
 	r.mux.HandleFunc("GET "+pattern, func(w http.ResponseWriter, req *http.Request) {
 		handleError(w, handler(w, req))
 	})
 }
 
-func (r *Router) Post(pattern string, handler HandlerFunc) {
+func (r *Router) Post(pattern string, handler HandlerFunc, permissions ...string) {
+	handler = r.authorize(handler, permissions)
 	r.mux.HandleFunc("POST "+pattern, func(w http.ResponseWriter, req *http.Request) {
 		handleError(w, handler(w, req))
 	})
 }
 
-func (r *Router) Put(pattern string, handler HandlerFunc) {
+func (r *Router) Put(pattern string, handler HandlerFunc, permissions ...string) {
+	handler = r.authorize(handler, permissions)
 	r.mux.HandleFunc("PUT "+pattern, func(w http.ResponseWriter, req *http.Request) {
 		handleError(w, handler(w, req))
 	})
 }
 
-func (r *Router) Patch(pattern string, handler HandlerFunc) {
+func (r *Router) Patch(pattern string, handler HandlerFunc, permissions ...string) {
+	handler = r.authorize(handler, permissions)
 	r.mux.HandleFunc("PATCH "+pattern, func(w http.ResponseWriter, req *http.Request) {
 		handleError(w, handler(w, req))
 	})
 }
 
-func (r *Router) Delete(pattern string, handler HandlerFunc) {
+func (r *Router) Delete(pattern string, handler HandlerFunc, permissions ...string) {
+	handler = r.authorize(handler, permissions)
 	r.mux.HandleFunc("DELETE "+pattern, func(w http.ResponseWriter, req *http.Request) {
 		handleError(w, handler(w, req))
 	})
 }
 
+// SetAuthorizer sets the decision point used for routes that declare required permissions.
+func (r *Router) SetAuthorizer(authorizer Authorizer) {
+	r.authorizer = authorizer
+}
+
+// authorize wraps the handler so the caller is resolved and checked against the permissions before it runs.
+// Routes without permissions are public.
+func (r *Router) authorize(handler HandlerFunc, permissions []string) HandlerFunc {
+	if len(permissions) < 1 {
+		return handler
+	}
+	if r.authorizer == nil {
+		panic("router: route declares permissions but no authorizer is set")
+	}
+	authorizer := r.authorizer
+	return func(w http.ResponseWriter, req *http.Request) error {
+		authorizedReq, err := authorizer.Authorize(req, permissions)
+		if err != nil {
+			return err
+		}
+		return handler(w, authorizedReq)
+	}
+}
+
 func handleError(w http.ResponseWriter, err error) {
 	if err != nil {
+		var authorizationError *datatypes.AuthorizationError
+		if errors.As(err, &authorizationError) && authorizationError.Challenge() != "" {
+			w.Header().Set("WWW-Authenticate", authorizationError.Challenge())
+		}
 		var requestError datatypes.RequestErrorInterface
 		if errors.As(err, &requestError) {
 			w.Header().Set("Content-Type", "application/json")
@@ -88,6 +128,7 @@ func (r *Router) Group(prefix string) *Router {
 	group := &Router{
 		mux:        http.NewServeMux(),
 		middleware: r.middleware,
+		authorizer: r.authorizer,
 	}
 	r.Use(func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
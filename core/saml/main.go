@@ -0,0 +1,477 @@
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ghostship-dev/authservice/core/config"
+	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/federation"
+	"github.com/ghostship-dev/authservice/core/tenancy"
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+const (
+	namespaceProtocol  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	namespaceAssertion = "urn:oasis:names:tc:SAML:2.0:assertion"
+	bindingPOST        = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+
+	statusSuccess          = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	confirmationBearer     = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+	nameIDFormatEmail      = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+	nameIDFormatPersistent = "urn:oasis:names:tc:SAML:2.0:nameid-format:persistent"
+	nameIDFormatTransient  = "urn:oasis:names:tc:SAML:2.0:nameid-format:transient"
+)
+
+var (
+	ErrInvalidResponse = errors.New("saml response is invalid")
+	ErrUnsuccessful    = errors.New("identity provider did not authenticate the user")
+)
+
+// Attributes identity providers commonly send the email, username and name of the user in,
+// used when the provider has no attribute configured.
+var (
+	emailAttributes = []string{
+		"email",
+		"mail",
+		"emailAddress",
+		"urn:oid:0.9.2342.19200300.100.1.3",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress",
+	}
+	usernameAttributes = []string{
+		"username",
+		"uid",
+		"urn:oid:0.9.2342.19200300.100.1.1",
+	}
+	nameAttributes = []string{
+		"displayName",
+		"name",
+		"urn:oid:2.16.840.1.113730.3.1.241",
+		"http://schemas.microsoft.com/identity/claims/displayname",
+	}
+)
+
+// Authentication context classes which state that the identity provider authenticated the user with more than one factor.
+var multiFactorContexts = []string{
+	"https://refeds.org/profile/mfa",
+	"http://schemas.microsoft.com/claims/multipleauthn",
+	"urn:oasis:names:tc:SAML:2.0:ac:classes:MobileTwoFactorContract",
+	"urn:oasis:names:tc:SAML:2.0:ac:classes:MobileTwoFactorUnregistered",
+	"urn:oasis:names:tc:SAML:2.0:ac:classes:TimeSyncToken",
+}
+
+// Assertion is what the identity provider asserted about the user in a validated response.
+type Assertion struct {
+	ID           string
+	NameID       string
+	NameIDFormat string
+	SessionIndex string
+	AuthnContext string
+	Attributes   map[string][]string
+	// ExpiresAt is when the assertion cannot be accepted anymore, so it only has to be remembered until then to detect replays.
+	ExpiresAt time.Time
+}
+
+// EntityID identifies the organization as service provider. It is the URL of its metadata.
+func EntityID(organization datatypes.Organization) string {
+	return tenancy.Issuer(organization) + "/saml/metadata"
+}
+
+// AssertionConsumerServiceURL is where identity providers post their responses to.
+func AssertionConsumerServiceURL(organization datatypes.Organization) string {
+	return tenancy.Issuer(organization) + "/saml/acs"
+}
+
+// SingleSignOnURL is where the browser picks up an authentication request sent with the HTTP-POST binding.
+func SingleSignOnURL(organization datatypes.Organization, relayState string) string {
+	return tenancy.Issuer(organization) + "/saml/sso?" + url.Values{"RelayState": {relayState}}.Encode()
+}
+
+// ClockSkew is how far the clocks of the identity providers may be off.
+func ClockSkew() time.Duration {
+	return config.GetEnvDuration("SAML_CLOCK_SKEW", 2*time.Minute)
+}
+
+// NewRequestID returns the ID of an authentication request, which has to start with a letter or an underscore.
+func NewRequestID() (string, error) {
+	id, err := gonanoid.Generate("abcdefghijklmnopqrstuvwxyz0123456789", 40)
+	if err != nil {
+		return "", err
+	}
+	return "_" + id, nil
+}
+
+type indexedEndpoint struct {
+	Binding   string `xml:"Binding,attr"`
+	Location  string `xml:"Location,attr"`
+	Index     int    `xml:"index,attr"`
+	IsDefault bool   `xml:"isDefault,attr"`
+}
+
+type entityDescriptor struct {
+	XMLName         xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+	EntityID        string   `xml:"entityID,attr"`
+	SPSSODescriptor struct {
+		AuthnRequestsSigned        bool              `xml:"AuthnRequestsSigned,attr"`
+		WantAssertionsSigned       bool              `xml:"WantAssertionsSigned,attr"`
+		ProtocolSupportEnumeration string            `xml:"protocolSupportEnumeration,attr"`
+		NameIDFormats              []string          `xml:"NameIDFormat"`
+		AssertionConsumerServices  []indexedEndpoint `xml:"AssertionConsumerService"`
+	} `xml:"SPSSODescriptor"`
+}
+
+// Metadata returns the service provider metadata of the organization to register with identity providers.
+// Authentication requests are not signed and responses are only accepted with the HTTP-POST binding.
+func Metadata(organization datatypes.Organization) ([]byte, error) {
+	descriptor := entityDescriptor{EntityID: EntityID(organization)}
+	descriptor.SPSSODescriptor.WantAssertionsSigned = true
+	descriptor.SPSSODescriptor.ProtocolSupportEnumeration = namespaceProtocol
+	descriptor.SPSSODescriptor.NameIDFormats = []string{nameIDFormatPersistent, nameIDFormatEmail}
+	descriptor.SPSSODescriptor.AssertionConsumerServices = []indexedEndpoint{{
+		Binding:   bindingPOST,
+		Location:  AssertionConsumerServiceURL(organization),
+		Index:     0,
+		IsDefault: true,
+	}}
+
+	metadata, err := xml.MarshalIndent(descriptor, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), metadata...), nil
+}
+
+type authnRequest struct {
+	XMLName                     xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol AuthnRequest"`
+	ID                          string   `xml:"ID,attr"`
+	Version                     string   `xml:"Version,attr"`
+	IssueInstant                string   `xml:"IssueInstant,attr"`
+	Destination                 string   `xml:"Destination,attr"`
+	AssertionConsumerServiceURL string   `xml:"AssertionConsumerServiceURL,attr"`
+	ProtocolBinding             string   `xml:"ProtocolBinding,attr"`
+	Issuer                      struct {
+		XMLName xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+		Value   string   `xml:",chardata"`
+	}
+	NameIDPolicy struct {
+		XMLName     xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol NameIDPolicy"`
+		AllowCreate bool     `xml:"AllowCreate,attr"`
+	}
+}
+
+// AuthnRequest returns the authentication request with the ID for the single sign-on service of the provider.
+func AuthnRequest(provider datatypes.IdentityProvider, requestID string, now time.Time) ([]byte, error) {
+	destination, _ := provider.AuthorizationEndpoint.Get()
+	request := authnRequest{
+		ID:                          requestID,
+		Version:                     "2.0",
+		IssueInstant:                now.UTC().Format(time.RFC3339),
+		Destination:                 destination,
+		AssertionConsumerServiceURL: AssertionConsumerServiceURL(provider.Organization),
+		ProtocolBinding:             bindingPOST,
+	}
+	request.Issuer.Value = EntityID(provider.Organization)
+	request.NameIDPolicy.AllowCreate = true
+	return xml.Marshal(request)
+}
+
+// RedirectURL returns the URL sending the authentication request to the provider with the HTTP-Redirect binding.
+func RedirectURL(provider datatypes.IdentityProvider, request []byte, relayState string) (string, error) {
+	var deflated bytes.Buffer
+	writer, err := flate.NewWriter(&deflated, flate.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err = writer.Write(request); err != nil {
+		return "", err
+	}
+	if err = writer.Close(); err != nil {
+		return "", err
+	}
+
+	destination, _ := provider.AuthorizationEndpoint.Get()
+	params := url.Values{
+		"SAMLRequest": {base64.StdEncoding.EncodeToString(deflated.Bytes())},
+		"RelayState":  {relayState},
+	}
+	separator := "?"
+	if strings.Contains(destination, "?") {
+		separator = "&"
+	}
+	return destination + separator + params.Encode(), nil
+}
+
+var postFormTemplate = template.Must(template.New("post").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Signing in</title></head>
+<body onload="document.forms[0].submit()">
+<form method="post" action="{{.Destination}}">
+<input type="hidden" name="SAMLRequest" value="{{.Request}}">
+<input type="hidden" name="RelayState" value="{{.RelayState}}">
+<noscript><button type="submit">Continue</button></noscript>
+</form>
+</body>
+</html>
+`))
+
+// WritePOSTForm writes a page which posts the authentication request to the provider with the HTTP-POST binding.
+func WritePOSTForm(w http.ResponseWriter, provider datatypes.IdentityProvider, request []byte, relayState string) error {
+	destination, _ := provider.AuthorizationEndpoint.Get()
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	return postFormTemplate.Execute(w, struct {
+		Destination template.URL
+		Request     string
+		RelayState  string
+	}{
+		Destination: template.URL(destination),
+		Request:     base64.StdEncoding.EncodeToString(request),
+		RelayState:  relayState,
+	})
+}
+
+// ParseResponse validates the base64 encoded response the provider posted for the authentication request with the ID
+// and returns its assertion. The response or the assertion has to be signed by a certificate of the provider,
+// and the assertion has to be addressed to the organization and valid at the time.
+// Encrypted assertions and responses the provider sends without a request are not supported.
+func ParseResponse(provider datatypes.IdentityProvider, encoded, requestID string, now time.Time) (Assertion, error) {
+	data, err := decodeBase64(encoded)
+	if err != nil {
+		return Assertion{}, fmt.Errorf("%w: malformed encoding", ErrInvalidResponse)
+	}
+	response, err := parseDocument(data)
+	if err != nil {
+		return Assertion{}, err
+	}
+
+	if !response.is(namespaceProtocol, "Response") || response.attr("Version") != "2.0" {
+		return Assertion{}, fmt.Errorf("%w: not a saml 2.0 response", ErrInvalidResponse)
+	}
+	if destination := response.attr("Destination"); destination != "" && destination != AssertionConsumerServiceURL(provider.Organization) {
+		return Assertion{}, fmt.Errorf("%w: destination %s is not the assertion consumer service", ErrInvalidResponse, destination)
+	}
+	if response.attr("InResponseTo") != requestID {
+		return Assertion{}, fmt.Errorf("%w: response is not for the request", ErrInvalidResponse)
+	}
+	idpEntityID, _ := provider.Issuer.Get()
+	if issuer := response.child(namespaceAssertion, "Issuer"); issuer != nil && issuer.text() != idpEntityID {
+		return Assertion{}, fmt.Errorf("%w: issuer %s is not the provider", ErrInvalidResponse, issuer.text())
+	}
+
+	if status := statusCode(response); status != statusSuccess {
+		message := ""
+		if statusElement := response.child(namespaceProtocol, "Status"); statusElement != nil {
+			message = statusElement.childText(namespaceProtocol, "StatusMessage")
+		}
+		return Assertion{}, fmt.Errorf("%w: %s %s", ErrUnsuccessful, status, message)
+	}
+
+	if response.child(namespaceAssertion, "EncryptedAssertion") != nil {
+		return Assertion{}, fmt.Errorf("%w: encrypted assertions are not supported", ErrInvalidResponse)
+	}
+	assertions := response.childrenNamed(namespaceAssertion, "Assertion")
+	if len(assertions) != 1 {
+		return Assertion{}, fmt.Errorf("%w: expected one assertion", ErrInvalidResponse)
+	}
+	assertion := assertions[0]
+
+	certificates, err := ParseCertificates(provider.SAMLCertificates)
+	if err != nil {
+		return Assertion{}, err
+	}
+	responseErr := verifySignature(response, certificates)
+	if responseErr != nil && !errors.Is(responseErr, ErrNotSigned) {
+		return Assertion{}, responseErr
+	}
+	assertionErr := verifySignature(assertion, certificates)
+	if assertionErr != nil && !errors.Is(assertionErr, ErrNotSigned) {
+		return Assertion{}, assertionErr
+	}
+	if responseErr != nil && assertionErr != nil {
+		return Assertion{}, ErrNotSigned
+	}
+
+	return readAssertion(provider, assertion, requestID, now)
+}
+
+// statusCode returns the top-level status code of the response followed by the second-level code if there is one.
+func statusCode(response *element) string {
+	status := response.child(namespaceProtocol, "Status")
+	if status == nil {
+		return ""
+	}
+	code := status.child(namespaceProtocol, "StatusCode")
+	if code == nil {
+		return ""
+	}
+	if code.attr("Value") != statusSuccess {
+		if nested := code.child(namespaceProtocol, "StatusCode"); nested != nil {
+			return code.attr("Value") + " " + nested.attr("Value")
+		}
+	}
+	return code.attr("Value")
+}
+
+func readAssertion(provider datatypes.IdentityProvider, el *element, requestID string, now time.Time) (Assertion, error) {
+	skew := ClockSkew()
+	organization := provider.Organization
+
+	assertion := Assertion{ID: el.attr("ID"), Attributes: make(map[string][]string)}
+	if assertion.ID == "" || el.attr("Version") != "2.0" {
+		return Assertion{}, fmt.Errorf("%w: assertion has no ID", ErrInvalidResponse)
+	}
+	idpEntityID, _ := provider.Issuer.Get()
+	if issuer := el.childText(namespaceAssertion, "Issuer"); issuer != idpEntityID {
+		return Assertion{}, fmt.Errorf("%w: assertion issuer %s is not the provider", ErrInvalidResponse, issuer)
+	}
+
+	subject := el.child(namespaceAssertion, "Subject")
+	if subject == nil {
+		return Assertion{}, fmt.Errorf("%w: assertion has no subject", ErrInvalidResponse)
+	}
+	nameID := subject.child(namespaceAssertion, "NameID")
+	if nameID == nil || nameID.text() == "" {
+		return Assertion{}, fmt.Errorf("%w: assertion has no NameID", ErrInvalidResponse)
+	}
+	assertion.NameID = nameID.text()
+	assertion.NameIDFormat = nameID.attr("Format")
+	if assertion.NameIDFormat == nameIDFormatTransient {
+		return Assertion{}, fmt.Errorf("%w: transient NameIDs cannot identify the user", ErrInvalidResponse)
+	}
+
+	// A bearer confirmation proves the assertion was issued for this request to this service provider
+	var confirmedUntil time.Time
+	for _, confirmation := range subject.childrenNamed(namespaceAssertion, "SubjectConfirmation") {
+		data := confirmation.child(namespaceAssertion, "SubjectConfirmationData")
+		if confirmation.attr("Method") != confirmationBearer || data == nil {
+			continue
+		}
+		notOnOrAfter, err := parseTime(data.attr("NotOnOrAfter"))
+		if err != nil || !now.Before(notOnOrAfter.Add(skew)) {
+			continue
+		}
+		if data.attr("Recipient") != AssertionConsumerServiceURL(organization) {
+			continue
+		}
+		if data.hasAttr("InResponseTo") && data.attr("InResponseTo") != requestID {
+			continue
+		}
+		confirmedUntil = notOnOrAfter
+		break
+	}
+	if confirmedUntil.IsZero() {
+		return Assertion{}, fmt.Errorf("%w: subject is not confirmed for this service provider or expired", ErrInvalidResponse)
+	}
+	assertion.ExpiresAt = confirmedUntil.Add(skew)
+
+	conditions := el.child(namespaceAssertion, "Conditions")
+	if conditions == nil {
+		return Assertion{}, fmt.Errorf("%w: assertion has no conditions", ErrInvalidResponse)
+	}
+	if conditions.hasAttr("NotBefore") {
+		notBefore, err := parseTime(conditions.attr("NotBefore"))
+		if err != nil || now.Add(skew).Before(notBefore) {
+			return Assertion{}, fmt.Errorf("%w: assertion is not valid yet", ErrInvalidResponse)
+		}
+	}
+	if conditions.hasAttr("NotOnOrAfter") {
+		notOnOrAfter, err := parseTime(conditions.attr("NotOnOrAfter"))
+		if err != nil || !now.Before(notOnOrAfter.Add(skew)) {
+			return Assertion{}, fmt.Errorf("%w: assertion expired", ErrInvalidResponse)
+		}
+		if notOnOrAfter.Add(skew).After(assertion.ExpiresAt) {
+			assertion.ExpiresAt = notOnOrAfter.Add(skew)
+		}
+	}
+	restrictions := conditions.childrenNamed(namespaceAssertion, "AudienceRestriction")
+	if len(restrictions) == 0 {
+		return Assertion{}, fmt.Errorf("%w: assertion has no audience", ErrInvalidResponse)
+	}
+	for _, restriction := range restrictions {
+		audiences := restriction.childrenNamed(namespaceAssertion, "Audience")
+		if !slices.ContainsFunc(audiences, func(audience *element) bool { return audience.text() == EntityID(organization) }) {
+			return Assertion{}, fmt.Errorf("%w: assertion is not addressed to %s", ErrInvalidResponse, EntityID(organization))
+		}
+	}
+
+	statement := el.child(namespaceAssertion, "AuthnStatement")
+	if statement == nil {
+		return Assertion{}, fmt.Errorf("%w: assertion has no authentication statement", ErrInvalidResponse)
+	}
+	if statement.hasAttr("SessionNotOnOrAfter") {
+		sessionNotOnOrAfter, err := parseTime(statement.attr("SessionNotOnOrAfter"))
+		if err != nil || !now.Before(sessionNotOnOrAfter.Add(skew)) {
+			return Assertion{}, fmt.Errorf("%w: session at the provider expired", ErrInvalidResponse)
+		}
+	}
+	assertion.SessionIndex = statement.attr("SessionIndex")
+	if context := statement.child(namespaceAssertion, "AuthnContext"); context != nil {
+		assertion.AuthnContext = context.childText(namespaceAssertion, "AuthnContextClassRef")
+	}
+
+	for _, statement := range el.childrenNamed(namespaceAssertion, "AttributeStatement") {
+		for _, attribute := range statement.childrenNamed(namespaceAssertion, "Attribute") {
+			var values []string
+			for _, value := range attribute.childrenNamed(namespaceAssertion, "AttributeValue") {
+				values = append(values, value.text())
+			}
+			for _, name := range []string{attribute.attr("Name"), attribute.attr("FriendlyName")} {
+				if name != "" {
+					assertion.Attributes[name] = append(assertion.Attributes[name], values...)
+				}
+			}
+		}
+	}
+	return assertion, nil
+}
+
+func parseTime(value string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+// attribute returns the first non-empty value of the configured attribute, or of the first well-known attribute with one.
+func (a Assertion) attribute(configured string, wellKnown []string) string {
+	names := wellKnown
+	if configured != "" {
+		names = []string{configured}
+	}
+	for _, name := range names {
+		for _, value := range a.Attributes[name] {
+			if value != "" {
+				return value
+			}
+		}
+	}
+	return ""
+}
+
+// Identity maps the assertion to the account of the user at the provider. The NameID identifies the user,
+// the email and username are read from the attributes configured for the provider. As the provider is configured
+// by the organization as its authority on its users, the email it asserts counts as verified.
+func Identity(provider datatypes.IdentityProvider, assertion Assertion) federation.Identity {
+	emailAttribute, _ := provider.EmailAttribute.Get()
+	usernameAttribute, _ := provider.UsernameAttribute.Get()
+
+	identity := federation.Identity{
+		Subject:     assertion.NameID,
+		Email:       assertion.attribute(emailAttribute, emailAttributes),
+		Name:        assertion.attribute("", nameAttributes),
+		Username:    assertion.attribute(usernameAttribute, usernameAttributes),
+		MultiFactor: slices.Contains(multiFactorContexts, assertion.AuthnContext),
+	}
+	if identity.Email == "" && assertion.NameIDFormat == nameIDFormatEmail {
+		identity.Email = assertion.NameID
+	}
+	identity.EmailVerified = identity.Email != ""
+	return identity
+}
@@ -0,0 +1,238 @@
+package saml
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/ghostship-dev/authservice/core/datatypes"
+)
+
+// The responses in testdata were signed with the xmlsec reference implementation using the key of testdata/idp.crt.
+// assertion-signed.xml signs the assertion with rsa-sha256 and an InclusiveNamespaces prefix list,
+// response-signed.xml signs the whole response with rsa-sha1 and default namespaces.
+// assertion-signed-other-key.xml is signed the same way with a key which is not the one of the provider.
+
+const testRequestID = "_req1"
+
+var testNow = time.Date(2026, 10, 19, 10, 1, 0, 0, time.UTC)
+
+var signaturePattern = regexp.MustCompile(`(?s)<ds:Signature .*?</ds:Signature>`)
+
+func testProvider(t *testing.T) datatypes.IdentityProvider {
+	t.Helper()
+	certificate, err := os.ReadFile("testdata/idp.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return datatypes.IdentityProvider{
+		Organization:     datatypes.Organization{Issuer: edgedb.NewOptionalStr("https://sp.example.com")},
+		Name:             "corp",
+		Kind:             datatypes.IdentityProviderSAML,
+		Issuer:           edgedb.NewOptionalStr("https://idp.example.com/metadata"),
+		SAMLCertificates: []string{string(certificate)},
+		SAMLBinding:      datatypes.SAMLBindingPOST,
+		Enabled:          true,
+	}
+}
+
+func readResponse(t *testing.T, name string) string {
+	t.Helper()
+	response, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(response)
+}
+
+// replace replaces the first occurrence of old, which has to be in the response.
+func replace(t *testing.T, response, old, new string) string {
+	t.Helper()
+	if !strings.Contains(response, old) {
+		t.Fatalf("response does not contain %q", old)
+	}
+	return strings.Replace(response, old, new, 1)
+}
+
+func parse(provider datatypes.IdentityProvider, response string, now time.Time) (Assertion, error) {
+	return ParseResponse(provider, base64.StdEncoding.EncodeToString([]byte(response)), testRequestID, now)
+}
+
+func TestParseResponseWithSignedAssertion(t *testing.T) {
+	provider := testProvider(t)
+	assertion, err := parse(provider, readResponse(t, "assertion-signed.xml"), testNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assertion.ID != "_assert1" || assertion.NameID != "user-42" || assertion.SessionIndex != "_s1" {
+		t.Fatalf("unexpected assertion %+v", assertion)
+	}
+	if !assertion.ExpiresAt.Equal(time.Date(2026, 10, 19, 10, 5, 0, 0, time.UTC).Add(ClockSkew())) {
+		t.Fatalf("unexpected expiry %s", assertion.ExpiresAt)
+	}
+
+	identity := Identity(provider, assertion)
+	if identity.Subject != "user-42" || identity.Email != "jane&doe@corp.example.com" || identity.Username != "jane > doe" || !identity.MultiFactor {
+		t.Fatalf("unexpected identity %+v", identity)
+	}
+}
+
+func TestParseResponseWithSignedResponse(t *testing.T) {
+	provider := testProvider(t)
+	assertion, err := parse(provider, readResponse(t, "response-signed.xml"), testNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity := Identity(provider, assertion)
+	if identity.Subject != "jane@corp.example.com" || identity.Email != "jane@corp.example.com" || !identity.EmailVerified || identity.MultiFactor {
+		t.Fatalf("unexpected identity %+v", identity)
+	}
+}
+
+func TestParseResponseRejectsInvalidSignatures(t *testing.T) {
+	assertionSigned := readResponse(t, "assertion-signed.xml")
+	responseSigned := readResponse(t, "response-signed.xml")
+
+	tests := []struct {
+		name     string
+		response string
+	}{
+		{"other key", readResponse(t, "assertion-signed-other-key.xml")},
+		{"tampered signed assertion", replace(t, assertionSigned, ">user-42<", ">admin<")},
+		{"tampered signed response", replace(t, responseSigned, ">jdoe<", ">admin<")},
+		{"tampered digest", replace(t, assertionSigned, "<ds:DigestValue>H", "<ds:DigestValue>A")},
+		{"tampered signature value", replace(t, responseSigned, "<SignatureValue>C", "<SignatureValue>A")},
+		{"reference to another element", replace(t, assertionSigned, `URI="#_assert1"`, `URI="#_resp1"`)},
+		{"second signature", replace(t, assertionSigned, "<saml:Subject>",
+			signaturePattern.FindString(assertionSigned)+"<saml:Subject>")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := parse(testProvider(t), test.response, testNow); !errors.Is(err, ErrInvalidSignature) {
+				t.Fatalf("expected ErrInvalidSignature, got %v", err)
+			}
+		})
+	}
+}
+
+func TestParseResponseRejectsUnsignedAssertions(t *testing.T) {
+	unsigned := signaturePattern.ReplaceAllString(readResponse(t, "assertion-signed.xml"), "")
+	if _, err := parse(testProvider(t), unsigned, testNow); !errors.Is(err, ErrNotSigned) {
+		t.Fatalf("expected ErrNotSigned, got %v", err)
+	}
+}
+
+// Signature wrapping moves the signed element where the signature still verifies and puts a forged one where it is read.
+func TestParseResponseRejectsSignatureWrapping(t *testing.T) {
+	assertionSigned := readResponse(t, "assertion-signed.xml")
+	responseSigned := readResponse(t, "response-signed.xml")
+
+	start, end := strings.Index(assertionSigned, "<saml:Assertion "), strings.Index(assertionSigned, "</saml:Assertion>")+len("</saml:Assertion>")
+	signedAssertion := assertionSigned[start:end]
+	forgedAssertion := replace(t, signaturePattern.ReplaceAllString(signedAssertion, ""), ">user-42<", ">admin<")
+	forgedAssertionWithSignature := replace(t, signedAssertion, ">user-42<", ">admin<")
+
+	responseStart := strings.Index(responseSigned, "<samlp:Response ")
+	forgedResponse := replace(t, replace(t, responseSigned[responseStart:], `ID="_resp2"`, `ID="_forged"`), ">jdoe<", ">admin<")
+
+	tests := []struct {
+		name     string
+		response string
+		err      error
+	}{
+		{"signed assertion in extensions, forged assertion without signature",
+			assertionSigned[:start] + "<samlp:Extensions>" + signedAssertion + "</samlp:Extensions>" + forgedAssertion + assertionSigned[end:],
+			ErrNotSigned},
+		{"signed assertion in extensions, forged assertion with its signature",
+			assertionSigned[:start] + "<samlp:Extensions>" + signedAssertion + "</samlp:Extensions>" + forgedAssertionWithSignature + assertionSigned[end:],
+			ErrInvalidSignature},
+		{"signed assertion inside the forged assertion",
+			assertionSigned[:start] + replace(t, forgedAssertion, "</saml:Subject>", "</saml:Subject>"+signedAssertion) + assertionSigned[end:],
+			ErrNotSigned},
+		{"forged assertion next to the signed assertion",
+			assertionSigned[:start] + forgedAssertion + signedAssertion + assertionSigned[end:],
+			ErrInvalidResponse},
+		{"forged assertion next to the assertion of a signed response",
+			replace(t, responseSigned, "</samlp:Response>", replace(t, forgedAssertion, "<saml:Assertion ", `<saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" `)+"</samlp:Response>"),
+			ErrInvalidResponse},
+		{"signed response inside a forged response",
+			replace(t, forgedResponse, "</samlp:Response>", "<samlp:Extensions>"+responseSigned[responseStart:]+"</samlp:Extensions></samlp:Response>"),
+			ErrInvalidSignature},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assertion, err := parse(testProvider(t), test.response, testNow)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v with NameID %q", test.err, err, assertion.NameID)
+			}
+		})
+	}
+}
+
+// Comments are not part of the canonical form, so inserting one keeps the signature valid. The NameID has to be
+// read across it, or the comment truncates it to the identity of another user.
+func TestParseResponseReadsTextAcrossComments(t *testing.T) {
+	provider := testProvider(t)
+
+	assertion, err := parse(provider, replace(t, readResponse(t, "assertion-signed.xml"), ">user-42<", ">user<!---->-42<"), testNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assertion.NameID != "user-42" {
+		t.Fatalf("expected NameID user-42, got %q", assertion.NameID)
+	}
+
+	assertion, err = parse(provider, replace(t, readResponse(t, "response-signed.xml"), ">jane@corp.example.com<", ">jane@corp.example.com<!-- -->.evil<"), testNow)
+	if !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected text added next to a comment to break the signature, got %v with NameID %q", err, assertion.NameID)
+	}
+
+	assertion, err = parse(provider, replace(t, readResponse(t, "response-signed.xml"), ">jane@corp.example.com<", ">jane@corp<!---->.example.com<"), testNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Identity(provider, assertion).Email != "jane@corp.example.com" {
+		t.Fatalf("expected email jane@corp.example.com, got %q", Identity(provider, assertion).Email)
+	}
+}
+
+func TestParseResponseRejectsInvalidAssertions(t *testing.T) {
+	assertionSigned := readResponse(t, "assertion-signed.xml")
+	otherOrganization := testProvider(t)
+	otherOrganization.Organization.Issuer = edgedb.NewOptionalStr("https://other.example.com")
+	otherProvider := testProvider(t)
+	otherProvider.Issuer = edgedb.NewOptionalStr("https://other-idp.example.com/metadata")
+
+	tests := []struct {
+		name      string
+		provider  datatypes.IdentityProvider
+		response  string
+		requestID string
+		now       time.Time
+		err       error
+	}{
+		{"other request", testProvider(t), assertionSigned, "_req2", testNow, ErrInvalidResponse},
+		{"expired", testProvider(t), assertionSigned, testRequestID, testNow.Add(10 * time.Minute), ErrInvalidResponse},
+		{"not valid yet", testProvider(t), assertionSigned, testRequestID, testNow.Add(-10 * time.Minute), ErrInvalidResponse},
+		{"other organization", otherOrganization, assertionSigned, testRequestID, testNow, ErrInvalidResponse},
+		{"other identity provider", otherProvider, assertionSigned, testRequestID, testNow, ErrInvalidResponse},
+		{"unsuccessful", testProvider(t),
+			replace(t, assertionSigned, "status:Success", "status:Requester"), testRequestID, testNow, ErrUnsuccessful},
+		{"document type declaration", testProvider(t),
+			replace(t, assertionSigned, "<samlp:Response ", `<!DOCTYPE r [<!ENTITY e "user-42">]><samlp:Response `), testRequestID, testNow, ErrMalformedDocument},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encoded := base64.StdEncoding.EncodeToString([]byte(test.response))
+			if _, err := ParseResponse(test.provider, encoded, test.requestID, test.now); !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package saml
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	namespaceDSig         = "http://www.w3.org/2000/09/xmldsig#"
+	namespaceExcC14N      = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	algorithmEnveloped    = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+	algorithmExcC14N      = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	algorithmRSASHA1      = "http://www.w3.org/2000/09/xmldsig#rsa-sha1"
+	algorithmRSASHA256    = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	algorithmRSASHA512    = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha512"
+	algorithmDigestSHA1   = "http://www.w3.org/2000/09/xmldsig#sha1"
+	algorithmDigestSHA256 = "http://www.w3.org/2001/04/xmlenc#sha256"
+	algorithmDigestSHA512 = "http://www.w3.org/2001/04/xmlenc#sha512"
+)
+
+var (
+	ErrNotSigned        = errors.New("element is not signed")
+	ErrInvalidSignature = errors.New("signature is invalid")
+)
+
+var signatureHashes = map[string]crypto.Hash{
+	algorithmRSASHA1:   crypto.SHA1,
+	algorithmRSASHA256: crypto.SHA256,
+	algorithmRSASHA512: crypto.SHA512,
+}
+
+var digestHashes = map[string]crypto.Hash{
+	algorithmDigestSHA1:   crypto.SHA1,
+	algorithmDigestSHA256: crypto.SHA256,
+	algorithmDigestSHA512: crypto.SHA512,
+}
+
+// ParseCertificates parses the PEM encoded signing certificates of an identity provider.
+// Certificates copied from metadata without PEM armor are accepted as well.
+func ParseCertificates(encoded []string) ([]*x509.Certificate, error) {
+	var certificates []*x509.Certificate
+	for _, value := range encoded {
+		rest := []byte(strings.TrimSpace(value))
+		if !bytes.HasPrefix(rest, []byte("-----BEGIN")) {
+			rest = []byte("-----BEGIN CERTIFICATE-----\n" + string(rest) + "\n-----END CERTIFICATE-----")
+		}
+		for {
+			var block *pem.Block
+			block, rest = pem.Decode(rest)
+			if block == nil {
+				break
+			}
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			certificate, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			if _, isRSA := certificate.PublicKey.(*rsa.PublicKey); !isRSA {
+				return nil, errors.New("only rsa signing certificates are supported")
+			}
+			certificates = append(certificates, certificate)
+		}
+	}
+	if len(certificates) == 0 {
+		return nil, errors.New("no certificate found")
+	}
+	return certificates, nil
+}
+
+// verifySignature checks the enveloped signature of the element against the certificates of the identity provider.
+// Only a signature which references the element itself by its ID is accepted, so the signature cannot vouch for
+// a different part of the document. Keys sent along in the signature are ignored.
+func verifySignature(el *element, certificates []*x509.Certificate) error {
+	signature := el.child(namespaceDSig, "Signature")
+	if signature == nil {
+		return ErrNotSigned
+	}
+	if len(el.childrenNamed(namespaceDSig, "Signature")) > 1 {
+		return fmt.Errorf("%w: more than one signature", ErrInvalidSignature)
+	}
+
+	signedInfo := signature.child(namespaceDSig, "SignedInfo")
+	if signedInfo == nil {
+		return fmt.Errorf("%w: SignedInfo is missing", ErrInvalidSignature)
+	}
+
+	canonicalizationMethod := signedInfo.child(namespaceDSig, "CanonicalizationMethod")
+	if canonicalizationMethod == nil || canonicalizationMethod.attr("Algorithm") != algorithmExcC14N {
+		return fmt.Errorf("%w: unsupported canonicalization method", ErrInvalidSignature)
+	}
+	signatureMethod := signedInfo.child(namespaceDSig, "SignatureMethod")
+	if signatureMethod == nil {
+		return fmt.Errorf("%w: SignatureMethod is missing", ErrInvalidSignature)
+	}
+	signatureHash, supported := signatureHashes[signatureMethod.attr("Algorithm")]
+	if !supported {
+		return fmt.Errorf("%w: unsupported signature method %s", ErrInvalidSignature, signatureMethod.attr("Algorithm"))
+	}
+
+	references := signedInfo.childrenNamed(namespaceDSig, "Reference")
+	if len(references) != 1 {
+		return fmt.Errorf("%w: expected one reference", ErrInvalidSignature)
+	}
+	reference := references[0]
+	id := el.attr("ID")
+	if id == "" || reference.attr("URI") != "#"+id {
+		return fmt.Errorf("%w: reference does not point to the signed element", ErrInvalidSignature)
+	}
+
+	var inclusivePrefixes []string
+	if transforms := reference.child(namespaceDSig, "Transforms"); transforms != nil {
+		for _, transform := range transforms.childrenNamed(namespaceDSig, "Transform") {
+			switch transform.attr("Algorithm") {
+			case algorithmEnveloped:
+			case algorithmExcC14N:
+				inclusivePrefixes = inclusiveNamespaces(transform)
+			default:
+				return fmt.Errorf("%w: unsupported transform %s", ErrInvalidSignature, transform.attr("Algorithm"))
+			}
+		}
+	}
+
+	digestMethod := reference.child(namespaceDSig, "DigestMethod")
+	if digestMethod == nil {
+		return fmt.Errorf("%w: DigestMethod is missing", ErrInvalidSignature)
+	}
+	digestHash, supported := digestHashes[digestMethod.attr("Algorithm")]
+	if !supported {
+		return fmt.Errorf("%w: unsupported digest method %s", ErrInvalidSignature, digestMethod.attr("Algorithm"))
+	}
+	expectedDigest, err := decodeBase64(reference.childText(namespaceDSig, "DigestValue"))
+	if err != nil {
+		return fmt.Errorf("%w: malformed digest", ErrInvalidSignature)
+	}
+
+	digest := digestHash.New()
+	digest.Write(canonicalize(el, signature, inclusivePrefixes))
+	if subtle.ConstantTimeCompare(digest.Sum(nil), expectedDigest) != 1 {
+		return fmt.Errorf("%w: digest mismatch", ErrInvalidSignature)
+	}
+
+	signatureValue, err := decodeBase64(signature.childText(namespaceDSig, "SignatureValue"))
+	if err != nil {
+		return fmt.Errorf("%w: malformed signature value", ErrInvalidSignature)
+	}
+	hashed := signatureHash.New()
+	hashed.Write(canonicalize(signedInfo, nil, inclusiveNamespaces(canonicalizationMethod)))
+	for _, certificate := range certificates {
+		publicKey, isRSA := certificate.PublicKey.(*rsa.PublicKey)
+		if isRSA && rsa.VerifyPKCS1v15(publicKey, signatureHash, hashed.Sum(nil), signatureValue) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: not signed by a certificate of the identity provider", ErrInvalidSignature)
+}
+
+// inclusiveNamespaces returns the prefix list of the InclusiveNamespaces parameter of an exclusive canonicalization.
+func inclusiveNamespaces(method *element) []string {
+	if parameter := method.child(namespaceExcC14N, "InclusiveNamespaces"); parameter != nil {
+		return strings.Fields(parameter.attr("PrefixList"))
+	}
+	return nil
+}
+
+// decodeBase64 decodes base64 which may be wrapped over several lines.
+func decodeBase64(value string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(value), ""))
+}
@@ -0,0 +1,362 @@
+package saml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+const xmlNamespace = "http://www.w3.org/XML/1998/namespace"
+
+var ErrMalformedDocument = errors.New("malformed saml document")
+
+// element is a parsed XML element which keeps the prefixes and namespace declarations of the document,
+// as the signature covers the canonical form of the element rather than its meaning.
+type element struct {
+	prefix   string
+	local    string
+	space    string
+	attrs    []attribute
+	scope    map[string]string
+	children []interface{}
+}
+
+type attribute struct {
+	prefix string
+	local  string
+	space  string
+	value  string
+}
+
+// parseDocument parses the document into a tree of elements. Document type declarations are rejected,
+// so entities can neither expand nor reach outside the document.
+func parseDocument(data []byte) (*element, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = true
+
+	var root *element
+	var stack []*element
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrMalformedDocument, err)
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			if root != nil && len(stack) == 0 {
+				return nil, fmt.Errorf("%w: more than one root element", ErrMalformedDocument)
+			}
+			parentScope := map[string]string{"xml": xmlNamespace}
+			if len(stack) > 0 {
+				parentScope = stack[len(stack)-1].scope
+			}
+			el, err := newElement(token, parentScope)
+			if err != nil {
+				return nil, err
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, el)
+			} else {
+				root = el
+			}
+			stack = append(stack, el)
+		case xml.EndElement:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("%w: unexpected end element", ErrMalformedDocument)
+			}
+			el := stack[len(stack)-1]
+			if token.Name.Space != el.prefix || token.Name.Local != el.local {
+				return nil, fmt.Errorf("%w: element %s is not closed", ErrMalformedDocument, el.local)
+			}
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, string(token))
+			}
+		case xml.Directive:
+			return nil, fmt.Errorf("%w: document type declarations are not allowed", ErrMalformedDocument)
+		}
+	}
+	if root == nil || len(stack) > 0 {
+		return nil, fmt.Errorf("%w: incomplete document", ErrMalformedDocument)
+	}
+	return root, nil
+}
+
+func newElement(token xml.StartElement, parentScope map[string]string) (*element, error) {
+	el := &element{prefix: token.Name.Space, local: token.Name.Local, scope: parentScope}
+
+	declared := false
+	for _, attr := range token.Attr {
+		if attr.Name.Space == "xmlns" || (attr.Name.Space == "" && attr.Name.Local == "xmlns") {
+			if !declared {
+				el.scope = make(map[string]string, len(parentScope)+1)
+				for prefix, uri := range parentScope {
+					el.scope[prefix] = uri
+				}
+				declared = true
+			}
+			if attr.Name.Space == "xmlns" {
+				el.scope[attr.Name.Local] = attr.Value
+			} else {
+				el.scope[""] = attr.Value
+			}
+		}
+	}
+
+	var found bool
+	if el.space, found = el.scope[el.prefix]; !found && el.prefix != "" {
+		return nil, fmt.Errorf("%w: undeclared prefix %s", ErrMalformedDocument, el.prefix)
+	}
+	for _, attr := range token.Attr {
+		if attr.Name.Space == "xmlns" || (attr.Name.Space == "" && attr.Name.Local == "xmlns") {
+			continue
+		}
+		a := attribute{prefix: attr.Name.Space, local: attr.Name.Local, value: attr.Value}
+		if a.prefix != "" {
+			if a.space, found = el.scope[a.prefix]; !found {
+				return nil, fmt.Errorf("%w: undeclared prefix %s", ErrMalformedDocument, a.prefix)
+			}
+		}
+		el.attrs = append(el.attrs, a)
+	}
+	return el, nil
+}
+
+func (el *element) is(space, local string) bool {
+	return el.space == space && el.local == local
+}
+
+// attr returns the value of the attribute without namespace.
+func (el *element) attr(local string) string {
+	for _, a := range el.attrs {
+		if a.space == "" && a.local == local {
+			return a.value
+		}
+	}
+	return ""
+}
+
+func (el *element) hasAttr(local string) bool {
+	for _, a := range el.attrs {
+		if a.space == "" && a.local == local {
+			return true
+		}
+	}
+	return false
+}
+
+func (el *element) elements() []*element {
+	var elements []*element
+	for _, child := range el.children {
+		if child, isElement := child.(*element); isElement {
+			elements = append(elements, child)
+		}
+	}
+	return elements
+}
+
+// child returns the first child element with the name.
+func (el *element) child(space, local string) *element {
+	for _, child := range el.elements() {
+		if child.is(space, local) {
+			return child
+		}
+	}
+	return nil
+}
+
+func (el *element) childrenNamed(space, local string) []*element {
+	var elements []*element
+	for _, child := range el.elements() {
+		if child.is(space, local) {
+			elements = append(elements, child)
+		}
+	}
+	return elements
+}
+
+// text returns the character data of the element with surrounding whitespace removed.
+func (el *element) text() string {
+	var text strings.Builder
+	for _, child := range el.children {
+		if data, isText := child.(string); isText {
+			text.WriteString(data)
+		}
+	}
+	return strings.TrimSpace(text.String())
+}
+
+// childText returns the text of the first child element with the name, or an empty string.
+func (el *element) childText(space, local string) string {
+	if child := el.child(space, local); child != nil {
+		return child.text()
+	}
+	return ""
+}
+
+func (el *element) qualifiedName() string {
+	if el.prefix == "" {
+		return el.local
+	}
+	return el.prefix + ":" + el.local
+}
+
+// canonicalize serializes the element with Exclusive XML Canonicalization without comments. The excluded element is left out,
+// which implements the enveloped signature transform. Namespaces of the inclusive prefixes are rendered like by
+// inclusive canonicalization, where #default stands for the default namespace.
+func canonicalize(el *element, excluded *element, inclusivePrefixes []string) []byte {
+	inclusive := make(map[string]bool, len(inclusivePrefixes))
+	for _, prefix := range inclusivePrefixes {
+		if prefix == "#default" {
+			prefix = ""
+		}
+		inclusive[prefix] = true
+	}
+
+	var buffer bytes.Buffer
+	writeCanonical(&buffer, el, excluded, inclusive, map[string]string{})
+	return buffer.Bytes()
+}
+
+type namespaceDeclaration struct {
+	prefix string
+	uri    string
+}
+
+// writeCanonical writes the element. rendered holds the namespace declarations already in effect from output ancestors.
+func writeCanonical(buffer *bytes.Buffer, el *element, excluded *element, inclusive map[string]bool, rendered map[string]string) {
+	utilized := map[string]bool{el.prefix: true}
+	for _, a := range el.attrs {
+		if a.prefix != "" {
+			utilized[a.prefix] = true
+		}
+	}
+	for prefix := range inclusive {
+		if _, inScope := el.scope[prefix]; inScope {
+			utilized[prefix] = true
+		}
+	}
+
+	var declarations []namespaceDeclaration
+	for prefix := range utilized {
+		if prefix == "xml" {
+			continue
+		}
+		uri := el.scope[prefix]
+		previous, isRendered := rendered[prefix]
+		if isRendered && previous == uri {
+			continue
+		}
+		// An empty default namespace only has to be rendered to undo a default namespace of an output ancestor
+		if prefix == "" && uri == "" && (!isRendered || previous == "") {
+			continue
+		}
+		declarations = append(declarations, namespaceDeclaration{prefix: prefix, uri: uri})
+	}
+	sort.Slice(declarations, func(i, j int) bool {
+		return declarations[i].prefix < declarations[j].prefix
+	})
+
+	if len(declarations) > 0 {
+		inherited := rendered
+		rendered = make(map[string]string, len(inherited)+len(declarations))
+		for prefix, uri := range inherited {
+			rendered[prefix] = uri
+		}
+		for _, declaration := range declarations {
+			rendered[declaration.prefix] = declaration.uri
+		}
+	}
+
+	attrs := append([]attribute(nil), el.attrs...)
+	sort.Slice(attrs, func(i, j int) bool {
+		if attrs[i].space != attrs[j].space {
+			return attrs[i].space < attrs[j].space
+		}
+		return attrs[i].local < attrs[j].local
+	})
+
+	buffer.WriteByte('<')
+	buffer.WriteString(el.qualifiedName())
+	for _, declaration := range declarations {
+		if declaration.prefix == "" {
+			buffer.WriteString(` xmlns="`)
+		} else {
+			buffer.WriteString(` xmlns:` + declaration.prefix + `="`)
+		}
+		writeEscapedAttribute(buffer, declaration.uri)
+		buffer.WriteByte('"')
+	}
+	for _, a := range attrs {
+		buffer.WriteByte(' ')
+		if a.prefix != "" {
+			buffer.WriteString(a.prefix + ":")
+		}
+		buffer.WriteString(a.local + `="`)
+		writeEscapedAttribute(buffer, a.value)
+		buffer.WriteByte('"')
+	}
+	buffer.WriteByte('>')
+
+	for _, child := range el.children {
+		switch child := child.(type) {
+		case string:
+			writeEscapedText(buffer, child)
+		case *element:
+			if child != excluded {
+				writeCanonical(buffer, child, excluded, inclusive, rendered)
+			}
+		}
+	}
+
+	buffer.WriteString("</" + el.qualifiedName() + ">")
+}
+
+func writeEscapedText(buffer *bytes.Buffer, text string) {
+	for _, r := range text {
+		switch r {
+		case '&':
+			buffer.WriteString("&amp;")
+		case '<':
+			buffer.WriteString("&lt;")
+		case '>':
+			buffer.WriteString("&gt;")
+		case '\r':
+			buffer.WriteString("&#xD;")
+		default:
+			buffer.WriteRune(r)
+		}
+	}
+}
+
+func writeEscapedAttribute(buffer *bytes.Buffer, value string) {
+	for _, r := range value {
+		switch r {
+		case '&':
+			buffer.WriteString("&amp;")
+		case '<':
+			buffer.WriteString("&lt;")
+		case '"':
+			buffer.WriteString("&quot;")
+		case '\t':
+			buffer.WriteString("&#x9;")
+		case '\n':
+			buffer.WriteString("&#xA;")
+		case '\r':
+			buffer.WriteString("&#xD;")
+		default:
+			buffer.WriteRune(r)
+		}
+	}
+}
@@ -1,16 +1,181 @@
 package scopes
 
-var AllowedScopes = map[string]bool{
-	"profile":       true,
-	"email":         true,
-	"account_write": true,
-	"account_read":  true,
-	"admin":         false,
-	"*":             false,
+import (
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Wildcard grants every registered scope.
+const Wildcard = "*"
+
+type Definition struct {
+	Name           string `json:"name"`
+	Description    string `json:"description"`
+	Parent         string `json:"parent,omitempty"`
+	FirstPartyOnly bool   `json:"first_party_only"`
+	RequiresAdmin  bool   `json:"requires_admin"`
+}
+
+// DefaultDefinitions are used until the registry has been loaded from the database
+// and to seed an empty database.
+var DefaultDefinitions = []Definition{
+	{Name: "openid", Description: "Sign you in with your account"},
+	{Name: "profile", Description: "View your username and avatar"},
+	{Name: "email", Description: "View your email address"},
+	{Name: "account_read", Description: "View your account details"},
+	{Name: "account_write", Description: "Change your account details"},
+	{Name: "account_otp_write", Description: "Manage your two-factor authentication", FirstPartyOnly: true},
+	{Name: "oauth2_write", Description: "Create and modify your OAuth2 applications", FirstPartyOnly: true},
+	{Name: "oauth2_delete", Description: "Delete your OAuth2 applications", Parent: "oauth2_write", FirstPartyOnly: true},
+	{Name: "admin", Description: "Administer this service", RequiresAdmin: true},
+}
+
+var (
+	registryLock sync.RWMutex
+	registry     = toRegistry(DefaultDefinitions)
+)
+
+func toRegistry(definitions []Definition) map[string]Definition {
+	r := make(map[string]Definition, len(definitions))
+	for _, definition := range definitions {
+		r[definition.Name] = definition
+	}
+	return r
+}
+
+// Load replaces the registry with the given definitions.
+func Load(definitions []Definition) {
+	r := toRegistry(definitions)
+	registryLock.Lock()
+	registry = r
+	registryLock.Unlock()
+}
+
+func Get(scope string) (Definition, bool) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	definition, found := registry[scope]
+	return definition, found
+}
+
+// All returns every registered scope ordered by name.
+func All() []Definition {
+	registryLock.RLock()
+	definitions := make([]Definition, 0, len(registry))
+	for _, definition := range registry {
+		definitions = append(definitions, definition)
+	}
+	registryLock.RUnlock()
+	sort.Slice(definitions, func(i, j int) bool {
+		return definitions[i].Name < definitions[j].Name
+	})
+	return definitions
+}
+
+// IsAncestor reports whether ancestor is found while walking up the parents of scope.
+func IsAncestor(ancestor, scope string) bool {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	current := scope
+	for i := 0; i < len(registry); i++ {
+		definition, found := registry[current]
+		if !found || definition.Parent == "" {
+			return false
+		}
+		if definition.Parent == ancestor {
+			return true
+		}
+		current = definition.Parent
+	}
+	return false
+}
+
+// Expand resolves wildcards and parent scopes into the full set of scopes they grant.
+// "*" grants every scope, "prefix_*" grants every scope starting with "prefix_"
+// and a parent scope grants all of its descendants.
+func Expand(scope []string) []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	children := make(map[string][]string)
+	for _, definition := range registry {
+		if definition.Parent != "" {
+			children[definition.Parent] = append(children[definition.Parent], definition.Name)
+		}
+	}
+
+	expanded := make(map[string]bool)
+	var visit func(name string)
+	visit = func(name string) {
+		if expanded[name] {
+			return
+		}
+		expanded[name] = true
+		for _, child := range children[name] {
+			visit(child)
+		}
+	}
+
+	for _, s := range scope {
+		switch {
+		case s == Wildcard:
+			for name := range registry {
+				visit(name)
+			}
+		case strings.HasSuffix(s, "_*"):
+			prefix := strings.TrimSuffix(s, "*")
+			for name := range registry {
+				if strings.HasPrefix(name, prefix) {
+					visit(name)
+				}
+			}
+		default:
+			if _, found := registry[s]; found {
+				visit(s)
+			}
+		}
+	}
+
+	result := make([]string, 0, len(expanded))
+	for name := range expanded {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Satisfies reports whether the granted scopes cover the required scope.
+func Satisfies(granted []string, required string) bool {
+	if slices.Contains(granted, required) {
+		return true
+	}
+	return slices.Contains(Expand(granted), required)
+}
+
+// Cap limits the requested scopes to what the allowed scopes grant.
+func Cap(requested, allowed []string) []string {
+	allowedScopes := Expand(allowed)
+	capped := make([]string, 0)
+	for _, scope := range Expand(requested) {
+		if slices.Contains(allowedScopes, scope) {
+			capped = append(capped, scope)
+		}
+	}
+	return capped
 }
 
+// IsScopeAllowed reports whether a scope may be requested by OAuth2 client applications.
 func IsScopeAllowed(scope string) bool {
-	return AllowedScopes[scope]
+	definition, found := Get(scope)
+	return found && !definition.RequiresAdmin
+}
+
+// IsScopeAllowedForClient additionally rejects first-party only scopes for third-party clients.
+func IsScopeAllowedForClient(scope string, firstParty bool) bool {
+	definition, found := Get(scope)
+	return found && !definition.RequiresAdmin && (firstParty || !definition.FirstPartyOnly)
 }
 
 func AllScopesAllowed(scope []string) bool {
@@ -31,3 +196,13 @@ func GetForbiddenScopes(scope []string) []string {
 	}
 	return forbiddenScopes
 }
+
+func GetForbiddenScopesForClient(scope []string, firstParty bool) []string {
+	var forbiddenScopes []string
+	for _, scope := range scope {
+		if !IsScopeAllowedForClient(scope, firstParty) {
+			forbiddenScopes = append(forbiddenScopes, scope)
+		}
+	}
+	return forbiddenScopes
+}
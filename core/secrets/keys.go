@@ -0,0 +1,123 @@
+package secrets
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ghostship-dev/authservice/core/config"
+)
+
+// KeyProvider wraps and unwraps data keys with versioned master keys, like a key management service does.
+// Master keys never leave the provider, so a remote KMS can implement it as well.
+type KeyProvider interface {
+	// CurrentVersion is the version of the master key new data keys are wrapped with.
+	CurrentVersion() string
+	WrapKey(dataKey []byte) (version string, wrappedKey []byte, err error)
+	UnwrapKey(version string, wrappedKey []byte) ([]byte, error)
+}
+
+// LocalKeyProvider holds the master keys in memory. Retired versions are kept to unwrap
+// existing data keys until every value was re-encrypted with the current version.
+type LocalKeyProvider struct {
+	current string
+	keys    map[string][]byte
+}
+
+func NewLocalKeyProvider(current string, keys map[string][]byte) (*LocalKeyProvider, error) {
+	if _, found := keys[current]; !found {
+		return nil, fmt.Errorf("master key version %q is not configured", current)
+	}
+	for version, key := range keys {
+		if version == "" || strings.Contains(version, ":") {
+			return nil, fmt.Errorf("invalid master key version %q", version)
+		}
+		if len(key) != dataKeySize {
+			return nil, fmt.Errorf("master key version %q must be %d bytes long", version, dataKeySize)
+		}
+	}
+	return &LocalKeyProvider{current: current, keys: keys}, nil
+}
+
+func (p *LocalKeyProvider) CurrentVersion() string {
+	return p.current
+}
+
+// WrapKey encrypts the data key with the current master key, bound to the version it is tagged with.
+func (p *LocalKeyProvider) WrapKey(dataKey []byte) (string, []byte, error) {
+	wrappedKey, err := seal(p.keys[p.current], dataKey, []byte(p.current))
+	return p.current, wrappedKey, err
+}
+
+func (p *LocalKeyProvider) UnwrapKey(version string, wrappedKey []byte) ([]byte, error) {
+	key, found := p.keys[version]
+	if !found {
+		return nil, ErrUnknownKey
+	}
+	return open(key, wrappedKey, []byte(version))
+}
+
+// keyFile is the format of SECRETS_MASTER_KEY_FILE, the keys are base64 encoded.
+type keyFile struct {
+	CurrentVersion string            `json:"current_version"`
+	Keys           map[string]string `json:"keys"`
+}
+
+// NewKeyProviderFromEnv creates the key provider selected by SECRETS_KEY_PROVIDER (config or file).
+// The config provider reads SECRETS_MASTER_KEYS as comma separated version:base64key pairs and uses
+// SECRETS_MASTER_KEY_VERSION, or the first pair, as current version. The file provider reads a JSON file
+// with the current_version and the keys by version from SECRETS_MASTER_KEY_FILE.
+// It returns ErrNotConfigured if the selected provider has no master keys set.
+func NewKeyProviderFromEnv() (KeyProvider, error) {
+	switch config.GetEnv("SECRETS_KEY_PROVIDER", "config") {
+	case "config":
+		masterKeys := os.Getenv("SECRETS_MASTER_KEYS")
+		if masterKeys == "" {
+			return nil, fmt.Errorf("%w: SECRETS_MASTER_KEYS is not set", ErrNotConfigured)
+		}
+		pairs := strings.Split(masterKeys, ",")
+		keys := make(map[string]string, len(pairs))
+		current := os.Getenv("SECRETS_MASTER_KEY_VERSION")
+		for _, pair := range pairs {
+			version, key, found := strings.Cut(strings.TrimSpace(pair), ":")
+			if !found {
+				return nil, errors.New("SECRETS_MASTER_KEYS must contain version:base64key pairs")
+			}
+			keys[version] = key
+			if current == "" {
+				current = version
+			}
+		}
+		return newLocalKeyProvider(current, keys)
+	case "file":
+		path := os.Getenv("SECRETS_MASTER_KEY_FILE")
+		if path == "" {
+			return nil, fmt.Errorf("%w: SECRETS_MASTER_KEY_FILE is not set", ErrNotConfigured)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var file keyFile
+		if err = json.Unmarshal(content, &file); err != nil {
+			return nil, fmt.Errorf("invalid master key file: %w", err)
+		}
+		return newLocalKeyProvider(file.CurrentVersion, file.Keys)
+	}
+	return nil, errors.New("invalid SECRETS_KEY_PROVIDER (available providers: config, file)")
+}
+
+func newLocalKeyProvider(current string, encodedKeys map[string]string) (*LocalKeyProvider, error) {
+	keys := make(map[string][]byte, len(encodedKeys))
+	for version, encodedKey := range encodedKeys {
+		key, err := base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil {
+			return nil, fmt.Errorf("master key version %q is not valid base64", version)
+		}
+		keys[version] = key
+	}
+	return NewLocalKeyProvider(current, keys)
+}
@@ -0,0 +1,158 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// prefix marks envelope encrypted values, values without it are legacy plaintext.
+const prefix = "enc:v1:"
+
+const dataKeySize = 32
+
+var (
+	ErrNotEncrypted  = errors.New("value is not encrypted")
+	ErrMalformed     = errors.New("malformed encrypted value")
+	ErrUnknownKey    = errors.New("unknown master key version")
+	ErrDecryptFailed = errors.New("decryption failed")
+	ErrNotConfigured = errors.New("no master key is configured")
+)
+
+// Keys wraps the data keys of encrypted values. It is set on startup from NewKeyProviderFromEnv
+// and stays nil while no master key is configured.
+var Keys KeyProvider
+
+// Encrypt seals the plaintext with a fresh AES-256-GCM data key, which is wrapped by the current master key
+// and stored alongside the ciphertext together with the version of the master key.
+// The associated data, e.g. the id of the owning record, must be passed to Decrypt again,
+// so encrypted values can not be moved between records.
+func Encrypt(plaintext, associatedData []byte) (string, error) {
+	if Keys == nil {
+		return "", ErrNotConfigured
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+
+	sealed, err := seal(dataKey, plaintext, associatedData)
+	if err != nil {
+		return "", err
+	}
+
+	version, wrappedKey, err := Keys.WrapKey(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	encoding := base64.RawURLEncoding
+	return prefix + version + ":" + encoding.EncodeToString(wrappedKey) + ":" + encoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a value created by Encrypt with the same associated data.
+func Decrypt(value string, associatedData []byte) ([]byte, error) {
+	if Keys == nil {
+		return nil, ErrNotConfigured
+	}
+
+	version, wrappedKey, sealed, err := parse(value)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := Keys.UnwrapKey(version, wrappedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return open(dataKey, sealed, associatedData)
+}
+
+// IsConfigured reports whether a master key is configured, without one secrets are stored as plaintext.
+func IsConfigured() bool {
+	return Keys != nil
+}
+
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// KeyVersion returns the version of the master key the data key of the value is wrapped with.
+func KeyVersion(value string) (string, bool) {
+	version, _, _, err := parse(value)
+	return version, err == nil
+}
+
+// NeedsReencryption reports whether the value is plaintext or its data key is not wrapped with the current master key.
+func NeedsReencryption(value string) bool {
+	version, isEncrypted := KeyVersion(value)
+	return !isEncrypted || Keys == nil || version != Keys.CurrentVersion()
+}
+
+func parse(value string) (version string, wrappedKey, sealed []byte, err error) {
+	if !IsEncrypted(value) {
+		return "", nil, nil, ErrNotEncrypted
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if len(parts) != 3 || parts[0] == "" {
+		return "", nil, nil, ErrMalformed
+	}
+
+	encoding := base64.RawURLEncoding
+	if wrappedKey, err = encoding.DecodeString(parts[1]); err != nil {
+		return "", nil, nil, ErrMalformed
+	}
+	if sealed, err = encoding.DecodeString(parts[2]); err != nil {
+		return "", nil, nil, ErrMalformed
+	}
+	return parts[0], wrappedKey, sealed, nil
+}
+
+// seal encrypts with AES-GCM and prepends the random nonce to the ciphertext.
+func seal(key, plaintext, associatedData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, associatedData), nil
+}
+
+func open(key, sealed, associatedData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], associatedData)
+	if err != nil {
+		return nil, ErrDecryptFailed
+	}
+	return plaintext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != dataKeySize {
+		return nil, fmt.Errorf("keys must be %d bytes long", dataKeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,128 @@
+package sms
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghostship-dev/authservice/core/config"
+)
+
+type Message struct {
+	To   string
+	Body string
+}
+
+// Sender delivers text messages to phone numbers.
+type Sender interface {
+	Send(message Message) error
+}
+
+// HTTPSender posts messages as form to a Twilio-style messaging API, authenticated with basic authentication.
+// For Twilio the URL is https://api.twilio.com/2010-04-01/Accounts/{AccountSid}/Messages.json.
+type HTTPSender struct {
+	URL      string
+	Username string
+	Password string
+	From     string
+	Client   *http.Client
+}
+
+func (s *HTTPSender) Send(message Message) error {
+	form := url.Values{}
+	form.Set("From", s.From)
+	form.Set("To", message.To)
+	form.Set("Body", message.Body)
+
+	req, err := http.NewRequest(http.MethodPost, s.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if s.Username != "" {
+		req.SetBasicAuth(s.Username, s.Password)
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("messaging api responded with %d: %s", resp.StatusCode, body)
+	}
+	return nil
+}
+
+// FileSender appends every message to a file, or prints it to stdout if no path is set.
+// It is meant for development and tests.
+type FileSender struct {
+	Path string
+	lock sync.Mutex
+}
+
+func (s *FileSender) Send(message Message) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	line := fmt.Sprintf("%s SMS to %s: %s", time.Now().Format(time.RFC3339), message.To, message.Body)
+	if s.Path == "" {
+		fmt.Println(line)
+		return nil
+	}
+
+	file, err := os.OpenFile(s.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+
+	_, err = file.WriteString(line + "\n")
+	return err
+}
+
+// NewSenderFromEnv creates the sender selected by SMS_DRIVER (http, file or log).
+func NewSenderFromEnv() (Sender, error) {
+	switch config.GetEnv("SMS_DRIVER", "log") {
+	case "http":
+		apiURL := os.Getenv("SMS_HTTP_URL")
+		if apiURL == "" {
+			return nil, errors.New("SMS_HTTP_URL is required for the http sms driver")
+		}
+		return &HTTPSender{
+			URL:      apiURL,
+			Username: os.Getenv("SMS_HTTP_USERNAME"),
+			Password: os.Getenv("SMS_HTTP_PASSWORD"),
+			From:     os.Getenv("SMS_FROM"),
+			Client:   &http.Client{Timeout: 10 * time.Second},
+		}, nil
+	case "file":
+		return &FileSender{Path: config.GetEnv("SMS_FILE_PATH", "sms.log")}, nil
+	case "log":
+		return &FileSender{}, nil
+	}
+	return nil, errors.New("invalid SMS_DRIVER (available drivers: http, file, log)")
+}
+
+var Connection Sender = &FileSender{}
+
+// Send delivers the message in the background so slow providers do not block requests.
+func Send(message Message) {
+	go func() {
+		if err := Connection.Send(message); err != nil {
+			fmt.Println(fmt.Sprintf("sending sms to %s failed: %s", message.To, err))
+		}
+	}()
+}
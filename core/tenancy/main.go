@@ -0,0 +1,164 @@
+package tenancy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/ghostship-dev/authservice/core/datatypes"
+)
+
+// PathPrefix selects a tenant by slug, e.g. /t/acme/login.
+const PathPrefix = "/t/"
+
+type contextKey struct{}
+
+var (
+	registryLock  sync.RWMutex
+	organizations []datatypes.Organization
+)
+
+// Load replaces the in-memory organization registry.
+func Load(orgs []datatypes.Organization) {
+	registryLock.Lock()
+	organizations = orgs
+	registryLock.Unlock()
+}
+
+func All() []datatypes.Organization {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	return append([]datatypes.Organization(nil), organizations...)
+}
+
+func find(match func(organization datatypes.Organization) bool) (datatypes.Organization, bool) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	for _, organization := range organizations {
+		if match(organization) {
+			return organization, true
+		}
+	}
+	return datatypes.Organization{}, false
+}
+
+func GetBySlug(slug string) (datatypes.Organization, bool) {
+	return find(func(organization datatypes.Organization) bool {
+		return organization.Slug == slug
+	})
+}
+
+func GetByID(id edgedb.UUID) (datatypes.Organization, bool) {
+	return find(func(organization datatypes.Organization) bool {
+		return organization.Id == id
+	})
+}
+
+func GetByHost(host string) (datatypes.Organization, bool) {
+	host = strings.ToLower(host)
+	hostname, _, err := net.SplitHostPort(host)
+	if err != nil {
+		hostname = host
+	}
+	for _, candidate := range []string{host, hostname} {
+		organization, found := find(func(organization datatypes.Organization) bool {
+			for _, domain := range organization.Domains {
+				if domain == candidate {
+					return true
+				}
+			}
+			return false
+		})
+		if found {
+			return organization, true
+		}
+	}
+	return datatypes.Organization{}, false
+}
+
+func GetByIssuer(issuer string) (datatypes.Organization, bool) {
+	return find(func(organization datatypes.Organization) bool {
+		return Issuer(organization) == issuer
+	})
+}
+
+// Default returns the organization used when a request does not select a tenant.
+func Default() datatypes.Organization {
+	organization, _ := GetBySlug(datatypes.DefaultOrganizationSlug)
+	return organization
+}
+
+// ForAccount returns the organization the account belongs to, or the default organization if it was not loaded.
+func ForAccount(account datatypes.Account) datatypes.Organization {
+	if organization, found := GetByID(account.Organization.Id); found {
+		return organization
+	}
+	return Default()
+}
+
+// ForClient returns the organization the client application belongs to, or the default organization if it was not loaded.
+func ForClient(client datatypes.OAuthClient) datatypes.Organization {
+	if organization, found := GetByID(client.Organization.Id); found {
+		return organization
+	}
+	return Default()
+}
+
+// BaseIssuer returns the OpenID Connect issuer identifier of the default organization.
+func BaseIssuer() string {
+	return strings.TrimSuffix(os.Getenv("OIDC_ISSUER"), "/")
+}
+
+// Issuer returns the configured issuer of the organization or derives a path based one.
+func Issuer(organization datatypes.Organization) string {
+	if issuer, isSet := organization.Issuer.Get(); isSet && issuer != "" {
+		return issuer
+	}
+	if organization.Slug == "" || organization.Slug == datatypes.DefaultOrganizationSlug {
+		return BaseIssuer()
+	}
+	return BaseIssuer() + strings.TrimSuffix(PathPrefix, "/") + "/" + organization.Slug
+}
+
+// SigningKey returns the key used to sign access and refresh tokens of the organization.
+// Organizations without their own key fall back to JWT_SECRET_KEY.
+func SigningKey(organization datatypes.Organization) []byte {
+	if key, isSet := organization.SigningKey.Get(); isSet && key != "" {
+		return []byte(key)
+	}
+	return []byte(os.Getenv("JWT_SECRET_KEY"))
+}
+
+// Middleware resolves the tenant of a request from a /t/{slug} path prefix or the Host header
+// and attaches it to the request context. Unknown tenant paths are rejected.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		organization, found := datatypes.Organization{}, false
+
+		if strings.HasPrefix(req.URL.Path, PathPrefix) {
+			slug, rest, _ := strings.Cut(strings.TrimPrefix(req.URL.Path, PathPrefix), "/")
+			organization, found = GetBySlug(slug)
+			if !found {
+				http.NotFound(w, req)
+				return
+			}
+			req.URL.Path = "/" + rest
+		} else if organization, found = GetByHost(req.Host); !found {
+			organization = Default()
+		}
+
+		next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), contextKey{}, organization)))
+	})
+}
+
+// GetOrganization returns the tenant resolved for the request.
+func GetOrganization(req *http.Request) datatypes.Organization {
+	if organization, ok := req.Context().Value(contextKey{}).(datatypes.Organization); ok {
+		return organization
+	}
+	return Default()
+}
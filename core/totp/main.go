@@ -0,0 +1,178 @@
+package totp
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/ghostship-dev/authservice/core/config"
+	"github.com/ghostship-dev/authservice/core/database"
+	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/secrets"
+	"github.com/xlzd/gotp"
+)
+
+// Defaults of RFC 6238, which accounts enrolled before the settings were stored use.
+const (
+	DefaultAlgorithm = "SHA1"
+	DefaultDigits    = 6
+	DefaultPeriod    = 30
+)
+
+// secretLength is the number of base32 characters of a new secret, 160 bits as recommended by RFC 4226.
+const secretLength = 32
+
+var hashers = map[string]*gotp.Hasher{
+	"SHA1":   {HashName: "sha1", Digest: sha1.New},
+	"SHA256": {HashName: "sha256", Digest: sha256.New},
+	"SHA512": {HashName: "sha512", Digest: sha512.New},
+}
+
+// Settings are the parameters an authenticator app generates codes with. They are stored with the
+// secret on enrollment, so changing the configuration only affects accounts enrolled afterwards.
+type Settings struct {
+	Algorithm string
+	Digits    int
+	Period    int
+}
+
+// SettingsFromEnv returns the settings for new enrollments from TOTP_ALGORITHM, TOTP_DIGITS and TOTP_PERIOD.
+// Unsupported values fall back to the defaults, as most authenticator apps only support those.
+func SettingsFromEnv() Settings {
+	settings := Settings{
+		Algorithm: strings.ToUpper(config.GetEnv("TOTP_ALGORITHM", DefaultAlgorithm)),
+		Digits:    config.GetEnvInt("TOTP_DIGITS", DefaultDigits),
+		Period:    config.GetEnvInt("TOTP_PERIOD", DefaultPeriod),
+	}
+	if _, ok := hashers[settings.Algorithm]; !ok {
+		settings.Algorithm = DefaultAlgorithm
+	}
+	if settings.Digits < 6 || settings.Digits > 8 {
+		settings.Digits = DefaultDigits
+	}
+	if settings.Period < 15 || settings.Period > 120 {
+		settings.Period = DefaultPeriod
+	}
+	return settings
+}
+
+// AccountSettings returns the settings the account enrolled with.
+func AccountSettings(account datatypes.Account) Settings {
+	settings := Settings{Algorithm: DefaultAlgorithm, Digits: DefaultDigits, Period: DefaultPeriod}
+	if algorithm, isSet := account.OtpAlgorithm.Get(); isSet {
+		if _, ok := hashers[algorithm]; ok {
+			settings.Algorithm = algorithm
+		}
+	}
+	if digits, isSet := account.OtpDigits.Get(); isSet {
+		settings.Digits = int(digits)
+	}
+	if period, isSet := account.OtpPeriod.Get(); isSet && period > 0 {
+		settings.Period = int(period)
+	}
+	return settings
+}
+
+// Skew is the number of time-steps before and after the current one whose codes are still accepted.
+func Skew() int {
+	skew := config.GetEnvInt("TOTP_SKEW", 1)
+	if skew < 0 {
+		return 0
+	}
+	return skew
+}
+
+func (s Settings) generator(secret string) *gotp.TOTP {
+	return gotp.NewTOTP(secret, s.Digits, s.Period, hashers[s.Algorithm])
+}
+
+func NewSecret() string {
+	return gotp.RandomSecret(secretLength)
+}
+
+// SealSecret encrypts the secret for storage, bound to the account it belongs to.
+// While no master key is configured the secret is stored as it is.
+func SealSecret(accountId edgedb.UUID, secret string) (string, error) {
+	if !secrets.IsConfigured() {
+		return secret, nil
+	}
+	return secrets.Encrypt([]byte(secret), accountId[:])
+}
+
+// OpenSecret decrypts the stored secret of the account. Secrets stored before they were encrypted
+// are returned as they are until ReencryptSecrets ran.
+func OpenSecret(accountId edgedb.UUID, storedSecret string) (string, error) {
+	if !secrets.IsEncrypted(storedSecret) {
+		return storedSecret, nil
+	}
+	secret, err := secrets.Decrypt(storedSecret, accountId[:])
+	return string(secret), err
+}
+
+// ReencryptSecrets encrypts every plaintext secret and every secret whose data key is wrapped
+// with a retired master key version with the current one. It returns the number of updated secrets.
+func ReencryptSecrets() (int, error) {
+	accounts, err := database.Connection.Queries.GetOTPSecrets()
+	if err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for _, account := range accounts {
+		storedSecret, _ := account.OtpSecret.Get()
+		if !secrets.NeedsReencryption(storedSecret) {
+			continue
+		}
+
+		secret, err := OpenSecret(account.Id, storedSecret)
+		if err != nil {
+			fmt.Println(fmt.Sprintf("decrypting the otp secret of account %s failed: %s", account.Id, err))
+			continue
+		}
+		sealedSecret, err := SealSecret(account.Id, secret)
+		if err != nil {
+			return updated, err
+		}
+
+		// The secret is only replaced if it did not change since it was read, e.g. by a new enrollment
+		replaced, err := database.Connection.Queries.ReplaceOTPSecret(account.Id, storedSecret, sealedSecret)
+		if err != nil {
+			return updated, err
+		}
+		if replaced {
+			updated++
+		}
+	}
+	return updated, nil
+}
+
+// ProvisioningURI returns the otpauth:// URI to enroll the secret in an authenticator app.
+func ProvisioningURI(secret, accountName, issuerName string, settings Settings) string {
+	return settings.generator(secret).ProvisioningUri(accountName, issuerName)
+}
+
+// Match returns the time-step the code was generated for, if it is valid within the skew window around now.
+// Callers must reject steps which were already accepted to prevent replays.
+func Match(secret, code string, settings Settings, now time.Time) (int64, bool) {
+	if len(code) != settings.Digits {
+		return 0, false
+	}
+	generator := settings.generator(secret)
+	current := now.Unix() / int64(settings.Period)
+	skew := int64(Skew())
+	for step := current - skew; step <= current+skew; step++ {
+		if step < 0 {
+			continue
+		}
+		expected := generator.At(step * int64(settings.Period))
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
@@ -1,20 +1,27 @@
 package utility
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
+	"github.com/ghostship-dev/authservice/core/config"
 	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/tenancy"
 	"github.com/golang-jwt/jwt/v5"
+	gonanoid "github.com/matoous/go-nanoid/v2"
 )
 
-func NewAccessToken(account datatypes.Account, expires time.Time, scope []string) (datatypes.Token, error) {
-	tokenString, err := GenerateJWT(expires, account, scope, "access_token")
+const BackChannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+func NewAccessToken(account datatypes.Account, expires time.Time, scope []string, authentication datatypes.Authentication) (datatypes.Token, error) {
+	tokenString, err := GenerateJWT(expires, account, scope, "access_token", authentication)
 	if err != nil {
 		fmt.Println(err)
 		return datatypes.Token{}, err
@@ -29,8 +36,8 @@ func NewAccessToken(account datatypes.Account, expires time.Time, scope []string
 	}, nil
 }
 
-func NewRefreshToken(account datatypes.Account, expires time.Time, scope []string) (datatypes.Token, error) {
-	tokenString, err := GenerateJWT(expires, account, scope, "refresh_token")
+func NewRefreshToken(account datatypes.Account, expires time.Time, scope []string, authentication datatypes.Authentication) (datatypes.Token, error) {
+	tokenString, err := GenerateJWT(expires, account, scope, "refresh_token", authentication)
 	if err != nil {
 		return datatypes.Token{}, err
 	}
@@ -44,17 +51,95 @@ func NewRefreshToken(account datatypes.Account, expires time.Time, scope []strin
 	}, nil
 }
 
-func GenerateJWT(expires time.Time, account datatypes.Account, scope []string, tokenVariant string) (string, error) {
+func GenerateJWT(expires time.Time, account datatypes.Account, scope []string, tokenVariant string, authentication datatypes.Authentication) (string, error) {
 	token := jwt.New(jwt.SigningMethodHS256)
 	claims := token.Claims.(jwt.MapClaims)
 	claims["exp"] = expires.Unix()
 	claims["account_id"] = account.Id
 	claims["scope"] = scope
 	claims["variant"] = tokenVariant
+	addRoleClaims(claims, account)
+	addAuthenticationClaims(claims, authentication)
+
+	organization := tenancy.ForAccount(account)
+	claims["iss"] = tenancy.Issuer(organization)
+
+	return token.SignedString(tenancy.SigningKey(organization))
+}
+
+// GenerateIDToken creates an OpenID Connect ID token for the given client.
+// ID tokens are signed with the client secret, so the relying party can verify them without sharing our key.
+func GenerateIDToken(expires time.Time, account datatypes.Account, client datatypes.OAuthClient, authentication datatypes.Authentication) (string, error) {
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["iss"] = tenancy.Issuer(tenancy.ForAccount(account))
+	claims["sub"] = account.Id.String()
+	claims["aud"] = client.ClientID
+	claims["iat"] = time.Now().Unix()
+	claims["exp"] = expires.Unix()
+	addRoleClaims(claims, account)
+	addAuthenticationClaims(claims, authentication)
 
-	key := []byte(os.Getenv("JWT_SECRET_KEY"))
+	return token.SignedString([]byte(client.ClientSecret))
+}
+
+// addRoleClaims adds the role names of the account if JWT_INCLUDE_ROLE_CLAIMS is enabled.
+func addRoleClaims(claims jwt.MapClaims, account datatypes.Account) {
+	if config.GetEnvBool("JWT_INCLUDE_ROLE_CLAIMS", false) {
+		claims["roles"] = account.RoleNames()
+	}
+}
+
+// addAuthenticationClaims adds the auth_time, acr and amr claims if the authentication of the session is known.
+func addAuthenticationClaims(claims jwt.MapClaims, authentication datatypes.Authentication) {
+	if !authentication.IsSet() {
+		return
+	}
+	claims["auth_time"] = authentication.Time.Unix()
+	claims["acr"] = authentication.ACR
+	claims["amr"] = authentication.AMR
+}
 
-	return token.SignedString(key)
+// GenerateLogoutToken creates an OpenID Connect back-channel logout token for the given client.
+func GenerateLogoutToken(accountId string, client datatypes.OAuthClient) (string, error) {
+	jti, err := gonanoid.New(32)
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.New(jwt.SigningMethodHS256)
+	token.Header["typ"] = "logout+jwt"
+	claims := token.Claims.(jwt.MapClaims)
+	claims["iss"] = tenancy.Issuer(tenancy.ForClient(client))
+	claims["sub"] = accountId
+	claims["aud"] = client.ClientID
+	claims["iat"] = time.Now().Unix()
+	claims["exp"] = time.Now().Add(2 * time.Minute).Unix()
+	claims["jti"] = jti
+	claims["events"] = map[string]interface{}{BackChannelLogoutEvent: map[string]interface{}{}}
+
+	return token.SignedString([]byte(client.ClientSecret))
+}
+
+// HashToken returns the hex encoded SHA-256 digest of a one-time token, so only digests have to be stored.
+func HashToken(value string) string {
+	digest := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(digest[:])
+}
+
+// ClientIP returns the address of the client. The first X-Forwarded-For entry is only used with TRUST_PROXY_HEADERS,
+// as clients can set the header freely when they connect directly.
+func ClientIP(r *http.Request) string {
+	if config.GetEnvBool("TRUST_PROXY_HEADERS", false) {
+		if forwarded, _, _ := strings.Cut(r.Header.Get("X-Forwarded-For"), ","); strings.TrimSpace(forwarded) != "" {
+			return strings.TrimSpace(forwarded)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
 }
 
 func GetBearerTokenFromHeader(h *http.Header) (string, error) {
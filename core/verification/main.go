@@ -0,0 +1,146 @@
+package verification
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/ghostship-dev/authservice/core/config"
+	"github.com/ghostship-dev/authservice/core/datatypes"
+	"github.com/ghostship-dev/authservice/core/mail"
+	"github.com/ghostship-dev/authservice/core/tenancy"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Modes of EMAIL_VERIFICATION_MODE deciding how unverified accounts are treated on login.
+const (
+	ModeOff     = "off"
+	ModeLimit   = "limit"
+	ModeRequire = "require"
+)
+
+const tokenType = "email_verification"
+
+// Mode returns the configured verification mode. Unknown values are treated as ModeRequire.
+func Mode() string {
+	mode := config.GetEnv("EMAIL_VERIFICATION_MODE", ModeOff)
+	if mode != ModeOff && mode != ModeLimit {
+		return ModeRequire
+	}
+	return mode
+}
+
+// IsVerified reports whether the account left the "created" state by verifying its email address.
+func IsVerified(account datatypes.Account) bool {
+	return account.Status != datatypes.AccountStatusCreated
+}
+
+// AllowsLogin reports whether the account may sign in under the configured mode.
+func AllowsLogin(account datatypes.Account) bool {
+	return IsVerified(account) || Mode() != ModeRequire
+}
+
+// CapScope limits the scope of unverified accounts to EMAIL_VERIFICATION_UNVERIFIED_SCOPES in ModeLimit.
+func CapScope(account datatypes.Account, scope []string) []string {
+	if IsVerified(account) || Mode() != ModeLimit {
+		return scope
+	}
+	allowed := strings.Split(config.GetEnv("EMAIL_VERIFICATION_UNVERIFIED_SCOPES", "account_read"), ",")
+	capped := make([]string, 0)
+	for _, s := range scope {
+		if slices.Contains(allowed, s) {
+			capped = append(capped, s)
+		}
+	}
+	return capped
+}
+
+// ResendInterval is the minimum time between two verification mails to the same account.
+func ResendInterval() time.Duration {
+	return config.GetEnvDuration("EMAIL_VERIFICATION_RESEND_INTERVAL", time.Minute)
+}
+
+// GenerateToken creates a signed verification token bound to the account and its current email address.
+func GenerateToken(account datatypes.Account, expires time.Time) (string, error) {
+	organization := tenancy.ForAccount(account)
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+	claims["iss"] = tenancy.Issuer(organization)
+	claims["sub"] = account.Id.String()
+	claims["email"] = account.Email
+	claims["typ"] = tokenType
+	claims["exp"] = expires.Unix()
+	return token.SignedString(tenancy.SigningKey(organization))
+}
+
+// ParseToken validates a verification token issued by the organization and returns the account id and email it is bound to.
+func ParseToken(organization datatypes.Organization, value string) (edgedb.UUID, string, error) {
+	token, err := jwt.Parse(value, func(token *jwt.Token) (interface{}, error) {
+		return tenancy.SigningKey(organization), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer(tenancy.Issuer(organization)), jwt.WithExpirationRequired())
+	if err != nil || !token.Valid {
+		return edgedb.UUID{}, "", errors.New("invalid verification token")
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	if claims["typ"] != tokenType {
+		return edgedb.UUID{}, "", errors.New("invalid verification token")
+	}
+	email, _ := claims["email"].(string)
+	subject, err := claims.GetSubject()
+	if err != nil {
+		return edgedb.UUID{}, "", err
+	}
+	accountId, err := edgedb.ParseUUID(subject)
+	return accountId, email, err
+}
+
+// Link returns the verification link for the token. EMAIL_VERIFICATION_URL points it to a frontend,
+// otherwise the verification endpoint of the organization is used.
+func Link(organization datatypes.Organization, token string) string {
+	link := config.GetEnv("EMAIL_VERIFICATION_URL", tenancy.Issuer(organization)+"/account/verify")
+	separator := "?"
+	if strings.Contains(link, "?") {
+		separator = "&"
+	}
+	return link + separator + url.Values{"token": {token}}.Encode()
+}
+
+// SendMail mails a fresh verification link to the account.
+func SendMail(account datatypes.Account) error {
+	ttl := config.GetEnvDuration("EMAIL_VERIFICATION_TTL", 24*time.Hour)
+	token, err := GenerateToken(account, time.Now().Add(ttl))
+	if err != nil {
+		return err
+	}
+
+	mail.Send(mail.Message{
+		To:      account.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hello %s,\r\n\r\nplease confirm your email address by opening the following link within %s:\r\n\r\n%s\r\n\r\nIf you did not create an account, you can ignore this email.",
+			account.Username, ttl, Link(tenancy.ForAccount(account), token)),
+	})
+	return nil
+}
+
+// SendEmailChangeMail mails a verification link for newEmail, which replaces the email of the account once verified.
+func SendEmailChangeMail(account datatypes.Account, newEmail string) error {
+	ttl := config.GetEnvDuration("EMAIL_VERIFICATION_TTL", 24*time.Hour)
+	account.Email = newEmail
+	token, err := GenerateToken(account, time.Now().Add(ttl))
+	if err != nil {
+		return err
+	}
+
+	mail.Send(mail.Message{
+		To:      newEmail,
+		Subject: "Confirm your new email address",
+		Body: fmt.Sprintf("Hello %s,\r\n\r\nplease confirm that this is the new email address of your account by opening the following link within %s:\r\n\r\n%s\r\n\r\nIf you did not request this change, you can ignore this email.",
+			account.Username, ttl, Link(tenancy.ForAccount(account), token)),
+	})
+	return nil
+}